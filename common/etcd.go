@@ -2,9 +2,39 @@ package common
 
 import (
 	"fmt"
+	"strings"
+
 	"github.com/coreos/go-etcd/etcd"
 )
 
+// DefaultEtcdEndpoint is used when SQUIRREL_ENDPOINT is not set.
+const DefaultEtcdEndpoint = "http://127.0.0.1:4001"
+
+// EtcdEndpoints parses endpoints, a comma separated list of etcd endpoint
+// URIs as in SQUIRREL_ENDPOINT, so that the configuration can be pulled from a
+// central etcd cluster. etcd:// and etcds:// are accepted as aliases of
+// http:// and https://, and URIs without scheme are taken as http://.
+func EtcdEndpoints(endpoints string) (ret []string) {
+	for _, e := range strings.Split(endpoints, ",") {
+		e = strings.TrimSpace(e)
+		switch {
+		case e == "":
+			continue
+		case strings.HasPrefix(e, "etcd://"):
+			e = "http://" + strings.TrimPrefix(e, "etcd://")
+		case strings.HasPrefix(e, "etcds://"):
+			e = "https://" + strings.TrimPrefix(e, "etcds://")
+		case !strings.Contains(e, "://"):
+			e = "http://" + e
+		}
+		ret = append(ret, e)
+	}
+	if len(ret) == 0 {
+		ret = []string{DefaultEtcdEndpoint}
+	}
+	return
+}
+
 func GetEtcdValue(client *etcd.Client, key string) (value string, err error) {
 	var resp *etcd.Response
 	resp, err = client.Get(key, false, false)
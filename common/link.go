@@ -30,6 +30,17 @@ func (l *Link) WriteFrame(frame *ReusableSlice) {
 	l.outgoing <- frame
 }
 
+// TryWriteFrame is like WriteFrame, but returns false rather than blocking if
+// frames already waiting to be sent fill up the Link.
+func (l *Link) TryWriteFrame(frame *ReusableSlice) bool {
+	select {
+	case l.outgoing <- frame:
+		return true
+	default:
+		return false
+	}
+}
+
 func (l *Link) Done() {
 	close(l.outgoing)
 }
@@ -0,0 +1,173 @@
+package common
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// DecodeParameters decodes parameters, a Dir node as passed to Configure of a
+// MobilityManager or September, into dst, which needs to be a pointer to a
+// struct. Fields are mapped to children by their `etcd` tag, and fields
+// without the tag are left untouched:
+//
+//	type parameters struct {
+//	    TransmissionRange float64       `etcd:"transmission_range,required"`
+//	    MacProtocol       string        `etcd:"mac_protocol" default:"802.11p10MHz"`
+//	    Interval          time.Duration `etcd:"interval" default:"1s"`
+//	    Antenna           struct { ... } `etcd:"antenna"` // a Dir child
+//	    Groups            map[string]struct { ... } `etcd:"groups"` // Dir of Dirs
+//	}
+//
+// A field is set to its `default` tag if the child doesn't exist, and an error
+// is returned if it's "required". Supported field types are strings, bools,
+// integers, floats, time.Duration, structs (for Dir children) and maps from
+// strings to structs (for Dir children of Dirs, keyed by name). Children
+// that don't map to any field are logged and ignored. parameters can be nil,
+// in which case only defaults are applied.
+func DecodeParameters(parameters *etcd.Node, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("DecodeParameters needs a pointer to struct (got %T)", dst)
+	}
+	return decodeDir(parameters, v.Elem(), "")
+}
+
+func decodeDir(node *etcd.Node, v reflect.Value, dir string) error {
+	children := make(map[string]*etcd.Node)
+	if node != nil {
+		if !node.Dir {
+			return fmt.Errorf("%s is not a Dir node", node.Key)
+		}
+		dir = node.Key
+		for _, child := range node.Nodes {
+			children[path.Base(child.Key)] = child
+		}
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("etcd")
+		if tag == "" || field.PkgPath != "" {
+			continue
+		}
+		options := strings.Split(tag, ",")
+		name := options[0]
+		required := len(options) > 1 && options[1] == "required"
+		key := path.Join(dir, name)
+
+		child, ok := children[name]
+		delete(children, name)
+		if field.Type.Kind() == reflect.Struct && field.Type != durationType {
+			if ok && !child.Dir {
+				return fmt.Errorf("%s is not a Dir node", key)
+			}
+			if err := decodeDir(child, v.Field(i), key); err != nil {
+				return err
+			}
+			continue
+		}
+		if field.Type.Kind() == reflect.Map && field.Type.Key().Kind() == reflect.String && field.Type.Elem().Kind() == reflect.Struct {
+			if !ok {
+				if required {
+					return fmt.Errorf("%s is required", key)
+				}
+				continue
+			}
+			if err := decodeMap(child, v.Field(i)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var value string
+		if ok {
+			if child.Dir {
+				return fmt.Errorf("%s is a Dir (expected a value)", key)
+			}
+			value = child.Value
+		} else if required {
+			return fmt.Errorf("%s is required", key)
+		} else if value, ok = field.Tag.Lookup("default"); !ok {
+			continue
+		}
+		if err := decodeValue(value, v.Field(i)); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %v", value, key, err)
+		}
+	}
+	for name := range children {
+		log.Printf("unknown parameter %s is ignored\n", path.Join(dir, name))
+	}
+	return nil
+}
+
+// decodeMap decodes each Dir child of node into an element of map v, keyed by
+// name.
+func decodeMap(node *etcd.Node, v reflect.Value) error {
+	if !node.Dir {
+		return fmt.Errorf("%s is not a Dir node", node.Key)
+	}
+	m := reflect.MakeMap(v.Type())
+	for _, child := range node.Nodes {
+		if !child.Dir {
+			return fmt.Errorf("%s is not a Dir node", child.Key)
+		}
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if err := decodeDir(child, elem, child.Key); err != nil {
+			return err
+		}
+		m.SetMapIndex(reflect.ValueOf(path.Base(child.Key)).Convert(v.Type().Key()), elem)
+	}
+	v.Set(m)
+	return nil
+}
+
+func decodeValue(value string, v reflect.Value) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %v", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+func loadCertPool(caFile string) (pool *x509.CertPool, err error) {
+	var pem []byte
+	pem, err = ioutil.ReadFile(caFile)
+	if err != nil {
+		return
+	}
+	pool = x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		err = fmt.Errorf("no certificate found in %s", caFile)
+	}
+	return
+}
+
+// ServerTLSConfig creates TLS configuration for master's listener from PEM
+// files. If clientCAFile is not empty, clients are required to present a
+// certificate signed by a CA in it.
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (conf *tls.Config, err error) {
+	var cert tls.Certificate
+	cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return
+	}
+	conf = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if clientCAFile != "" {
+		conf.ClientCAs, err = loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return
+}
+
+// ClientTLSConfig creates TLS configuration for connecting to master from PEM
+// files. Master's certificate is verified against CAs in caFile, or system
+// roots if it's empty. certFile and keyFile are optional, and only needed if
+// master requires client certificates.
+func ClientTLSConfig(caFile, certFile, keyFile string) (conf *tls.Config, err error) {
+	conf = &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		conf.RootCAs, err = loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if certFile != "" || keyFile != "" {
+		var cert tls.Certificate
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return
+}
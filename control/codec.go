@@ -0,0 +1,53 @@
+package control
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc/encoding"
+	// registers the "proto" codec that protoCodec falls back to
+	_ "google.golang.org/grpc/encoding/proto"
+)
+
+// protoCodec encodes messages of the control service in protobuf wire format,
+// and hands other messages to the codec it replaces, so that it can be
+// registered as "proto", the codec that gRPC clients use by default.
+type protoCodec struct {
+	fallback encoding.Codec
+}
+
+func (c protoCodec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(message); ok {
+		return m.appendWire(nil), nil
+	}
+	if c.fallback == nil {
+		return nil, fmt.Errorf("control: cannot marshal %T", v)
+	}
+	return c.fallback.Marshal(v)
+}
+
+func (c protoCodec) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(message); ok {
+		return m.consumeWire(data)
+	}
+	if c.fallback == nil {
+		return fmt.Errorf("control: cannot unmarshal %T", v)
+	}
+	return c.fallback.Unmarshal(data, v)
+}
+
+func (protoCodec) Name() string { return "proto" }
+
+// jsonCodec encodes messages as JSON, with field names as in control.proto,
+// for clients that ask for the "json" content-subtype rather than generating
+// stubs, e.g. Python with json.dumps and json.loads as serializers.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return "json" }
+
+func init() {
+	encoding.RegisterCodec(protoCodec{fallback: encoding.GetCodec("proto")})
+	encoding.RegisterCodec(jsonCodec{})
+}
@@ -0,0 +1,355 @@
+package control
+
+import "sort"
+
+// Position is in configured units, relative to the configured origin, as
+// Mobility Managers see it.
+type Position struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Height float64 `json:"height"`
+}
+
+func (p *Position) appendWire(b []byte) []byte {
+	b = appendDouble(b, 1, p.X)
+	b = appendDouble(b, 2, p.Y)
+	return appendDouble(b, 3, p.Height)
+}
+
+func (p *Position) consumeWire(b []byte) error {
+	return consumeFields(b, func(f wireField) (err error) {
+		switch f.num {
+		case 1:
+			p.X, err = f.double()
+		case 2:
+			p.Y, err = f.double()
+		case 3:
+			p.Height, err = f.double()
+		}
+		return
+	})
+}
+
+// Node is a node known to master. Position is nil while it's disabled.
+type Node struct {
+	Addr      string            `json:"addr"`
+	Index     int               `json:"index"`
+	Connected bool              `json:"connected"`
+	Enabled   bool              `json:"enabled"`
+	Position  *Position         `json:"position"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// metadataEntry is an entry of Node.Metadata, which is encoded as a map
+// field.
+type metadataEntry struct {
+	key, value string
+}
+
+func (e *metadataEntry) appendWire(b []byte) []byte {
+	b = appendString(b, 1, e.key)
+	return appendString(b, 2, e.value)
+}
+
+func (e *metadataEntry) consumeWire(b []byte) error {
+	return consumeFields(b, func(f wireField) (err error) {
+		switch f.num {
+		case 1:
+			e.key, err = f.string()
+		case 2:
+			e.value, err = f.string()
+		}
+		return
+	})
+}
+
+func (n *Node) appendWire(b []byte) []byte {
+	b = appendString(b, 1, n.Addr)
+	b = appendInt(b, 2, int64(n.Index))
+	b = appendBool(b, 3, n.Connected)
+	b = appendBool(b, 4, n.Enabled)
+	if n.Position != nil {
+		b = appendMessage(b, 5, n.Position)
+	}
+	keys := make([]string, 0, len(n.Metadata))
+	for k := range n.Metadata {
+		keys = append(keys, k)
+	}
+	// for the same node to be encoded the same
+	sort.Strings(keys)
+	for _, k := range keys {
+		b = appendMessage(b, 6, &metadataEntry{key: k, value: n.Metadata[k]})
+	}
+	return b
+}
+
+func (n *Node) consumeWire(b []byte) error {
+	return consumeFields(b, func(f wireField) (err error) {
+		switch f.num {
+		case 1:
+			n.Addr, err = f.string()
+		case 2:
+			n.Index, err = f.int32()
+		case 3:
+			n.Connected, err = f.bool()
+		case 4:
+			n.Enabled, err = f.bool()
+		case 5:
+			n.Position = new(Position)
+			err = f.message(n.Position)
+		case 6:
+			var e metadataEntry
+			if err = f.message(&e); err == nil {
+				if n.Metadata == nil {
+					n.Metadata = make(map[string]string)
+				}
+				n.Metadata[e.key] = e.value
+			}
+		}
+		return
+	})
+}
+
+// LinkQuality is what September believes about a link, for packets of 1500
+// bytes. RSSI and SNR are nil, and Rate is 0, if it doesn't model them.
+type LinkQuality struct {
+	From string   `json:"from"`
+	To   string   `json:"to"`
+	Loss float64  `json:"loss"`
+	RSSI *float64 `json:"rssi,omitempty"`
+	SNR  *float64 `json:"snr,omitempty"`
+	Rate float64  `json:"rate,omitempty"`
+}
+
+func (q *LinkQuality) appendWire(b []byte) []byte {
+	b = appendString(b, 1, q.From)
+	b = appendString(b, 2, q.To)
+	b = appendDouble(b, 3, q.Loss)
+	b = appendOptionalDouble(b, 4, q.RSSI)
+	b = appendOptionalDouble(b, 5, q.SNR)
+	return appendDouble(b, 6, q.Rate)
+}
+
+func (q *LinkQuality) consumeWire(b []byte) error {
+	return consumeFields(b, func(f wireField) (err error) {
+		switch f.num {
+		case 1:
+			q.From, err = f.string()
+		case 2:
+			q.To, err = f.string()
+		case 3:
+			q.Loss, err = f.double()
+		case 4:
+			q.RSSI, err = f.optionalDouble()
+		case 5:
+			q.SNR, err = f.optionalDouble()
+		case 6:
+			q.Rate, err = f.double()
+		}
+		return
+	})
+}
+
+type NodesRequest struct{}
+
+func (*NodesRequest) appendWire(b []byte) []byte { return b }
+func (*NodesRequest) consumeWire(b []byte) error { return consumeFields(b, skipField) }
+
+type NodesResponse struct {
+	Nodes []Node `json:"nodes"`
+}
+
+func (r *NodesResponse) appendWire(b []byte) []byte {
+	for i := range r.Nodes {
+		b = appendMessage(b, 1, &r.Nodes[i])
+	}
+	return b
+}
+
+func (r *NodesResponse) consumeWire(b []byte) error {
+	return consumeFields(b, func(f wireField) (err error) {
+		if f.num == 1 {
+			var n Node
+			if err = f.message(&n); err == nil {
+				r.Nodes = append(r.Nodes, n)
+			}
+		}
+		return
+	})
+}
+
+type SetPositionRequest struct {
+	Addr     string   `json:"addr"`
+	Position Position `json:"position"`
+}
+
+func (r *SetPositionRequest) appendWire(b []byte) []byte {
+	b = appendString(b, 1, r.Addr)
+	return appendMessage(b, 2, &r.Position)
+}
+
+func (r *SetPositionRequest) consumeWire(b []byte) error {
+	return consumeFields(b, func(f wireField) (err error) {
+		switch f.num {
+		case 1:
+			r.Addr, err = f.string()
+		case 2:
+			err = f.message(&r.Position)
+		}
+		return
+	})
+}
+
+type SetPositionResponse struct{}
+
+func (*SetPositionResponse) appendWire(b []byte) []byte { return b }
+func (*SetPositionResponse) consumeWire(b []byte) error { return consumeFields(b, skipField) }
+
+type SetEnabledRequest struct {
+	Addr    string `json:"addr"`
+	Enabled bool   `json:"enabled"`
+}
+
+func (r *SetEnabledRequest) appendWire(b []byte) []byte {
+	b = appendString(b, 1, r.Addr)
+	return appendBool(b, 2, r.Enabled)
+}
+
+func (r *SetEnabledRequest) consumeWire(b []byte) error {
+	return consumeFields(b, func(f wireField) (err error) {
+		switch f.num {
+		case 1:
+			r.Addr, err = f.string()
+		case 2:
+			r.Enabled, err = f.bool()
+		}
+		return
+	})
+}
+
+type SetEnabledResponse struct{}
+
+func (*SetEnabledResponse) appendWire(b []byte) []byte { return b }
+func (*SetEnabledResponse) consumeWire(b []byte) error { return consumeFields(b, skipField) }
+
+type LinkQualityRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+func (r *LinkQualityRequest) appendWire(b []byte) []byte {
+	b = appendString(b, 1, r.From)
+	return appendString(b, 2, r.To)
+}
+
+func (r *LinkQualityRequest) consumeWire(b []byte) error {
+	return consumeFields(b, func(f wireField) (err error) {
+		switch f.num {
+		case 1:
+			r.From, err = f.string()
+		case 2:
+			r.To, err = f.string()
+		}
+		return
+	})
+}
+
+type LinkQualityResponse struct {
+	Quality LinkQuality `json:"quality"`
+}
+
+func (r *LinkQualityResponse) appendWire(b []byte) []byte {
+	return appendMessage(b, 1, &r.Quality)
+}
+
+func (r *LinkQualityResponse) consumeWire(b []byte) error {
+	return consumeFields(b, func(f wireField) (err error) {
+		if f.num == 1 {
+			err = f.message(&r.Quality)
+		}
+		return
+	})
+}
+
+type WatchPositionsRequest struct{}
+
+func (*WatchPositionsRequest) appendWire(b []byte) []byte { return b }
+func (*WatchPositionsRequest) consumeWire(b []byte) error { return consumeFields(b, skipField) }
+
+// PositionEvent is sent each time a node is moved.
+type PositionEvent struct {
+	Addr         string   `json:"addr"`
+	Index        int      `json:"index"`
+	Position     Position `json:"position"`
+	TimeUnixNano int64    `json:"time_unix_nano"`
+}
+
+func (e *PositionEvent) appendWire(b []byte) []byte {
+	b = appendString(b, 1, e.Addr)
+	b = appendInt(b, 2, int64(e.Index))
+	b = appendMessage(b, 3, &e.Position)
+	return appendInt(b, 4, e.TimeUnixNano)
+}
+
+func (e *PositionEvent) consumeWire(b []byte) error {
+	return consumeFields(b, func(f wireField) (err error) {
+		switch f.num {
+		case 1:
+			e.Addr, err = f.string()
+		case 2:
+			e.Index, err = f.int32()
+		case 3:
+			err = f.message(&e.Position)
+		case 4:
+			e.TimeUnixNano, err = f.int64()
+		}
+		return
+	})
+}
+
+// WatchLinksRequest asks for links to be checked every IntervalMS
+// milliseconds, or every second if it's 0.
+type WatchLinksRequest struct {
+	IntervalMS int64 `json:"interval_ms,omitempty"`
+}
+
+func (r *WatchLinksRequest) appendWire(b []byte) []byte {
+	return appendInt(b, 1, r.IntervalMS)
+}
+
+func (r *WatchLinksRequest) consumeWire(b []byte) error {
+	return consumeFields(b, func(f wireField) (err error) {
+		if f.num == 1 {
+			r.IntervalMS, err = f.int64()
+		}
+		return
+	})
+}
+
+// LinkEvent is sent when a link comes up, i.e. packets may be delivered over
+// it, or goes down, as far as September can tell.
+type LinkEvent struct {
+	Up           bool        `json:"up"`
+	Quality      LinkQuality `json:"quality"`
+	TimeUnixNano int64       `json:"time_unix_nano"`
+}
+
+func (e *LinkEvent) appendWire(b []byte) []byte {
+	b = appendBool(b, 1, e.Up)
+	b = appendMessage(b, 2, &e.Quality)
+	return appendInt(b, 3, e.TimeUnixNano)
+}
+
+func (e *LinkEvent) consumeWire(b []byte) error {
+	return consumeFields(b, func(f wireField) (err error) {
+		switch f.num {
+		case 1:
+			e.Up, err = f.bool()
+		case 2:
+			err = f.message(&e.Quality)
+		case 3:
+			e.TimeUnixNano, err = f.int64()
+		}
+		return
+	})
+}
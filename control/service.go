@@ -0,0 +1,210 @@
+package control
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// ServiceName is the full name of master's gRPC control service, as
+// control.proto defines it. Messages are protobuf encoded by default, or JSON
+// encoded for clients that ask for the "json" content-subtype.
+const ServiceName = "squirrel.Control"
+
+// ControlServer is implemented by master to serve the control service.
+// Streams are sent PositionEvents and LinkEvents until their contexts are
+// done.
+type ControlServer interface {
+	Nodes(context.Context, *NodesRequest) (*NodesResponse, error)
+	SetPosition(context.Context, *SetPositionRequest) (*SetPositionResponse, error)
+	SetEnabled(context.Context, *SetEnabledRequest) (*SetEnabledResponse, error)
+	LinkQuality(context.Context, *LinkQualityRequest) (*LinkQualityResponse, error)
+	WatchPositions(*WatchPositionsRequest, grpc.ServerStream) error
+	WatchLinks(*WatchLinksRequest, grpc.ServerStream) error
+}
+
+// interceptUnary calls serve with req, through interceptor if there's one.
+func interceptUnary(srv interface{}, ctx context.Context, req interface{}, method string, interceptor grpc.UnaryServerInterceptor, serve grpc.UnaryHandler) (interface{}, error) {
+	if interceptor == nil {
+		return serve(ctx, req)
+	}
+	return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/" + method}, serve)
+}
+
+func nodesHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(NodesRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	return interceptUnary(srv, ctx, req, "Nodes", interceptor, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).Nodes(ctx, req.(*NodesRequest))
+	})
+}
+
+func setPositionHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(SetPositionRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	return interceptUnary(srv, ctx, req, "SetPosition", interceptor, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).SetPosition(ctx, req.(*SetPositionRequest))
+	})
+}
+
+func setEnabledHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(SetEnabledRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	return interceptUnary(srv, ctx, req, "SetEnabled", interceptor, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).SetEnabled(ctx, req.(*SetEnabledRequest))
+	})
+}
+
+func linkQualityHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := new(LinkQualityRequest)
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	return interceptUnary(srv, ctx, req, "LinkQuality", interceptor, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServer).LinkQuality(ctx, req.(*LinkQualityRequest))
+	})
+}
+
+func watchPositionsHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := new(WatchPositionsRequest)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(ControlServer).WatchPositions(req, stream)
+}
+
+func watchLinksHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := new(WatchLinksRequest)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(ControlServer).WatchLinks(req, stream)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*ControlServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Nodes", Handler: nodesHandler},
+		{MethodName: "SetPosition", Handler: setPositionHandler},
+		{MethodName: "SetEnabled", Handler: setEnabledHandler},
+		{MethodName: "LinkQuality", Handler: linkQualityHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "WatchPositions", Handler: watchPositionsHandler, ServerStreams: true},
+		{StreamName: "WatchLinks", Handler: watchLinksHandler, ServerStreams: true},
+	},
+	Metadata: "control.proto",
+}
+
+// RegisterControlServer registers srv as the control service of s.
+func RegisterControlServer(s *grpc.Server, srv ControlServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+// ControlClient is a typed client of master's control service.
+type ControlClient struct {
+	cc   grpc.ClientConnInterface
+	opts []grpc.CallOption
+}
+
+// NewControlClient returns a ControlClient calling through cc with opts, e.g.
+// grpc.CallContentSubtype("json") to debug with JSON encoded messages.
+func NewControlClient(cc grpc.ClientConnInterface, opts ...grpc.CallOption) *ControlClient {
+	return &ControlClient{cc: cc, opts: opts}
+}
+
+func (c *ControlClient) invoke(ctx context.Context, method string, req, rsp interface{}) error {
+	return c.cc.Invoke(ctx, "/"+ServiceName+"/"+method, req, rsp, c.opts...)
+}
+
+// Nodes returns all nodes known to master.
+func (c *ControlClient) Nodes(ctx context.Context) ([]Node, error) {
+	var rsp NodesResponse
+	if err := c.invoke(ctx, "Nodes", &NodesRequest{}, &rsp); err != nil {
+		return nil, err
+	}
+	return rsp.Nodes, nil
+}
+
+// SetPosition moves node with hardware address addr to pos.
+func (c *ControlClient) SetPosition(ctx context.Context, addr string, pos Position) error {
+	return c.invoke(ctx, "SetPosition", &SetPositionRequest{Addr: addr, Position: pos}, &SetPositionResponse{})
+}
+
+// SetEnabled enables or disables node with hardware address addr.
+func (c *ControlClient) SetEnabled(ctx context.Context, addr string, enabled bool) error {
+	return c.invoke(ctx, "SetEnabled", &SetEnabledRequest{Addr: addr, Enabled: enabled}, &SetEnabledResponse{})
+}
+
+// LinkQuality returns quality of the link from node with hardware address
+// from to that with to.
+func (c *ControlClient) LinkQuality(ctx context.Context, from, to string) (*LinkQuality, error) {
+	var rsp LinkQualityResponse
+	if err := c.invoke(ctx, "LinkQuality", &LinkQualityRequest{From: from, To: to}, &rsp); err != nil {
+		return nil, err
+	}
+	return &rsp.Quality, nil
+}
+
+func (c *ControlClient) watch(ctx context.Context, method string, req interface{}) (grpc.ClientStream, error) {
+	desc := &grpc.StreamDesc{StreamName: method, ServerStreams: true}
+	stream, err := c.cc.NewStream(ctx, desc, "/"+ServiceName+"/"+method, c.opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = stream.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err = stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// PositionStream receives PositionEvents of WatchPositions.
+type PositionStream struct {
+	grpc.ClientStream
+}
+
+func (s PositionStream) Recv() (*PositionEvent, error) {
+	e := new(PositionEvent)
+	if err := s.RecvMsg(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// WatchPositions streams moves of nodes until ctx is done.
+func (c *ControlClient) WatchPositions(ctx context.Context) (PositionStream, error) {
+	stream, err := c.watch(ctx, "WatchPositions", &WatchPositionsRequest{})
+	return PositionStream{stream}, err
+}
+
+// LinkStream receives LinkEvents of WatchLinks.
+type LinkStream struct {
+	grpc.ClientStream
+}
+
+func (s LinkStream) Recv() (*LinkEvent, error) {
+	e := new(LinkEvent)
+	if err := s.RecvMsg(e); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// WatchLinks streams links coming up and going down, checked every interval
+// (a second if it's 0), until ctx is done. Links that are up when it starts
+// come first.
+func (c *ControlClient) WatchLinks(ctx context.Context, interval time.Duration) (LinkStream, error) {
+	stream, err := c.watch(ctx, "WatchLinks", &WatchLinksRequest{IntervalMS: interval.Milliseconds()})
+	return LinkStream{stream}, err
+}
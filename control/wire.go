@@ -0,0 +1,162 @@
+package control
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// message is implemented by messages of the control service, which encode
+// themselves in protobuf wire format as control.proto defines them, so that
+// no generated code is needed to build squirrel.
+type message interface {
+	appendWire(b []byte) []byte
+	consumeWire(b []byte) error
+}
+
+// appendDouble appends field num unless v is 0. Like other append functions,
+// it leaves out fields at their defaults, as proto3 does.
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	return appendOptionalDouble(b, num, &v)
+}
+
+func appendOptionalDouble(b []byte, num protowire.Number, v *float64) []byte {
+	if v == nil {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(*v))
+}
+
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, protowire.EncodeBool(v))
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+// appendMessage appends m even if it's empty, so that it's set rather than
+// unset.
+func appendMessage(b []byte, num protowire.Number, m message) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m.appendWire(nil))
+}
+
+// wireField is a field of an encoded message, with v holding its value.
+type wireField struct {
+	num protowire.Number
+	typ protowire.Type
+	v   []byte
+}
+
+// skipField is for messages without fields.
+func skipField(wireField) error { return nil }
+
+// consumeFields calls f with each field encoded in b. Fields that f doesn't
+// know of are to be skipped, for messages from newer peers.
+func consumeFields(b []byte, f func(field wireField) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		m := protowire.ConsumeFieldValue(num, typ, b)
+		if m < 0 {
+			return protowire.ParseError(m)
+		}
+		if err := f(wireField{num: num, typ: typ, v: b[:m]}); err != nil {
+			return err
+		}
+		b = b[m:]
+	}
+	return nil
+}
+
+func (f wireField) check(typ protowire.Type) error {
+	if f.typ != typ {
+		return fmt.Errorf("field %d has wire type %d (expected %d)", f.num, f.typ, typ)
+	}
+	return nil
+}
+
+func (f wireField) double() (float64, error) {
+	if err := f.check(protowire.Fixed64Type); err != nil {
+		return 0, err
+	}
+	v, _ := protowire.ConsumeFixed64(f.v)
+	return math.Float64frombits(v), nil
+}
+
+func (f wireField) optionalDouble() (*float64, error) {
+	v, err := f.double()
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (f wireField) varint() (uint64, error) {
+	if err := f.check(protowire.VarintType); err != nil {
+		return 0, err
+	}
+	v, _ := protowire.ConsumeVarint(f.v)
+	return v, nil
+}
+
+func (f wireField) int32() (int, error) {
+	v, err := f.varint()
+	return int(int32(v)), err
+}
+
+func (f wireField) int64() (int64, error) {
+	v, err := f.varint()
+	return int64(v), err
+}
+
+func (f wireField) bool() (bool, error) {
+	v, err := f.varint()
+	return protowire.DecodeBool(v), err
+}
+
+func (f wireField) bytes() ([]byte, error) {
+	if err := f.check(protowire.BytesType); err != nil {
+		return nil, err
+	}
+	v, _ := protowire.ConsumeBytes(f.v)
+	return v, nil
+}
+
+func (f wireField) string() (string, error) {
+	v, err := f.bytes()
+	return string(v), err
+}
+
+func (f wireField) message(m message) error {
+	v, err := f.bytes()
+	if err != nil {
+		return err
+	}
+	return m.consumeWire(v)
+}
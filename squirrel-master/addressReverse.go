@@ -38,3 +38,15 @@ func (a *addressReverse) GetS(addr string) (identity int, ok bool) {
 	identity, ok = a.addrs[strings.ToLower(addr)]
 	return
 }
+
+// All returns a copy of the mapping from (lower-cased) hardware addresses to
+// identities.
+func (a *addressReverse) All() map[string]int {
+	a.RLock()
+	defer a.RUnlock()
+	ret := make(map[string]int, len(a.addrs))
+	for addr, identity := range a.addrs {
+		ret[addr] = identity
+	}
+	return ret
+}
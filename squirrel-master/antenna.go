@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+type antennaParameters struct {
+	Pattern   string  `etcd:"pattern" default:"isotropic"`
+	Gain      float64 `etcd:"gain" default:"0"`
+	Beamwidth float64 `etcd:"beamwidth" default:"60"`
+	SideLobe  float64 `etcd:"side_lobe" default:"-20"`
+	Gains     string  `etcd:"gains"`
+	Direction float64 `etcd:"direction" default:"0"`
+	Tilt      float64 `etcd:"tilt" default:"0"`
+}
+
+// antennaHelp documents antenna parameters of radio propagation Septembers,
+// for ParametersHelp.
+const antennaHelp = `
+  antennas/<name>/pattern [Optional]:
+    Radiation pattern of antenna <name>, which nodes use when their metadata
+    "antenna" is <name>: isotropic, sector (gain within beamwidth of
+    boresight, side_lobe elsewhere), parabolic (gain falling off by 12 dB
+    times the square of angle over beamwidth, down to side_lobe, as in 3GPP
+    TR 38.901) or table (gains). Gains of both ends add to tx_power, at the
+    angles between boresight of each and the other one. Default: isotropic
+
+  antennas/<name>/gain, antennas/<name>/side_lobe [Optional]:
+    Gain in dBi on boresight, and outside the main lobe of sector and
+    parabolic patterns. Default: 0 and -20
+
+  antennas/<name>/beamwidth [Optional]:
+    Half-power beamwidth in degrees of sector and parabolic patterns.
+    Default: 60
+
+  antennas/<name>/gains [Optional]:
+    Gains of the table pattern, as comma separated <degrees>:<dBi> pairs of
+    angles off boresight from 0 to 180, e.g. 0:12,30:9,60:0,180:-15; gains in
+    between are interpolated linearly.
+
+  antennas/<name>/direction, antennas/<name>/tilt [Optional]:
+    Boresight in degrees counterclockwise from heading of the node, and
+    above its pitch, e.g. for sectors of a base station. Nodes face where
+    their orientation says, as set by the Mobility Manager or the control
+    API. Default: 0 and 0
+
+  default_antenna [Optional]:
+    Name of the antenna of nodes without metadata "antenna", or with one not
+    in antennas. Default: isotropic with no gain
+`
+
+// antenna is a radiation pattern, symmetric around boresight.
+type antenna struct {
+	gain      func(angle float64) float64 // dBi at angle in radians off boresight
+	peak      float64                     // the highest gain
+	direction float64                     // boresight relative to heading, in radians
+	tilt      float64                     // boresight relative to pitch, in radians
+}
+
+func newAntenna(params antennaParameters) (*antenna, error) {
+	a := &antenna{peak: params.Gain, direction: params.Direction * math.Pi / 180, tilt: params.Tilt * math.Pi / 180}
+	beamwidth := params.Beamwidth * math.Pi / 180
+	switch params.Pattern {
+	case "isotropic":
+		a.gain = func(float64) float64 { return params.Gain }
+	case "sector", "parabolic":
+		if beamwidth <= 0 {
+			return nil, fmt.Errorf("beamwidth needs to be positive (got %v)", params.Beamwidth)
+		}
+		if params.SideLobe > params.Gain {
+			return nil, fmt.Errorf("side_lobe cannot be higher than gain (got %v and %v)", params.SideLobe, params.Gain)
+		}
+		if params.Pattern == "sector" {
+			a.gain = func(angle float64) float64 {
+				if angle <= beamwidth/2 {
+					return params.Gain
+				}
+				return params.SideLobe
+			}
+		} else {
+			a.gain = func(angle float64) float64 {
+				return params.Gain - math.Min(12*(angle/beamwidth)*(angle/beamwidth), params.Gain-params.SideLobe)
+			}
+		}
+	case "table":
+		angles, gains, err := parseGainTable(params.Gains)
+		if err != nil {
+			return nil, fmt.Errorf("gains: %v", err)
+		}
+		a.peak = gains[0]
+		for _, g := range gains {
+			a.peak = math.Max(a.peak, g)
+		}
+		a.gain = func(angle float64) float64 {
+			i := sort.SearchFloat64s(angles, angle)
+			if i == 0 {
+				return gains[0]
+			}
+			if i == len(angles) {
+				return gains[len(gains)-1]
+			}
+			return gains[i-1] + (gains[i]-gains[i-1])*(angle-angles[i-1])/(angles[i]-angles[i-1])
+		}
+	default:
+		return nil, fmt.Errorf("unknown pattern %s (expected isotropic, sector, parabolic or table)", params.Pattern)
+	}
+	return a, nil
+}
+
+// parseGainTable parses gains in the form of "deg:dBi,deg:dBi,...", into
+// angles in radians in increasing order and gains at them.
+func parseGainTable(s string) (angles, gains []float64, err error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil, fmt.Errorf("no gains")
+	}
+	for _, entry := range strings.Split(s, ",") {
+		fields := strings.Split(strings.TrimSpace(entry), ":")
+		if len(fields) != 2 {
+			return nil, nil, fmt.Errorf("invalid entry %q (expected <degrees>:<dBi>)", entry)
+		}
+		angle, err1 := strconv.ParseFloat(fields[0], 64)
+		gain, err2 := strconv.ParseFloat(fields[1], 64)
+		if err1 != nil || err2 != nil {
+			return nil, nil, fmt.Errorf("invalid entry %q (expected <degrees>:<dBi>)", entry)
+		}
+		if angle < 0 || angle > 180 {
+			return nil, nil, fmt.Errorf("angle %v is not within 0 and 180", angle)
+		}
+		angle *= math.Pi / 180
+		if len(angles) > 0 && angle <= angles[len(angles)-1] {
+			return nil, nil, fmt.Errorf("angles need to be increasing (got %q)", entry)
+		}
+		angles = append(angles, angle)
+		gains = append(gains, gain)
+	}
+	return
+}
+
+// gainTowards returns gain of a facing o, towards a target offset by v in
+// meters (X east, Y north, Height up in geographic mode). A nil antenna is
+// isotropic with no gain.
+func (a *antenna) gainTowards(o squirrel.Orientation, v squirrel.Position) float64 {
+	if a == nil {
+		return 0
+	}
+	norm := math.Sqrt(v.X*v.X + v.Y*v.Y + v.Height*v.Height)
+	if norm == 0 {
+		return a.peak
+	}
+	heading, pitch := o.Heading+a.direction, o.Pitch+a.tilt
+	cos := (math.Cos(pitch)*math.Cos(heading)*v.X + math.Cos(pitch)*math.Sin(heading)*v.Y + math.Sin(pitch)*v.Height) / norm
+	return a.gain(math.Acos(math.Max(-1, math.Min(1, cos))))
+}
+
+// antennas are antennas of nodes, by name.
+type antennas struct {
+	named    map[string]*antenna
+	fallback *antenna // nil for isotropic with no gain
+	peak     float64  // the highest gain of any of them
+}
+
+// newAntennas returns nil if all nodes are isotropic with no gain.
+func newAntennas(params map[string]antennaParameters, defaultAntenna string) (*antennas, error) {
+	if len(params) == 0 && defaultAntenna == "" {
+		return nil, nil
+	}
+	a := &antennas{named: make(map[string]*antenna, len(params)), peak: math.Inf(-1)}
+	for name, p := range params {
+		an, err := newAntenna(p)
+		if err != nil {
+			return nil, fmt.Errorf("antennas/%s: %v", name, err)
+		}
+		a.named[name] = an
+		a.peak = math.Max(a.peak, an.peak)
+	}
+	if defaultAntenna != "" {
+		var ok bool
+		if a.fallback, ok = a.named[defaultAntenna]; !ok {
+			return nil, fmt.Errorf("default_antenna %s doesn't exist in antennas", defaultAntenna)
+		}
+	} else {
+		// nodes without one are isotropic
+		a.peak = math.Max(a.peak, 0)
+	}
+	return a, nil
+}
+
+// of returns antenna of node at index. A nil antennas has none.
+func (a *antennas) of(positionManager squirrel.PositionManager, index int) *antenna {
+	if a == nil {
+		return nil
+	}
+	if name, ok := positionManager.GetMetadata(index, "antenna"); ok {
+		if an, ok := a.named[name]; ok {
+			return an
+		}
+	}
+	return a.fallback
+}
+
+// margin returns how much stronger than without antennas a link can be, with
+// both ends at their highest gain, or 0 if there are no antennas.
+func (a *antennas) margin() float64 {
+	if a == nil {
+		return 0
+	}
+	return 2 * a.peak
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// arenaPolicy defines what happens when a node is moved out of arena.
+type arenaPolicy int
+
+const (
+	arenaReject arenaPolicy = iota // the move fails with an error
+	arenaClamp                     // the node is kept at arena boundary
+	arenaWrap                      // the node re-enters from the opposite side
+)
+
+func parseArenaPolicy(s string) (policy arenaPolicy, err error) {
+	switch s {
+	case "reject":
+		policy = arenaReject
+	case "clamp":
+		policy = arenaClamp
+	case "wrap":
+		policy = arenaWrap
+	default:
+		err = fmt.Errorf("unknown arena policy %s (expected reject, clamp or wrap)", s)
+	}
+	return
+}
+
+// arena is a bounding box that positions should be in. Unbounded dimensions
+// have infinite bounds.
+type arena struct {
+	min    squirrel.Position
+	max    squirrel.Position
+	policy arenaPolicy
+}
+
+func newArena() *arena {
+	return &arena{
+		min: squirrel.Position{X: math.Inf(-1), Y: math.Inf(-1), Height: math.Inf(-1)},
+		max: squirrel.Position{X: math.Inf(1), Y: math.Inf(1), Height: math.Inf(1)},
+	}
+}
+
+func (a *arena) contains(pos squirrel.Position) bool {
+	return pos.X >= a.min.X && pos.X <= a.max.X &&
+		pos.Y >= a.min.Y && pos.Y <= a.max.Y &&
+		pos.Height >= a.min.Height && pos.Height <= a.max.Height
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(min, math.Min(max, v))
+}
+
+func wrap(v, min, max float64) float64 {
+	if math.IsInf(min, 0) || math.IsInf(max, 0) || max <= min {
+		return clamp(v, min, max)
+	}
+	v = math.Mod(v-min, max-min)
+	if v < 0 {
+		v += max - min
+	}
+	return min + v
+}
+
+// apply enforces arena on pos according to policy.
+func (a *arena) apply(pos squirrel.Position) (squirrel.Position, error) {
+	if a.contains(pos) {
+		return pos, nil
+	}
+	switch a.policy {
+	case arenaClamp:
+		return squirrel.Position{
+			X:      clamp(pos.X, a.min.X, a.max.X),
+			Y:      clamp(pos.Y, a.min.Y, a.max.Y),
+			Height: clamp(pos.Height, a.min.Height, a.max.Height),
+		}, nil
+	case arenaWrap:
+		return squirrel.Position{
+			X:      wrap(pos.X, a.min.X, a.max.X),
+			Y:      wrap(pos.Y, a.min.Y, a.max.Y),
+			Height: wrap(pos.Height, a.min.Height, a.max.Height),
+		}, nil
+	default:
+		return pos, fmt.Errorf("position %v is out of arena (%v to %v)", pos, a.min, a.max)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// attachment makes a node positioned relative to another (parent) node, e.g.
+// devices mounted on the same vehicle.
+type attachment struct {
+	parent int
+	offset squirrel.Position
+}
+
+func addOffset(pos, offset squirrel.Position) squirrel.Position {
+	return squirrel.Position{X: pos.X + offset.X, Y: pos.Y + offset.Y, Height: pos.Height + offset.Height}
+}
+
+func (p *PositionManager) attachedTo(index int) (a attachment, ok bool) {
+	p.muAttach.RLock()
+	defer p.muAttach.RUnlock()
+	a, ok = p.attachments[index]
+	return
+}
+
+// descendants returns positions of nodes attached directly or indirectly to
+// node at index, given that it's at pos.
+func (p *PositionManager) descendants(index int, pos squirrel.Position) (ret []squirrel.PositionUpdate) {
+	p.muAttach.RLock()
+	defer p.muAttach.RUnlock()
+	if len(p.attachments) == 0 {
+		return
+	}
+	queue := []squirrel.PositionUpdate{{Index: index, Position: pos}}
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		for child, a := range p.attachments {
+			if a.parent == u.Index {
+				c := squirrel.PositionUpdate{Index: child, Position: addOffset(u.Position, a.offset)}
+				ret = append(ret, c)
+				queue = append(queue, c)
+			}
+		}
+	}
+	return
+}
+
+// attachedMoved updates derived state of node at index and its descendants,
+// whose positions are derived from their parents'.
+func (p *PositionManager) attachedMoved(index int, pos squirrel.Position) {
+	updates := append([]squirrel.PositionUpdate{{Index: index, Position: pos}}, p.descendants(index, pos)...)
+	for _, u := range updates {
+		p.invalidateDistances(u.Index)
+		p.index.update(u.Index, p.cartesian(u.Position))
+	}
+	for _, u := range updates {
+		p.notifyPositionChanged(u.Index, u.Position)
+	}
+}
+
+// Attach makes node at index positioned at offset relative to node at parent.
+// Positions set on an attached node are ignored until it's detached.
+func (p *PositionManager) Attach(index, parent int, offset *squirrel.Position) (err error) {
+	if index == parent {
+		return fmt.Errorf("node with index %d cannot be attached to itself", index)
+	}
+	if _, err = p.Get(index); err != nil {
+		return
+	}
+	var parentPos squirrel.Position
+	if parentPos, err = p.Get(parent); err != nil {
+		return
+	}
+	p.muAttach.Lock()
+	for i := parent; ; {
+		a, ok := p.attachments[i]
+		if !ok {
+			break
+		}
+		if a.parent == index {
+			p.muAttach.Unlock()
+			return fmt.Errorf("attaching node with index %d to %d makes a cycle", index, parent)
+		}
+		i = a.parent
+	}
+	a := attachment{parent: parent, offset: p.scaled(*offset)}
+	p.attachments[index] = a
+	p.muAttach.Unlock()
+	p.attachedMoved(index, addOffset(parentPos, a.offset))
+	return
+}
+
+func (p *PositionManager) AttachAddr(hardAddr, parentAddr string, offset *squirrel.Position) (err error) {
+	id, ok := p.addrReverse.GetS(hardAddr)
+	if !ok {
+		return fmt.Errorf("node with hardware address %s is not found", hardAddr)
+	}
+	parent, ok := p.addrReverse.GetS(parentAddr)
+	if !ok {
+		return fmt.Errorf("node with hardware address %s is not found", parentAddr)
+	}
+	return p.Attach(id, parent, offset)
+}
+
+// Detach makes an attached node positioned independently again, at where it
+// currently is.
+func (p *PositionManager) Detach(index int) (err error) {
+	var pos squirrel.Position
+	if pos, err = p.Get(index); err != nil {
+		return
+	}
+	p.muAttach.Lock()
+	delete(p.attachments, index)
+	p.muAttach.Unlock()
+	p.storePosition(index, pos)
+	return
+}
+
+// detachAll removes all attachments involving node at index, which is at pos
+// and is being disabled. Nodes directly attached to it become independent at
+// where they currently are.
+func (p *PositionManager) detachAll(index int, pos squirrel.Position) {
+	if _, ok := p.attachedTo(index); ok {
+		p.muAttach.Lock()
+		delete(p.attachments, index)
+		p.muAttach.Unlock()
+	}
+	var children []squirrel.PositionUpdate
+	p.muAttach.Lock()
+	for child, a := range p.attachments {
+		if a.parent == index {
+			children = append(children, squirrel.PositionUpdate{Index: child, Position: addOffset(pos, a.offset)})
+			delete(p.attachments, child)
+		}
+	}
+	p.muAttach.Unlock()
+	for _, c := range children {
+		p.storePosition(c.Index, c.Position)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/common"
+)
+
+// timedPosition is a waypoint of a node: where it is at a point of time.
+type timedPosition struct {
+	at  time.Duration
+	pos squirrel.Position
+}
+
+// interpolate returns position at t on a path through waypoints, which are
+// ordered by time. Nodes stay at first and last waypoints before and after
+// the path.
+func interpolate(waypoints []timedPosition, t time.Duration) squirrel.Position {
+	i := sort.Search(len(waypoints), func(i int) bool { return waypoints[i].at > t })
+	if i == 0 {
+		return waypoints[0].pos
+	}
+	if i == len(waypoints) {
+		return waypoints[i-1].pos
+	}
+	a, b := waypoints[i-1], waypoints[i]
+	f := float64(t-a.at) / float64(b.at-a.at)
+	return squirrel.Position{
+		X:      a.pos.X + (b.pos.X-a.pos.X)*f,
+		Y:      a.pos.Y + (b.pos.Y-a.pos.Y)*f,
+		Height: a.pos.Height + (b.pos.Height-a.pos.Height)*f,
+	}
+}
+
+// lastWaypoint returns time of the last waypoint of any node.
+func lastWaypoint(nodes [][]timedPosition) (last time.Duration) {
+	for _, waypoints := range nodes {
+		if n := len(waypoints); n > 0 && waypoints[n-1].at > last {
+			last = waypoints[n-1].at
+		}
+	}
+	return
+}
+
+// parseBonnMotion parses a BonnMotion movements file, gzipped or not, where
+// line N is node N's waypoints as "time x y" (or "time x y z" if dimensions is
+// 3) repeated.
+func parseBonnMotion(filename string, dimensions int) (nodes [][]timedPosition, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(br); err != nil {
+			return
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	width := dimensions + 1
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<26) // lines of long traces are long
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields)%width != 0 {
+			return nil, fmt.Errorf("%s:%d: number of fields is not a multiple of %d", filename, lineNo, width)
+		}
+		waypoints := make([]timedPosition, 0, len(fields)/width)
+		for i := 0; i < len(fields); i += width {
+			var v [4]float64
+			for j := 0; j < width; j++ {
+				if v[j], err = strconv.ParseFloat(fields[i+j], 64); err != nil {
+					return nil, fmt.Errorf("%s:%d: %v", filename, lineNo, err)
+				}
+			}
+			w := timedPosition{at: time.Duration(v[0] * float64(time.Second)), pos: squirrel.Position{X: v[1], Y: v[2], Height: v[3]}}
+			if n := len(waypoints); n > 0 && w.at < waypoints[n-1].at {
+				return nil, fmt.Errorf("%s:%d: waypoints are not in order of time", filename, lineNo)
+			}
+			waypoints = append(waypoints, w)
+		}
+		nodes = append(nodes, waypoints)
+	}
+	err = scanner.Err()
+	return
+}
+
+type bonnMotionParameters struct {
+	Path       string        `etcd:"path,required"`
+	Dimensions int           `etcd:"dimensions" default:"2"`
+	Nodes      string        `etcd:"nodes"`
+	Interval   time.Duration `etcd:"interval" default:"100ms"`
+}
+
+// bonnMotionReplay moves nodes along waypoints in a BonnMotion movements file,
+// with time 0 of the file being when the Mobility Manager is initialized.
+type bonnMotionReplay struct {
+	nodes     traceNodes
+	waypoints [][]timedPosition // by trace node
+	interval  time.Duration
+}
+
+func newBonnMotionReplay() squirrel.MobilityManager {
+	return &bonnMotionReplay{}
+}
+
+func (m *bonnMotionReplay) ParametersHelp() string {
+	return `
+  path [Required]:
+    Path to a BonnMotion movements file, e.g. scenario.movements.gz.
+
+  dimensions [Optional]:
+    2, or 3 if the scenario is generated with z coordinates. Default: 2
+` + traceNodesHelp + `
+  interval [Optional]:
+    How often positions are updated. Default: 100ms
+    `
+}
+
+func (m *bonnMotionReplay) Configure(conf *etcd.Node) (err error) {
+	var params bonnMotionParameters
+	if err = common.DecodeParameters(conf, &params); err != nil {
+		return
+	}
+	if params.Dimensions != 2 && params.Dimensions != 3 {
+		return fmt.Errorf("dimensions needs to be 2 or 3 (got %d)", params.Dimensions)
+	}
+	if params.Interval <= 0 {
+		return fmt.Errorf("interval needs to be positive (got %v)", params.Interval)
+	}
+	m.interval = params.Interval
+	if m.nodes.addrs, err = parseTraceNodes(params.Nodes); err != nil {
+		return
+	}
+	m.waypoints, err = parseBonnMotion(params.Path, params.Dimensions)
+	return
+}
+
+func (m *bonnMotionReplay) Initialize(positionManager squirrel.PositionManager) {
+	m.nodes.positionManager = positionManager
+	m.nodes.replay("bonnmotion", clock.Now(), m.interval, lastWaypoint(m.waypoints), func(t time.Duration) map[int]squirrel.Position {
+		positions := make(map[int]squirrel.Position, len(m.waypoints))
+		for id, waypoints := range m.waypoints {
+			if len(waypoints) > 0 {
+				positions[id] = interpolate(waypoints, t)
+			}
+		}
+		return positions
+	})
+}
+
+// Stop stops replaying.
+func (m *bonnMotionReplay) Stop() {
+	m.nodes.stop()
+}
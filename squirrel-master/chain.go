@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// septemberChain is a September made of stages, each deciding on packets that
+// earlier ones deliver, e.g. LogDistanceSeptember followed by StaticSeptember
+// to keep propagation only on links of a topology. Stages that are
+// squirrel.Stages get each packet along with what earlier stages have noted
+// about it. The first stage picks recipients of broadcast packets.
+type septemberChain struct {
+	names  []string
+	stages []squirrel.September
+
+	conf []*etcd.Node // of each stage, for which ones Reconfigure changes
+	mu   sync.Mutex   // conf
+}
+
+// parseSeptemberNames splits a comma separated list of names of Septembers.
+func parseSeptemberNames(value string) (names []string) {
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return
+}
+
+// newSeptemberChain creates stages named in names, in order.
+func newSeptemberChain(names []string) (*septemberChain, error) {
+	c := &septemberChain{names: names, conf: make([]*etcd.Node, len(names))}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("September %s is in the chain more than once", name)
+		}
+		seen[name] = true
+		stage, err := newSeptember(name)
+		if err != nil {
+			return nil, fmt.Errorf("September %s: %v", name, err)
+		}
+		c.stages = append(c.stages, stage)
+	}
+	return c, nil
+}
+
+func (c *septemberChain) ParametersHelp() string {
+	help := `
+  Parameters of each stage of the chain are in a child Dir named after it,
+  e.g. LogDistanceSeptember/tx_power.
+`
+	for i, stage := range c.stages {
+		help += fmt.Sprintf("\n%s/:\n%s", c.names[i], stage.ParametersHelp())
+	}
+	return help
+}
+
+// stageConf returns the child of conf named after stage i, or nil if there's
+// none.
+func (c *septemberChain) stageConf(conf *etcd.Node, i int) *etcd.Node {
+	if conf == nil {
+		return nil
+	}
+	for _, child := range conf.Nodes {
+		if path.Base(child.Key) == c.names[i] {
+			return child
+		}
+	}
+	return nil
+}
+
+func (c *septemberChain) Configure(conf *etcd.Node) error {
+	for i, stage := range c.stages {
+		c.conf[i] = c.stageConf(conf, i)
+		if err := stage.Configure(c.conf[i]); err != nil {
+			return fmt.Errorf("%s: %v", c.names[i], err)
+		}
+	}
+	return nil
+}
+
+// Reconfigure reconfigures stages whose parameters are changed.
+func (c *septemberChain) Reconfigure(conf *etcd.Node) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, stage := range c.stages {
+		stageConf := c.stageConf(conf, i)
+		if sameEtcdNode(c.conf[i], stageConf) {
+			continue
+		}
+		if err := reconfigure(stage, "September "+c.names[i], stageConf); err != nil {
+			return err
+		}
+		c.conf[i] = stageConf
+	}
+	return nil
+}
+
+// SetRandomSource hands random to stages that draw from master's
+// RandomSource.
+func (c *septemberChain) SetRandomSource(random squirrel.RandomSource) {
+	for _, stage := range c.stages {
+		if r, ok := stage.(squirrel.Randomized); ok {
+			r.SetRandomSource(random)
+		}
+	}
+}
+
+func (c *septemberChain) Initialize(positionManager squirrel.PositionManager) {
+	for _, stage := range c.stages {
+		stage.Initialize(positionManager)
+	}
+}
+
+// sendUnicastPacket sends packet to destination under september, by
+// SendUnicastPacket if it's a squirrel.PacketSeptember.
+func sendUnicastPacket(september squirrel.September, packet *squirrel.Packet, destination int) bool {
+	if s, ok := september.(squirrel.PacketSeptember); ok {
+		return s.SendUnicastPacket(packet, destination)
+	}
+	return september.SendUnicast(packet.Source, destination, packet.Size)
+}
+
+// sendBroadcastPacket broadcasts packet under september, by
+// SendBroadcastPacket if it's a squirrel.PacketSeptember.
+func sendBroadcastPacket(september squirrel.September, packet *squirrel.Packet, underlying []int) []int {
+	if s, ok := september.(squirrel.PacketSeptember); ok {
+		return s.SendBroadcastPacket(packet, underlying)
+	}
+	return september.SendBroadcast(packet.Source, packet.Size, underlying)
+}
+
+func (c *septemberChain) SendUnicast(source int, destination int, size int) bool {
+	return c.SendUnicastPacket(&squirrel.Packet{Source: source, Size: size}, destination)
+}
+
+func (c *septemberChain) SendUnicastPacket(packet *squirrel.Packet, destination int) bool {
+	for _, stage := range c.stages {
+		if st, ok := stage.(squirrel.Stage); ok {
+			if !st.Unicast(packet, destination) {
+				return false
+			}
+		} else if !sendUnicastPacket(stage, packet, destination) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *septemberChain) SendBroadcast(source int, size int, underlying []int) []int {
+	return c.SendBroadcastPacket(&squirrel.Packet{Source: source, Size: size}, underlying)
+}
+
+func (c *septemberChain) SendBroadcastPacket(packet *squirrel.Packet, underlying []int) []int {
+	recipients := sendBroadcastPacket(c.stages[0], packet, underlying)
+	for _, stage := range c.stages[1:] {
+		if len(recipients) == 0 {
+			break
+		}
+		if st, ok := stage.(squirrel.Stage); ok {
+			recipients = st.Broadcast(packet, recipients)
+			continue
+		}
+		// underlying is taken by recipients, so the stage gets one of its own
+		delivered := make(map[int]bool)
+		for _, id := range sendBroadcastPacket(stage, packet, make([]int, len(underlying))) {
+			delivered[id] = true
+		}
+		kept := recipients[:0]
+		for _, id := range recipients {
+			if delivered[id] {
+				kept = append(kept, id)
+			}
+		}
+		recipients = kept
+	}
+	return recipients
+}
+
+// Delay returns the sum of delays of stages that delay packets.
+func (c *septemberChain) Delay(source int, destination int, size int) (delay time.Duration) {
+	for _, stage := range c.stages {
+		if d, ok := stage.(squirrel.Delayer); ok {
+			delay += d.Delay(source, destination, size)
+		}
+	}
+	return
+}
+
+// DelayBroadcast sets delays to sums of those of stages that delay packets,
+// at once for stages that can.
+func (c *septemberChain) DelayBroadcast(source int, size int, recipients []int, delays []time.Duration) {
+	for i := range delays {
+		delays[i] = 0
+	}
+	var stageDelays []time.Duration
+	for _, stage := range c.stages {
+		switch d := stage.(type) {
+		case squirrel.BroadcastDelayer:
+			if stageDelays == nil {
+				stageDelays = make([]time.Duration, len(recipients))
+			}
+			d.DelayBroadcast(source, size, recipients, stageDelays)
+			for i := range delays {
+				delays[i] += stageDelays[i]
+			}
+		case squirrel.Delayer:
+			for i, id := range recipients {
+				delays[i] += d.Delay(source, id, size)
+			}
+		}
+	}
+}
+
+// LinkQuality combines qualities of stages: a packet is lost unless all of
+// them deliver it, and RSSI, SNR and rate are of the last stage that models
+// them. It's false unless all stages tell.
+func (c *septemberChain) LinkQuality(source int, destination int) (squirrel.LinkQuality, bool) {
+	q := squirrel.LinkQuality{RSSI: math.NaN(), SNR: math.NaN()}
+	delivered := 1.0
+	for _, stage := range c.stages {
+		lq, ok := stage.(squirrel.LinkQualifier)
+		if !ok {
+			return q, false
+		}
+		sq, ok := lq.LinkQuality(source, destination)
+		if !ok {
+			return q, false
+		}
+		delivered *= 1 - sq.Loss
+		if !math.IsNaN(sq.RSSI) {
+			q.RSSI, q.SNR = sq.RSSI, sq.SNR
+		}
+		if sq.Rate > 0 {
+			q.Rate = sq.Rate
+		}
+	}
+	q.Loss = 1 - delivered
+	return q, true
+}
+
+// confirmation returns a function that tells whether a packet is still
+// delivered by all stages that can reverse their decisions, or nil if none
+// can.
+func (c *septemberChain) confirmation(source, destination int) func() bool {
+	var confirms []func() bool
+	for _, stage := range c.stages {
+		if cf, ok := stage.(confirmer); ok {
+			if confirm := cf.confirmation(source, destination); confirm != nil {
+				confirms = append(confirms, confirm)
+			}
+		}
+	}
+	switch len(confirms) {
+	case 0:
+		return nil
+	case 1:
+		return confirms[0]
+	}
+	return func() bool {
+		for _, confirm := range confirms {
+			if !confirm() {
+				return false
+			}
+		}
+		return true
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel/common"
+)
+
+// configClient reads master's configuration from etcd. ${NAME} in values is
+// substituted with environment variable NAME or, if it is not set, with
+// /squirrel/master/vars/NAME, so that one template can be used for many
+// configurations. $$ is a literal $.
+type configClient struct {
+	*etcd.Client
+	vars map[string]string
+}
+
+func newConfigClient(client *etcd.Client, varsDir string) (c *configClient, err error) {
+	c = &configClient{Client: client, vars: make(map[string]string)}
+	var resp *etcd.Response
+	resp, err = client.Get(varsDir, false, true)
+	if err != nil {
+		if common.IsEtcdNotFoundError(err) {
+			err = nil
+		}
+		return
+	}
+	if !resp.Node.Dir {
+		err = fmt.Errorf("%s is not a Dir node", varsDir)
+		return
+	}
+	for _, node := range resp.Node.Nodes {
+		if node.Dir {
+			err = fmt.Errorf("%s is a Dir (expected a value)", node.Key)
+			return
+		}
+		c.vars[path.Base(node.Key)] = node.Value
+	}
+	return
+}
+
+// expand substitutes variables in value of key.
+func (c *configClient) expand(value string, key string) (string, error) {
+	if !strings.Contains(value, "$") {
+		return value, nil
+	}
+	var b bytes.Buffer
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 == len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+		switch value[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(value[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated ${ in %s", key)
+			}
+			name := value[i+2 : i+end]
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				if v, ok = c.vars[name]; !ok {
+					return "", fmt.Errorf("undefined variable %s in %s", name, key)
+				}
+			}
+			b.WriteString(v)
+			i += end
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String(), nil
+}
+
+func (c *configClient) expandNode(node *etcd.Node) (err error) {
+	if !node.Dir {
+		node.Value, err = c.expand(node.Value, node.Key)
+		return
+	}
+	for _, child := range node.Nodes {
+		if err = c.expandNode(child); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Get is like etcd.Client.Get, but with variables in values substituted.
+func (c *configClient) Get(key string, sort, recursive bool) (resp *etcd.Response, err error) {
+	resp, err = c.Client.Get(key, sort, recursive)
+	if err != nil {
+		return
+	}
+	err = c.expandNode(resp.Node)
+	return
+}
@@ -0,0 +1,751 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// controlStatus is the body of GET /mobility on the control API.
+type controlStatus struct {
+	MobilityManager string     `json:"mobility_manager"`
+	Paused          bool       `json:"paused"`
+	TimeScale       float64    `json:"time_scale"`
+	Seeds           []usedSeed `json:"seeds"`
+}
+
+// controlSwitch is the body of PUT /mobility/manager.
+type controlSwitch struct {
+	MobilityManager string `json:"mobility_manager"`
+	ConfigPath      string `json:"config_path"`
+}
+
+// controlStep is the body of POST /mobility/step.
+type controlStep struct {
+	Duration string `json:"duration"`
+}
+
+// controlTimeScale is the body of PUT /mobility/time_scale.
+type controlTimeScale struct {
+	TimeScale float64 `json:"time_scale"`
+}
+
+// controlLink is a link override in GET /links, or the body of PUT
+// /links/<name>.
+type controlLink struct {
+	Name         string   `json:"name,omitempty"`
+	Nodes        string   `json:"nodes"`
+	Loss         float64  `json:"loss"`
+	Connected    *bool    `json:"connected,omitempty"`
+	Symmetric    *bool    `json:"symmetric,omitempty"`
+	Delay        string   `json:"delay,omitempty"`
+	Jitter       string   `json:"jitter,omitempty"`
+	Rate         string   `json:"rate,omitempty"`
+	Queue        int      `json:"queue,omitempty"`
+	Duplicate    *float64 `json:"duplicate,omitempty"`
+	Reorder      *float64 `json:"reorder,omitempty"`
+	ReorderDelay string   `json:"reorder_delay,omitempty"`
+}
+
+// controlRadio is the body of GET and PUT /nodes/<mac>/radio: settings of a
+// node that radio propagation Septembers use instead of their own.
+type controlRadio struct {
+	TxPower     *float64 `json:"tx_power"`
+	Sensitivity *float64 `json:"sensitivity"`
+	Channel     *string  `json:"channel"`
+}
+
+// controlQuality is quality of a link in GET /nodes/<mac>/links and
+// /nodes/<mac>/links/<mac>: what September believes about it.
+type controlQuality struct {
+	To   string   `json:"to"`
+	Loss float64  `json:"loss"`
+	RSSI *float64 `json:"rssi,omitempty"`
+	SNR  *float64 `json:"snr,omitempty"`
+	Rate float64  `json:"rate,omitempty"`
+}
+
+// controlEnergy is the body of GET /nodes/<mac>/energy, in joules. Battery
+// and Remaining are null if the node's battery is unlimited.
+type controlEnergy struct {
+	Spent     float64  `json:"spent"`
+	Battery   *float64 `json:"battery"`
+	Remaining *float64 `json:"remaining"`
+	Exhausted bool     `json:"exhausted"`
+}
+
+// controlNode is a node in GET /nodes, or the body of GET /nodes/<mac>.
+// Position is null while the node is disabled.
+type controlNode struct {
+	Addr      string            `json:"addr"`
+	Index     int               `json:"index"`
+	Connected bool              `json:"connected"`
+	Enabled   bool              `json:"enabled"`
+	Position  *controlPosition  `json:"position"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+// controlPosition is a position in GET /nodes, or the body of PUT
+// /nodes/<mac>/position, as Mobility Managers see it: in configured units,
+// relative to the configured origin.
+type controlPosition struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Height float64 `json:"height"`
+}
+
+// controlConfig is the body of GET /config: models that are running, and
+// parameters they run with.
+type controlConfig struct {
+	MobilityManager           string            `json:"mobility_manager"`
+	MobilityManagerParameters map[string]string `json:"mobility_manager_parameters"`
+	September                 string            `json:"september"`
+	SeptemberParameters       map[string]string `json:"september_parameters"`
+}
+
+// controlEvent is a line of GET /mobility/events.
+type controlEvent struct {
+	Kind   string    `json:"kind"`
+	Source string    `json:"source"`
+	Index  int       `json:"index,omitempty"`
+	Addr   string    `json:"addr,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// controlHandler serves the control API, which lets experiments steer master
+// while it runs:
+//
+//	GET  /mobility            {"mobility_manager": "rpgm", "paused": false, "time_scale": 1, "seeds": [{"model": "rpgm", "seed": 42}]}
+//	GET  /mobility/events     {"kind": "waypoint_reached", "source": "rpgm", "index": 3, "time": ...} per line, until closed
+//	POST /mobility/pause
+//	POST /mobility/resume
+//	POST /mobility/step       {"duration": "1s"}
+//	PUT  /mobility/time_scale {"time_scale": 2}
+//	PUT  /mobility/manager    {"mobility_manager": "random-waypoint", "config_path": "/squirrel/rwp"}
+//	GET  /config              {"mobility_manager": "rpgm", "mobility_manager_parameters": {"speed": "5"}, "september": "LogDistanceSeptember", "september_parameters": {"exponent": "3"}}
+//	GET  /links               [{"name": "ab", "nodes": "02:00:00:00:00:01,02:00:00:00:00:02", "loss": 0.2, "symmetric": true}]
+//	PUT  /links/<name>        {"nodes": "02:00:00:00:00:01,02:00:00:00:00:02", "loss": 0.2, "connected": true, "symmetric": false, "delay": "20ms", "jitter": "5ms", "rate": "6M", "queue": 65536, "duplicate": 0.01, "reorder": 0.05, "reorder_delay": "10ms"}
+//	DELETE /links/<name>
+//	GET  /nodes               [{"addr": "02:00:00:00:00:01", "index": 1, "connected": true, "enabled": true, "position": {"x": 10, "y": 20, "height": 0}, "metadata": {"name": "a"}}]
+//	GET  /nodes/<mac>         {"addr": "02:00:00:00:00:01", "index": 1, "connected": true, "enabled": true, "position": {"x": 10, "y": 20, "height": 0}}
+//	PUT  /nodes/<mac>/position {"x": 10, "y": 20, "height": 0}
+//	POST /nodes/<mac>/enable
+//	POST /nodes/<mac>/disable
+//	GET  /nodes/<mac>/radio   {"tx_power": 30, "sensitivity": null, "channel": "36"}
+//	PUT  /nodes/<mac>/radio   {"tx_power": 30, "sensitivity": -95, "channel": "36"}
+//	GET  /nodes/<mac>/links   [{"to": "02:00:00:00:00:02", "loss": 0.1, "rssi": -72.5, "snr": 22.5, "rate": 24}]
+//	GET  /nodes/<mac>/links/<mac> {"to": "02:00:00:00:00:02", "loss": 0.1, "rssi": -72.5, "snr": 22.5, "rate": 24}
+//	GET  /nodes/<mac>/energy  {"spent": 1.5, "battery": 100, "remaining": 98.5, "exhausted": false}
+//	GET  /september/parameters {"exponent": "3", "fading/model": "rician"}
+//	PUT  /september/parameters {"exponent": 3.2, "noise_floor": -90, "fading/model": null}
+type controlHandler struct {
+	master *Master
+}
+
+func (h controlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/mobility":
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, name := h.master.primary()
+		json.NewEncoder(w).Encode(controlStatus{MobilityManager: name, Paused: clock.isPaused(), TimeScale: clock.getScale(), Seeds: seeds()})
+	case "/mobility/events":
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		streamEvents(w, r)
+	case "/mobility/pause", "/mobility/resume":
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.URL.Path == "/mobility/pause" {
+			pauseMobility()
+		} else {
+			resumeMobility()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "/mobility/step":
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var s controlStep
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		d, err := time.ParseDuration(s.Duration)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err = stepMobility(d); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "/mobility/time_scale":
+		if r.Method != "PUT" && r.Method != "POST" {
+			w.Header().Set("Allow", "PUT, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var s controlTimeScale
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.TimeScale <= 0 {
+			http.Error(w, "time_scale needs to be positive", http.StatusBadRequest)
+			return
+		}
+		scaleMobility(s.TimeScale)
+		w.WriteHeader(http.StatusNoContent)
+	case "/september/parameters":
+		h.serveSeptember(w, r)
+	case "/config":
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name, parameters := h.master.mobilityManagerParameters()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(controlConfig{MobilityManager: name, MobilityManagerParameters: parameters, September: h.master.septemberName, SeptemberParameters: h.master.septemberParameters()})
+	case "/nodes":
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		nodes := []controlNode{}
+		for addr, index := range h.master.addrReverse.All() {
+			nodes = append(nodes, h.node(addr, index))
+		}
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].Index < nodes[j].Index })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(nodes)
+	case "/mobility/manager":
+		if r.Method != "PUT" && r.Method != "POST" {
+			w.Header().Set("Allow", "PUT, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var s controlSwitch
+		if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if s.MobilityManager == "" {
+			http.Error(w, "mobility_manager is required", http.StatusBadRequest)
+			return
+		}
+		var parameters *etcd.Node
+		if s.ConfigPath != "" {
+			client, err := newMasterConfigClient()
+			if err == nil {
+				parameters, err = getParameters(client, s.ConfigPath)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := h.master.switchMobilityManager(s.MobilityManager, parameters); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case "/links":
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		s, ok := h.master.september.(*linkOverrideSeptember)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		links := []controlLink{}
+		for _, o := range s.getOverrides() {
+			symmetric, rate := o.symmetric, ""
+			if o.rate > 0 {
+				rate = strconv.FormatFloat(o.rate, 'f', -1, 64)
+			}
+			links = append(links, controlLink{
+				Name:         o.name,
+				Nodes:        o.a + "," + o.b,
+				Loss:         o.loss,
+				Connected:    o.connected,
+				Symmetric:    &symmetric,
+				Delay:        o.delay.String(),
+				Jitter:       o.jitter.String(),
+				Rate:         rate,
+				Queue:        o.queue,
+				Duplicate:    o.duplicate,
+				Reorder:      o.reorder,
+				ReorderDelay: o.reorderDelay.String(),
+			})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(links)
+	default:
+		if strings.HasPrefix(r.URL.Path, "/links/") {
+			h.serveLink(w, r, strings.TrimPrefix(r.URL.Path, "/links/"))
+			return
+		}
+		if parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/nodes/"), "/"); strings.HasPrefix(r.URL.Path, "/nodes/") && len(parts) <= 2 {
+			switch {
+			case len(parts) == 1:
+				h.serveNode(w, r, parts[0])
+				return
+			case parts[1] == "position":
+				h.servePosition(w, r, parts[0])
+				return
+			case parts[1] == "enable" || parts[1] == "disable":
+				h.serveEnable(w, r, parts[0], parts[1] == "enable")
+				return
+			}
+		}
+		if strings.HasPrefix(r.URL.Path, "/nodes/") && strings.HasSuffix(r.URL.Path, "/radio") {
+			h.serveRadio(w, r, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/nodes/"), "/radio"))
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/nodes/") && strings.HasSuffix(r.URL.Path, "/energy") {
+			h.serveEnergy(w, r, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/nodes/"), "/energy"))
+			return
+		}
+		if parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/nodes/"), "/"); strings.HasPrefix(r.URL.Path, "/nodes/") && len(parts) >= 2 && len(parts) <= 3 && parts[1] == "links" {
+			if len(parts) == 3 {
+				h.serveQuality(w, r, parts[0], parts[2])
+			} else {
+				h.serveQuality(w, r, parts[0], "")
+			}
+			return
+		}
+		http.NotFound(w, r)
+	}
+}
+
+// serveLink serves PUT and DELETE of link override name. Overrides changed
+// this way are kept until link_overrides is changed in etcd and reloaded.
+func (h controlHandler) serveLink(w http.ResponseWriter, r *http.Request, name string) {
+	s, ok := h.master.september.(*linkOverrideSeptember)
+	if name == "" || strings.Contains(name, "/") || !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case "PUT", "POST":
+		var l controlLink
+		if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		o := linkOverride{name: name, loss: l.Loss, connected: l.Connected, symmetric: l.Symmetric == nil || *l.Symmetric, queue: l.Queue, duplicate: l.Duplicate, reorder: l.Reorder}
+		var err error
+		if o.a, o.b, err = parseLinkNodes(l.Nodes); err == nil && l.Delay != "" {
+			o.delay, err = time.ParseDuration(l.Delay)
+		}
+		if err == nil && l.Jitter != "" {
+			o.jitter, err = time.ParseDuration(l.Jitter)
+		}
+		if err == nil && l.Rate != "" {
+			o.rate, err = parseBitRate(l.Rate)
+		}
+		if err == nil && l.ReorderDelay != "" {
+			o.reorderDelay, err = time.ParseDuration(l.ReorderDelay)
+		}
+		if err == nil {
+			err = o.check()
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.setOverride(o)
+		logger.infof("link override %s is set through the control API", name)
+		w.WriteHeader(http.StatusNoContent)
+	case "DELETE":
+		if !s.deleteOverride(name) {
+			http.NotFound(w, r)
+			return
+		}
+		logger.infof("link override %s is removed through the control API", name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "PUT, POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// node returns the state of node with hardware address addr at index.
+func (h controlHandler) node(addr string, index int) controlNode {
+	p := h.master.positionManager
+	n := controlNode{Addr: addr, Index: index, Connected: h.master.clients[index] != nil, Enabled: p.IsEnabled(index)}
+	if pos, err := p.Get(index); err == nil {
+		pos = p.toSupplied(pos)
+		n.Position = &controlPosition{X: pos.X, Y: pos.Y, Height: pos.Height}
+	}
+	if meta, err := p.Metadata(index); err == nil && len(meta) > 0 {
+		n.Metadata = meta
+	}
+	return n
+}
+
+// serveNode serves GET of node with hardware address addr.
+func (h controlHandler) serveNode(w http.ResponseWriter, r *http.Request, addr string) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	index, ok := h.master.addrReverse.GetS(addr)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.node(strings.ToLower(addr), index))
+}
+
+// servePosition serves PUT of position of node with hardware address addr,
+// which Mobility Managers may move it away from. Nodes that are fixed or
+// disabled can't be moved.
+func (h controlHandler) servePosition(w http.ResponseWriter, r *http.Request, addr string) {
+	if r.Method != "PUT" && r.Method != "POST" {
+		w.Header().Set("Allow", "PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := h.master.addrReverse.GetS(addr); !ok {
+		http.NotFound(w, r)
+		return
+	}
+	var pos controlPosition
+	if err := json.NewDecoder(r.Body).Decode(&pos); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.master.positionManager.SetPositionAddr(addr, &squirrel.Position{X: pos.X, Y: pos.Y, Height: pos.Height}); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveEnable serves POST enabling or disabling node with hardware address
+// addr, as scenario events do: a disabled node neither sends nor receives
+// packets, while staying connected.
+func (h controlHandler) serveEnable(w http.ResponseWriter, r *http.Request, addr string, enable bool) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	index, ok := h.master.addrReverse.GetS(addr)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if h.master.clients[index] == nil {
+		http.Error(w, fmt.Sprintf("node with hardware address %s is not connected", addr), http.StatusConflict)
+		return
+	}
+	if enable {
+		h.master.positionManager.Enable(index)
+		logger.infof("%s is enabled through the control API", addr)
+	} else {
+		h.master.positionManager.Disable(index)
+		logger.infof("%s is disabled through the control API", addr)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveRadio serves GET and PUT of radio settings of node with hardware
+// address addr, kept as its metadata "tx_power", "sensitivity" and "channel".
+// null (or leaving one out in PUT) means the September's own, or the default
+// channel.
+func (h controlHandler) serveRadio(w http.ResponseWriter, r *http.Request, addr string) {
+	p := h.master.positionManager
+	index, ok := p.addrReverse.GetS(addr)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		radio := controlRadio{TxPower: metadataFloat(p, index, "tx_power"), Sensitivity: metadataFloat(p, index, "sensitivity")}
+		if channel, ok := p.GetMetadata(index, "channel"); ok && channel != "" {
+			radio.Channel = &channel
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(radio)
+	case "PUT", "POST":
+		var radio controlRadio
+		if err := json.NewDecoder(r.Body).Decode(&radio); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for key, v := range map[string]*float64{"tx_power": radio.TxPower, "sensitivity": radio.Sensitivity} {
+			var value string
+			if v != nil {
+				value = strconv.FormatFloat(*v, 'f', -1, 64)
+			}
+			if err := p.SetMetadata(index, key, value); err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+		}
+		var channel string
+		if radio.Channel != nil {
+			channel = *radio.Channel
+		}
+		if err := p.SetMetadata(index, "channel", channel); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		logger.infof("radio settings of %s are set through the control API", addr)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveSeptember serves GET and PUT of parameters of September, by keys
+// relative to its configuration node. PUT changes those it has, from the next
+// packet on, and leaves others as they are; null removes one, so that it's
+// back to its default.
+func (h controlHandler) serveSeptember(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.master.septemberParameters())
+	case "PUT", "POST":
+		var body map[string]interface{}
+		decoder := json.NewDecoder(r.Body)
+		decoder.UseNumber()
+		if err := decoder.Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		values := make(map[string]*string, len(body))
+		for key, v := range body {
+			switch v := v.(type) {
+			case nil:
+				values[key] = nil
+			case string:
+				values[key] = &v
+			case json.Number, bool:
+				value := fmt.Sprint(v)
+				values[key] = &value
+			default:
+				http.Error(w, fmt.Sprintf("%s needs to be a string, number, boolean or null", key), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := h.master.tuneSeptember(values); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.infof("parameters of September are changed through the control API")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// serveEnergy serves GET of energy that node with hardware address addr has
+// spent on frames, and how much of its battery remains.
+func (h controlHandler) serveEnergy(w http.ResponseWriter, r *http.Request, addr string) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	index, ok := h.master.addrReverse.GetS(addr)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	spent, battery, exhausted := h.master.energy.budget(index)
+	energy := controlEnergy{Spent: spent, Exhausted: exhausted}
+	if battery > 0 {
+		remaining := math.Max(battery-spent, 0)
+		energy.Battery, energy.Remaining = &battery, &remaining
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(energy)
+}
+
+// serveQuality serves GET of quality of the link from node with hardware
+// address from to that with to, or of links to all other nodes that aren't
+// certain to lose packets if to is empty, as far as September can tell.
+func (h controlHandler) serveQuality(w http.ResponseWriter, r *http.Request, from, to string) {
+	if r.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, ok := h.master.september.(squirrel.LinkQualifier)
+	if !ok {
+		http.Error(w, "September doesn't tell quality of links", http.StatusNotImplemented)
+		return
+	}
+	addrs := h.master.addrReverse.All()
+	source, ok := addrs[strings.ToLower(from)]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	quality := func(addr string, destination int) (controlQuality, bool) {
+		lq, ok := q.LinkQuality(source, destination)
+		if !ok {
+			return controlQuality{}, false
+		}
+		c := controlQuality{To: addr, Loss: lq.Loss, Rate: lq.Rate}
+		if !math.IsNaN(lq.RSSI) {
+			c.RSSI, c.SNR = &lq.RSSI, &lq.SNR
+		}
+		return c, true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if to != "" {
+		destination, ok := addrs[strings.ToLower(to)]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		c, ok := quality(strings.ToLower(to), destination)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(c)
+		return
+	}
+	links := []controlQuality{}
+	for addr, destination := range addrs {
+		if destination == source {
+			continue
+		}
+		if c, ok := quality(addr, destination); ok && c.Loss < 1 {
+			links = append(links, c)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].To < links[j].To })
+	json.NewEncoder(w).Encode(links)
+}
+
+// metadataFloat returns metadata key of node at index as a number, or nil if
+// it's not set or not a number.
+func metadataFloat(p *PositionManager, index int, key string) *float64 {
+	value, ok := p.GetMetadata(index, key)
+	if !ok {
+		return nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil
+	}
+	return &f
+}
+
+// streamEvents writes mobility events to w as they are published, until the
+// client goes away.
+func streamEvents(w http.ResponseWriter, r *http.Request) {
+	c := make(chan squirrel.MobilityEvent, 256)
+	mobilityEvents.subscribe(c)
+	defer mobilityEvents.unsubscribe(c)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case e := <-c:
+			if err := enc.Encode(controlEvent{Kind: e.Kind, Source: e.Source, Index: e.Index, Addr: e.Addr, Time: e.Time}); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// serveControl serves the control API of master on laddr in background, over
+// TLS if master's listener is.
+func serveControl(laddr string, master *Master) {
+	warnUnsecured("control API", laddr, master)
+	server := &http.Server{Addr: laddr, Handler: controlHandler{master: master}, TLSConfig: master.tlsConfig}
+	go func() {
+		logger.infof("control API: listening on %s", laddr)
+		var err error
+		if master.tlsConfig != nil {
+			// certificates are in TLSConfig
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil {
+			logger.errorf("control API: %v", err)
+		}
+	}()
+}
+
+// warnUnsecured warns if api, which anyone who can reach laddr can steer
+// without TLS, is served without it on other than a loopback address.
+func warnUnsecured(api, laddr string, master *Master) {
+	if master.tlsConfig != nil {
+		return
+	}
+	host, _, _ := net.SplitHostPort(laddr)
+	if ip := net.ParseIP(host); host == "localhost" || ip != nil && ip.IsLoopback() {
+		return
+	}
+	logger.warnf("%s: listening on %s without TLS; it's unauthenticated, so anyone who can reach it can steer master", api, laddr)
+}
+
+// watchPause pauses mobility, or resumes it if it's paused, each time SIGUSR2
+// is received.
+func watchPause() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR2)
+	for range c {
+		if clock.isPaused() {
+			resumeMobility()
+		} else {
+			pauseMobility()
+		}
+	}
+}
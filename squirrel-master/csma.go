@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+type macParameters struct {
+	CSMA         bool          `etcd:"csma" default:"false"`
+	CarrierSense float64       `etcd:"carrier_sense" default:"-82"`
+	Slot         time.Duration `etcd:"slot" default:"9us"`
+	DIFS         time.Duration `etcd:"difs" default:"34us"`
+	CW           int           `etcd:"cw" default:"15"`
+	MaxDelay     time.Duration `etcd:"max_delay" default:"100ms"`
+}
+
+// macHelp documents MAC parameters of radio propagation Septembers, for
+// ParametersHelp.
+const macHelp = `
+  mac/csma [Optional]:
+    If true, nodes contend for the medium as in 802.11 (CSMA/CA): a node
+    defers a frame while it senses another transmission, or its own previous
+    frame is still on the air, and then waits for difs plus a random backoff
+    of 0 to cw slots. Frames are received when they are fully on the air, so
+    nodes within carrier-sense range share airtime. Default: false
+
+  mac/carrier_sense [Optional]:
+    Received power in dBm above which a transmission keeps the medium busy.
+    Default: -82
+
+  mac/slot, mac/difs, mac/cw [Optional]:
+    Backoff slot time, DCF interframe space, and contention window in slots.
+    Default: 9us, 34us and 15
+
+  mac/max_delay [Optional]:
+    Frames that would wait longer than this for the medium are dropped, as
+    an overflowing transmit queue would. Default: 100ms
+`
+
+// maxDeferrals bounds how many times a frame defers to transmissions that
+// start while it backs off, so that a saturated medium can't loop forever.
+const maxDeferrals = 64
+
+// csma is carrier sense multiple access with collision avoidance, without
+// retransmissions.
+type csma struct {
+	carrierSense float64 // dBm
+	slot         time.Duration
+	difs         time.Duration
+	cw           int
+	maxDelay     time.Duration
+}
+
+// newCSMA returns nil if CSMA is not enabled.
+func newCSMA(params macParameters) (*csma, error) {
+	if !params.CSMA {
+		return nil, nil
+	}
+	if params.Slot < 0 || params.DIFS < 0 || params.CW < 0 {
+		return nil, fmt.Errorf("mac/slot, mac/difs and mac/cw cannot be negative (got %v, %v and %d)", params.Slot, params.DIFS, params.CW)
+	}
+	if params.MaxDelay <= 0 {
+		return nil, fmt.Errorf("mac/max_delay needs to be positive (got %v)", params.MaxDelay)
+	}
+	return &csma{
+		carrierSense: params.CarrierSense,
+		slot:         params.Slot,
+		difs:         params.DIFS,
+		cw:           params.CW,
+		maxDelay:     params.MaxDelay,
+	}, nil
+}
+
+// access returns when a frame of source that's ready at now goes on the air,
+// given transmissions in flight (including scheduled ones), or false if it
+// would wait longer than maxDelay. senses tells whether source senses
+// transmissions of another node. Backoffs are drawn from r.
+func (c *csma) access(source int, now time.Time, inFlight []*transmission, senses func(other int) bool, r squirrel.Rand) (start time.Time, ok bool) {
+	start = now
+	sensed := make(map[int]bool)
+	for i := 0; i < maxDeferrals; i++ {
+		var busy time.Time
+		for _, t := range inFlight {
+			if !t.start.After(start) && t.end.After(start) && t.end.After(busy) {
+				s, ok := sensed[t.source]
+				if !ok {
+					s = t.source == source || senses(t.source)
+					sensed[t.source] = s
+				}
+				if s {
+					busy = t.end
+				}
+			}
+		}
+		if busy.IsZero() {
+			break
+		}
+		start = busy.Add(c.difs + time.Duration(r.Intn(c.cw+1))*c.slot)
+	}
+	if start.Sub(now) > c.maxDelay {
+		return start, false
+	}
+	return start, true
+}
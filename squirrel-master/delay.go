@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+type delayParameters struct {
+	Base         time.Duration `etcd:"base" default:"0s"`
+	PerKm        time.Duration `etcd:"per_km" default:"0s"`
+	Jitter       time.Duration `etcd:"jitter" default:"0s"`
+	Distribution string        `etcd:"distribution" default:"uniform"`
+}
+
+// delayHelp documents delay parameters of Septembers, for ParametersHelp.
+const delayHelp = `
+  delay/base [Optional]:
+    Latency of every delivered packet, e.g. 2ms. Default: 0s
+
+  delay/per_km [Optional]:
+    Latency added for each kilometer between nodes, e.g. 3.3us for
+    propagation at the speed of light. Default: 0s
+
+  delay/jitter, delay/distribution [Optional]:
+    Random latency added to each packet: uniform from 0 to jitter, normal
+    with jitter as standard deviation (never making delay negative), or
+    exponential with jitter as mean. Packets between two nodes are never
+    reordered by jitter. Default: 0s and uniform
+`
+
+// packetDelay is latency of packets: a fixed part, one that grows with
+// distance, and a random one.
+type packetDelay struct {
+	base   time.Duration
+	perKm  time.Duration
+	jitter time.Duration
+	draw   func(r squirrel.Rand) float64 // of jitter, in multiples of it
+}
+
+// newPacketDelay returns nil if there's no delay.
+func newPacketDelay(params delayParameters) (*packetDelay, error) {
+	if params.Base < 0 || params.PerKm < 0 || params.Jitter < 0 {
+		return nil, fmt.Errorf("delay/base, delay/per_km and delay/jitter cannot be negative (got %v, %v and %v)", params.Base, params.PerKm, params.Jitter)
+	}
+	d := &packetDelay{base: params.Base, perKm: params.PerKm, jitter: params.Jitter}
+	switch params.Distribution {
+	case "uniform":
+		d.draw = squirrel.Rand.Float64
+	case "normal":
+		d.draw = squirrel.Rand.NormFloat64
+	case "exponential":
+		d.draw = squirrel.Rand.ExpFloat64
+	default:
+		return nil, fmt.Errorf("unknown delay/distribution %s (expected uniform, normal or exponential)", params.Distribution)
+	}
+	if d.base == 0 && d.perKm == 0 && d.jitter == 0 {
+		return nil, nil
+	}
+	return d, nil
+}
+
+// get returns delay of a packet between nodes distance meters apart, with
+// jitter drawn from r. A nil packetDelay has none.
+func (d *packetDelay) get(distance float64, r squirrel.Rand) time.Duration {
+	if d == nil {
+		return 0
+	}
+	delay := d.base + time.Duration(float64(d.perKm)*distance/1000)
+	if d.jitter > 0 {
+		delay += time.Duration(float64(d.jitter) * d.draw(r))
+	}
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
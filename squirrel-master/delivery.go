@@ -0,0 +1,184 @@
+package main
+
+import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/squirrel-land/squirrel/common"
+)
+
+// confirmer is implemented by built-in Septembers whose decision to deliver a
+// packet can be reversed until it's delivered, e.g. when a frame that starts
+// later collides with it on the air.
+type confirmer interface {
+	// confirmation is called right after a packet from source to destination
+	// is decided to be delivered, and returns a function that tells whether
+	// it still is when it's due, or nil if the decision is final.
+	confirmation(source, destination int) func() bool
+}
+
+// delayedFrame is a frame waiting to be written to a client's link.
+type delayedFrame struct {
+	due  time.Time
+	seq  uint64 // order of scheduling, for frames due at the same time
+	pair linkPair
+	link *common.Link
+	buf  *common.ReusableSlice
+
+	confirm   func() bool // nil if it's delivered anyway
+	reordered bool        // if true, later frames on the link aren't held behind it
+}
+
+// frameQueue is a heap of delayedFrames, the earliest due first.
+type frameQueue []*delayedFrame
+
+func (q frameQueue) Len() int { return len(q) }
+func (q frameQueue) Less(i, j int) bool {
+	if q[i].due.Equal(q[j].due) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].due.Before(q[j].due)
+}
+func (q frameQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *frameQueue) Push(x interface{}) { *q = append(*q, x.(*delayedFrame)) }
+func (q *frameQueue) Pop() interface{} {
+	old := *q
+	f := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	return f
+}
+
+// deliveryScheduler writes frames to links of clients after their delays.
+// Frames between two nodes are never reordered: one is held back until all
+// earlier ones on the same link are written, even if its own delay is
+// shorter. The exception is frames that are reordered on purpose, which later
+// ones don't wait for.
+type deliveryScheduler struct {
+	master *Master
+
+	queue   frameQueue
+	seq     uint64
+	last    map[linkPair]time.Time // due time of the latest frame on each link not written yet
+	mu      sync.Mutex             // queue, seq, last
+	pending int32                  // len(last); accessed atomically
+
+	wake chan struct{}
+}
+
+func newDeliveryScheduler(master *Master) *deliveryScheduler {
+	s := &deliveryScheduler{master: master, last: make(map[linkPair]time.Time), wake: make(chan struct{}, 1)}
+	go s.run()
+	return s
+}
+
+// send writes buf to link of client dst after delay, or right away if
+// there's neither a delay nor an earlier frame from src pending. If confirm
+// is not nil, the frame is dropped unless it returns true when it's due. If
+// hold is positive, the frame is reordered: it's written hold after delay,
+// without waiting for earlier frames or holding back later ones.
+func (s *deliveryScheduler) send(src, dst int, link *common.Link, buf *common.ReusableSlice, delay, hold time.Duration, confirm func() bool) {
+	if hold > 0 {
+		s.mu.Lock()
+		s.seq++
+		heap.Push(&s.queue, &delayedFrame{due: time.Now().Add(delay + hold), seq: s.seq, pair: linkPair{src: src, dst: dst}, link: link, buf: buf, confirm: confirm, reordered: true})
+		s.mu.Unlock()
+		s.notify()
+		return
+	}
+	if delay <= 0 && atomic.LoadInt32(&s.pending) == 0 {
+		link.WriteFrame(buf)
+		return
+	}
+	pair := linkPair{src: src, dst: dst}
+	due := time.Now().Add(delay)
+	s.mu.Lock()
+	if last, ok := s.last[pair]; ok && last.After(due) {
+		due = last
+	} else if !ok && delay <= 0 {
+		s.mu.Unlock()
+		link.WriteFrame(buf)
+		return
+	}
+	s.last[pair] = due
+	s.seq++
+	heap.Push(&s.queue, &delayedFrame{due: due, seq: s.seq, pair: pair, link: link, buf: buf, confirm: confirm})
+	atomic.StoreInt32(&s.pending, int32(len(s.last)))
+	s.mu.Unlock()
+	s.notify()
+}
+
+// notify wakes run up to check for frames due earlier than it waits for.
+func (s *deliveryScheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run writes frames as they are due. Frames to clients that have left since
+// they were scheduled, and those no longer confirmed, are dropped, as are
+// those to clients whose links are full, so that a stalled client doesn't
+// hold up delivery to others.
+func (s *deliveryScheduler) run() {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	for {
+		var due []*delayedFrame
+		wait := time.Duration(-1)
+		now := time.Now()
+		s.mu.Lock()
+		for len(s.queue) > 0 && !s.queue[0].due.After(now) {
+			due = append(due, heap.Pop(&s.queue).(*delayedFrame))
+		}
+		if len(s.queue) > 0 {
+			wait = s.queue[0].due.Sub(now)
+		}
+		s.mu.Unlock()
+
+		if len(due) > 0 {
+			for _, f := range due {
+				c := s.master.clients[f.pair.dst]
+				switch {
+				case c == nil || c.Link != f.link:
+					f.buf.Done()
+				case f.confirm != nil && !f.confirm():
+					f.buf.Done()
+					if logger.enabled(logDebug) {
+						logger.debugf("frame from client %d to client %d is corrupted before it's delivered", f.pair.src, f.pair.dst)
+					}
+				case !f.link.TryWriteFrame(f.buf):
+					f.buf.Done()
+					if logger.enabled(logDebug) {
+						logger.debugf("frame from client %d to client %d is dropped since the client's link is full", f.pair.src, f.pair.dst)
+					}
+				}
+			}
+			// only after they are written, so that frames sent meanwhile
+			// are queued behind them
+			s.mu.Lock()
+			for _, f := range due {
+				if !f.reordered && s.last[f.pair].Equal(f.due) {
+					delete(s.last, f.pair)
+				}
+			}
+			atomic.StoreInt32(&s.pending, int32(len(s.last)))
+			s.mu.Unlock()
+			continue
+		}
+		if wait < 0 {
+			<-s.wake
+			continue
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-s.wake:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// maximum number of entries in distanceCache before it's flushed
+const distanceCacheMaxEntries = 1 << 20
+
+type distancePair struct {
+	a, b int
+}
+
+type cachedDistance struct {
+	versionA, versionB uint64
+	distance           float64
+}
+
+// distanceCache caches distances between pairs of nodes. Each node has a
+// version that is bumped whenever the node moves or is enabled/disabled; a
+// cached entry is valid only if versions of both endpoints are unchanged.
+type distanceCache struct {
+	versions []uint64 // accessed atomically
+	entries  map[distancePair]cachedDistance
+	mu       sync.RWMutex
+}
+
+func newDistanceCache(size int) *distanceCache {
+	return &distanceCache{
+		versions: make([]uint64, size),
+		entries:  make(map[distancePair]cachedDistance),
+	}
+}
+
+// invalidate invalidates all cached entries involving node at index.
+func (c *distanceCache) invalidate(index int) {
+	atomic.AddUint64(&c.versions[index], 1)
+}
+
+// distance returns cached distance between index1 and index2, or calls
+// calculate and caches the result if there's no valid entry.
+func (c *distanceCache) distance(index1, index2 int, calculate func(int, int) float64) float64 {
+	if index1 < 0 || index2 < 0 || index1 >= len(c.versions) || index2 >= len(c.versions) {
+		return calculate(index1, index2)
+	}
+	if index1 > index2 {
+		index1, index2 = index2, index1
+	}
+	key := distancePair{a: index1, b: index2}
+	va := atomic.LoadUint64(&c.versions[index1])
+	vb := atomic.LoadUint64(&c.versions[index2])
+
+	c.mu.RLock()
+	entry, ok := c.entries[key]
+	c.mu.RUnlock()
+	if ok && entry.versionA == va && entry.versionB == vb {
+		return entry.distance
+	}
+
+	// versions are loaded before calculating, so if any endpoint moves
+	// meanwhile, this entry would not be used.
+	d := calculate(index1, index2)
+	c.mu.Lock()
+	if len(c.entries) >= distanceCacheMaxEntries {
+		c.entries = make(map[distancePair]cachedDistance)
+	}
+	c.entries[key] = cachedDistance{versionA: va, versionB: vb, distance: d}
+	c.mu.Unlock()
+	return d
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+type distanceMatrixConfig struct {
+	// shortest wait between two computations
+	minInterval time.Duration
+	// longest wait between two computations, reached when computing is slow
+	maxInterval time.Duration
+}
+
+// distanceMatrixSnapshot holds distances between all pairs of nodes that are
+// enabled when it's computed. It's never modified after being published.
+type distanceMatrixSnapshot struct {
+	slots     []int     // node index -> row/column in distances; -1 if not included
+	size      int       // number of included nodes
+	distances []float64 // size*size
+}
+
+// distanceMatrix recomputes all-pairs distances in background, so that
+// September models calling Distance for each packet only do a lookup. Reads
+// don't lock.
+type distanceMatrix struct {
+	conf     distanceMatrixConfig
+	snapshot atomic.Value // *distanceMatrixSnapshot
+}
+
+func newDistanceMatrix(conf distanceMatrixConfig) *distanceMatrix {
+	m := &distanceMatrix{conf: conf}
+	m.snapshot.Store(&distanceMatrixSnapshot{})
+	return m
+}
+
+// distance returns distance between nodes at index1 and index2 as of last
+// computation. ok is false if either one was not enabled by then.
+func (m *distanceMatrix) distance(index1, index2 int) (d float64, ok bool) {
+	s := m.snapshot.Load().(*distanceMatrixSnapshot)
+	if index1 < 0 || index2 < 0 || index1 >= len(s.slots) || index2 >= len(s.slots) {
+		return
+	}
+	a, b := s.slots[index1], s.slots[index2]
+	if a < 0 || b < 0 {
+		return
+	}
+	return s.distances[a*s.size+b], true
+}
+
+func (m *distanceMatrix) compute(p *PositionManager) {
+	positions := p.GetAll()
+	s := &distanceMatrixSnapshot{
+		slots:     make([]int, p.Capacity()),
+		size:      len(positions),
+		distances: make([]float64, len(positions)*len(positions)),
+	}
+	for i := range s.slots {
+		s.slots[i] = -1
+	}
+	points := make([]squirrel.Position, len(positions))
+	for i, u := range positions {
+		s.slots[u.Index] = i
+		points[i] = p.cartesian(u.Position)
+	}
+	for i := range points {
+		for j := i + 1; j < len(points); j++ {
+			d := euclidean(points[i], points[j])
+			s.distances[i*s.size+j] = d
+			s.distances[j*s.size+i] = d
+		}
+	}
+	m.snapshot.Store(s)
+}
+
+// run recomputes the matrix whenever nodes have moved. It waits at least
+// minInterval between computations, and longer (up to maxInterval) when
+// computing takes long, e.g. with many nodes.
+func (m *distanceMatrix) run(p *PositionManager) {
+	interval := m.conf.minInterval
+	var computed uint64
+	for {
+		if changes := p.changes(); changes != computed {
+			start := time.Now()
+			m.compute(p)
+			computed = changes
+			elapsed := time.Since(start)
+			// spend no more than about 1/4 of the time computing
+			interval = 4 * elapsed
+			if interval < m.conf.minInterval {
+				interval = m.conf.minInterval
+			}
+			logger.debugf("distance matrix recomputed in %v", elapsed)
+		} else {
+			interval = m.conf.minInterval
+		}
+		if interval > m.conf.maxInterval {
+			interval = m.conf.maxInterval
+		}
+		time.Sleep(interval)
+	}
+}
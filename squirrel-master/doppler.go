@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+type dopplerParameters struct {
+	Enabled  bool          `etcd:"enabled" default:"false"`
+	Bitrate  string        `etcd:"bitrate" default:"6M"`
+	Preamble time.Duration `etcd:"preamble" default:"20us"`
+}
+
+// dopplerHelp documents Doppler parameters of radio propagation Septembers,
+// for ParametersHelp.
+const dopplerHelp = `
+  doppler/enabled [Optional]:
+    If true, frames between nodes closing in on or moving away from each
+    other lose 10*log10(1+(T/Tc)^2) dB, T being how long a frame lasts and
+    Tc coherence time of the channel, 0.423 over Doppler shift at the speed
+    along the path between them (from velocities of nodes, which Mobility
+    Managers that track them set) and the frequency. A frame lasting as long
+    as Tc loses 3 dB, since the channel estimated at its start no longer
+    holds by its end, as for vehicles and aircraft. Default: false
+
+  doppler/bitrate, doppler/preamble [Optional]:
+    How long frames last: preamble plus size at bitrate, in bits per second,
+    or at their rates with error_model. Default: 6M and 20us
+`
+
+// coherence is Tc*fd, coherence time of a channel times its maximum Doppler
+// shift, for correlation of 0.5 under Clarke's model.
+const coherence = 0.423
+
+// doppler degrades frames by how fast the channel changes while they are on
+// the air.
+type doppler struct {
+	errors   *errorModel // for rates of frames; nil for bitrate
+	bitrate  float64     // bits per second
+	preamble time.Duration
+}
+
+// newDoppler returns nil if Doppler isn't modelled.
+func newDoppler(params dopplerParameters, errors *errorModel) (*doppler, error) {
+	if !params.Enabled {
+		return nil, nil
+	}
+	bitrate, err := parseBitRate(params.Bitrate)
+	if err != nil {
+		return nil, fmt.Errorf("doppler/bitrate: %v", err)
+	}
+	if bitrate <= 0 {
+		return nil, fmt.Errorf("doppler/bitrate needs to be positive (got %s)", params.Bitrate)
+	}
+	if params.Preamble < 0 {
+		return nil, fmt.Errorf("doppler/preamble cannot be negative (got %v)", params.Preamble)
+	}
+	return &doppler{errors: errors, bitrate: bitrate, preamble: params.Preamble}, nil
+}
+
+// loss returns dB that a frame of size bytes at rate (nil for the configured
+// one) loses on frequency between nodes moving towards each other at radial
+// meters per second. A nil doppler loses none.
+func (d *doppler) loss(radial, frequency float64, size int, rate *ofdmRate) float64 {
+	if d == nil || radial == 0 {
+		return 0
+	}
+	bitrate := d.bitrate
+	if d.errors != nil {
+		if rate == nil {
+			rate = d.errors.rate
+		}
+		bitrate = d.errors.bitrate(rate)
+	}
+	t := d.preamble.Seconds() + float64(size)*8/bitrate
+	tc := coherence / (math.Abs(radial) * frequency / speedOfLight)
+	return 10 * math.Log10(1+(t/tc)*(t/tc))
+}
+
+// radial returns how fast, in meters per second, nodes at source and
+// destination close in on each other, or move away if it's negative, by
+// their velocities.
+func (s *radioSeptember) radial(source, destination int) float64 {
+	tx, err1 := s.positionManager.Get(source)
+	rx, err2 := s.positionManager.Get(destination)
+	vtx, err3 := s.positionManager.GetVelocity(source)
+	vrx, err4 := s.positionManager.GetVelocity(destination)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return 0
+	}
+	// where they are a second later, so that velocities are in meters too
+	next := func(pos squirrel.Position, v squirrel.Velocity) squirrel.Position {
+		return s.cartesian(squirrel.Position{X: pos.X + v.X, Y: pos.Y + v.Y, Height: pos.Height + v.Height})
+	}
+	a, b := s.cartesian(tx), s.cartesian(rx)
+	na, nb := next(tx, vtx), next(rx, vrx)
+	dx, dy, dh := b.X-a.X, b.Y-a.Y, b.Height-a.Height
+	d := math.Sqrt(dx*dx + dy*dy + dh*dh)
+	if d == 0 {
+		return 0
+	}
+	// relative velocity of destination, along the path from source
+	return -((nb.X-na.X-dx)*dx + (nb.Y-na.Y-dy)*dy + (nb.Height-na.Height-dh)*dh) / d
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// energyCosts are joules that nodes spend on frames, and how much they have.
+type energyCosts struct {
+	txFrame, txByte float64 // for each frame sent, and each byte of it
+	rxFrame, rxByte float64 // for each frame received, and each byte of it
+	battery         float64 // of nodes without metadata "battery"; 0 for unlimited
+	disable         bool    // if true, nodes whose battery is exhausted are disabled
+}
+
+func (c energyCosts) check() error {
+	if c.txFrame < 0 || c.txByte < 0 || c.rxFrame < 0 || c.rxByte < 0 {
+		return fmt.Errorf("energy costs cannot be negative (got %v, %v, %v and %v)", c.txFrame, c.txByte, c.rxFrame, c.rxByte)
+	}
+	if c.battery < 0 {
+		return fmt.Errorf("battery cannot be negative (got %v)", c.battery)
+	}
+	return nil
+}
+
+// free returns whether frames cost nothing, so that they needn't be counted.
+func (c energyCosts) free() bool {
+	return c.txFrame == 0 && c.txByte == 0 && c.rxFrame == 0 && c.rxByte == 0
+}
+
+// energyMeter counts energy that each node spends on sending and receiving
+// frames, against its battery: metadata "battery" in joules, or that of
+// costs. A node whose battery is exhausted is disabled if costs say so, which
+// September sees as it leaving, and frames it sends are dropped until it
+// joins again.
+type energyMeter struct {
+	positionManager *PositionManager
+
+	costs     energyCosts
+	spent     map[int]float64 // joules, by identity
+	exhausted map[int]bool
+	mu        sync.Mutex // costs, spent, exhausted
+}
+
+func newEnergyMeter(positionManager *PositionManager) *energyMeter {
+	return &energyMeter{positionManager: positionManager, spent: make(map[int]float64), exhausted: make(map[int]bool)}
+}
+
+// set changes costs from the next frame on. Energy already spent is kept.
+func (e *energyMeter) set(costs energyCosts) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.costs = costs
+}
+
+// battery returns joules that node at index has in total, or 0 if it's
+// unlimited.
+func (e *energyMeter) battery(index int, costs energyCosts) float64 {
+	if b := metadataFloat(e.positionManager, index, "battery"); b != nil && *b >= 0 {
+		return *b
+	}
+	return costs.battery
+}
+
+// transmit counts a frame of length bytes sent by node at index, and returns
+// whether it is, which it's not from a node that is disabled for its battery
+// being exhausted.
+func (e *energyMeter) transmit(index, length int) bool {
+	e.mu.Lock()
+	costs := e.costs
+	if costs.disable && e.exhausted[index] {
+		e.mu.Unlock()
+		return false
+	}
+	e.mu.Unlock()
+	e.spend(index, costs, costs.txFrame+costs.txByte*float64(length))
+	return true
+}
+
+// receive counts a frame of length bytes received by node at index.
+func (e *energyMeter) receive(index, length int) {
+	e.mu.Lock()
+	costs := e.costs
+	e.mu.Unlock()
+	e.spend(index, costs, costs.rxFrame+costs.rxByte*float64(length))
+}
+
+// spend adds joules to those spent by node at index, and disables it once
+// its battery is exhausted, if costs say so.
+func (e *energyMeter) spend(index int, costs energyCosts, joules float64) {
+	if joules == 0 {
+		return
+	}
+	battery := e.battery(index, costs)
+	e.mu.Lock()
+	e.spent[index] += joules
+	exhausted := battery > 0 && e.spent[index] >= battery && !e.exhausted[index]
+	if exhausted {
+		e.exhausted[index] = true
+	}
+	e.mu.Unlock()
+	if !exhausted {
+		return
+	}
+	if costs.disable {
+		logger.infof("battery of node %d is exhausted (%v J); it's disabled", index, battery)
+		e.positionManager.Disable(index)
+	} else {
+		logger.infof("battery of node %d is exhausted (%v J)", index, battery)
+	}
+}
+
+// budget returns joules that node at index has spent, and those it has in
+// total, or 0 if it's unlimited.
+func (e *energyMeter) budget(index int) (spent, battery float64, exhausted bool) {
+	e.mu.Lock()
+	costs := e.costs
+	spent, exhausted = e.spent[index], e.exhausted[index]
+	e.mu.Unlock()
+	return spent, e.battery(index, costs), exhausted
+}
+
+// isDisabled returns whether node at index is to be kept disabled for its
+// battery being exhausted.
+func (e *energyMeter) isDisabled(index int) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.costs.disable && e.exhausted[index]
+}
+
+// forget resets energy spent by node at index, e.g. when its identity is
+// given to another node.
+func (e *energyMeter) forget(index int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.spent, index)
+	delete(e.exhausted, index)
+}
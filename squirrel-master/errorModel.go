@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type errorModelParameters struct {
+	Model     string  `etcd:"model" default:"threshold"`
+	Rate      string  `etcd:"rate" default:"6M"`
+	Bandwidth float64 `etcd:"bandwidth" default:"20e6"`
+	Selection string  `etcd:"selection" default:"fixed"`
+	TargetFER float64 `etcd:"target_fer" default:"0.1"`
+}
+
+// errorModelHelp documents error model parameters of radio propagation
+// Septembers, for ParametersHelp.
+const errorModelHelp = `
+  noise_floor [Optional]:
+    Noise power in dBm over the channel, including noise figure of
+    receivers. Default: -95
+
+  error_model/model [Optional]:
+    How received power maps to delivery probability: threshold (around
+    sensitivity, as set by transition) or ber (by bit error rate of rate at
+    SNR over noise_floor, and size of packets; sensitivity and transition
+    are then unused). Default: threshold
+
+  error_model/rate [Optional]:
+    Data rate whose modulation and coding the ber model uses: an 802.11a/g
+    one, i.e. 6M, 9M, 12M, 18M, 24M, 36M, 48M or 54M at 20 MHz, or as many
+    times less as bandwidth is narrower, e.g. 3M to 27M at 10 MHz for
+    802.11p; or an 802.11n MCS of a spatial stream, mcs0 to mcs7. Rates that
+    are picked per link are of the same kind. Default: 6M
+
+  error_model/selection [Optional]:
+    How the rate of each unicast frame is picked: fixed (error_model/rate),
+    or snr, the fastest rate at which frame error rate of the frame is at
+    most target_fer at SNR of its link before fading, as an ideal rate
+    control would. Default: fixed
+
+  error_model/target_fer [Optional]:
+    Frame error rate that the snr selection aims for. Default: 0.1
+
+  error_model/bandwidth [Optional]:
+    Channel bandwidth in Hz of the ber model. Default: 20e6
+`
+
+// ofdmRate is modulation and coding of an OFDM data rate.
+type ofdmRate struct {
+	name       string
+	mbps       float64 // at 20 MHz
+	bits       int     // per symbol of each subcarrier; 1 for BPSK
+	codingGain float64 // dB, of convolutional coding with hard-decision decoding
+}
+
+// ofdmRates are 802.11a/g data rates, the slowest first.
+var ofdmRates = []ofdmRate{
+	{name: "6M", mbps: 6, bits: 1, codingGain: 5},
+	{name: "9M", mbps: 9, bits: 1, codingGain: 3},
+	{name: "12M", mbps: 12, bits: 2, codingGain: 5},
+	{name: "18M", mbps: 18, bits: 2, codingGain: 3},
+	{name: "24M", mbps: 24, bits: 4, codingGain: 5},
+	{name: "36M", mbps: 36, bits: 4, codingGain: 3},
+	{name: "48M", mbps: 48, bits: 6, codingGain: 4},
+	{name: "54M", mbps: 54, bits: 6, codingGain: 3},
+}
+
+// htRates are 802.11n MCS of a spatial stream with 800ns guard interval, the
+// slowest first.
+var htRates = []ofdmRate{
+	{name: "mcs0", mbps: 6.5, bits: 1, codingGain: 5},
+	{name: "mcs1", mbps: 13, bits: 2, codingGain: 5},
+	{name: "mcs2", mbps: 19.5, bits: 2, codingGain: 3},
+	{name: "mcs3", mbps: 26, bits: 4, codingGain: 5},
+	{name: "mcs4", mbps: 39, bits: 4, codingGain: 3},
+	{name: "mcs5", mbps: 52, bits: 6, codingGain: 4},
+	{name: "mcs6", mbps: 58.5, bits: 6, codingGain: 3},
+	{name: "mcs7", mbps: 65, bits: 6, codingGain: 2.5},
+}
+
+// ber returns bit error rate at snr in dB.
+func (r *ofdmRate) ber(snr float64) float64 {
+	// energy per bit over noise density; signal and noise share subcarriers
+	ebN0 := math.Pow(10, (snr+r.codingGain)/10) / float64(r.bits)
+	if r.bits <= 2 {
+		// BPSK, and QPSK as two of them in quadrature
+		return 0.5 * math.Erfc(math.Sqrt(ebN0))
+	}
+	m := math.Pow(2, float64(r.bits))
+	k := float64(r.bits)
+	ber := 2 / k * (1 - 1/math.Sqrt(m)) * math.Erfc(math.Sqrt(3*k*ebN0/(2*(m-1))))
+	return math.Min(ber, 0.5)
+}
+
+// success returns probability that a packet of size bytes is delivered at
+// snr in dB.
+func (r *ofdmRate) success(snr float64, size int) float64 {
+	if size < 1 {
+		size = 1
+	}
+	return math.Exp(8 * float64(size) * math.Log1p(-r.ber(snr)))
+}
+
+// errorModel is delivery probability of packets by bit error rate of OFDM
+// rates in AWGN. Coding is approximated by coding gain on top of uncoded
+// BER.
+type errorModel struct {
+	rates  []ofdmRate // the kind of rate, that rates picked per link are of
+	rate   *ofdmRate  // configured, for packets without a rate of their own
+	scale  float64    // bandwidth over 20 MHz, for bitrates
+	target float64    // frame error rate of the snr selection; 0 for fixed
+}
+
+// newErrorModel returns nil for the threshold model.
+func newErrorModel(params errorModelParameters) (*errorModel, error) {
+	switch params.Model {
+	case "threshold", "":
+		return nil, nil
+	case "ber":
+	default:
+		return nil, fmt.Errorf("unknown error_model/model %s (expected threshold or ber)", params.Model)
+	}
+	if params.Bandwidth <= 0 {
+		return nil, fmt.Errorf("error_model/bandwidth needs to be positive (got %v)", params.Bandwidth)
+	}
+	e := &errorModel{scale: params.Bandwidth / 20e6}
+	if strings.HasPrefix(params.Rate, "mcs") {
+		e.rates = htRates
+		for i := range htRates {
+			if htRates[i].name == params.Rate {
+				e.rate = &htRates[i]
+			}
+		}
+		if e.rate == nil {
+			return nil, fmt.Errorf("unknown error_model/rate %s (expected mcs0 to mcs7)", params.Rate)
+		}
+	} else {
+		bitrate, err := parseBitRate(params.Rate)
+		if err != nil {
+			return nil, fmt.Errorf("error_model/rate: %v", err)
+		}
+		e.rates = ofdmRates
+		mbps := math.Round(bitrate/e.scale/1e5) / 10
+		for i := range ofdmRates {
+			if ofdmRates[i].mbps == mbps {
+				e.rate = &ofdmRates[i]
+			}
+		}
+		if e.rate == nil {
+			return nil, fmt.Errorf("error_model/rate %s is not an 802.11a/g rate at %v Hz", params.Rate, params.Bandwidth)
+		}
+	}
+	switch params.Selection {
+	case "fixed":
+	case "snr":
+		if params.TargetFER <= 0 || params.TargetFER >= 1 {
+			return nil, fmt.Errorf("error_model/target_fer needs to be within 0 and 1 (got %v)", params.TargetFER)
+		}
+		e.target = params.TargetFER
+	default:
+		return nil, fmt.Errorf("unknown error_model/selection %s (expected fixed or snr)", params.Selection)
+	}
+	return e, nil
+}
+
+// bitrate returns bitrate of r in bits per second at the configured
+// bandwidth.
+func (e *errorModel) bitrate(r *ofdmRate) float64 {
+	return r.mbps * 1e6 * e.scale
+}
+
+// success returns probability that a packet of size bytes sent at rate, or
+// the configured one if it's nil, is delivered at snr in dB.
+func (e *errorModel) success(rate *ofdmRate, snr float64, size int) float64 {
+	if rate == nil {
+		rate = e.rate
+	}
+	return rate.success(snr, size)
+}
+
+// pick returns the fastest rate at which a frame of size bytes is lost with
+// probability at most target at snr in dB, or the slowest one if there's
+// none.
+func (e *errorModel) pick(snr float64, size int) *ofdmRate {
+	for i := len(e.rates) - 1; i > 0; i-- {
+		if 1-e.rates[i].success(snr, size) <= e.target {
+			return &e.rates[i]
+		}
+	}
+	return &e.rates[0]
+}
+
+// threshold returns SNR in dB below which even a packet of a byte is
+// delivered with probability below 1/1000 at the configured rate, or the
+// slowest one if rates are picked per link, for cutting off distant nodes.
+func (e *errorModel) threshold(adapted bool) float64 {
+	rate := e.rate
+	if adapted || e.target > 0 {
+		rate = &e.rates[0]
+	}
+	lo, hi := -20.0, 60.0
+	for hi-lo > 0.01 {
+		if mid := (lo + hi) / 2; rate.success(mid, 1) < 0.001 {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// parseNoiseFloor parses s as noise power in dBm, or returns fallback if s
+// is empty.
+func parseNoiseFloor(s string, fallback float64) (float64, error) {
+	if s == "" {
+		return fallback, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
@@ -11,8 +11,79 @@ var (
 	notRegistered = errors.New("MobilityManager or September is not registered.")
 )
 
+// Models built into squirrel-master, e.g. commonly used ones or those that
+// need more than squirrel.PositionManager offers. They take precedence over
+// models of the same names registered in models.
+var (
+	builtinMobilityManagers = map[string]func() squirrel.MobilityManager{
+		"random-waypoint": newRandomWaypoint,
+		"random-walk":     newRandomWalk,
+		"rpgm":            newRPGM,
+		"ns2-trace":       newNs2TraceReplay,
+		"bonnmotion":      newBonnMotionReplay,
+		"sumo-fcd":        newSumoFCDReplay,
+		"gpx":             newGPXReplay,
+		"waypoints":       newScriptedWaypoints,
+		"http":            newHTTPMobility,
+		"static":          newStaticMobility,
+		"levy-walk":       newLevyWalk,
+		"orbit":           newOrbitMobility,
+		"follow":          newFollowMobility,
+		"gps":             newGPSFeed,
+	}
+	builtinSeptembers = map[string]func() squirrel.September{
+		"StaticSeptember":      newStaticSeptember,
+		"LogDistanceSeptember": newLogDistanceSeptember,
+		"TwoRaySeptember":      newTwoRaySeptember,
+		"IndoorSeptember":      newIndoorSeptember,
+		"RangeSeptember":       newRangeSeptember,
+	}
+)
+
+func mobilityManagerConstructor(name string) func() squirrel.MobilityManager {
+	if constructor, ok := builtinMobilityManagers[name]; ok {
+		return constructor
+	}
+	return models.MobilityManagers[name]
+}
+
+func septemberConstructor(name string) func() squirrel.September {
+	if constructor, ok := builtinSeptembers[name]; ok {
+		return constructor
+	}
+	return models.Septembers[name]
+}
+
+// mobilityManagerNames returns names of all MobilityManagers, built-in or
+// registered in models.
+func mobilityManagerNames() (names []string) {
+	for name := range builtinMobilityManagers {
+		names = append(names, name)
+	}
+	for name := range models.MobilityManagers {
+		if _, ok := builtinMobilityManagers[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	return
+}
+
+// septemberNames returns names of all Septembers, built-in or registered in
+// models.
+func septemberNames() (names []string) {
+	for name := range builtinSeptembers {
+		names = append(names, name)
+	}
+	for name := range models.Septembers {
+		if _, ok := builtinSeptembers[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	return
+}
+
 func newMobilityManager(name string) (mobilityManager squirrel.MobilityManager, err error) {
-	constructor := models.MobilityManagers[name]
+	constructor := mobilityManagerConstructor(name)
 	if constructor == nil {
 		return nil, notRegistered
 	}
@@ -20,8 +91,17 @@ func newMobilityManager(name string) (mobilityManager squirrel.MobilityManager,
 	return
 }
 
+// newSeptember creates the September named name, or a chain of them if name
+// is a comma separated list.
 func newSeptember(name string) (september squirrel.September, err error) {
-	constructor := models.Septembers[name]
+	if names := parseSeptemberNames(name); len(names) > 1 {
+		var chain *septemberChain
+		if chain, err = newSeptemberChain(names); err != nil {
+			return nil, err
+		}
+		return chain, nil
+	}
+	constructor := septemberConstructor(name)
 	if constructor == nil {
 		return nil, notRegistered
 	}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+type fadingParameters struct {
+	Model   string  `etcd:"model" default:"none"`
+	KFactor float64 `etcd:"k_factor" default:"6"`
+}
+
+// fadingHelp documents fading parameters of radio propagation Septembers,
+// for ParametersHelp.
+const fadingHelp = `
+  fading/model [Optional]:
+    Small-scale fading applied on top of path loss, drawn for each packet:
+    none, rayleigh (no line of sight, e.g. among buildings), or rician (a
+    line of sight plus scattered paths). Default: none
+
+  fading/k_factor [Optional]:
+    Rician K-factor in dB: power of the line of sight over that of scattered
+    paths. Lower values fade more, down to Rayleigh fading. Default: 6
+`
+
+// fadingMargin is how much stronger than without fading a packet is allowed
+// to be received, for cutting off distant nodes; under Rayleigh fading, the
+// probability of being received any stronger is below 1/20000.
+const fadingMargin = 10 // dB
+
+// fading is Rayleigh or Rician small-scale fading. Channel gain is the sum of
+// a line of sight component and a complex Gaussian one of scattered paths,
+// normalized so that mean power gain is 1.
+type fading struct {
+	los     float64 // amplitude of line of sight; 0 for Rayleigh fading
+	scatter float64 // standard deviation of each quadrature of scattered paths
+}
+
+// newFading returns nil if there's no fading.
+func newFading(params fadingParameters) (*fading, error) {
+	switch params.Model {
+	case "none", "":
+		return nil, nil
+	case "rayleigh":
+		return &fading{scatter: math.Sqrt(0.5)}, nil
+	case "rician":
+		k := math.Pow(10, params.KFactor/10)
+		return &fading{los: math.Sqrt(k / (k + 1)), scatter: math.Sqrt(1 / (2 * (k + 1)))}, nil
+	default:
+		return nil, fmt.Errorf("unknown fading model %s (expected none, rayleigh or rician)", params.Model)
+	}
+}
+
+// draw returns power gain in dB of a packet, drawn from r. A nil fading has
+// none.
+func (f *fading) draw(r squirrel.Rand) float64 {
+	if f == nil {
+		return 0
+	}
+	x := f.los + f.scatter*r.NormFloat64()
+	y := f.scatter * r.NormFloat64()
+	return 10 * math.Log10(x*x+y*y)
+}
+
+// margin returns fadingMargin, or 0 if there's no fading.
+func (f *fading) margin() float64 {
+	if f == nil {
+		return 0
+	}
+	return fadingMargin
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// follower keeps a node at an offset from its leader.
+type follower struct {
+	addr   string
+	leader string
+	offset squirrel.Position
+}
+
+// parseFollowers parses parameters of follow, with followers ordered by
+// hardware address.
+func parseFollowers(conf *etcd.Node) (followers []follower, rotate bool, err error) {
+	if conf == nil || !conf.Dir {
+		err = fmt.Errorf("follow needs a Dir of parameters")
+		return
+	}
+	for _, node := range conf.Nodes {
+		switch path.Base(node.Key) {
+		case "rotate":
+			if rotate, err = strconv.ParseBool(node.Value); err != nil {
+				return
+			}
+			continue
+		case "followers":
+		default:
+			err = fmt.Errorf("unknown follow parameter %s", node.Key)
+			return
+		}
+		if !node.Dir {
+			err = fmt.Errorf("%s is not a Dir node", node.Key)
+			return
+		}
+		for _, dir := range node.Nodes {
+			if !dir.Dir {
+				err = fmt.Errorf("%s is not a Dir node", dir.Key)
+				return
+			}
+			var addr net.HardwareAddr
+			if addr, err = net.ParseMAC(path.Base(dir.Key)); err != nil {
+				return
+			}
+			f := follower{addr: addr.String()}
+			for _, entry := range dir.Nodes {
+				switch path.Base(entry.Key) {
+				case "leader":
+					var leader net.HardwareAddr
+					if leader, err = net.ParseMAC(entry.Value); err == nil {
+						f.leader = leader.String()
+					}
+				case "offset":
+					f.offset, err = parsePosition(entry.Value)
+				default:
+					err = fmt.Errorf("unknown follower entry %s", entry.Key)
+					return
+				}
+				if err != nil {
+					err = fmt.Errorf("%s: %v", entry.Key, err)
+					return
+				}
+			}
+			if f.leader == "" {
+				err = fmt.Errorf("follower %s needs a leader", f.addr)
+				return
+			}
+			if f.leader == f.addr {
+				err = fmt.Errorf("follower %s cannot follow itself", f.addr)
+				return
+			}
+			followers = append(followers, f)
+		}
+	}
+	if len(followers) == 0 {
+		err = fmt.Errorf("follow needs at least one follower in followers")
+		return
+	}
+	sort.Slice(followers, func(i, j int) bool { return followers[i].addr < followers[j].addr })
+	return
+}
+
+// followMobility moves follower nodes along with their leaders, keeping a
+// formation. Leaders are moved by whatever else moves them, e.g. another
+// Mobility Manager that they're assigned to; followers are moved each time a
+// position changes. Unlike an attachment, the offset can turn with the
+// leader's direction of travel.
+type followMobility struct {
+	positionManager squirrel.PositionManager
+
+	followers []follower
+	rotate    bool
+	rejoined  bool       // nodes are enabled since last update, so followers need to be placed again
+	mu        sync.Mutex // followers, rotate, rejoined
+
+	// only accessed by update
+	leaders map[string]*leaderTrack // by hardware address
+	applied map[string]squirrel.Position
+
+	changed chan squirrel.PositionUpdate
+	diffs   chan squirrel.EnabledDiff
+	wake    chan struct{}
+	done    chan struct{} // closed by Stop
+}
+
+// leaderTrack is where a leader is, and which way it's heading.
+type leaderTrack struct {
+	pos     squirrel.Position
+	heading float64 // radians, counterclockwise from X axis
+}
+
+func newFollowMobility() squirrel.MobilityManager {
+	return &followMobility{
+		leaders: make(map[string]*leaderTrack),
+		applied: make(map[string]squirrel.Position),
+		changed: make(chan squirrel.PositionUpdate, 64),
+		diffs:   make(chan squirrel.EnabledDiff, 1),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+func (m *followMobility) ParametersHelp() string {
+	return `
+  followers/<mac>/leader [Required]:
+    Hardware address of the node that node <mac> follows. Leaders can be
+    followers themselves.
+
+  followers/<mac>/offset [Optional]:
+    Where the follower is kept relative to its leader, as "x,y" or
+    "x,y,height", in units of positions (/squirrel/master/units and scale).
+    Default: 0,0
+
+  rotate [Optional]:
+    If true, offset is in the leader's frame, i.e. X is ahead of it and Y to
+    its left as it moves; otherwise offset is along axes. Default: false
+
+  To leave leaders to other Mobility Managers, assign followers to this one
+  with /squirrel/master/mobility_managers/<name>.
+    `
+}
+
+func (m *followMobility) Configure(conf *etcd.Node) (err error) {
+	m.followers, m.rotate, err = parseFollowers(conf)
+	return
+}
+
+// Reconfigure replaces the formation; followers move to new offsets right
+// away.
+func (m *followMobility) Reconfigure(conf *etcd.Node) error {
+	followers, rotate, err := parseFollowers(conf)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.followers, m.rotate = followers, rotate
+	m.mu.Unlock()
+	m.nudge()
+	return nil
+}
+
+func (m *followMobility) Initialize(positionManager squirrel.PositionManager) {
+	m.positionManager = positionManager
+	// moving followers causes more notifications, so they only wake up the
+	// goroutine that moves followers
+	positionManager.RegisterPositionChanged(m.changed)
+	go func() {
+		for {
+			select {
+			case <-m.changed:
+				m.nudge()
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	positionManager.RegisterEnabledDiff(m.diffs)
+	go func() {
+		for {
+			select {
+			case diff := <-m.diffs:
+				if len(diff.Added) > 0 {
+					m.mu.Lock()
+					m.rejoined = true
+					m.mu.Unlock()
+					m.nudge()
+				}
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-m.wake:
+				m.update()
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	m.nudge()
+}
+
+// Stop unregisters channels of m, and ends goroutines draining them and the
+// one moving followers.
+func (m *followMobility) Stop() {
+	if m.positionManager == nil {
+		return
+	}
+	m.positionManager.UnregisterPositionChanged(m.changed)
+	m.positionManager.UnregisterEnabledDiff(m.diffs)
+	close(m.done)
+}
+
+func (m *followMobility) nudge() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// update moves followers whose leaders have moved.
+func (m *followMobility) update() {
+	m.mu.Lock()
+	followers, rotate := m.followers, m.rotate
+	if m.rejoined {
+		m.applied = make(map[string]squirrel.Position)
+		m.rejoined = false
+	}
+	m.mu.Unlock()
+	for _, f := range followers {
+		// read in units that positions are set in, so that followers are
+		// placed with the offset as is
+		pos, err := m.positionManager.GetAddr(f.leader)
+		if err != nil {
+			continue
+		}
+		track, ok := m.leaders[f.leader]
+		if !ok {
+			track = &leaderTrack{pos: pos}
+			m.leaders[f.leader] = track
+		} else {
+			if dx, dy := pos.X-track.pos.X, pos.Y-track.pos.Y; dx != 0 || dy != 0 {
+				track.heading = math.Atan2(dy, dx)
+			}
+			track.pos = pos
+		}
+
+		offset := f.offset
+		if rotate {
+			sin, cos := math.Sincos(track.heading)
+			offset.X, offset.Y = f.offset.X*cos-f.offset.Y*sin, f.offset.X*sin+f.offset.Y*cos
+		}
+		target := addOffset(track.pos, offset)
+		if last, ok := m.applied[f.addr]; ok && last == target {
+			continue
+		}
+		if err := m.positionManager.SetPositionAddr(f.addr, &target); err != nil {
+			logger.debugf("follow: %v", err)
+			continue
+		}
+		m.applied[f.addr] = target
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"math"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// WGS84 ellipsoid parameters
+const (
+	wgs84A  = 6378137.0         // semi-major axis, in meters
+	wgs84F  = 1 / 298.257223563 // flattening
+	wgs84E2 = wgs84F * (2 - wgs84F)
+)
+
+// wgs84ToECEF converts a geographic position (X: longitude in degrees, Y:
+// latitude in degrees, Height: altitude in meters above the ellipsoid) into
+// Earth-Centered, Earth-Fixed Cartesian coordinates in meters. Euclidean
+// distance between two ECEF positions is the straight-line distance between
+// them, which is what matters for radio propagation.
+func wgs84ToECEF(pos squirrel.Position) squirrel.Position {
+	lon := pos.X * math.Pi / 180
+	lat := pos.Y * math.Pi / 180
+	sinLat := math.Sin(lat)
+	n := wgs84A / math.Sqrt(1-wgs84E2*sinLat*sinLat)
+	return squirrel.Position{
+		X:      (n + pos.Height) * math.Cos(lat) * math.Cos(lon),
+		Y:      (n + pos.Height) * math.Cos(lat) * math.Sin(lon),
+		Height: (n*(1-wgs84E2) + pos.Height) * sinLat,
+	}
+}
+
+// ecefToENU converts to, in ECEF coordinates, into East-North-Up coordinates
+// relative to origin, at the geographic position at (in ECEF it's origin).
+func ecefToENU(origin, to, at squirrel.Position) squirrel.Position {
+	lon := at.X * math.Pi / 180
+	lat := at.Y * math.Pi / 180
+	dx, dy, dz := to.X-origin.X, to.Y-origin.Y, to.Height-origin.Height
+	return squirrel.Position{
+		X:      -math.Sin(lon)*dx + math.Cos(lon)*dy,
+		Y:      -math.Sin(lat)*math.Cos(lon)*dx - math.Sin(lat)*math.Sin(lon)*dy + math.Cos(lat)*dz,
+		Height: math.Cos(lat)*math.Cos(lon)*dx + math.Cos(lat)*math.Sin(lon)*dy + math.Sin(lat)*dz,
+	}
+}
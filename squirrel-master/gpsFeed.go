@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/common"
+)
+
+type gpsFeedParameters struct {
+	Listen  string `etcd:"listen" default:":10110"`
+	Devices string `etcd:"devices"`
+}
+
+// gpsReport is a position fix from a device. Height is only meaningful if
+// hasHeight is set, as some NMEA sentences don't have altitude.
+type gpsReport struct {
+	device    string // as in devices parameter
+	node      string // hardware address, if the report names the node itself
+	lat, lon  float64
+	height    float64
+	hasHeight bool
+}
+
+// gpsJSON is a JSON report: either a gpsd TPV object, or
+//
+//	{"node": "02:00:00:00:00:01", "lat": 48.1, "lon": 11.5, "alt": 520}
+type gpsJSON struct {
+	Class  string   `json:"class"`
+	Device string   `json:"device"`
+	Mode   int      `json:"mode"`
+	Node   string   `json:"node"`
+	Lat    *float64 `json:"lat"`
+	Lon    *float64 `json:"lon"`
+	Alt    *float64 `json:"alt"`
+}
+
+// errNoFix is returned for reports that are well-formed but carry no
+// position, e.g. NMEA sentences without a fix, or other gpsd classes.
+var errNoFix = errors.New("no fix")
+
+// parseNMEACoordinate parses a latitude (ddmm.mmmm) or longitude (dddmm.mmmm)
+// with its hemisphere into degrees.
+func parseNMEACoordinate(v, hemisphere string) (deg float64, err error) {
+	i := strings.IndexByte(v, '.')
+	if i < 0 {
+		i = len(v)
+	}
+	if i < 3 {
+		return 0, fmt.Errorf("invalid coordinate %q", v)
+	}
+	d, err := strconv.ParseFloat(v[:i-2], 64)
+	if err != nil {
+		return
+	}
+	min, err := strconv.ParseFloat(v[i-2:], 64)
+	if err != nil {
+		return
+	}
+	deg = d + min/60
+	switch hemisphere {
+	case "N", "E":
+	case "S", "W":
+		deg = -deg
+	default:
+		return 0, fmt.Errorf("invalid hemisphere %q", hemisphere)
+	}
+	return
+}
+
+// parseNMEA parses a GGA or RMC sentence, of any talker (GP, GN, GL, ...).
+// Device of the returned report is not set.
+func parseNMEA(line string) (r gpsReport, err error) {
+	body := strings.TrimPrefix(line, "$")
+	if i := strings.LastIndexByte(body, '*'); i >= 0 {
+		var sum []byte
+		if sum, err = hex.DecodeString(body[i+1:]); err != nil || len(sum) != 1 {
+			return r, fmt.Errorf("invalid checksum in %q", line)
+		}
+		body = body[:i]
+		var x byte
+		for k := 0; k < len(body); k++ {
+			x ^= body[k]
+		}
+		if x != sum[0] {
+			return r, fmt.Errorf("checksum mismatch in %q", line)
+		}
+	}
+	fields := strings.Split(body, ",")
+	if len(fields[0]) != 5 {
+		return r, fmt.Errorf("invalid sentence %q", line)
+	}
+	var lat, lon int // indexes of fields
+	switch fields[0][2:] {
+	case "GGA":
+		// $GPGGA,time,lat,N,lon,E,quality,satellites,hdop,altitude,M,...
+		if len(fields) < 10 {
+			return r, fmt.Errorf("short GGA sentence %q", line)
+		}
+		if fields[6] == "" || fields[6] == "0" {
+			return r, errNoFix
+		}
+		lat, lon = 2, 4
+		if fields[9] != "" {
+			if r.height, err = strconv.ParseFloat(fields[9], 64); err != nil {
+				return
+			}
+			r.hasHeight = true
+		}
+	case "RMC":
+		// $GPRMC,time,status,lat,N,lon,E,...
+		if len(fields) < 7 {
+			return r, fmt.Errorf("short RMC sentence %q", line)
+		}
+		if fields[2] != "A" {
+			return r, errNoFix
+		}
+		lat, lon = 3, 5
+	default:
+		return r, errNoFix
+	}
+	if r.lat, err = parseNMEACoordinate(fields[lat], fields[lat+1]); err != nil {
+		return
+	}
+	r.lon, err = parseNMEACoordinate(fields[lon], fields[lon+1])
+	return
+}
+
+// parseGPSJSON parses a JSON report. Device of a gpsd TPV report is its
+// device path.
+func parseGPSJSON(line []byte) (r gpsReport, err error) {
+	var j gpsJSON
+	if err = json.Unmarshal(line, &j); err != nil {
+		return
+	}
+	switch {
+	case j.Class == "TPV":
+		// mode 2 is a 2D fix, 3 a 3D one
+		if j.Mode < 2 {
+			return r, errNoFix
+		}
+		r.device = j.Device
+	case j.Class != "":
+		return r, errNoFix
+	default:
+		var addr net.HardwareAddr
+		if addr, err = net.ParseMAC(j.Node); err != nil {
+			return
+		}
+		r.node = addr.String()
+	}
+	if j.Lat == nil || j.Lon == nil {
+		return r, errNoFix
+	}
+	r.lat, r.lon = *j.Lat, *j.Lon
+	if j.Alt != nil {
+		r.height, r.hasHeight = *j.Alt, true
+	}
+	return
+}
+
+// parseGPSDevices parses the devices parameter, a comma separated list of
+// <device>=<hardware address>.
+func parseGPSDevices(s string) (devices map[string]string, err error) {
+	devices = make(map[string]string)
+	if s == "" {
+		return
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid device mapping %q (expected <device>=<hardware address>)", pair)
+		}
+		var addr net.HardwareAddr
+		if addr, err = net.ParseMAC(kv[1]); err != nil {
+			return
+		}
+		if _, ok := devices[kv[0]]; ok {
+			return nil, fmt.Errorf("device %s is mapped more than once", kv[0])
+		}
+		devices[kv[0]] = addr.String()
+	}
+	return
+}
+
+// gpsFeed mirrors positions reported by real GPS receivers over UDP, e.g.
+// nodes carried around in the field, into emulation. Each datagram has one
+// or more lines, each of which is an NMEA 0183 sentence (GGA or RMC), a gpsd
+// TPV object, or a JSON object naming the node itself.
+type gpsFeed struct {
+	positionManager squirrel.PositionManager
+	listen          string
+	devices         map[string]string // hardware address by device
+
+	conn    net.PacketConn
+	stopped chan struct{}
+}
+
+func newGPSFeed() squirrel.MobilityManager {
+	return &gpsFeed{stopped: make(chan struct{})}
+}
+
+func (m *gpsFeed) ParametersHelp() string {
+	return `
+  listen [Optional]:
+    host:port that reports are received on, over UDP. Default: :10110
+
+  devices [Optional]:
+    Comma separated mapping from devices to hardware addresses of nodes.
+    NMEA sentences are from the device named by IP address of the sender
+    (e.g. 10.0.0.5=02:00:00:00:00:01), and gpsd TPV reports from their device
+    (e.g. /dev/ttyUSB0=02:00:00:00:00:02). Reports from devices not in the
+    mapping are ignored.
+
+  JSON reports as {"node": "<mac>", "lat": ..., "lon": ..., "alt": ...} move
+  node <mac> without a mapping. Positions are in geographic coordinates, so
+  /squirrel/master/coordinate_system needs to be wgs84. NMEA sentences
+  without altitude keep height of the node where it is. Reports are dropped
+  while mobility is paused.
+    `
+}
+
+func (m *gpsFeed) Configure(conf *etcd.Node) (err error) {
+	var params gpsFeedParameters
+	if err = common.DecodeParameters(conf, &params); err != nil {
+		return
+	}
+	if _, _, err = net.SplitHostPort(params.Listen); err != nil {
+		return fmt.Errorf("invalid listen address %s: %v", params.Listen, err)
+	}
+	m.listen = params.Listen
+	m.devices, err = parseGPSDevices(params.Devices)
+	return
+}
+
+func (m *gpsFeed) Initialize(positionManager squirrel.PositionManager) {
+	m.positionManager = positionManager
+	if !isGeographic(positionManager) {
+		logger.errorf("gps: reports are in longitude and latitude, which need coordinate_system to be wgs84; not listening on %s", m.listen)
+		return
+	}
+	conn, err := net.ListenPacket("udp", m.listen)
+	if err != nil {
+		logger.errorf("gps: %v", err)
+		return
+	}
+	logger.infof("gps: listening on %s", m.listen)
+	m.conn = conn
+	go m.receive()
+}
+
+// isGeographic returns whether positionManager, which is master's or a view
+// of it, is in geographic coordinates.
+func isGeographic(positionManager squirrel.PositionManager) bool {
+	switch p := positionManager.(type) {
+	case *PositionManager:
+		return p.geographic
+	case *positionManagerView:
+		return p.geographic
+	}
+	return false
+}
+
+// Stop closes the listener, so that the address can be listened on again.
+func (m *gpsFeed) Stop() {
+	if m.conn != nil {
+		close(m.stopped)
+		m.conn.Close()
+	}
+}
+
+func (m *gpsFeed) receive() {
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := m.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-m.stopped:
+			default:
+				logger.errorf("gps: %v", err)
+			}
+			return
+		}
+		if clock.isPaused() {
+			continue
+		}
+		sender := from.String()
+		if host, _, err := net.SplitHostPort(sender); err == nil {
+			sender = host
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(buf[:n]))
+		for scanner.Scan() {
+			if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+				m.handle(line, sender)
+			}
+		}
+	}
+}
+
+// handle applies a report line from sender, an IP address.
+func (m *gpsFeed) handle(line []byte, sender string) {
+	var r gpsReport
+	var err error
+	switch line[0] {
+	case '$':
+		r, err = parseNMEA(string(line))
+		r.device = sender
+	case '{':
+		r, err = parseGPSJSON(line)
+	default:
+		err = fmt.Errorf("unknown report %q", line)
+	}
+	if err == errNoFix {
+		return
+	}
+	if err != nil {
+		logger.debugf("gps: from %s: %v", sender, err)
+		return
+	}
+	addr := r.node
+	if addr == "" {
+		if addr = m.devices[r.device]; addr == "" {
+			logger.debugf("gps: device %s of report from %s is not mapped to any node", r.device, sender)
+			return
+		}
+	}
+	pos := squirrel.Position{X: r.lon, Y: r.lat, Height: r.height}
+	if !r.hasHeight {
+		// carried over in units that it's set in, so that it isn't scaled
+		// again on each report
+		current, err := m.positionManager.GetAddr(addr)
+		if err != nil {
+			logger.debugf("gps: %v", err)
+			return
+		}
+		pos.Height = current.Height
+	}
+	if err := m.positionManager.SetPositionAddr(addr, &pos); err != nil {
+		logger.debugf("gps: %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/common"
+)
+
+type gpxFile struct {
+	Tracks []struct {
+		Segments []struct {
+			Points []struct {
+				Lat  float64   `xml:"lat,attr"`
+				Lon  float64   `xml:"lon,attr"`
+				Ele  float64   `xml:"ele"`
+				Time time.Time `xml:"time"`
+			} `xml:"trkpt"`
+		} `xml:"trkseg"`
+	} `xml:"trk"`
+}
+
+// gpxTrack is a recorded GPS track, with positions in geographic
+// coordinates.
+type gpxTrack struct {
+	first     time.Time       // time of the first point
+	waypoints []timedPosition // since first
+}
+
+// parseGPX reads points of all tracks (and segments) in a GPX file, in order
+// of time. Points without time are skipped.
+func parseGPX(filename string) (track *gpxTrack, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	var g gpxFile
+	if err = xml.NewDecoder(f).Decode(&g); err != nil {
+		return nil, fmt.Errorf("parsing %s failed: %v", filename, err)
+	}
+	type point struct {
+		t   time.Time
+		pos squirrel.Position
+	}
+	var points []point
+	for _, trk := range g.Tracks {
+		for _, seg := range trk.Segments {
+			for _, p := range seg.Points {
+				if !p.Time.IsZero() {
+					points = append(points, point{t: p.Time, pos: squirrel.Position{X: p.Lon, Y: p.Lat, Height: p.Ele}})
+				}
+			}
+		}
+	}
+	if len(points) == 0 {
+		return nil, fmt.Errorf("%s has no track points with time", filename)
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].t.Before(points[j].t) })
+	track = &gpxTrack{first: points[0].t, waypoints: make([]timedPosition, len(points))}
+	for i, p := range points {
+		track.waypoints[i] = timedPosition{at: p.t.Sub(track.first), pos: p.pos}
+	}
+	return
+}
+
+type gpxParameters struct {
+	Start    string        `etcd:"start"`
+	Interval time.Duration `etcd:"interval" default:"1s"`
+}
+
+// gpxReplay moves each node along the GPX track named by its metadata "gpx".
+type gpxReplay struct {
+	positionManager squirrel.PositionManager
+
+	start    time.Time // recorded time replayed at initialization; zero to start each track from its beginning
+	interval time.Duration
+
+	tracks map[string]*gpxTrack // by path; nil if it can't be read
+	mu     sync.Mutex           // tracks
+
+	ticker squirrel.Ticker
+}
+
+func newGPXReplay() squirrel.MobilityManager {
+	return &gpxReplay{tracks: make(map[string]*gpxTrack)}
+}
+
+func (m *gpxReplay) ParametersHelp() string {
+	return `
+  Each node is moved along the GPX track in file named by its metadata "gpx",
+  e.g. set by /squirrel/master/nodes/<mac>/gpx. Tracks are in geographic
+  coordinates, so /squirrel/master/coordinate_system needs to be wgs84.
+
+  start [Optional]:
+    Recorded time, in RFC 3339, that is replayed when master starts, so that
+    tracks recorded together stay in sync, e.g. 2017-05-01T09:30:00Z.
+    Default: each track is replayed from its beginning.
+
+  interval [Optional]:
+    How often positions are updated. Default: 1s
+    `
+}
+
+func (m *gpxReplay) Configure(conf *etcd.Node) (err error) {
+	var params gpxParameters
+	if err = common.DecodeParameters(conf, &params); err != nil {
+		return
+	}
+	if params.Interval <= 0 {
+		return fmt.Errorf("interval needs to be positive (got %v)", params.Interval)
+	}
+	m.interval = params.Interval
+	if params.Start != "" {
+		if m.start, err = time.Parse(time.RFC3339, params.Start); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (m *gpxReplay) Initialize(positionManager squirrel.PositionManager) {
+	m.positionManager = positionManager
+	m.run(clock.Now())
+}
+
+// Stop stops replaying.
+func (m *gpxReplay) Stop() {
+	if m.ticker != nil {
+		m.ticker.Stop()
+	}
+}
+
+// track returns the parsed track in filename. Files are read once, when a
+// node referring to them is first seen.
+func (m *gpxReplay) track(filename string) *gpxTrack {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	track, ok := m.tracks[filename]
+	if !ok {
+		var err error
+		if track, err = parseGPX(filename); err != nil {
+			logger.errorf("gpx: %v", err)
+		}
+		m.tracks[filename] = track
+	}
+	return track
+}
+
+func (m *gpxReplay) run(started time.Time) {
+	finished := make(map[int]bool) // nodes whose tracks are replayed to the end
+	m.ticker = clock.Every(m.interval, func(now time.Time) {
+		elapsed := now.Sub(started)
+		var updates []squirrel.PositionUpdate
+		var events []squirrel.MobilityEvent
+		for _, index := range m.positionManager.Enabled() {
+			filename, ok := m.positionManager.GetMetadata(index, "gpx")
+			if !ok {
+				continue
+			}
+			track := m.track(filename)
+			if track == nil {
+				continue
+			}
+			t := elapsed
+			if !m.start.IsZero() {
+				t += m.start.Sub(track.first)
+			}
+			updates = append(updates, squirrel.PositionUpdate{Index: index, Position: interpolate(track.waypoints, t)})
+			if !finished[index] && t >= track.waypoints[len(track.waypoints)-1].at {
+				finished[index] = true
+				events = append(events, squirrel.MobilityEvent{Kind: squirrel.TraceFinished, Source: "gpx", Index: index, Time: now})
+			}
+		}
+		if len(updates) > 0 {
+			if err := m.positionManager.SetBatch(updates); err != nil {
+				logger.debugf("gpx: %v", err)
+			}
+		}
+		for _, e := range events {
+			mobilityEvents.Publish(e)
+		}
+	})
+}
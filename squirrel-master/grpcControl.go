@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/control"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// positionFeed fans moves of nodes out to subscribers. Subscribers that don't
+// keep up miss moves rather than holding up Mobility Managers.
+type positionFeed struct {
+	subscribers map[chan<- squirrel.PositionUpdate]bool
+	mu          sync.RWMutex // subscribers
+	dropped     uint64       // accessed atomically
+}
+
+func newPositionFeed(p *PositionManager) *positionFeed {
+	f := &positionFeed{subscribers: make(map[chan<- squirrel.PositionUpdate]bool)}
+	c := make(chan squirrel.PositionUpdate, 1024)
+	p.RegisterPositionChanged(c)
+	go func() {
+		for u := range c {
+			f.publish(u)
+		}
+	}()
+	return f
+}
+
+func (f *positionFeed) publish(u squirrel.PositionUpdate) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for c := range f.subscribers {
+		select {
+		case c <- u:
+		default:
+			if d := atomic.AddUint64(&f.dropped, 1); d == 1 || d%1000 == 0 {
+				logger.warnf("moves of nodes are dropped for slow gRPC subscribers (%d so far)", d)
+			}
+		}
+	}
+}
+
+func (f *positionFeed) subscribe(c chan<- squirrel.PositionUpdate) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.subscribers[c] = true
+}
+
+func (f *positionFeed) unsubscribe(c chan<- squirrel.PositionUpdate) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.subscribers, c)
+}
+
+// grpcControl serves control.ControlServer, a gRPC counterpart of the control
+// API for clients that poll or steer master often, with streams of moves and
+// of links coming up and going down instead of polling.
+type grpcControl struct {
+	master    *Master
+	positions *positionFeed
+}
+
+func (g *grpcControl) Nodes(ctx context.Context, req *control.NodesRequest) (*control.NodesResponse, error) {
+	h := controlHandler{master: g.master}
+	rsp := &control.NodesResponse{Nodes: []control.Node{}}
+	for addr, index := range g.master.addrReverse.All() {
+		n := h.node(addr, index)
+		node := control.Node{Addr: n.Addr, Index: n.Index, Connected: n.Connected, Enabled: n.Enabled, Metadata: n.Metadata}
+		if n.Position != nil {
+			node.Position = &control.Position{X: n.Position.X, Y: n.Position.Y, Height: n.Position.Height}
+		}
+		rsp.Nodes = append(rsp.Nodes, node)
+	}
+	return rsp, nil
+}
+
+func (g *grpcControl) SetPosition(ctx context.Context, req *control.SetPositionRequest) (*control.SetPositionResponse, error) {
+	if _, ok := g.master.addrReverse.GetS(req.Addr); !ok {
+		return nil, status.Errorf(codes.NotFound, "node with hardware address %s is not found", req.Addr)
+	}
+	pos := squirrel.Position{X: req.Position.X, Y: req.Position.Y, Height: req.Position.Height}
+	if err := g.master.positionManager.SetPositionAddr(req.Addr, &pos); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return &control.SetPositionResponse{}, nil
+}
+
+// SetEnabled enables or disables a node as POST /nodes/<mac>/enable and
+// /nodes/<mac>/disable do.
+func (g *grpcControl) SetEnabled(ctx context.Context, req *control.SetEnabledRequest) (*control.SetEnabledResponse, error) {
+	index, ok := g.master.addrReverse.GetS(req.Addr)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "node with hardware address %s is not found", req.Addr)
+	}
+	if g.master.clients[index] == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "node with hardware address %s is not connected", req.Addr)
+	}
+	if req.Enabled {
+		g.master.positionManager.Enable(index)
+		logger.infof("%s is enabled through the gRPC control API", req.Addr)
+	} else {
+		g.master.positionManager.Disable(index)
+		logger.infof("%s is disabled through the gRPC control API", req.Addr)
+	}
+	return &control.SetEnabledResponse{}, nil
+}
+
+// quality returns quality of the link from source to destination, as
+// September tells it.
+func (g *grpcControl) quality(q squirrel.LinkQualifier, from, to string, source, destination int) (control.LinkQuality, bool) {
+	lq, ok := q.LinkQuality(source, destination)
+	if !ok {
+		return control.LinkQuality{}, false
+	}
+	c := control.LinkQuality{From: from, To: to, Loss: lq.Loss, Rate: lq.Rate}
+	if !math.IsNaN(lq.RSSI) {
+		c.RSSI, c.SNR = &lq.RSSI, &lq.SNR
+	}
+	return c, true
+}
+
+func (g *grpcControl) LinkQuality(ctx context.Context, req *control.LinkQualityRequest) (*control.LinkQualityResponse, error) {
+	q, ok := g.master.september.(squirrel.LinkQualifier)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "September doesn't tell quality of links")
+	}
+	source, ok1 := g.master.addrReverse.GetS(req.From)
+	destination, ok2 := g.master.addrReverse.GetS(req.To)
+	if !ok1 || !ok2 {
+		return nil, status.Errorf(codes.NotFound, "link from %s to %s is not found", req.From, req.To)
+	}
+	c, ok := g.quality(q, strings.ToLower(req.From), strings.ToLower(req.To), source, destination)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "link from %s to %s is not found", req.From, req.To)
+	}
+	return &control.LinkQualityResponse{Quality: c}, nil
+}
+
+// WatchPositions sends moves of nodes in configured units, like Nodes.
+func (g *grpcControl) WatchPositions(req *control.WatchPositionsRequest, stream grpc.ServerStream) error {
+	c := make(chan squirrel.PositionUpdate, 1024)
+	g.positions.subscribe(c)
+	defer g.positions.unsubscribe(c)
+	names := make(map[int]string)
+	for {
+		select {
+		case u := <-c:
+			name, ok := names[u.Index]
+			if !ok {
+				// the node has joined since names were last looked up
+				for addr, index := range g.master.addrReverse.All() {
+					names[index] = addr
+				}
+				name = names[u.Index]
+			}
+			pos := g.master.positionManager.toSupplied(u.Position)
+			e := &control.PositionEvent{Addr: name, Index: u.Index, Position: control.Position{X: pos.X, Y: pos.Y, Height: pos.Height}, TimeUnixNano: time.Now().UnixNano()}
+			if err := stream.SendMsg(e); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// WatchLinks checks links between all enabled nodes every interval of req,
+// and sends those that have come up or gone down since the last check, as
+// SendUnicast of September would have it: a link is up unless it's certain to
+// lose packets.
+func (g *grpcControl) WatchLinks(req *control.WatchLinksRequest, stream grpc.ServerStream) error {
+	q, ok := g.master.september.(squirrel.LinkQualifier)
+	if !ok {
+		return status.Error(codes.Unimplemented, "September doesn't tell quality of links")
+	}
+	interval := time.Second
+	if req.IntervalMS < 0 {
+		return status.Errorf(codes.InvalidArgument, "interval cannot be negative (got %d ms)", req.IntervalMS)
+	} else if req.IntervalMS > 0 {
+		interval = time.Duration(req.IntervalMS) * time.Millisecond
+	}
+	up := make(map[linkPair]control.LinkQuality)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		addrs := g.master.addrReverse.All()
+		seen := make(map[linkPair]bool, len(up))
+		now := time.Now().UnixNano()
+		for from, source := range addrs {
+			if !g.master.positionManager.IsEnabled(source) {
+				continue
+			}
+			for to, destination := range addrs {
+				if source == destination || !g.master.positionManager.IsEnabled(destination) {
+					continue
+				}
+				pair := linkPair{src: source, dst: destination}
+				c, ok := g.quality(q, from, to, source, destination)
+				if !ok || c.Loss >= 1 {
+					continue
+				}
+				seen[pair] = true
+				if _, ok := up[pair]; ok {
+					up[pair] = c
+					continue
+				}
+				up[pair] = c
+				if err := stream.SendMsg(&control.LinkEvent{Up: true, Quality: c, TimeUnixNano: now}); err != nil {
+					return err
+				}
+			}
+		}
+		for pair, c := range up {
+			if seen[pair] {
+				continue
+			}
+			delete(up, pair)
+			c.Loss, c.RSSI, c.SNR, c.Rate = 1, nil, nil, 0
+			if err := stream.SendMsg(&control.LinkEvent{Up: false, Quality: c, TimeUnixNano: now}); err != nil {
+				return err
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// serveGRPC serves the gRPC control service of master on laddr in
+// background, over TLS if master's listener is.
+func serveGRPC(laddr string, master *Master) {
+	warnUnsecured("gRPC control API", laddr, master)
+	var opts []grpc.ServerOption
+	if master.tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(master.tlsConfig)))
+	}
+	go func() {
+		listener, err := net.Listen("tcp", laddr)
+		if err != nil {
+			logger.errorf("gRPC control API: %v", err)
+			return
+		}
+		server := grpc.NewServer(opts...)
+		control.RegisterControlServer(server, &grpcControl{master: master, positions: newPositionFeed(master.positionManager)})
+		logger.infof("gRPC control API: listening on %s", laddr)
+		if err = server.Serve(listener); err != nil {
+			logger.errorf("gRPC control API: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/common"
+)
+
+type httpMobilityParameters struct {
+	Listen string `etcd:"listen" default:":8080"`
+}
+
+// httpPosition is the body of requests and responses of httpMobility.
+type httpPosition struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Height float64 `json:"height"`
+}
+
+// httpMobility lets external controllers, e.g. scripts, gamepads or other
+// simulators, move nodes over HTTP:
+//
+//	POST /nodes/<mac>/position {"x": 1, "y": 2, "height": 0}
+//	GET  /nodes/<mac>/position
+type httpMobility struct {
+	positionManager squirrel.PositionManager
+	listen          string
+	server          *http.Server
+}
+
+func newHTTPMobility() squirrel.MobilityManager {
+	return &httpMobility{}
+}
+
+func (m *httpMobility) ParametersHelp() string {
+	return `
+  listen [Optional]:
+    host:port that the HTTP API listens on. Default: :8080
+
+  POST /nodes/<mac>/position with {"x": ..., "y": ..., "height": ...} moves
+  node with hardware address <mac>; GET /nodes/<mac>/position returns where it
+  is.
+    `
+}
+
+func (m *httpMobility) Configure(conf *etcd.Node) (err error) {
+	var params httpMobilityParameters
+	if err = common.DecodeParameters(conf, &params); err != nil {
+		return
+	}
+	if _, _, err = net.SplitHostPort(params.Listen); err != nil {
+		return fmt.Errorf("invalid listen address %s: %v", params.Listen, err)
+	}
+	m.listen = params.Listen
+	return
+}
+
+func (m *httpMobility) Initialize(positionManager squirrel.PositionManager) {
+	m.positionManager = positionManager
+	m.server = &http.Server{Addr: m.listen, Handler: m}
+	go func() {
+		logger.infof("http mobility: listening on %s", m.listen)
+		if err := m.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.errorf("http mobility: %v", err)
+		}
+	}()
+}
+
+// Stop closes the listener, so that the address can be listened on again.
+func (m *httpMobility) Stop() {
+	if m.server != nil {
+		m.server.Close()
+	}
+}
+
+func (m *httpMobility) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// /nodes/<mac>/position
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[0] != "nodes" || parts[2] != "position" {
+		http.NotFound(w, r)
+		return
+	}
+	addr, err := net.ParseMAC(parts[1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		pos, err := m.positionManager.GetAddr(addr.String())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(httpPosition{X: pos.X, Y: pos.Y, Height: pos.Height})
+	case "POST", "PUT":
+		if clock.isPaused() {
+			http.Error(w, "mobility is paused", http.StatusConflict)
+			return
+		}
+		var p httpPosition
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		pos := squirrel.Position{X: p.X, Y: p.Y, Height: p.Height}
+		if err := m.positionManager.SetPositionAddr(addr.String(), &pos); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// linkImpairment duplicates and reorders frames that September decides to
+// deliver, so that transport protocols can be tested against them as well as
+// against loss. A duplicate goes through the link as a frame of its own,
+// right after the original one. A reordered frame is held back for
+// reorderDelay beyond its delay, while frames sent after it on the same link
+// aren't held behind it, so they can overtake it.
+type linkImpairment struct {
+	duplicate    float64       // probability that a frame is duplicated, on links without an override
+	reorder      float64       // probability that a frame is reordered, likewise
+	reorderDelay time.Duration // likewise
+	mu           sync.Mutex    // duplicate, reorder and reorderDelay
+}
+
+func newLinkImpairment() *linkImpairment {
+	return &linkImpairment{}
+}
+
+// checkImpairment checks probabilities of duplicating and reordering frames,
+// and how long reordered ones are held back.
+func checkImpairment(duplicate, reorder float64, reorderDelay time.Duration) error {
+	if duplicate < 0 || duplicate > 1 || reorder < 0 || reorder > 1 {
+		return fmt.Errorf("duplicate and reorder need to be between 0 and 1 (got %v and %v)", duplicate, reorder)
+	}
+	if reorderDelay < 0 {
+		return fmt.Errorf("reorder_delay cannot be negative (got %v)", reorderDelay)
+	}
+	return nil
+}
+
+// set changes impairments of links without an override, from the next frame
+// on.
+func (i *linkImpairment) set(duplicate, reorder float64, reorderDelay time.Duration) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.duplicate, i.reorder, i.reorderDelay = duplicate, reorder, reorderDelay
+}
+
+// get returns impairments of a link whose override is o, if any; those it
+// sets take precedence.
+func (i *linkImpairment) get(o *linkOverride) (duplicate, reorder float64, reorderDelay time.Duration) {
+	i.mu.Lock()
+	duplicate, reorder, reorderDelay = i.duplicate, i.reorder, i.reorderDelay
+	i.mu.Unlock()
+	if o != nil {
+		if o.duplicate != nil {
+			duplicate = *o.duplicate
+		}
+		if o.reorder != nil {
+			reorder = *o.reorder
+		}
+		if o.reorderDelay > 0 {
+			reorderDelay = o.reorderDelay
+		}
+	}
+	return
+}
+
+// duplicated returns whether a frame on a link whose override is o is
+// duplicated, drawn from r.
+func (i *linkImpairment) duplicated(o *linkOverride, r squirrel.Rand) bool {
+	duplicate, _, _ := i.get(o)
+	return duplicate > 0 && r.Float64() < duplicate
+}
+
+// holdBack returns how much longer than its delay a frame on a link whose
+// override is o is held back to be reordered, or 0 if it isn't, drawn from
+// r.
+func (i *linkImpairment) holdBack(o *linkOverride, r squirrel.Rand) time.Duration {
+	_, reorder, reorderDelay := i.get(o)
+	if reorder > 0 && r.Float64() < reorder {
+		return reorderDelay
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// includeKey is the child of a Mobility Manager or September configuration
+// Dir that lists (comma separated) other Dirs whose entries are merged into
+// it, e.g. common September parameters shared by several scenarios.
+const includeKey = "_include"
+
+// mergeIncludes returns node with entries of Dirs listed in its _include child
+// merged in. Entries of node take precedence over included ones, and later
+// includes over earlier ones. Included Dirs can include others.
+func mergeIncludes(client *configClient, node *etcd.Node) (*etcd.Node, error) {
+	return mergeIncludesVisiting(client, node, make(map[string]bool))
+}
+
+func mergeIncludesVisiting(client *configClient, node *etcd.Node, visiting map[string]bool) (merged *etcd.Node, err error) {
+	var includes []string
+	own := &etcd.Node{Key: node.Key, Dir: true}
+	for _, child := range node.Nodes {
+		if path.Base(child.Key) == includeKey && !child.Dir {
+			for _, dir := range strings.Split(child.Value, ",") {
+				if dir = strings.TrimSpace(dir); dir != "" {
+					includes = append(includes, dir)
+				}
+			}
+		} else {
+			own.Nodes = append(own.Nodes, child)
+		}
+	}
+	if len(includes) == 0 {
+		return node, nil
+	}
+
+	visiting[node.Key] = true
+	defer delete(visiting, node.Key)
+	merged = &etcd.Node{Key: node.Key, Dir: true}
+	for _, dir := range includes {
+		if visiting[dir] {
+			err = fmt.Errorf("%s includes itself through %s", dir, node.Key)
+			return
+		}
+		var resp *etcd.Response
+		resp, err = client.Get(dir, false, true)
+		if err != nil {
+			return
+		}
+		if !resp.Node.Dir {
+			err = fmt.Errorf("%s included by %s is not a Dir node", dir, node.Key)
+			return
+		}
+		var included *etcd.Node
+		if included, err = mergeIncludesVisiting(client, resp.Node, visiting); err != nil {
+			return
+		}
+		merged = mergeEtcdNodes(merged, included, node.Key)
+	}
+	merged = mergeEtcdNodes(merged, own, node.Key)
+	return
+}
+
+// mergeEtcdNodes returns a Dir at key that has children of both base and
+// override. Children that exist in both are taken from override, or merged if
+// they are both Dirs. Keys of children are rewritten to be under key.
+func mergeEtcdNodes(base, override *etcd.Node, key string) *etcd.Node {
+	ret := &etcd.Node{Key: key, Dir: true}
+	index := make(map[string]int)
+	add := func(child *etcd.Node) {
+		name := path.Base(child.Key)
+		childKey := path.Join(key, name)
+		if i, ok := index[name]; ok {
+			if ret.Nodes[i].Dir && child.Dir {
+				ret.Nodes[i] = mergeEtcdNodes(ret.Nodes[i], child, childKey)
+				return
+			}
+			ret.Nodes[i] = rekeyEtcdNode(child, childKey)
+			return
+		}
+		index[name] = len(ret.Nodes)
+		ret.Nodes = append(ret.Nodes, rekeyEtcdNode(child, childKey))
+	}
+	for _, child := range base.Nodes {
+		add(child)
+	}
+	for _, child := range override.Nodes {
+		add(child)
+	}
+	return ret
+}
+
+// rekeyEtcdNode returns a copy of node (and its children) moved to key.
+func rekeyEtcdNode(node *etcd.Node, key string) *etcd.Node {
+	ret := *node
+	ret.Key = key
+	ret.Nodes = nil
+	for _, child := range node.Nodes {
+		ret.Nodes = append(ret.Nodes, rekeyEtcdNode(child, path.Join(key, path.Base(child.Key))))
+	}
+	return &ret
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/common"
+)
+
+type wallParameters struct {
+	Points   string  `etcd:"points,required"`
+	Material string  `etcd:"material"`
+	Loss     float64 `etcd:"loss" default:"0"`
+	Floor    int     `etcd:"floor" default:"-1"`
+}
+
+type materialParameters struct {
+	Loss float64 `etcd:"loss,required"`
+}
+
+type indoorParameters struct {
+	TxPower        float64                       `etcd:"tx_power" default:"20"`
+	Sensitivity    float64                       `etcd:"sensitivity" default:"-90"`
+	MinSensitivity string                        `etcd:"min_sensitivity"`
+	Transition     float64                       `etcd:"transition" default:"2"`
+	Frequency      float64                       `etcd:"frequency" default:"2.4e9"`
+	Channels       map[string]channelParameters  `etcd:"channels"`
+	Exponent       float64                       `etcd:"exponent" default:"2"`
+	Walls          map[string]wallParameters     `etcd:"walls"`
+	Materials      map[string]materialParameters `etcd:"materials"`
+	FloorHeight    float64                       `etcd:"floor_height" default:"3"`
+	FloorLoss      float64                       `etcd:"floor_loss" default:"15"`
+	NoiseFloor     float64                       `etcd:"noise_floor" default:"-95"`
+	ErrorModel     errorModelParameters          `etcd:"error_model"`
+	RateAdaptation rateAdaptationParameters      `etcd:"rate_adaptation"`
+	Obstruction    obstructionParameters         `etcd:"obstruction"`
+	Antennas       map[string]antennaParameters  `etcd:"antennas"`
+	DefaultAntenna string                        `etcd:"default_antenna"`
+	Fading         fadingParameters              `etcd:"fading"`
+	Shadowing      shadowingParameters           `etcd:"shadowing"`
+	Interference   interferenceParameters        `etcd:"interference"`
+	MAC            macParameters                 `etcd:"mac"`
+	Delay          delayParameters               `etcd:"delay"`
+	Doppler        dopplerParameters             `etcd:"doppler"`
+}
+
+func (p *indoorParameters) radio() radioParameters {
+	return radioParameters{
+		txPower:      p.TxPower,
+		sensitivity:  p.Sensitivity,
+		lowest:       p.MinSensitivity,
+		transition:   p.Transition,
+		frequency:    p.Frequency,
+		channels:     p.Channels,
+		noiseFloor:   p.NoiseFloor,
+		errors:       p.ErrorModel,
+		rates:        p.RateAdaptation,
+		obstruction:  p.Obstruction,
+		antennas:     p.Antennas,
+		antenna:      p.DefaultAntenna,
+		fading:       p.Fading,
+		shadowing:    p.Shadowing,
+		interference: p.Interference,
+		mac:          p.MAC,
+		delay:        p.Delay,
+		doppler:      p.Doppler,
+	}
+}
+
+// materials are losses in dB of walls of common materials at 2.4 GHz, which
+// materials in parameters add to or override.
+var materials = map[string]float64{
+	"drywall":  3,
+	"glass":    2,
+	"wood":     4,
+	"brick":    8,
+	"concrete": 12,
+	"metal":    25,
+}
+
+// wall is a segment of a floor plan. It's on all floors if floor is
+// negative.
+type wall struct {
+	a, b  point2
+	loss  float64 // dB
+	floor int
+}
+
+// indoorLoss is multi-wall path loss (Motley-Keenan): free-space loss at a
+// meter, 10*exponent dB more for each tenfold distance beyond it, and losses
+// of each wall and floor between nodes.
+type indoorLoss struct {
+	exponent    float64
+	walls       []wall
+	floorHeight float64
+	floorLoss   float64
+}
+
+// floor returns which floor height is on.
+func (l *indoorLoss) floor(height float64) int {
+	return int(math.Floor(height / l.floorHeight))
+}
+
+func (l *indoorLoss) loss(tx, rx squirrel.Position, d, frequency float64) float64 {
+	loss := freeSpaceLoss(1, frequency)
+	if d > 1 {
+		loss += 10 * l.exponent * math.Log10(d)
+	}
+	f1, f2 := l.floor(tx.Height), l.floor(rx.Height)
+	if f1 != f2 {
+		loss += math.Abs(float64(f1-f2)) * l.floorLoss
+	}
+	p1, p2 := point2{tx.X, tx.Y}, point2{rx.X, rx.Y}
+	for _, w := range l.walls {
+		t, ok := segmentIntersection(p1, p2, w.a, w.b)
+		if ok && (w.floor < 0 || l.floor(tx.Height+t*(rx.Height-tx.Height)) == w.floor) {
+			loss += w.loss
+		}
+	}
+	return loss
+}
+
+// maxRange is range without walls and floors, which only add loss.
+func (l *indoorLoss) maxRange(loss, frequency float64) float64 {
+	reference := freeSpaceLoss(1, frequency)
+	if loss < reference {
+		return 1
+	}
+	return math.Pow(10, (loss-reference)/(10*l.exponent))
+}
+
+func parseIndoor(conf *etcd.Node) (*radioConfig, error) {
+	var params indoorParameters
+	if err := common.DecodeParameters(conf, &params); err != nil {
+		return nil, err
+	}
+	if params.Frequency <= 0 {
+		return nil, fmt.Errorf("frequency needs to be positive (got %v)", params.Frequency)
+	}
+	if params.Exponent <= 0 {
+		return nil, fmt.Errorf("exponent needs to be positive (got %v)", params.Exponent)
+	}
+	if params.FloorHeight <= 0 {
+		return nil, fmt.Errorf("floor_height needs to be positive (got %v)", params.FloorHeight)
+	}
+	losses := make(map[string]float64, len(materials)+len(params.Materials))
+	for name, loss := range materials {
+		losses[name] = loss
+	}
+	for name, m := range params.Materials {
+		losses[name] = m.Loss
+	}
+	model := &indoorLoss{
+		exponent:    params.Exponent,
+		floorHeight: params.FloorHeight,
+		floorLoss:   params.FloorLoss,
+	}
+	for name, w := range params.Walls {
+		points, err := parsePolygon(w.Points)
+		if err != nil {
+			return nil, fmt.Errorf("walls/%s/points: %v", name, err)
+		}
+		loss := w.Loss
+		if w.Material != "" {
+			var ok bool
+			if loss, ok = losses[w.Material]; !ok {
+				return nil, fmt.Errorf("walls/%s/material %s doesn't exist in materials", name, w.Material)
+			}
+		}
+		for i := 0; i+1 < len(points); i++ {
+			model.walls = append(model.walls, wall{a: points[i], b: points[i+1], loss: loss, floor: w.Floor})
+		}
+	}
+	return newRadioConfig(params.radio(), model)
+}
+
+// newIndoorSeptember creates a September that delivers packets by received
+// power under multi-wall path loss of a floor plan, for office or factory
+// WLAN scenarios.
+func newIndoorSeptember() squirrel.September {
+	return &radioSeptember{
+		parse: parseIndoor,
+		help: radioHelp + `
+  exponent [Optional]:
+    Path loss exponent between walls: loss grows by 10*exponent dB for each
+    tenfold distance beyond a meter. Default: 2
+
+  walls/<name>/points [Required]:
+    Wall <name> of the floor plan, as "x1,y1 x2,y2 ..."; each pair of
+    consecutive points is a segment of it.
+
+  walls/<name>/material, walls/<name>/loss [Optional]:
+    Loss in dB that the wall adds to paths crossing it: that of material
+    (from materials, or built-in drywall 3, glass 2, wood 4, brick 8,
+    concrete 12 and metal 25), or loss. Default: loss of 0
+
+  walls/<name>/floor [Optional]:
+    Floor that the wall is on, the ground one being 0, or -1 for all of them.
+    Default: -1
+
+  materials/<name>/loss [Required]:
+    Loss in dB of walls of material <name>, adding to or overriding built-in
+    ones.
+
+  floor_height, floor_loss [Optional]:
+    Height in meters of floors, by which Height of nodes tells which floor
+    they are on, and loss in dB that each floor between nodes adds.
+    Default: 3 and 15
+
+  Path loss is free-space loss at a meter, plus 10*exponent dB for each
+  tenfold distance, plus losses of each wall and floor crossed (the
+  multi-wall model). Each packet is delivered with a probability that goes
+  from 0 to 1 as received power, tx_power minus path loss, rises through
+  sensitivity.
+    `,
+	}
+}
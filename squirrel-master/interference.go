@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+type interferenceParameters struct {
+	Enabled       bool          `etcd:"enabled" default:"false"`
+	Bitrate       string        `etcd:"bitrate" default:"6M"`
+	Preamble      time.Duration `etcd:"preamble" default:"20us"`
+	NoiseFloor    string        `etcd:"noise_floor"`
+	SINRThreshold float64       `etcd:"sinr_threshold" default:"10"`
+}
+
+// interferenceHelp documents interference parameters of radio propagation
+// Septembers, for ParametersHelp.
+const interferenceHelp = `
+  interference/enabled [Optional]:
+    If true, frames on the air at the same time interfere with each other:
+    a frame is also dropped with a probability given by its SINR, i.e.
+    received power over noise_floor plus power received from all other
+    transmissions overlapping with it. Frames are received when they are
+    fully on the air, so one can be corrupted by another that starts later,
+    e.g. from a hidden terminal out of range of its sender. Nodes don't
+    receive while they are transmitting. Default: false
+
+  interference/bitrate, interference/preamble [Optional]:
+    How long frames are on the air, also for mac/csma: preamble plus size at
+    bitrate, in bits per second (e.g. 54M). Default: 6M and 20us
+
+  interference/noise_floor [Optional]:
+    Noise power in dBm. Default: noise_floor
+
+  interference/sinr_threshold [Optional]:
+    SINR in dB at which half of frames are decoded; transition applies as it
+    does to sensitivity. Unused with the ber error model, which judges SINR
+    as it does SNR. Default: 10
+`
+
+// transmission is a frame on the air. Its fields other than receptions are
+// never modified after it's put on the air.
+type transmission struct {
+	source     int
+	size       int       // bytes
+	rate       *ofdmRate // nil for the configured one
+	start, end time.Time
+	receptions map[int]*reception // by receiver; only with interference
+}
+
+// reception is a transmission being received by a node.
+type reception struct {
+	signal       float64 // mW
+	interference float64 // mW, including noise
+	draw         float64 // uniform in [0, 1); decoded while below probability by SINR
+	corrupted    bool
+}
+
+// airspace tracks transmissions in flight, for computing interference among
+// them and sensing the medium. A frame that overlaps with another one
+// interferes with it, whichever is sent first: frames are received when
+// they are fully on the air, and can be corrupted until then, e.g. by a
+// hidden terminal that starts transmitting to the same receiver halfway.
+type airspace struct {
+	bitrate       float64 // bits per second
+	preamble      time.Duration
+	interference  bool        // whether frames are judged by SINR
+	noiseFloor    float64     // mW
+	sinrThreshold float64     // dB
+	transition    float64     // dB
+	errors        *errorModel // nil for sinrThreshold and transition
+	csma          *csma       // nil if frames go on the air right away
+
+	inFlight []*transmission
+	latest   map[int]*transmission // by source
+	mu       sync.Mutex            // inFlight, latest, receptions of transmissions
+}
+
+// newAirspace returns nil if neither interference nor CSMA is enabled.
+func newAirspace(params interferenceParameters, mac macParameters, transition, noiseFloor float64, errors *errorModel) (*airspace, error) {
+	c, err := newCSMA(mac)
+	if err != nil {
+		return nil, err
+	}
+	if !params.Enabled && c == nil {
+		return nil, nil
+	}
+	bitrate, err := parseBitRate(params.Bitrate)
+	if err != nil {
+		return nil, fmt.Errorf("interference/bitrate: %v", err)
+	}
+	if bitrate <= 0 {
+		return nil, fmt.Errorf("interference/bitrate needs to be positive (got %s)", params.Bitrate)
+	}
+	if params.Preamble < 0 {
+		return nil, fmt.Errorf("interference/preamble cannot be negative (got %v)", params.Preamble)
+	}
+	if noiseFloor, err = parseNoiseFloor(params.NoiseFloor, noiseFloor); err != nil {
+		return nil, fmt.Errorf("invalid interference/noise_floor %q: %v", params.NoiseFloor, err)
+	}
+	return &airspace{
+		bitrate:       bitrate,
+		preamble:      params.Preamble,
+		interference:  params.Enabled,
+		noiseFloor:    dBmToMilliwatts(noiseFloor),
+		sinrThreshold: params.SINRThreshold,
+		transition:    transition,
+		errors:        errors,
+		csma:          c,
+		latest:        make(map[int]*transmission),
+	}, nil
+}
+
+func dBmToMilliwatts(dBm float64) float64 {
+	return math.Pow(10, dBm/10)
+}
+
+func milliwattsToDBm(mW float64) float64 {
+	return 10 * math.Log10(mW)
+}
+
+// airtime returns how long a frame of size bytes is on the air at rate, or
+// bitrate if it's nil.
+func (a *airspace) airtime(size int, rate *ofdmRate) time.Duration {
+	bitrate := a.bitrate
+	if rate != nil {
+		bitrate = a.errors.bitrate(rate)
+	}
+	return a.preamble + time.Duration(float64(size)*8/bitrate*float64(time.Second))
+}
+
+// transmit puts a frame of size bytes from source on the air at rate (nil
+// for the configured one), and returns
+// other transmissions that overlap with it. With CSMA, the frame waits until
+// source senses the medium idle, by senses(other) of each node transmitting;
+// it's dropped (ok is false) if it would wait longer than max_delay.
+// Transmissions that are over are forgotten.
+func (a *airspace) transmit(source, size int, rate *ofdmRate, senses func(other int) bool, r squirrel.Rand) (t *transmission, others []*transmission, ok bool) {
+	now := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	inFlight := a.inFlight[:0]
+	for _, f := range a.inFlight {
+		if f.end.After(now) {
+			inFlight = append(inFlight, f)
+		} else if a.latest[f.source] == f {
+			delete(a.latest, f.source)
+		}
+	}
+	for i := len(inFlight); i < len(a.inFlight); i++ {
+		a.inFlight[i] = nil
+	}
+	a.inFlight = inFlight
+
+	start := now
+	if a.csma != nil {
+		if start, ok = a.csma.access(source, now, a.inFlight, senses, r); !ok {
+			return nil, nil, false
+		}
+	}
+	t = &transmission{source: source, size: size, rate: rate, start: start, end: start.Add(a.airtime(size, rate))}
+	if a.interference {
+		t.receptions = make(map[int]*reception)
+	}
+	for _, other := range a.inFlight {
+		if other.source != source && other.start.Before(t.end) && other.end.After(t.start) {
+			others = append(others, other)
+		}
+	}
+	a.inFlight = append(a.inFlight, t)
+	a.latest[source] = t
+	return t, others, true
+}
+
+// decodes returns whether SINR of a reception of t is high enough for it to
+// be decoded.
+func (a *airspace) decodes(t *transmission, r *reception) bool {
+	sinr := milliwattsToDBm(r.signal) - milliwattsToDBm(r.interference)
+	if a.errors != nil {
+		return r.draw < a.errors.success(t.rate, sinr, t.size)
+	}
+	return r.draw < deliveryProbability(sinr, a.sinrThreshold, a.transition)
+}
+
+// receive records that receiver receives t at signal mW, with interference
+// mW from overlapping transmissions (not including noise), and returns
+// whether it's decoded so far. Whether it's decoded at its SINR is drawn
+// from random.
+func (a *airspace) receive(t *transmission, receiver int, signal, interference float64, random squirrel.Rand) bool {
+	r := &reception{signal: signal, interference: a.noiseFloor + interference, draw: random.Float64()}
+	r.corrupted = !a.decodes(t, r)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t.receptions[receiver] = r
+	return !r.corrupted
+}
+
+// receivers returns nodes receiving any of transmissions, each once.
+func (a *airspace) receivers(transmissions []*transmission) (ret []int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	seen := make(map[int]bool)
+	for _, t := range transmissions {
+		for receiver := range t.receptions {
+			if !seen[receiver] {
+				seen[receiver] = true
+				ret = append(ret, receiver)
+			}
+		}
+	}
+	return
+}
+
+// interfere adds interference of a later transmission to receptions of
+// transmissions by receiver: power received is power[receiver] mW, or
+// +Inf if receiver is the one transmitting, as nodes can't receive while
+// they are transmitting.
+func (a *airspace) interfere(transmissions []*transmission, power map[int]float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, t := range transmissions {
+		for receiver, r := range t.receptions {
+			if p, ok := power[receiver]; ok && !r.corrupted {
+				r.interference += p
+				r.corrupted = !a.decodes(t, r)
+			}
+		}
+	}
+}
+
+// confirm returns a function that tells whether t is still decoded by
+// receiver, or nil if it can't be corrupted.
+func (a *airspace) confirm(t *transmission, receiver int) func() bool {
+	if t == nil || t.receptions == nil {
+		return nil
+	}
+	return func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		r, ok := t.receptions[receiver]
+		return !ok || !r.corrupted
+	}
+}
+
+// delay returns how long until the latest frame of source is fully on the
+// air, which is when it's received.
+func (a *airspace) delay(source int) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if t, ok := a.latest[source]; ok {
+		if d := time.Until(t.end); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// latestOf returns the latest transmission of source, or nil if it's over.
+func (a *airspace) latestOf(source int) *transmission {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.latest[source]
+}
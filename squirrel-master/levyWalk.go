@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/common"
+)
+
+type levyWalkParameters struct {
+	Alpha      float64       `etcd:"alpha" default:"1.5"`
+	MinFlight  float64       `etcd:"min_flight" default:"1"`
+	MaxFlight  float64       `etcd:"max_flight" default:"0"`
+	Speed      float64       `etcd:"speed" default:"1"`
+	PauseAlpha float64       `etcd:"pause_alpha" default:"1.5"`
+	MinPause   time.Duration `etcd:"min_pause" default:"0s"`
+	MaxPause   time.Duration `etcd:"max_pause" default:"0s"`
+	Interval   time.Duration `etcd:"interval" default:"100ms"`
+	Seed       int64         `etcd:"seed" default:"0"`
+	Arena      mobilityArena `etcd:"arena"`
+}
+
+func (p *levyWalkParameters) check() error {
+	if p.Alpha <= 0 || p.Alpha > 2 || p.PauseAlpha <= 0 || p.PauseAlpha > 2 {
+		return fmt.Errorf("levy-walk needs alpha and pause_alpha in (0, 2] (got %v and %v)", p.Alpha, p.PauseAlpha)
+	}
+	if p.MinFlight <= 0 || (p.MaxFlight != 0 && p.MaxFlight < p.MinFlight) {
+		return fmt.Errorf("levy-walk needs 0 < min_flight <= max_flight, or max_flight 0 (got %v and %v)", p.MinFlight, p.MaxFlight)
+	}
+	if p.MinPause < 0 || (p.MaxPause != 0 && p.MaxPause < p.MinPause) {
+		return fmt.Errorf("levy-walk needs 0 <= min_pause <= max_pause, or max_pause 0 (got %v and %v)", p.MinPause, p.MaxPause)
+	}
+	if p.Speed <= 0 {
+		return fmt.Errorf("speed needs to be positive (got %v)", p.Speed)
+	}
+	if p.Interval <= 0 {
+		return fmt.Errorf("interval needs to be positive (got %v)", p.Interval)
+	}
+	return p.Arena.check()
+}
+
+// pareto draws from a Pareto distribution with scale min and shape alpha, so
+// that P(x > l) = (min/l)^alpha, truncated at max unless it's 0.
+func pareto(r *rand.Rand, min, max, alpha float64) float64 {
+	// 1-Float64() is in (0, 1]
+	x := min * math.Pow(1-r.Float64(), -1/alpha)
+	if max > 0 && x > max {
+		x = max
+	}
+	return x
+}
+
+type levyNode struct {
+	pos        squirrel.Position
+	heading    float64 // radians, counterclockwise from X axis
+	climb      float64 // sine of pitch
+	remaining  float64 // distance left in current flight
+	pauseUntil time.Time
+}
+
+// levyWalk moves nodes in flights of heavy-tailed lengths, each in a random
+// direction (in 3D if the arena has a range of altitude) at constant speed,
+// optionally with a heavy-tailed pause between them. Most flights are short,
+// but occasional long ones are much more common than under random-walk, as
+// observed in human mobility. By default, nodes bounce off boundaries of the
+// arena.
+type levyWalk struct {
+	positionManager squirrel.PositionManager
+
+	params levyWalkParameters
+	rand   *rand.Rand
+	ticker squirrel.Ticker
+	mu     sync.Mutex // params, rand, ticker
+}
+
+func newLevyWalk() squirrel.MobilityManager {
+	return &levyWalk{}
+}
+
+func (m *levyWalk) ParametersHelp() string {
+	return `
+  arena/width, arena/height [Required]:
+    Size of the arena, from (0, 0), that nodes move in. Nodes start at random
+    points in the arena.
+` + arenaAltitudeHelp + arenaBoundaryHelp + ` Default: bounce
+
+  alpha [Optional]:
+    Exponent of flight lengths, in (0, 2]: probability of a flight longer
+    than l is (min_flight/l)^alpha. Smaller alpha makes long flights more
+    likely. Default: 1.5
+
+  min_flight, max_flight [Optional]:
+    Shortest flight, and longest one that flights are truncated at (0 for no
+    limit). Default: 1 and 0
+
+  speed [Optional]:
+    Speed that nodes fly at, in units per second. Default: 1
+
+  pause_alpha, min_pause, max_pause [Optional]:
+    Same as above, for pauses between flights, e.g. min_pause 1s. Nodes
+    don't pause if min_pause is 0. Default: 1.5, 0s and 0s
+
+  interval [Optional]:
+    How often positions are updated. Default: 100ms
+
+  seed [Optional]:
+    Seed of random numbers, for reproducible runs. Default: 0 (current time;
+    the seed is logged, and reported by GET /mobility of the control API)
+    `
+}
+
+func (m *levyWalk) Configure(conf *etcd.Node) error {
+	if err := common.DecodeParameters(conf, &m.params); err != nil {
+		return err
+	}
+	if err := m.params.check(); err != nil {
+		return err
+	}
+	m.rand = newRand("levy-walk", m.params.Seed)
+	return nil
+}
+
+// Reconfigure applies new parameters. Current flights and pauses are
+// finished as they are.
+func (m *levyWalk) Reconfigure(conf *etcd.Node) error {
+	var params levyWalkParameters
+	if err := common.DecodeParameters(conf, &params); err != nil {
+		return err
+	}
+	if err := params.check(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if params.Seed != m.params.Seed {
+		m.rand = newRand("levy-walk", params.Seed)
+	}
+	if m.ticker != nil && params.Interval != m.params.Interval {
+		m.ticker.Reset(params.Interval)
+	}
+	m.params = params
+	return nil
+}
+
+func (m *levyWalk) Initialize(positionManager squirrel.PositionManager) {
+	m.positionManager = positionManager
+	m.run()
+}
+
+// Stop stops updating positions.
+func (m *levyWalk) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ticker != nil {
+		m.ticker.Stop()
+	}
+}
+
+// nextFlight pauses n, if pauses are configured, and then has it fly in a
+// random direction.
+func (m *levyWalk) nextFlight(n *levyNode, now time.Time) {
+	p := &m.params
+	if p.MinPause > 0 {
+		pause := pareto(m.rand, float64(p.MinPause), float64(p.MaxPause), p.PauseAlpha)
+		n.pauseUntil = now.Add(time.Duration(pause))
+	}
+	m.direct(n)
+	n.remaining = pareto(m.rand, p.MinFlight, p.MaxFlight, p.Alpha)
+}
+
+// direct points n in a random direction, in 3D if the arena is.
+func (m *levyWalk) direct(n *levyNode) {
+	n.heading = m.rand.Float64() * 2 * math.Pi
+	if m.params.Arena.is3D() {
+		// uniform on the sphere
+		n.climb = 2*m.rand.Float64() - 1
+	} else {
+		n.climb = 0
+	}
+}
+
+func (m *levyWalk) step(nodes map[int]*levyNode, now time.Time, elapsed time.Duration) (updates []squirrel.PositionUpdate, events []squirrel.MobilityEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	enabled := make(map[int]bool)
+	for _, index := range m.positionManager.Enabled() {
+		enabled[index] = true
+		n, ok := nodes[index]
+		if !ok {
+			n = &levyNode{pos: m.params.Arena.random(m.rand)}
+			m.direct(n)
+			n.remaining = pareto(m.rand, m.params.MinFlight, m.params.MaxFlight, m.params.Alpha)
+			nodes[index] = n
+		} else {
+			// time spent pausing doesn't count toward the flight
+			t := elapsed
+			if n.pauseUntil.After(now.Add(-elapsed)) {
+				t = now.Sub(n.pauseUntil)
+			}
+			if t <= 0 {
+				continue
+			}
+			d := math.Min(m.params.Speed*t.Seconds(), n.remaining)
+			n.remaining -= d
+			horizontal := d * math.Sqrt(1-n.climb*n.climb)
+			n.pos.X += horizontal * math.Cos(n.heading)
+			n.pos.Y += horizontal * math.Sin(n.heading)
+			n.pos.Height += d * n.climb
+			x, y, height, respawned := m.params.Arena.keepIn(&n.pos, boundaryBounce, m.rand)
+			if x {
+				n.heading = math.Pi - n.heading
+			}
+			if y {
+				n.heading = -n.heading
+			}
+			if height {
+				n.climb = -n.climb
+			}
+			if respawned {
+				// the rest of the flight goes on from where it's placed
+				m.direct(n)
+			}
+			if n.remaining <= 0 {
+				m.nextFlight(n, now)
+				events = append(events, squirrel.MobilityEvent{Kind: squirrel.DirectionChanged, Source: "levy-walk", Index: index, Time: now})
+			}
+		}
+		updates = append(updates, squirrel.PositionUpdate{Index: index, Position: n.pos})
+	}
+	for index := range nodes {
+		if !enabled[index] {
+			delete(nodes, index)
+		}
+	}
+	return
+}
+
+func (m *levyWalk) run() {
+	nodes := make(map[int]*levyNode)
+	last := clock.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ticker = clock.Every(m.params.Interval, func(now time.Time) {
+		updates, events := m.step(nodes, now, now.Sub(last))
+		if len(updates) > 0 {
+			// fixed nodes are refused; they just stay where they are
+			if err := m.positionManager.SetBatch(updates); err != nil {
+				logger.debugf("levy-walk: %v", err)
+			}
+		}
+		for _, e := range events {
+			mobilityEvents.Publish(e)
+		}
+		last = now
+	})
+}
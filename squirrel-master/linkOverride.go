@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// linkOverride changes delivery of packets between two nodes, on top of
+// whatever September decides.
+type linkOverride struct {
+	name      string
+	a, b      string  // hardware addresses
+	symmetric bool    // if false, only packets from a to b are affected
+	connected *bool   // if not nil, packets are always (true) or never (false) delivered
+	loss      float64 // additional probability that a packet is dropped
+	delay     time.Duration
+	jitter    time.Duration // packets are delayed by up to this much more, uniformly
+	rate      float64       // bits per second; 0 for that of other links
+	queue     int           // bytes; 0 for that of other links
+
+	duplicate    *float64      // probability that a packet is duplicated; nil for that of other links
+	reorder      *float64      // probability that a packet is reordered; nil for that of other links
+	reorderDelay time.Duration // 0 for that of other links
+}
+
+func (o *linkOverride) check() error {
+	if o.loss < 0 || o.loss > 1 {
+		return fmt.Errorf("loss of link override %s needs to be between 0 and 1 (got %v)", o.name, o.loss)
+	}
+	if o.a == o.b {
+		return fmt.Errorf("link override %s has the same node on both ends", o.name)
+	}
+	if o.rate < 0 || o.queue < 0 {
+		return fmt.Errorf("rate and queue of link override %s cannot be negative (got %v and %d)", o.name, o.rate, o.queue)
+	}
+	if o.delay < 0 || o.jitter < 0 {
+		return fmt.Errorf("delay and jitter of link override %s cannot be negative (got %v and %v)", o.name, o.delay, o.jitter)
+	}
+	var duplicate, reorder float64
+	if o.duplicate != nil {
+		duplicate = *o.duplicate
+	}
+	if o.reorder != nil {
+		reorder = *o.reorder
+	}
+	if err := checkImpairment(duplicate, reorder, o.reorderDelay); err != nil {
+		return fmt.Errorf("link override %s: %v", o.name, err)
+	}
+	return nil
+}
+
+// parseLinkNodes parses two comma separated hardware addresses.
+func parseLinkNodes(value string) (a, b string, err error) {
+	addrs := strings.Split(value, ",")
+	if len(addrs) != 2 {
+		err = fmt.Errorf("exactly two hardware addresses are needed (got %q)", value)
+		return
+	}
+	var addrA, addrB net.HardwareAddr
+	if addrA, err = net.ParseMAC(strings.TrimSpace(addrs[0])); err != nil {
+		return
+	}
+	if addrB, err = net.ParseMAC(strings.TrimSpace(addrs[1])); err != nil {
+		return
+	}
+	return addrA.String(), addrB.String(), nil
+}
+
+// delivers returns whether a packet is delivered given that September
+// decides it to be delivered (or not, if delivered is false), with loss drawn
+// from r.
+func (o *linkOverride) delivers(delivered bool, r squirrel.Rand) bool {
+	if o.connected != nil {
+		delivered = *o.connected
+	}
+	if delivered && o.loss > 0 && r.Float64() < o.loss {
+		return false
+	}
+	return delivered
+}
+
+type linkPair struct {
+	src, dst int
+}
+
+// linkOverrideSeptember applies linkOverrides on top of another September.
+// Overrides can be changed while it runs.
+type linkOverrideSeptember struct {
+	squirrel.September
+	overrides []linkOverride // ordered by name
+	mu        sync.Mutex     // overrides
+
+	positionManager *PositionManager
+	resolved        atomic.Value          // map[linkPair]*linkOverride
+	random          squirrel.RandomSource // of master; nil for math/rand
+}
+
+func newLinkOverrideSeptember(september squirrel.September, overrides []linkOverride) *linkOverrideSeptember {
+	s := &linkOverrideSeptember{September: september, overrides: overrides}
+	s.resolved.Store(make(map[linkPair]*linkOverride))
+	return s
+}
+
+// SetRandomSource hands random to underlying September, if it draws from
+// master's RandomSource, and keeps it for drawing loss and jitter.
+func (s *linkOverrideSeptember) SetRandomSource(random squirrel.RandomSource) {
+	s.random = random
+	if r, ok := s.September.(squirrel.Randomized); ok {
+		r.SetRandomSource(random)
+	}
+}
+
+// Initialize initializes underlying September with positionManager. Since
+// overrides refer to hardware addresses, they are resolved into identities
+// each time a node is enabled or disabled, which needs positionManager to be
+// master's PositionManager.
+func (s *linkOverrideSeptember) Initialize(positionManager squirrel.PositionManager) {
+	s.September.Initialize(positionManager)
+	p, ok := positionManager.(*PositionManager)
+	if !ok {
+		logger.warnf("link overrides are ignored since hardware addresses can't be resolved")
+		return
+	}
+	s.positionManager = p
+	diffs := make(chan squirrel.EnabledDiff, 1)
+	p.RegisterEnabledDiff(diffs)
+	go func() {
+		for range diffs {
+			s.resolve()
+		}
+	}()
+	s.resolve()
+}
+
+func (s *linkOverrideSeptember) resolve() {
+	if s.positionManager == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resolved := make(map[linkPair]*linkOverride)
+	for i := range s.overrides {
+		o := &s.overrides[i]
+		a, okA := s.positionManager.addrReverse.GetS(o.a)
+		b, okB := s.positionManager.addrReverse.GetS(o.b)
+		if !okA || !okB {
+			continue
+		}
+		resolved[linkPair{src: a, dst: b}] = o
+		if o.symmetric {
+			resolved[linkPair{src: b, dst: a}] = o
+		}
+	}
+	s.resolved.Store(resolved)
+	logger.debugf("%d of %d link overrides are in effect", len(resolved), len(s.overrides))
+}
+
+func (s *linkOverrideSeptember) SendUnicast(source int, destination int, size int) bool {
+	return s.SendUnicastPacket(&squirrel.Packet{Source: source, Size: size}, destination)
+}
+
+func (s *linkOverrideSeptember) SendUnicastPacket(packet *squirrel.Packet, destination int) bool {
+	source := packet.Source
+	delivered := sendUnicastPacket(s.September, packet, destination)
+	if o, ok := s.resolved.Load().(map[linkPair]*linkOverride)[linkPair{src: source, dst: destination}]; ok {
+		return o.delivers(delivered, randOf(s.random, source, destination))
+	}
+	return delivered
+}
+
+func (s *linkOverrideSeptember) SendBroadcast(source int, size int, underlying []int) []int {
+	return s.SendBroadcastPacket(&squirrel.Packet{Source: source, Size: size}, underlying)
+}
+
+func (s *linkOverrideSeptember) SendBroadcastPacket(packet *squirrel.Packet, underlying []int) []int {
+	source := packet.Source
+	recipients := sendBroadcastPacket(s.September, packet, underlying)
+	resolved := s.resolved.Load().(map[linkPair]*linkOverride)
+	if len(resolved) == 0 {
+		return recipients
+	}
+	// filtered in place so that the returned slice is still a sub-slice of
+	// underlying
+	ret := recipients[:0]
+	delivered := make(map[int]bool, len(recipients))
+	for _, id := range recipients {
+		delivered[id] = true
+		if o, ok := resolved[linkPair{src: source, dst: id}]; !ok || o.delivers(true, randOf(s.random, source, id)) {
+			ret = append(ret, id)
+		}
+	}
+	// nodes forced to be connected to source receive the packet even if
+	// September decides otherwise
+	for pair, o := range resolved {
+		if pair.src != source || delivered[pair.dst] || o.connected == nil || !*o.connected {
+			continue
+		}
+		if s.positionManager.IsEnabled(pair.dst) && o.delivers(false, randOf(s.random, source, pair.dst)) {
+			ret = append(ret, pair.dst)
+		}
+	}
+	return ret
+}
+
+// find returns the override of the link from source to destination, or nil if
+// there's none.
+func (s *linkOverrideSeptember) find(source, destination int) *linkOverride {
+	return s.resolved.Load().(map[linkPair]*linkOverride)[linkPair{src: source, dst: destination}]
+}
+
+// Delay returns delay of a packet under underlying September, if it delays
+// packets, plus that of an override of the link.
+func (s *linkOverrideSeptember) Delay(source int, destination int, size int) (delay time.Duration) {
+	if d, ok := s.September.(squirrel.Delayer); ok {
+		delay = d.Delay(source, destination, size)
+	}
+	if o := s.find(source, destination); o != nil {
+		delay += o.delay
+		if o.jitter > 0 {
+			delay += time.Duration(randOf(s.random, source, destination).Int63n(int64(o.jitter)))
+		}
+	}
+	return
+}
+
+// DelayBroadcast sets delays of recipients under underlying September, at
+// once if it can, plus those of overrides of their links.
+func (s *linkOverrideSeptember) DelayBroadcast(source int, size int, recipients []int, delays []time.Duration) {
+	switch d := s.September.(type) {
+	case squirrel.BroadcastDelayer:
+		d.DelayBroadcast(source, size, recipients, delays)
+	case squirrel.Delayer:
+		for i, id := range recipients {
+			delays[i] = d.Delay(source, id, size)
+		}
+	default:
+		for i := range recipients {
+			delays[i] = 0
+		}
+	}
+	resolved := s.resolved.Load().(map[linkPair]*linkOverride)
+	if len(resolved) == 0 {
+		return
+	}
+	for i, id := range recipients {
+		if o, ok := resolved[linkPair{src: source, dst: id}]; ok {
+			delays[i] += o.delay
+			if o.jitter > 0 {
+				delays[i] += time.Duration(randOf(s.random, source, id).Int63n(int64(o.jitter)))
+			}
+		}
+	}
+}
+
+// LinkQuality is that of underlying September, if it tells, with an override
+// of the link on top.
+func (s *linkOverrideSeptember) LinkQuality(source int, destination int) (q squirrel.LinkQuality, ok bool) {
+	lq, isQualifier := s.September.(squirrel.LinkQualifier)
+	if !isQualifier {
+		return q, false
+	}
+	if q, ok = lq.LinkQuality(source, destination); !ok {
+		return
+	}
+	if o := s.find(source, destination); o != nil {
+		if o.connected != nil && *o.connected {
+			q.Loss = 0
+		} else if o.connected != nil {
+			q.Loss = 1
+		}
+		q.Loss = 1 - (1-q.Loss)*(1-o.loss)
+	}
+	return
+}
+
+// confirmation forwards to underlying September, unless the link is forced
+// to be connected or not.
+func (s *linkOverrideSeptember) confirmation(source, destination int) func() bool {
+	if o := s.find(source, destination); o != nil && o.connected != nil {
+		return nil
+	}
+	if c, ok := s.September.(confirmer); ok {
+		return c.confirmation(source, destination)
+	}
+	return nil
+}
+
+// getOverrides returns a copy of current overrides, ordered by name.
+func (s *linkOverrideSeptember) getOverrides() []linkOverride {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]linkOverride(nil), s.overrides...)
+}
+
+// setOverrides replaces all overrides, from the next packet on.
+func (s *linkOverrideSeptember) setOverrides(overrides []linkOverride) {
+	s.mu.Lock()
+	s.overrides = append([]linkOverride(nil), overrides...)
+	s.mu.Unlock()
+	s.resolve()
+}
+
+// setOverride adds o, or replaces the override of the same name.
+func (s *linkOverrideSeptember) setOverride(o linkOverride) {
+	s.mu.Lock()
+	// overrides is copied since resolved ones point into it
+	overrides := make([]linkOverride, 0, len(s.overrides)+1)
+	for _, other := range s.overrides {
+		if other.name != o.name {
+			overrides = append(overrides, other)
+		}
+	}
+	overrides = append(overrides, o)
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].name < overrides[j].name })
+	s.overrides = overrides
+	s.mu.Unlock()
+	s.resolve()
+}
+
+// deleteOverride removes the override named name, and returns whether there
+// was one.
+func (s *linkOverrideSeptember) deleteOverride(name string) bool {
+	s.mu.Lock()
+	overrides := make([]linkOverride, 0, len(s.overrides))
+	for _, o := range s.overrides {
+		if o.name != name {
+			overrides = append(overrides, o)
+		}
+	}
+	found := len(overrides) < len(s.overrides)
+	s.overrides = overrides
+	s.mu.Unlock()
+	if found {
+		s.resolve()
+	}
+	return found
+}
+
+// Reconfigure reconfigures underlying September, if it supports that.
+// Overrides are kept.
+func (s *linkOverrideSeptember) Reconfigure(parameters *etcd.Node) error {
+	return reconfigure(s.September, "September", parameters)
+}
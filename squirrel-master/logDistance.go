@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/common"
+)
+
+type logDistanceParameters struct {
+	TxPower           float64                      `etcd:"tx_power" default:"20"`
+	Sensitivity       float64                      `etcd:"sensitivity" default:"-90"`
+	MinSensitivity    string                       `etcd:"min_sensitivity"`
+	Transition        float64                      `etcd:"transition" default:"2"`
+	Frequency         float64                      `etcd:"frequency" default:"2.4e9"`
+	Channels          map[string]channelParameters `etcd:"channels"`
+	Exponent          float64                      `etcd:"exponent" default:"3"`
+	ReferenceDistance float64                      `etcd:"reference_distance" default:"1"`
+	ReferenceLoss     string                       `etcd:"reference_loss"`
+	NoiseFloor        float64                      `etcd:"noise_floor" default:"-95"`
+	ErrorModel        errorModelParameters         `etcd:"error_model"`
+	RateAdaptation    rateAdaptationParameters     `etcd:"rate_adaptation"`
+	Obstruction       obstructionParameters        `etcd:"obstruction"`
+	Antennas          map[string]antennaParameters `etcd:"antennas"`
+	DefaultAntenna    string                       `etcd:"default_antenna"`
+	Fading            fadingParameters             `etcd:"fading"`
+	Shadowing         shadowingParameters          `etcd:"shadowing"`
+	Interference      interferenceParameters       `etcd:"interference"`
+	MAC               macParameters                `etcd:"mac"`
+	Delay             delayParameters              `etcd:"delay"`
+	Doppler           dopplerParameters            `etcd:"doppler"`
+}
+
+func (p *logDistanceParameters) radio() radioParameters {
+	return radioParameters{
+		txPower:      p.TxPower,
+		sensitivity:  p.Sensitivity,
+		lowest:       p.MinSensitivity,
+		transition:   p.Transition,
+		frequency:    p.Frequency,
+		channels:     p.Channels,
+		noiseFloor:   p.NoiseFloor,
+		errors:       p.ErrorModel,
+		rates:        p.RateAdaptation,
+		obstruction:  p.Obstruction,
+		antennas:     p.Antennas,
+		antenna:      p.DefaultAntenna,
+		fading:       p.Fading,
+		shadowing:    p.Shadowing,
+		interference: p.Interference,
+		mac:          p.MAC,
+		delay:        p.Delay,
+		doppler:      p.Doppler,
+	}
+}
+
+// logDistanceLoss is log-distance path loss: referenceLoss at
+// referenceDistance, and 10*exponent dB more for each tenfold distance beyond
+// it. Loss is never lower than referenceLoss, so nodes closer than
+// referenceDistance have as much as those that far. referenceLoss is at
+// frequency; it changes by 20 dB for each tenfold frequency, as free-space
+// loss does.
+type logDistanceLoss struct {
+	exponent          float64
+	referenceDistance float64
+	referenceLoss     float64
+	frequency         float64
+}
+
+func (l *logDistanceLoss) reference(frequency float64) float64 {
+	if frequency == l.frequency {
+		return l.referenceLoss
+	}
+	return l.referenceLoss + 20*math.Log10(frequency/l.frequency)
+}
+
+func (l *logDistanceLoss) loss(tx, rx squirrel.Position, d, frequency float64) float64 {
+	if d <= l.referenceDistance {
+		return l.reference(frequency)
+	}
+	return l.reference(frequency) + 10*l.exponent*math.Log10(d/l.referenceDistance)
+}
+
+func (l *logDistanceLoss) maxRange(loss, frequency float64) float64 {
+	reference := l.reference(frequency)
+	if loss < reference {
+		return l.referenceDistance
+	}
+	return l.referenceDistance * math.Pow(10, (loss-reference)/(10*l.exponent))
+}
+
+func parseLogDistance(conf *etcd.Node) (*radioConfig, error) {
+	var params logDistanceParameters
+	if err := common.DecodeParameters(conf, &params); err != nil {
+		return nil, err
+	}
+	if params.Frequency <= 0 {
+		return nil, fmt.Errorf("frequency needs to be positive (got %v)", params.Frequency)
+	}
+	if params.Exponent <= 0 {
+		return nil, fmt.Errorf("exponent needs to be positive (got %v)", params.Exponent)
+	}
+	if params.ReferenceDistance <= 0 {
+		return nil, fmt.Errorf("reference_distance needs to be positive (got %v)", params.ReferenceDistance)
+	}
+	model := &logDistanceLoss{
+		exponent:          params.Exponent,
+		referenceDistance: params.ReferenceDistance,
+		referenceLoss:     freeSpaceLoss(params.ReferenceDistance, params.Frequency),
+		frequency:         params.Frequency,
+	}
+	if params.ReferenceLoss != "" {
+		var err error
+		if model.referenceLoss, err = strconv.ParseFloat(params.ReferenceLoss, 64); err != nil {
+			return nil, fmt.Errorf("reference_loss: %v", err)
+		}
+	}
+	return newRadioConfig(params.radio(), model)
+}
+
+// newLogDistanceSeptember creates a September that delivers packets by
+// received power under log-distance path loss, which fits most environments
+// with a suitable exponent: 2 for free space, around 3 for urban areas, and
+// 4 to 6 indoors or with obstructions.
+func newLogDistanceSeptember() squirrel.September {
+	return &radioSeptember{
+		parse: parseLogDistance,
+		help: radioHelp + `
+  exponent [Optional]:
+    Path loss exponent: loss grows by 10*exponent dB each tenfold distance
+    beyond reference_distance. Default: 3
+
+  reference_distance [Optional]:
+    Distance in meters that reference_loss is measured at; closer nodes have
+    the same loss. Default: 1
+
+  reference_loss [Optional]:
+    Path loss in dB at reference_distance. Default: free-space loss at
+    reference_distance and frequency (40 dB at 1m and 2.4GHz)
+
+  Each packet is delivered with a probability that goes from 0 to 1 as
+  received power, tx_power minus path loss, rises through sensitivity.
+    `,
+	}
+}
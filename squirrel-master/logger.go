@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type logLevel int32
+
+const (
+	logDebug logLevel = iota
+	logInfo
+	logWarn
+	logError
+)
+
+var logLevelNames = map[logLevel]string{
+	logDebug: "debug",
+	logInfo:  "info",
+	logWarn:  "warn",
+	logError: "error",
+}
+
+func parseLogLevel(s string) (level logLevel, err error) {
+	for l, name := range logLevelNames {
+		if strings.ToLower(s) == name {
+			return l, nil
+		}
+	}
+	err = fmt.Errorf("unknown log level %s (expected debug, info, warn or error)", s)
+	return
+}
+
+type logConfig struct {
+	level  logLevel
+	format string // text or json
+	output string // path of log file; empty for stdout
+	// a log file is rotated when it grows beyond maxSize bytes, keeping up to
+	// maxBackups old files (path.1 being the newest); 0 disables rotation
+	maxSize    int64
+	maxBackups int
+}
+
+func defaultLogConfig() logConfig {
+	return logConfig{level: logInfo, format: "text"}
+}
+
+// leveledLogger writes messages at or above its level. The level can be
+// changed while logging.
+type leveledLogger struct {
+	level int32 // logLevel; accessed atomically
+	json  bool
+
+	out io.Writer
+	mu  sync.Mutex
+}
+
+// logger is used throughout squirrel-master. It logs info and above to stdout
+// until configured by setupLogger.
+var logger = &leveledLogger{level: int32(logInfo), out: os.Stdout}
+
+// setupLogger configures logger with conf. Output of the standard log package,
+// e.g. from models, goes to the same place.
+func setupLogger(conf logConfig) error {
+	var out io.Writer = os.Stdout
+	if conf.output != "" {
+		f, err := newRotatingFile(conf.output, conf.maxSize, conf.maxBackups)
+		if err != nil {
+			return err
+		}
+		out = f
+	}
+	logger.mu.Lock()
+	logger.out = out
+	logger.json = conf.format == "json"
+	logger.mu.Unlock()
+	logger.setLevel(conf.level)
+	log.SetOutput(out)
+	return nil
+}
+
+func (l *leveledLogger) setLevel(level logLevel) {
+	atomic.StoreInt32(&l.level, int32(level))
+}
+
+// enabled returns whether messages at level are written. Callers on hot paths
+// check it to avoid formatting arguments for nothing.
+func (l *leveledLogger) enabled(level logLevel) bool {
+	return level >= logLevel(atomic.LoadInt32(&l.level))
+}
+
+func (l *leveledLogger) logf(level logLevel, format string, v ...interface{}) {
+	if !l.enabled(level) {
+		return
+	}
+	now := time.Now()
+	msg := strings.TrimSuffix(fmt.Sprintf(format, v...), "\n")
+	var line []byte
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.json {
+		line, _ = json.Marshal(struct {
+			Time  string `json:"time"`
+			Level string `json:"level"`
+			Msg   string `json:"msg"`
+		}{now.Format(time.RFC3339Nano), logLevelNames[level], msg})
+	} else {
+		line = []byte(fmt.Sprintf("%s %-5s %s", now.Format("2006/01/02 15:04:05"), strings.ToUpper(logLevelNames[level]), msg))
+	}
+	l.out.Write(append(line, '\n'))
+}
+
+func (l *leveledLogger) debugf(format string, v ...interface{}) { l.logf(logDebug, format, v...) }
+func (l *leveledLogger) infof(format string, v ...interface{})  { l.logf(logInfo, format, v...) }
+func (l *leveledLogger) warnf(format string, v ...interface{})  { l.logf(logWarn, format, v...) }
+func (l *leveledLogger) errorf(format string, v ...interface{}) { l.logf(logError, format, v...) }
+
+// rotatingFile is an io.Writer appending to a file that is rotated by size.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	f    *os.File
+	size int64
+	mu   sync.Mutex
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() (err error) {
+	r.f, err = os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	var info os.FileInfo
+	if info, err = r.f.Stat(); err != nil {
+		r.f.Close()
+		return
+	}
+	r.size = info.Size()
+	return
+}
+
+func (r *rotatingFile) rotate() error {
+	r.f.Close()
+	if r.maxBackups > 0 {
+		for i := r.maxBackups - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+		}
+		os.Rename(r.path, r.path+".1")
+	} else {
+		os.Remove(r.path)
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Write(p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err = r.rotate(); err != nil {
+			return
+		}
+	}
+	n, err = r.f.Write(p)
+	r.size += int64(n)
+	return
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
-	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io/ioutil"
 	"net"
 	"os"
+	"path"
 	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/coreos/go-etcd/etcd"
@@ -21,96 +24,1081 @@ type config struct {
 	emulatedSubnet        string
 	mobilityManager       string
 	mobilityManagerConfig *etcd.Node
+	mobilityAssignments   []mobilityAssignment
 	september             string
 	septemberConfig       *etcd.Node
+	septemberSeed         int64 // of master's random numbers for Septembers; 0 for current time
+	positionManager       positionManagerConfig
+	nodeMetadata          map[string]map[string]string // hardware address -> key -> value
+	staticNodes           []staticNode
+	positionsFile         string
+	positionExport        *positionExporterConfig // nil if not exporting
+	tls                   *tlsFiles               // nil if not using TLS
+	events                []scenarioEvent
+	linkOverrides         []linkOverride
+	linkRate              float64 // bits per second of each link; 0 for unlimited
+	linkQueue             int     // bytes that can be queued on each link
+	linkDuplicate         float64 // probability that a frame is duplicated on each link
+	linkReorder           float64 // probability that a frame is reordered on each link
+	linkReorderDelay      time.Duration
+	energy                energyCosts
+	log                   logConfig
+	controlListen         string // host:port of control API; empty if disabled
+	grpcListen            string // host:port of gRPC control service; empty if disabled
+	mobilityTimeScale     float64
+	mobilityPaused        bool              // start with master's clock paused
+	vars                  map[string]string // for substituting ${NAME} in other values
 }
 
-func getConfig() (conf config, err error) {
-	endpoint := os.Getenv("SQUIRREL_ENDPOINT")
-	if endpoint == "" {
-		endpoint = "http://127.0.0.1:4001"
+// tlsFiles are paths of PEM files used to secure master's listener.
+type tlsFiles struct {
+	cert     string
+	key      string
+	clientCA string // optional; if set, clients need certificates signed by it
+}
+
+// envOverride returns value of the environment variable that overrides etcd
+// key, e.g. SQUIRREL_MASTER_EMULATED_SUBNET for /squirrel/master/emulated_subnet.
+func envOverride(key string) (value string, ok bool) {
+	return os.LookupEnv(strings.ToUpper(strings.Replace(strings.Trim(key, "/"), "/", "_", -1)))
+}
+
+// getEtcdValue is like common.GetEtcdValue, but an environment variable (see
+// envOverride) takes precedence over the key in etcd. Variables in value are
+// substituted (see configClient).
+func getEtcdValue(client *configClient, key string) (value string, err error) {
+	var ok bool
+	if value, ok = envOverride(key); !ok {
+		if value, err = common.GetEtcdValue(client.Client, key); err != nil {
+			return
+		}
+	}
+	value, err = client.expand(value, key)
+	return
+}
+
+// getFlagOrEtcdValue returns flagValue if it's set on command line, or value of
+// key (see getEtcdValue) otherwise.
+func getFlagOrEtcdValue(client *configClient, flagValue string, key string) (value string, err error) {
+	if flagValue != "" {
+		value = flagValue
+		return
 	}
-	client := etcd.NewClient([]string{endpoint})
+	value, err = getEtcdValue(client, key)
+	return
+}
 
-	var ifce string
-	ifce, err = common.GetEtcdValue(client, "/squirrel/master_ifce")
+// getOptionalEtcdValue is like getEtcdValue, but ok is false rather than err
+// being set if key does not exist.
+func getOptionalEtcdValue(client *configClient, key string) (value string, ok bool, err error) {
+	value, err = getEtcdValue(client, key)
 	if err != nil {
+		if common.IsEtcdNotFoundError(err) {
+			err = nil
+		}
 		return
 	}
+	ok = true
+	return
+}
+
+// newMasterConfigClient connects to etcd for configuration of master.
+func newMasterConfigClient() (*configClient, error) {
+	return newConfigClient(etcd.NewClient(common.EtcdEndpoints(os.Getenv("SQUIRREL_ENDPOINT"))), "/squirrel/master/vars")
+}
 
-	var addr net.IP
-	addr, err = getAddr(ifce)
+// getParameters reads parameters of a model from the Dir at dir, with
+// includes merged.
+func getParameters(client *configClient, dir string) (parameters *etcd.Node, err error) {
+	var resp *etcd.Response
+	if resp, err = client.Get(dir, false, true); err != nil {
+		return
+	}
+	if !resp.Node.Dir {
+		return nil, fmt.Errorf("%s is not a Dir node", dir)
+	}
+	return mergeIncludes(client, resp.Node)
+}
+
+// getConfig reads configuration of master. It goes on past entries that
+// cannot be read or parsed, and returns problems with all of them, so that
+// they can be reported along with those found by validateConfig.
+func getConfig() (conf config, errs []error) {
+	client, err := newMasterConfigClient()
 	if err != nil {
+		errs = append(errs, err)
 		return
 	}
-	conf.uri = addr.String() + ":1234"
+	conf.vars = client.vars
+
+	var ip string
+	listen, ok := os.LookupEnv("SQUIRREL_MASTER_LISTEN_ADDRESS")
+	if *listenOverride != "" {
+		listen, ok = *listenOverride, true
+	}
+	if ok {
+		conf.uri = listen
+		// an invalid address is reported by validateConfig
+		ip, _, _ = net.SplitHostPort(listen)
+	} else {
+		var ifce string
+		var addr net.IP
+		if ifce, err = getEtcdValue(client, "/squirrel/master_ifce"); err != nil {
+			errs = append(errs, err)
+		} else if addr, err = getAddr(ifce); err != nil {
+			errs = append(errs, fmt.Errorf("master_ifce: %v", err))
+		} else {
+			ip = addr.String()
+			conf.uri = ip + ":1234"
+		}
+	}
+
+	if conf.emulatedSubnet, err = getFlagOrEtcdValue(client, *networkOverride, "/squirrel/master/emulated_subnet"); err != nil {
+		errs = append(errs, err)
+	}
+
+	if conf.mobilityManager, err = getFlagOrEtcdValue(client, *mobilityOverride, "/squirrel/master/mobility_manager"); err != nil {
+		errs = append(errs, err)
+	}
+
+	if conf.mobilityManagerConfig, err = getModelConfig(client, "/squirrel/master/mobility_manager_config_path"); err != nil {
+		errs = append(errs, fmt.Errorf("mobility_manager_config_path: %v", err))
+	}
+
+	if conf.mobilityAssignments, err = getMobilityAssignments(client, "/squirrel/master/mobility_managers"); err != nil {
+		errs = append(errs, err)
+	}
+
+	if conf.september, err = getFlagOrEtcdValue(client, *septemberOverride, "/squirrel/master/september"); err != nil {
+		errs = append(errs, err)
+	}
+
+	var seed string
+	if seed, ok, err = getOptionalEtcdValue(client, "/squirrel/master/september_seed"); err != nil {
+		errs = append(errs, err)
+	} else if ok {
+		if conf.septemberSeed, err = strconv.ParseInt(seed, 10, 64); err != nil {
+			errs = append(errs, fmt.Errorf("september_seed: %v", err))
+		}
+	}
+
+	if conf.septemberConfig, err = getModelConfig(client, "/squirrel/master/september_config_path"); err != nil {
+		errs = append(errs, fmt.Errorf("september_config_path: %v", err))
+	}
+
+	if conf.positionManager, err = getPositionManagerConfig(client); err != nil {
+		errs = append(errs, err)
+	}
+
+	if conf.nodeMetadata, err = getNodeMetadata(client, "/squirrel/master/node_metadata"); err != nil {
+		errs = append(errs, err)
+	}
+
+	if conf.staticNodes, err = getStaticNodes(client, "/squirrel/master/nodes"); err != nil {
+		errs = append(errs, err)
+	}
+
+	if conf.positionsFile, _, err = getOptionalEtcdValue(client, "/squirrel/master/positions_file"); err != nil {
+		errs = append(errs, err)
+	}
+
+	if conf.controlListen, _, err = getOptionalEtcdValue(client, "/squirrel/master/control_listen"); err != nil {
+		errs = append(errs, err)
+	}
+
+	if conf.grpcListen, _, err = getOptionalEtcdValue(client, "/squirrel/master/grpc_listen"); err != nil {
+		errs = append(errs, err)
+	}
+
+	conf.mobilityTimeScale = 1
+	var timeScale string
+	if timeScale, ok, err = getOptionalEtcdValue(client, "/squirrel/master/mobility_time_scale"); err != nil {
+		errs = append(errs, err)
+	} else if ok {
+		if conf.mobilityTimeScale, err = strconv.ParseFloat(timeScale, 64); err != nil {
+			errs = append(errs, fmt.Errorf("mobility_time_scale: %v", err))
+		} else if conf.mobilityTimeScale <= 0 {
+			errs = append(errs, fmt.Errorf("mobility_time_scale needs to be positive (got %v)", conf.mobilityTimeScale))
+		}
+	}
 
-	_, err = client.Set("/squirrel/master_ip", addr.String(), 0)
+	var paused string
+	if paused, ok, err = getOptionalEtcdValue(client, "/squirrel/master/mobility_paused"); err != nil {
+		errs = append(errs, err)
+	} else if ok {
+		if conf.mobilityPaused, err = strconv.ParseBool(paused); err != nil {
+			errs = append(errs, fmt.Errorf("mobility_paused: %v", err))
+		}
+	}
+
+	if conf.positionExport, err = getPositionExporterConfig(client, "/squirrel/master/position_export"); err != nil {
+		errs = append(errs, err)
+	}
+
+	if conf.tls, err = getTLSFiles(client, "/squirrel/master/tls"); err != nil {
+		errs = append(errs, err)
+	}
+
+	if conf.events, err = getScenarioEvents(client, "/squirrel/master/events"); err != nil {
+		errs = append(errs, err)
+	}
+
+	if conf.linkOverrides, err = getLinkOverrides(client, "/squirrel/master/link_overrides"); err != nil {
+		errs = append(errs, err)
+	}
+
+	var rate string
+	if rate, ok, err = getOptionalEtcdValue(client, "/squirrel/master/link_rate"); err != nil {
+		errs = append(errs, err)
+	} else if ok {
+		if conf.linkRate, err = parseBitRate(rate); err != nil {
+			errs = append(errs, fmt.Errorf("link_rate: %v", err))
+		}
+	}
+
+	conf.linkQueue = 65536
+	var queue string
+	if queue, ok, err = getOptionalEtcdValue(client, "/squirrel/master/link_queue"); err != nil {
+		errs = append(errs, err)
+	} else if ok {
+		if conf.linkQueue, err = strconv.Atoi(queue); err != nil {
+			errs = append(errs, fmt.Errorf("link_queue: %v", err))
+		}
+	}
+
+	for key, v := range map[string]*float64{"link_duplicate": &conf.linkDuplicate, "link_reorder": &conf.linkReorder} {
+		var value string
+		if value, ok, err = getOptionalEtcdValue(client, "/squirrel/master/"+key); err != nil {
+			errs = append(errs, err)
+		} else if ok {
+			if *v, err = strconv.ParseFloat(value, 64); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", key, err))
+			}
+		}
+	}
+
+	conf.linkReorderDelay = 10 * time.Millisecond
+	var reorderDelay string
+	if reorderDelay, ok, err = getOptionalEtcdValue(client, "/squirrel/master/link_reorder_delay"); err != nil {
+		errs = append(errs, err)
+	} else if ok {
+		if conf.linkReorderDelay, err = time.ParseDuration(reorderDelay); err != nil {
+			errs = append(errs, fmt.Errorf("link_reorder_delay: %v", err))
+		}
+	}
+
+	for key, v := range map[string]*float64{
+		"energy_tx_frame": &conf.energy.txFrame,
+		"energy_tx_byte":  &conf.energy.txByte,
+		"energy_rx_frame": &conf.energy.rxFrame,
+		"energy_rx_byte":  &conf.energy.rxByte,
+		"battery":         &conf.energy.battery,
+	} {
+		var value string
+		if value, ok, err = getOptionalEtcdValue(client, "/squirrel/master/"+key); err != nil {
+			errs = append(errs, err)
+		} else if ok {
+			if *v, err = strconv.ParseFloat(value, 64); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", key, err))
+			}
+		}
+	}
+
+	var batteryDisable string
+	if batteryDisable, ok, err = getOptionalEtcdValue(client, "/squirrel/master/battery_disable"); err != nil {
+		errs = append(errs, err)
+	} else if ok {
+		if conf.energy.disable, err = strconv.ParseBool(batteryDisable); err != nil {
+			errs = append(errs, fmt.Errorf("battery_disable: %v", err))
+		}
+	}
+
+	if conf.log, err = getLogConfig(client, "/squirrel/master/log"); err != nil {
+		errs = append(errs, err)
+	}
+
+	// where master is found is only published for configuration it runs with
+	if len(errs) == 0 && !*printConfigOnly && !*check {
+		if _, err = client.Set("/squirrel/master_ip", ip, 0); err != nil {
+			errs = append(errs, err)
+		} else if _, err = client.Set("/squirrel/master_uri", conf.uri, 0); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return
+}
+
+// getModelConfig reads the configuration Dir of a Mobility Manager or
+// September, at the path that key holds, merged with its includes. It's nil if
+// key does not exist.
+func getModelConfig(client *configClient, key string) (node *etcd.Node, err error) {
+	path, ok, err := getOptionalEtcdValue(client, key)
+	if err != nil || !ok {
+		return
+	}
+	resp, err := client.Get(path, false, true)
 	if err != nil {
 		return
 	}
-	_, err = client.Set("/squirrel/master_uri", conf.uri, 0)
+	if !resp.Node.Dir {
+		err = fmt.Errorf("%s is not a Dir node", path)
+		return
+	}
+	return mergeIncludes(client, resp.Node)
+}
+
+// getPositionManagerConfig reads optional configuration entries of
+// PositionManager.
+func getPositionManagerConfig(client *configClient) (conf positionManagerConfig, err error) {
+	var ok bool
+	var historySize string
+	historySize, ok, err = getOptionalEtcdValue(client, "/squirrel/master/position_history_size")
 	if err != nil {
 		return
 	}
+	if ok {
+		conf.historySize, err = strconv.Atoi(historySize)
+		if err != nil {
+			return
+		}
+		if conf.historySize < 0 {
+			err = fmt.Errorf("position_history_size cannot be negative (got %d)", conf.historySize)
+			return
+		}
+	}
 
-	conf.emulatedSubnet, err = common.GetEtcdValue(client, "/squirrel/master/emulated_subnet")
+	conf.spatialIndexCellSize = 100
+	var cellSize string
+	cellSize, ok, err = getOptionalEtcdValue(client, "/squirrel/master/spatial_index_cell_size")
 	if err != nil {
 		return
 	}
+	if ok {
+		conf.spatialIndexCellSize, err = strconv.ParseFloat(cellSize, 64)
+		if err != nil {
+			return
+		}
+		if conf.spatialIndexCellSize <= 0 {
+			err = fmt.Errorf("spatial_index_cell_size needs to be positive (got %v)", conf.spatialIndexCellSize)
+			return
+		}
+	}
 
-	conf.mobilityManager, err = common.GetEtcdValue(client, "/squirrel/master/mobility_manager")
+	var coordinates string
+	coordinates, ok, err = getOptionalEtcdValue(client, "/squirrel/master/coordinate_system")
 	if err != nil {
 		return
 	}
+	if ok {
+		switch coordinates {
+		case "cartesian":
+		case "wgs84":
+			conf.geographic = true
+		default:
+			err = fmt.Errorf("unknown coordinate_system %s (expected cartesian or wgs84)", coordinates)
+			return
+		}
+	}
 
-	var mobilityManagerConfigPath string
-	mobilityManagerConfigPath, err = common.GetEtcdValue(client, "/squirrel/master/mobility_manager_config_path")
+	var preallocatedNodes string
+	preallocatedNodes, ok, err = getOptionalEtcdValue(client, "/squirrel/master/preallocated_nodes")
+	if err != nil {
+		return
+	}
+	if ok {
+		conf.preallocatedNodes, err = strconv.Atoi(preallocatedNodes)
+		if err != nil {
+			return
+		}
+	}
+
+	var distanceCache string
+	distanceCache, ok, err = getOptionalEtcdValue(client, "/squirrel/master/distance_cache")
+	if err != nil {
+		return
+	}
+	if ok {
+		conf.distanceCache, err = strconv.ParseBool(distanceCache)
+		if err != nil {
+			return
+		}
+	}
+
+	var planar string
+	planar, ok, err = getOptionalEtcdValue(client, "/squirrel/master/planar")
+	if err != nil {
+		return
+	}
+	if ok {
+		conf.planar, err = strconv.ParseBool(planar)
+		if err != nil {
+			return
+		}
+	}
+
+	var policy string
+	policy, ok, err = getOptionalEtcdValue(client, "/squirrel/master/enabled_changed_policy")
+	if err != nil {
+		return
+	}
+	if ok {
+		conf.notifyPolicy, err = parseNotifyPolicy(policy)
+		if err != nil {
+			return
+		}
+	}
+
+	conf.scale = 1
+	var units string
+	units, ok, err = getOptionalEtcdValue(client, "/squirrel/master/units")
+	if err != nil {
+		return
+	}
+	if ok {
+		conf.scale, err = parseLengthUnit(units)
+		if err != nil {
+			return
+		}
+	}
+
+	var scale string
+	scale, ok, err = getOptionalEtcdValue(client, "/squirrel/master/scale")
+	if err != nil {
+		return
+	}
+	if ok {
+		var s float64
+		s, err = strconv.ParseFloat(scale, 64)
+		if err != nil {
+			return
+		}
+		if s <= 0 {
+			err = fmt.Errorf("scale needs to be positive (got %v)", s)
+			return
+		}
+		conf.scale *= s
+	}
+
+	var origin string
+	origin, ok, err = getOptionalEtcdValue(client, "/squirrel/master/origin")
+	if err != nil {
+		return
+	}
+	if ok {
+		if conf.geographic {
+			err = fmt.Errorf("origin is not supported with wgs84 coordinate_system")
+			return
+		}
+		conf.origin, err = parsePosition(origin)
+		if err != nil {
+			return
+		}
+	}
+
+	conf.arena, err = getArena(client, "/squirrel/master/arena")
+	if err != nil {
+		return
+	}
+
+	conf.obstacles, err = getObstacleMap(client, "/squirrel/master/obstacles")
+	if err != nil {
+		return
+	}
+
+	var obstaclesFile string
+	obstaclesFile, ok, err = getOptionalEtcdValue(client, "/squirrel/master/obstacles_file")
+	if err != nil {
+		return
+	}
+	if ok {
+		var obstacles []obstacle
+		if obstacles, err = loadObstacles(obstaclesFile); err != nil {
+			return
+		}
+		conf.obstacles.obstacles = append(conf.obstacles.obstacles, obstacles...)
+	}
+
+	var terrainFile string
+	terrainFile, ok, err = getOptionalEtcdValue(client, "/squirrel/master/terrain/file")
+	if err != nil {
+		return
+	}
+	if ok {
+		relative := true
+		var s string
+		if s, ok, err = getOptionalEtcdValue(client, "/squirrel/master/terrain/relative"); err != nil {
+			return
+		}
+		if ok {
+			if relative, err = strconv.ParseBool(s); err != nil {
+				return
+			}
+		}
+		if conf.obstacles.terrain, err = loadTerrain(terrainFile, relative); err != nil {
+			return
+		}
+	}
+
+	conf.distanceMatrix, err = getDistanceMatrixConfig(client, "/squirrel/master/distance_matrix")
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// getArena reads arena bounds and policy from dir. It returns nil if dir
+// doesn't exist.
+func getArena(client *configClient, dir string) (a *arena, err error) {
+	var resp *etcd.Response
+	resp, err = client.Get(dir, false, true)
 	if err != nil {
 		if common.IsEtcdNotFoundError(err) {
 			err = nil
+		}
+		return
+	}
+	if !resp.Node.Dir {
+		err = fmt.Errorf("%s is not a Dir node", dir)
+		return
+	}
+	a = newArena()
+	bounds := map[string]*float64{
+		"min_x":      &a.min.X,
+		"max_x":      &a.max.X,
+		"min_y":      &a.min.Y,
+		"max_y":      &a.max.Y,
+		"min_height": &a.min.Height,
+		"max_height": &a.max.Height,
+	}
+	for _, node := range resp.Node.Nodes {
+		name := path.Base(node.Key)
+		if name == "policy" {
+			if a.policy, err = parseArenaPolicy(node.Value); err != nil {
+				return
+			}
+		} else if bound, ok := bounds[name]; ok {
+			if *bound, err = strconv.ParseFloat(node.Value, 64); err != nil {
+				return
+			}
 		} else {
+			err = fmt.Errorf("unknown arena entry %s", node.Key)
 			return
 		}
-	} else {
-		var resp *etcd.Response
-		resp, err = client.Get(mobilityManagerConfigPath, false, true)
-		if err != nil {
+	}
+	if a.min.X > a.max.X || a.min.Y > a.max.Y || a.min.Height > a.max.Height {
+		err = fmt.Errorf("arena has min bounds larger than max bounds: %v to %v", a.min, a.max)
+		return
+	}
+	return
+}
+
+// getObstacleMap reads obstacles from dir, where each child is a Dir named by
+// the obstacle, containing "polygon" and optionally "height".
+func getObstacleMap(client *configClient, dir string) (m *obstacleMap, err error) {
+	m = &obstacleMap{}
+	var resp *etcd.Response
+	resp, err = client.Get(dir, false, true)
+	if err != nil {
+		if common.IsEtcdNotFoundError(err) {
+			err = nil
+		}
+		return
+	}
+	if !resp.Node.Dir {
+		err = fmt.Errorf("%s is not a Dir node", dir)
+		return
+	}
+	for _, node := range resp.Node.Nodes {
+		if !node.Dir {
+			err = fmt.Errorf("%s is not a Dir node", node.Key)
 			return
 		}
-		if !resp.Node.Dir {
-			err = errors.New("mobilityManagerConfig is not a Dir node")
+		o := obstacle{name: path.Base(node.Key)}
+		for _, kv := range node.Nodes {
+			switch path.Base(kv.Key) {
+			case "polygon":
+				o.polygon, err = parsePolygon(kv.Value)
+			case "height":
+				o.height, err = strconv.ParseFloat(kv.Value, 64)
+			case "loss":
+				o.loss, err = strconv.ParseFloat(kv.Value, 64)
+			default:
+				err = fmt.Errorf("unknown obstacle entry %s", kv.Key)
+			}
+			if err != nil {
+				return
+			}
+		}
+		if o.polygon == nil {
+			err = fmt.Errorf("obstacle %s has no polygon", node.Key)
 			return
 		}
-		conf.mobilityManagerConfig = resp.Node
+		m.obstacles = append(m.obstacles, o)
 	}
+	return
+}
 
-	conf.september, err = common.GetEtcdValue(client, "/squirrel/master/september")
+// getPositionExporterConfig reads configuration of position exporter from dir.
+// It returns nil if dir doesn't exist.
+func getPositionExporterConfig(client *configClient, dir string) (conf *positionExporterConfig, err error) {
+	var resp *etcd.Response
+	resp, err = client.Get(dir, false, true)
 	if err != nil {
+		if common.IsEtcdNotFoundError(err) {
+			err = nil
+		}
+		return
+	}
+	if !resp.Node.Dir {
+		err = fmt.Errorf("%s is not a Dir node", dir)
 		return
 	}
+	conf = &positionExporterConfig{format: "csv", interval: time.Second}
+	for _, node := range resp.Node.Nodes {
+		switch path.Base(node.Key) {
+		case "path":
+			conf.path = node.Value
+		case "format":
+			conf.format = node.Value
+		case "interval":
+			conf.interval, err = time.ParseDuration(node.Value)
+		default:
+			err = fmt.Errorf("unknown position export entry %s", node.Key)
+		}
+		if err != nil {
+			return
+		}
+	}
+	if conf.path == "" {
+		err = fmt.Errorf("%s/path is required", dir)
+	}
+	return
+}
 
-	var septemberConfigPath string
-	septemberConfigPath, err = common.GetEtcdValue(client, "/squirrel/master/september_config_path")
+// getDistanceMatrixConfig reads configuration of distance matrix from dir. It
+// returns nil if dir doesn't exist.
+func getDistanceMatrixConfig(client *configClient, dir string) (conf *distanceMatrixConfig, err error) {
+	var resp *etcd.Response
+	resp, err = client.Get(dir, false, true)
 	if err != nil {
 		if common.IsEtcdNotFoundError(err) {
 			err = nil
-		} else {
+		}
+		return
+	}
+	if !resp.Node.Dir {
+		err = fmt.Errorf("%s is not a Dir node", dir)
+		return
+	}
+	conf = &distanceMatrixConfig{minInterval: 10 * time.Millisecond, maxInterval: time.Second}
+	for _, node := range resp.Node.Nodes {
+		switch path.Base(node.Key) {
+		case "min_interval":
+			conf.minInterval, err = time.ParseDuration(node.Value)
+		case "max_interval":
+			conf.maxInterval, err = time.ParseDuration(node.Value)
+		default:
+			err = fmt.Errorf("unknown distance matrix entry %s", node.Key)
+		}
+		if err != nil {
 			return
 		}
-	} else {
-		var resp *etcd.Response
-		resp, err = client.Get(septemberConfigPath, false, true)
+	}
+	if conf.minInterval <= 0 || conf.maxInterval < conf.minInterval {
+		err = fmt.Errorf("distance matrix needs 0 < min_interval <= max_interval (got %v and %v)", conf.minInterval, conf.maxInterval)
+	}
+	return
+}
+
+// getLogConfig reads logging configuration from dir. -debug overrides level.
+func getLogConfig(client *configClient, dir string) (conf logConfig, err error) {
+	conf = defaultLogConfig()
+	if *debug {
+		defer func() { conf.level = logDebug }()
+	}
+	var resp *etcd.Response
+	resp, err = client.Get(dir, false, true)
+	if err != nil {
+		if common.IsEtcdNotFoundError(err) {
+			err = nil
+		}
+		return
+	}
+	if !resp.Node.Dir {
+		err = fmt.Errorf("%s is not a Dir node", dir)
+		return
+	}
+	for _, node := range resp.Node.Nodes {
+		switch path.Base(node.Key) {
+		case "level":
+			conf.level, err = parseLogLevel(node.Value)
+		case "format":
+			conf.format = node.Value
+		case "output":
+			conf.output = node.Value
+		case "max_size":
+			conf.maxSize, err = strconv.ParseInt(node.Value, 10, 64)
+		case "max_backups":
+			conf.maxBackups, err = strconv.Atoi(node.Value)
+		default:
+			err = fmt.Errorf("unknown log entry %s", node.Key)
+		}
 		if err != nil {
 			return
 		}
-		if !resp.Node.Dir {
-			err = errors.New("septemberConfig is not a Dir node")
+	}
+	return
+}
+
+// getStaticNodes reads nodes declared in dir, where each child is a Dir named
+// by a hardware address.
+func getStaticNodes(client *configClient, dir string) (nodes []staticNode, err error) {
+	var resp *etcd.Response
+	resp, err = client.Get(dir, false, true)
+	if err != nil {
+		if common.IsEtcdNotFoundError(err) {
+			err = nil
+		}
+		return
+	}
+	if !resp.Node.Dir {
+		err = fmt.Errorf("%s is not a Dir node", dir)
+		return
+	}
+	for _, node := range resp.Node.Nodes {
+		if !node.Dir {
+			err = fmt.Errorf("%s is not a Dir node", node.Key)
+			return
+		}
+		var n staticNode
+		if n.addr, err = net.ParseMAC(path.Base(node.Key)); err != nil {
 			return
 		}
-		conf.septemberConfig = resp.Node
+		for _, entry := range node.Nodes {
+			switch path.Base(entry.Key) {
+			case "name":
+				n.name = entry.Value
+			case "position":
+				var pos squirrel.Position
+				if pos, err = parsePosition(entry.Value); err == nil {
+					n.position = &pos
+				}
+			case "tags":
+				for _, tag := range strings.Split(entry.Value, ",") {
+					if tag = strings.TrimSpace(tag); tag != "" {
+						n.tags = append(n.tags, tag)
+					}
+				}
+			case "fixed":
+				n.fixed, err = strconv.ParseBool(entry.Value)
+			case "group":
+				n.group = entry.Value
+			case "gpx":
+				n.gpx = entry.Value
+			case "channel":
+				n.channel = entry.Value
+			case "battery":
+				var v float64
+				if v, err = strconv.ParseFloat(entry.Value, 64); err == nil {
+					n.battery = &v
+				}
+			case "tx_power", "sensitivity":
+				var v float64
+				if v, err = strconv.ParseFloat(entry.Value, 64); err == nil && path.Base(entry.Key) == "tx_power" {
+					n.txPower = &v
+				} else if err == nil {
+					n.sensitivity = &v
+				}
+			default:
+				err = fmt.Errorf("unknown node entry %s", entry.Key)
+			}
+			if err != nil {
+				return
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	return
+}
+
+// getMobilityAssignments reads additional Mobility Managers from dir, where
+// each child is a Dir named by the assignment.
+func getMobilityAssignments(client *configClient, dir string) (assignments []mobilityAssignment, err error) {
+	var resp *etcd.Response
+	resp, err = client.Get(dir, false, true)
+	if err != nil {
+		if common.IsEtcdNotFoundError(err) {
+			err = nil
+		}
+		return
+	}
+	if !resp.Node.Dir {
+		err = fmt.Errorf("%s is not a Dir node", dir)
+		return
+	}
+	split := func(s string) (ret []string) {
+		for _, v := range strings.Split(s, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				ret = append(ret, v)
+			}
+		}
+		return
 	}
+	for _, node := range resp.Node.Nodes {
+		if !node.Dir {
+			err = fmt.Errorf("%s is not a Dir node", node.Key)
+			return
+		}
+		a := mobilityAssignment{name: path.Base(node.Key)}
+		for _, entry := range node.Nodes {
+			switch path.Base(entry.Key) {
+			case "mobility_manager":
+				a.mobilityManager = entry.Value
+			case "config_path":
+				var r *etcd.Response
+				if r, err = client.Get(entry.Value, false, true); err != nil {
+					return
+				}
+				if !r.Node.Dir {
+					err = fmt.Errorf("%s is not a Dir node", entry.Value)
+					return
+				}
+				a.parameters, err = mergeIncludes(client, r.Node)
+			case "nodes":
+				a.nodes = split(entry.Value)
+				for _, addr := range a.nodes {
+					if _, err = net.ParseMAC(addr); err != nil {
+						return
+					}
+				}
+			case "tags":
+				a.tags = split(entry.Value)
+			default:
+				err = fmt.Errorf("unknown mobility manager entry %s", entry.Key)
+			}
+			if err != nil {
+				return
+			}
+		}
+		if a.mobilityManager == "" {
+			err = fmt.Errorf("%s/mobility_manager is required", node.Key)
+			return
+		}
+		assignments = append(assignments, a)
+	}
+	sort.Slice(assignments, func(i, j int) bool { return assignments[i].name < assignments[j].name })
+	return
+}
 
+// getScenarioEvents reads timed and triggered events from dir, where each child is a Dir
+// named by the event.
+func getScenarioEvents(client *configClient, dir string) (events []scenarioEvent, err error) {
+	var resp *etcd.Response
+	resp, err = client.Get(dir, false, true)
+	if err != nil {
+		if common.IsEtcdNotFoundError(err) {
+			err = nil
+		}
+		return
+	}
+	if !resp.Node.Dir {
+		err = fmt.Errorf("%s is not a Dir node", dir)
+		return
+	}
+	for _, node := range resp.Node.Nodes {
+		if !node.Dir {
+			err = fmt.Errorf("%s is not a Dir node", node.Key)
+			return
+		}
+		e := scenarioEvent{name: path.Base(node.Key)}
+		for _, entry := range node.Nodes {
+			switch path.Base(entry.Key) {
+			case "at":
+				e.at, err = time.ParseDuration(entry.Value)
+			case "action":
+				e.action = entry.Value
+			case "mobility_manager":
+				e.mobilityManager = entry.Value
+			case "on":
+				switch entry.Value {
+				case squirrel.WaypointReached, squirrel.DirectionChanged, squirrel.TraceFinished:
+					e.on = entry.Value
+				default:
+					err = fmt.Errorf("%s: unknown mobility event %s (expected %s, %s or %s)", entry.Key, entry.Value, squirrel.WaypointReached, squirrel.DirectionChanged, squirrel.TraceFinished)
+				}
+			case "on_nodes":
+				for _, s := range strings.Split(entry.Value, ",") {
+					var addr net.HardwareAddr
+					if addr, err = net.ParseMAC(strings.TrimSpace(s)); err != nil {
+						break
+					}
+					e.onNodes = append(e.onNodes, addr.String())
+				}
+			case "node":
+				e.node = entry.Value
+			case "position":
+				e.position, err = parsePosition(entry.Value)
+			case "parameters_path":
+				var r *etcd.Response
+				if r, err = client.Get(entry.Value, false, true); err != nil {
+					return
+				}
+				if !r.Node.Dir {
+					err = fmt.Errorf("%s is not a Dir node", entry.Value)
+					return
+				}
+				e.parameters, err = mergeIncludes(client, r.Node)
+			default:
+				err = fmt.Errorf("unknown event entry %s", entry.Key)
+			}
+			if err != nil {
+				return
+			}
+		}
+		if err = e.check(); err != nil {
+			return
+		}
+		events = append(events, e)
+	}
+	return
+}
+
+// getLinkOverrides reads overrides of September decisions from dir, where each
+// child is a Dir named by the override.
+func getLinkOverrides(client *configClient, dir string) (overrides []linkOverride, err error) {
+	var resp *etcd.Response
+	resp, err = client.Get(dir, false, true)
+	if err != nil {
+		if common.IsEtcdNotFoundError(err) {
+			err = nil
+		}
+		return
+	}
+	if !resp.Node.Dir {
+		err = fmt.Errorf("%s is not a Dir node", dir)
+		return
+	}
+	for _, node := range resp.Node.Nodes {
+		if !node.Dir {
+			err = fmt.Errorf("%s is not a Dir node", node.Key)
+			return
+		}
+		o := linkOverride{name: path.Base(node.Key), symmetric: true}
+		for _, entry := range node.Nodes {
+			switch path.Base(entry.Key) {
+			case "nodes":
+				if o.a, o.b, err = parseLinkNodes(entry.Value); err != nil {
+					err = fmt.Errorf("%s: %v", entry.Key, err)
+				}
+			case "loss":
+				o.loss, err = strconv.ParseFloat(entry.Value, 64)
+			case "connected":
+				var connected bool
+				if connected, err = strconv.ParseBool(entry.Value); err == nil {
+					o.connected = &connected
+				}
+			case "symmetric":
+				o.symmetric, err = strconv.ParseBool(entry.Value)
+			case "delay":
+				o.delay, err = time.ParseDuration(entry.Value)
+			case "jitter":
+				o.jitter, err = time.ParseDuration(entry.Value)
+			case "rate":
+				o.rate, err = parseBitRate(entry.Value)
+			case "queue":
+				o.queue, err = strconv.Atoi(entry.Value)
+			case "duplicate", "reorder":
+				var v float64
+				if v, err = strconv.ParseFloat(entry.Value, 64); err == nil && path.Base(entry.Key) == "duplicate" {
+					o.duplicate = &v
+				} else if err == nil {
+					o.reorder = &v
+				}
+			case "reorder_delay":
+				o.reorderDelay, err = time.ParseDuration(entry.Value)
+			default:
+				err = fmt.Errorf("unknown link override entry %s", entry.Key)
+			}
+			if err != nil {
+				return
+			}
+		}
+		if o.a == "" {
+			err = fmt.Errorf("%s/nodes is required", node.Key)
+			return
+		}
+		overrides = append(overrides, o)
+	}
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].name < overrides[j].name })
+	return
+}
+
+// getTLSFiles reads paths of TLS material from dir. It returns nil if dir
+// doesn't exist.
+func getTLSFiles(client *configClient, dir string) (files *tlsFiles, err error) {
+	var resp *etcd.Response
+	resp, err = client.Get(dir, false, true)
+	if err != nil {
+		if common.IsEtcdNotFoundError(err) {
+			err = nil
+		}
+		return
+	}
+	if !resp.Node.Dir {
+		err = fmt.Errorf("%s is not a Dir node", dir)
+		return
+	}
+	files = new(tlsFiles)
+	for _, node := range resp.Node.Nodes {
+		switch path.Base(node.Key) {
+		case "cert":
+			files.cert = node.Value
+		case "key":
+			files.key = node.Value
+		case "client_ca":
+			files.clientCA = node.Value
+		default:
+			err = fmt.Errorf("unknown tls entry %s", node.Key)
+			return
+		}
+	}
+	if files.cert == "" || files.key == "" {
+		err = fmt.Errorf("%s/cert and %s/key are required", dir, dir)
+	}
+	return
+}
+
+// getNodeMetadata reads metadata of nodes from dir, where each child is a Dir
+// named by a hardware address, containing key-value pairs.
+func getNodeMetadata(client *configClient, dir string) (meta map[string]map[string]string, err error) {
+	meta = make(map[string]map[string]string)
+	var resp *etcd.Response
+	resp, err = client.Get(dir, false, true)
+	if err != nil {
+		if common.IsEtcdNotFoundError(err) {
+			err = nil
+		}
+		return
+	}
+	if !resp.Node.Dir {
+		err = fmt.Errorf("%s is not a Dir node", dir)
+		return
+	}
+	for _, node := range resp.Node.Nodes {
+		if !node.Dir {
+			err = fmt.Errorf("%s is not a Dir node", node.Key)
+			return
+		}
+		addr := path.Base(node.Key)
+		if _, err = net.ParseMAC(addr); err != nil {
+			return
+		}
+		meta[addr] = make(map[string]string)
+		for _, kv := range node.Nodes {
+			meta[addr][path.Base(kv.Key)] = kv.Value
+		}
+	}
 	return
 }
 
@@ -145,19 +1133,13 @@ func getAddr(interfaceName string) (net.IP, error) {
 	return nil, fmt.Errorf("Configured interface (%s) is not found", interfaceName)
 }
 
-func runMaster(conf config) (err error) {
-	var network *net.IPNet
-	_, network, err = net.ParseCIDR(conf.emulatedSubnet)
-	if err != nil {
-		return
-	}
-
-	var mobilityManager squirrel.MobilityManager
+// newModels creates and configures the MobilityManager and September named in
+// conf.
+func newModels(conf config) (mobilityManager squirrel.MobilityManager, assigned []assignedMobilityManager, september squirrel.September, err error) {
 	mobilityManager, err = newMobilityManager(conf.mobilityManager)
 	if err != nil {
 		return
 	}
-	var september squirrel.September
 	september, err = newSeptember(conf.september)
 	if err != nil {
 		return
@@ -165,52 +1147,467 @@ func runMaster(conf config) (err error) {
 
 	err = mobilityManager.Configure(conf.mobilityManagerConfig)
 	if err != nil {
-		log.Println("Creating MobilityManager failed. Following message might help:\n")
-		log.Println(mobilityManager.ParametersHelp())
+		logger.errorf("Creating MobilityManager failed. Following message might help:\n\n%s", mobilityManager.ParametersHelp())
 		return
 	}
 	err = september.Configure(conf.septemberConfig)
 	if err != nil {
-		log.Println("Creating September failed. Following message might help:\n")
-		log.Println(september.ParametersHelp())
+		logger.errorf("Creating September failed. Following message might help:\n\n%s", september.ParametersHelp())
+		return
+	}
+	// even without overrides, so that they can be added at runtime
+	september = newLinkOverrideSeptember(september, conf.linkOverrides)
+
+	for _, a := range conf.mobilityAssignments {
+		var model squirrel.MobilityManager
+		model, err = newMobilityManager(a.mobilityManager)
+		if err != nil {
+			return
+		}
+		err = model.Configure(a.parameters)
+		if err != nil {
+			logger.errorf("Creating MobilityManager for %s failed. Following message might help:\n\n%s", a.name, model.ParametersHelp())
+			return
+		}
+		assigned = append(assigned, assignedMobilityManager{mobilityAssignment: a, model: model})
+	}
+	return
+}
+
+func runMaster(conf config) (err error) {
+	var network *net.IPNet
+	_, network, err = net.ParseCIDR(conf.emulatedSubnet)
+	if err != nil {
+		return
+	}
+
+	var mobilityManager squirrel.MobilityManager
+	var assigned []assignedMobilityManager
+	var september squirrel.September
+	mobilityManager, assigned, september, err = newModels(conf)
+	if err != nil {
 		return
 	}
 
-	master := NewMaster(network, mobilityManager, september)
+	master := NewMaster(network, conf.mobilityManager, mobilityManager, assigned, september, conf.positionManager, conf.septemberSeed)
+	master.throttle.set(conf.linkRate, conf.linkQueue)
+	master.impair.set(conf.linkDuplicate, conf.linkReorder, conf.linkReorderDelay)
+	master.energy.set(conf.energy)
+	master.mobilityManagerConf = conf.mobilityManagerConfig
+	master.septemberName, master.septemberConfig = conf.september, conf.septemberConfig
+	if conf.tls != nil {
+		master.tlsConfig, err = common.ServerTLSConfig(conf.tls.cert, conf.tls.key, conf.tls.clientCA)
+		if err != nil {
+			return
+		}
+	}
+	err = master.addStaticNodes(conf.staticNodes)
+	if err != nil {
+		return
+	}
+	for addr, meta := range conf.nodeMetadata {
+		for k, v := range meta {
+			master.positionManager.setInitialMetadataAddr(addr, k, v)
+		}
+	}
+	if conf.positionsFile != "" {
+		var positions map[string]squirrel.Position
+		positions, err = readPositionsFile(conf.positionsFile)
+		if err != nil {
+			return
+		}
+		for addr, pos := range positions {
+			// positions file is in configured units; snapshots are in meters
+			master.positionManager.setInitialAddr(addr, master.positionManager.fromSupplied(pos))
+		}
+	}
+	if *snapshot != "" {
+		var data []byte
+		data, err = ioutil.ReadFile(*snapshot)
+		if err != nil {
+			return
+		}
+		err = master.positionManager.Restore(data)
+		if err != nil {
+			return
+		}
+	}
+	if conf.positionExport != nil {
+		var exporter *positionExporter
+		exporter, err = newPositionExporter(*conf.positionExport, master.positionManager, master.addrReverse)
+		if err != nil {
+			return
+		}
+		exporter.start()
+	}
+	go master.watchReload(conf)
+	clock.setScale(conf.mobilityTimeScale)
+	if conf.mobilityPaused {
+		pauseMobility()
+	}
+	go watchPause()
+	if conf.controlListen != "" {
+		serveControl(conf.controlListen, master)
+	}
+	if conf.grpcListen != "" {
+		serveGRPC(conf.grpcListen, master)
+	}
+	if len(conf.events) > 0 {
+		go master.runScenario(conf.events)
+	}
 	return master.Run(conf.uri)
 }
 
 func printHelp() {
 	fmt.Println()
-	fmt.Printf("Usage: %s\n", os.Args[0])
+	fmt.Printf("Usage: %s [flags]\n", os.Args[0])
+	fmt.Println()
+	fmt.Println("Flags:")
+	flag.CommandLine.SetOutput(os.Stdout)
+	flag.PrintDefaults()
 	fmt.Println()
 	fmt.Println("Environment Variables:")
-	fmt.Println("    SQUIRREL_ENDPOINT  : etcd endpoint UIR. [Optional]")
+	fmt.Println("    SQUIRREL_ENDPOINT  : etcd endpoint UIR, or comma separated URIs of an etcd")
+	fmt.Println("                             cluster. etcd:// and etcds:// are accepted as")
+	fmt.Println("                             http:// and https://. [Optional]")
 	fmt.Println("                             Default: http://127.0.0.1:4001")
+	fmt.Println("    SQUIRREL_MASTER_LISTEN_ADDRESS : host:port that master listens on, instead")
+	fmt.Println("                             of port 1234 on /squirrel/master_ifce. [Optional]")
+	fmt.Println("    SQUIRREL_MASTER_<KEY>  : Overrides etcd entry /squirrel/master/<key>, e.g.")
+	fmt.Println("                             SQUIRREL_MASTER_EMULATED_SUBNET. Only entries")
+	fmt.Println("                             with a single value can be overridden. [Optional]")
 	fmt.Println("Etcd Configuration Entries:")
 	fmt.Println("    /squirrel/master/emulated_subnet              [Required]")
 	fmt.Println("        Network in CIDR notation for emulated wireless network.")
 	fmt.Println("    /squirrel/master/mobility_manager             [Required]")
-	fmt.Println("        Name of the Mobility Manager.")
+	fmt.Println("        Name of the Mobility Manager. Built-in: random-waypoint, random-walk,")
+	fmt.Println("        rpgm, ns2-trace, bonnmotion, sumo-fcd, gpx, waypoints, http, static,")
+	fmt.Println("        levy-walk, orbit, follow, gps. It can be switched at runtime: on reload,")
+	fmt.Println("        through the control API, or by a scenario event.")
 	fmt.Println("    /squirrel/master/mobility_manager_config_path [Optional]")
 	fmt.Println("        Configuration node (a Dir) of the Mobility Manager.")
+	fmt.Println("    /squirrel/master/mobility_managers/<name>/mobility_manager [Optional]")
+	fmt.Println("        Name of an additional Mobility Manager that controls nodes assigned to")
+	fmt.Println("        it, instead of mobility_manager. A node assigned to more than one is")
+	fmt.Println("        controlled by the first by <name>; others cannot move it.")
+	fmt.Println("    /squirrel/master/mobility_managers/<name>/config_path      [Optional]")
+	fmt.Println("        Configuration node (a Dir) of the additional Mobility Manager.")
+	fmt.Println("    /squirrel/master/mobility_managers/<name>/nodes            [Optional]")
+	fmt.Println("        Comma separated hardware addresses of nodes assigned to it.")
+	fmt.Println("    /squirrel/master/mobility_managers/<name>/tags             [Optional]")
+	fmt.Println("        Comma separated tags; nodes with any of them (see nodes/<mac>/tags) are")
+	fmt.Println("        assigned to it.")
 	fmt.Println("    /squirrel/master/september                    [Required]")
-	fmt.Println("        Name of the September.")
+	fmt.Println("        Name of the September. Built-in: StaticSeptember, which connects nodes")
+	fmt.Println("        by a fixed list of links rather than by positions, and")
+	fmt.Println("        LogDistanceSeptember, TwoRaySeptember and IndoorSeptember, which")
+	fmt.Println("        deliver packets by received power under log-distance, two-ray ground")
+	fmt.Println("        reflection and multi-wall (of a floor plan) path loss, and")
+	fmt.Println("        RangeSeptember, which drops packets beyond max_range. A comma")
+	fmt.Println("        separated list of them, e.g. LogDistanceSeptember,StaticSeptember,")
+	fmt.Println("        chains them: each stage decides on packets that earlier ones deliver,")
+	fmt.Println("        and the first one picks recipients of broadcast packets, so that")
+	fmt.Println("        RangeSeptember,LogDistanceSeptember bounds neighbors of each node.")
 	fmt.Println("    /squirrel/master/september_config_path        [Optional]")
-	fmt.Println("        Configuration node (a Dir) of the September.")
+	fmt.Println("        Configuration node (a Dir) of the September. For a chain, parameters")
+	fmt.Println("        of each stage are in a child Dir named after it.")
+	fmt.Println("    /squirrel/master/september_seed               [Optional]")
+	fmt.Println("        Seed of random numbers of built-in Septembers, link overrides and")
+	fmt.Println("        impairments, each link drawing from a stream of its own, so that runs")
+	fmt.Println("        with the same seed and traces make the same decisions on each link.")
+	fmt.Println("        Decoding under interference and CSMA backoffs draw from streams apart,")
+	fmt.Println("        as how many draws they make depends on timing of transmissions.")
+	fmt.Println("        Septembers registered in models can draw from it too. Default: 0")
+	fmt.Println("        (current time, which is logged)")
+	fmt.Println("    <config_path>/_include                        [Optional]")
+	fmt.Println("        Comma separated Dirs whose entries are merged into configuration node")
+	fmt.Println("        of the Mobility Manager or September. Entries of the configuration")
+	fmt.Println("        node itself take precedence.")
+	fmt.Println("    /squirrel/master/position_history_size        [Optional]")
+	fmt.Println("        Number of recent positions kept for each node. Default: 0 (disabled)")
+	fmt.Println("    /squirrel/master/spatial_index_cell_size      [Optional]")
+	fmt.Println("        Grid cell size of spatial index for neighbor queries (in meters with wgs84). Default: 100")
+	fmt.Println("    /squirrel/master/coordinate_system            [Optional]")
+	fmt.Println("        cartesian, or wgs84 for (longitude, latitude, altitude). Default: cartesian")
+	fmt.Println("    /squirrel/master/preallocated_nodes           [Optional]")
+	fmt.Println("        Number of nodes whose state (position history, metadata) is allocated")
+	fmt.Println("        at startup; state of other nodes is allocated lazily as they join.")
+	fmt.Println("        A slot of a few bytes is still reserved for each address of")
+	fmt.Println("        emulated_subnet at startup. Default: size of emulated_subnet")
+	fmt.Println("    /squirrel/master/distance_cache               [Optional]")
+	fmt.Println("        Cache distances between nodes until either one moves. Default: false")
+	fmt.Println("    /squirrel/master/planar                       [Optional]")
+	fmt.Println("        Ignore Height of all positions (2D mode). Default: false")
+	fmt.Println("    /squirrel/master/enabled_changed_policy       [Optional]")
+	fmt.Println("        What happens to enabled and position changed notifications when a")
+	fmt.Println("        subscriber is slow: coalesce (deliver only the latest, of each node")
+	fmt.Println("        for positions), or drop. Default: coalesce")
+	fmt.Println("    /squirrel/master/units                        [Optional]")
+	fmt.Println("        Units of positions supplied by Mobility Manager, positions file etc.:")
+	fmt.Println("        m, km or ft. Positions are converted into meters before used by")
+	fmt.Println("        September, and back when Mobility Managers read them. Only Height is")
+	fmt.Println("        converted with wgs84. Default: m")
+	fmt.Println("    /squirrel/master/scale                        [Optional]")
+	fmt.Println("        Multiplier applied on top of units, e.g. 25 for grid units of 25m.")
+	fmt.Println("        Default: 1")
+	fmt.Println("    /squirrel/master/origin                       [Optional]")
+	fmt.Println("        Origin subtracted from supplied positions, as \"x,y\" or \"x,y,height\"")
+	fmt.Println("        in supplied units, e.g. for UTM traces. Positions used by September")
+	fmt.Println("        are relative to it. Not supported with wgs84. Default: 0,0,0")
+	fmt.Println("    /squirrel/master/arena/{min,max}_{x,y,height}  [Optional]")
+	fmt.Println("        Bounds of arena (after conversion into meters) that nodes are kept in.")
+	fmt.Println("        Default: unbounded")
+	fmt.Println("    /squirrel/master/arena/policy                 [Optional]")
+	fmt.Println("        What happens when a node is moved out of arena: reject, clamp, or wrap.")
+	fmt.Println("        Default: reject")
+	fmt.Println("    /squirrel/master/obstacles/<name>/polygon     [Optional]")
+	fmt.Println("        Obstacle for line-of-sight checks, as \"x1,y1 x2,y2 ...\". Two points")
+	fmt.Println("        make a wall; more points make a closed polygon.")
+	fmt.Println("    /squirrel/master/obstacles/<name>/height      [Optional]")
+	fmt.Println("        Height of the obstacle. Default: 0 (infinitely high)")
+	fmt.Println("    /squirrel/master/obstacles/<name>/loss        [Optional]")
+	fmt.Println("        dB that radio propagation Septembers add to paths the obstacle")
+	fmt.Println("        blocks. Default: obstruction/loss of the September")
+	fmt.Println("    /squirrel/master/obstacles_file               [Optional]")
+	fmt.Println("        GeoJSON file of more obstacles, e.g. buildings exported from a map:")
+	fmt.Println("        Polygon features are polygons, and LineString ones walls, with")
+	fmt.Println("        properties \"name\", \"height\" and \"loss\" as above.")
+	fmt.Println("    /squirrel/master/terrain/file                 [Optional]")
+	fmt.Println("        ESRI ASCII grid of ground height, in the units of positions. Ground")
+	fmt.Println("        that rises above the path between nodes blocks line of sight, and")
+	fmt.Println("        radio propagation Septembers add knife-edge diffraction loss of it.")
+	fmt.Println("    /squirrel/master/terrain/relative             [Optional]")
+	fmt.Println("        If true, Height of nodes is above ground; otherwise above the datum")
+	fmt.Println("        of terrain. Default: true")
+	fmt.Println("    /squirrel/master/distance_matrix/min_interval [Optional]")
+	fmt.Println("        Recompute distances between all enabled nodes in background, no more")
+	fmt.Println("        often than this, e.g. 10ms. Default: disabled; 10ms if max_interval is set")
+	fmt.Println("    /squirrel/master/distance_matrix/max_interval [Optional]")
+	fmt.Println("        Longest wait between recomputations when computing is slow. Default: 1s")
+	fmt.Println("    /squirrel/master/positions_file               [Optional]")
+	fmt.Println("        Path to a CSV file of initial positions (mac,x,y,height). Nodes are")
+	fmt.Println("        placed at these positions when they join.")
+	fmt.Println("    /squirrel/master/position_export/path         [Optional]")
+	fmt.Println("        File that positions of all enabled nodes are periodically appended to.")
+	fmt.Println("    /squirrel/master/position_export/format       [Optional]")
+	fmt.Println("        csv or jsonl. Default: csv")
+	fmt.Println("    /squirrel/master/position_export/interval     [Optional]")
+	fmt.Println("        Export interval, e.g. 500ms. Default: 1s")
+	fmt.Println("    /squirrel/master/nodes/<mac>/name             [Optional]")
+	fmt.Println("        Declares node with hardware address <mac>. It has an identity (and")
+	fmt.Println("        address) reserved before it connects. name is kept as metadata.")
+	fmt.Println("    /squirrel/master/nodes/<mac>/position         [Optional]")
+	fmt.Println("        Initial position of the node, as \"x,y\" or \"x,y,height\".")
+	fmt.Println("    /squirrel/master/nodes/<mac>/tags             [Optional]")
+	fmt.Println("        Comma separated tags of the node, kept as metadata \"tags\".")
+	fmt.Println("    /squirrel/master/nodes/<mac>/fixed            [Optional]")
+	fmt.Println("        If true, the node stays at its initial position. Default: false")
+	fmt.Println("    /squirrel/master/nodes/<mac>/group            [Optional]")
+	fmt.Println("        Mobility group of the node, kept as metadata \"group\". Nodes in a")
+	fmt.Println("        group move together under group mobility models, e.g. rpgm.")
+	fmt.Println("    /squirrel/master/nodes/<mac>/gpx              [Optional]")
+	fmt.Println("        GPX file of the node, kept as metadata \"gpx\", that the gpx")
+	fmt.Println("        Mobility Manager replays.")
+	fmt.Println("    /squirrel/master/nodes/<mac>/tx_power         [Optional]")
+	fmt.Println("        Transmit power of the node in dBm, kept as metadata \"tx_power\", that")
+	fmt.Println("        radio propagation Septembers use instead of their own tx_power.")
+	fmt.Println("    /squirrel/master/nodes/<mac>/sensitivity      [Optional]")
+	fmt.Println("        Sensitivity of the node in dBm, kept as metadata \"sensitivity\",")
+	fmt.Println("        likewise.")
+	fmt.Println("    /squirrel/master/nodes/<mac>/channel          [Optional]")
+	fmt.Println("        Channel of the node, kept as metadata \"channel\", that radio")
+	fmt.Println("        propagation Septembers take frequency of from their channels. Nodes")
+	fmt.Println("        only receive from and interfere with nodes on the same channel.")
+	fmt.Println("    /squirrel/master/nodes/<mac>/battery          [Optional]")
+	fmt.Println("        Energy of the node in joules, kept as metadata \"battery\", instead")
+	fmt.Println("        of battery.")
+	fmt.Println("    /squirrel/master/tls/{cert,key}               [Optional]")
+	fmt.Println("        PEM certificate and key files. If set, workers connect over TLS, and")
+	fmt.Println("        the control API and gRPC control service are served over it.")
+	fmt.Println("    /squirrel/master/tls/client_ca                [Optional]")
+	fmt.Println("        PEM file of CAs that worker certificates need to be signed by. If not")
+	fmt.Println("        set, workers are not required to present certificates.")
+	fmt.Println("    /squirrel/master/events/<name>/at             [Optional]")
+	fmt.Println("        Time after master starts that event <name> happens, e.g. 30s; or after it's")
+	fmt.Println("        triggered, if on is set.")
+	fmt.Println("    /squirrel/master/events/<name>/action         [Optional]")
+	fmt.Println("        enable, disable or move a node; or reconfigure_mobility_manager or")
+	fmt.Println("        reconfigure_september with new parameters; or switch_mobility_manager;")
+	fmt.Println("        or pause_mobility or resume_mobility.")
+	fmt.Println("    /squirrel/master/events/<name>/on             [Optional]")
+	fmt.Println("        Mobility event that triggers event <name>, once: waypoint_reached")
+	fmt.Println("        (random-waypoint, rpgm, waypoints), direction_changed (random-walk,")
+	fmt.Println("        levy-walk) or trace_finished (trace replaying Mobility Managers).")
+	fmt.Println("    /squirrel/master/events/<name>/on_nodes       [Optional]")
+	fmt.Println("        Comma separated hardware addresses of nodes that all need to have had")
+	fmt.Println("        the mobility event, e.g. to start traffic once all nodes reach their")
+	fmt.Println("        start positions. Default: the first one of any node triggers it.")
+	fmt.Println("    /squirrel/master/events/<name>/node           [Optional]")
+	fmt.Println("        Hardware address of the node to enable, disable or move.")
+	fmt.Println("    /squirrel/master/events/<name>/position       [Optional]")
+	fmt.Println("        Position to move the node to, as \"x,y\" or \"x,y,height\".")
+	fmt.Println("    /squirrel/master/events/<name>/parameters_path [Optional]")
+	fmt.Println("        Configuration node (a Dir) to reconfigure, or switch, with.")
+	fmt.Println("    /squirrel/master/events/<name>/mobility_manager [Optional]")
+	fmt.Println("        Name of the Mobility Manager to switch to, e.g. random-waypoint after a")
+	fmt.Println("        warm-up with static; parameters are from parameters_path.")
+	fmt.Println("    /squirrel/master/link_overrides/<name>/nodes  [Optional]")
+	fmt.Println("        Two comma separated hardware addresses. Packets between these nodes")
+	fmt.Println("        are handled as below after September decides on them.")
+	fmt.Println("    /squirrel/master/link_overrides/<name>/loss   [Optional]")
+	fmt.Println("        Additional probability (0 to 1) that a packet is dropped. Default: 0")
+	fmt.Println("    /squirrel/master/link_overrides/<name>/connected [Optional]")
+	fmt.Println("        If true, packets are always delivered (subject to loss); if false,")
+	fmt.Println("        never. Default: as September decides")
+	fmt.Println("    /squirrel/master/link_overrides/<name>/symmetric [Optional]")
+	fmt.Println("        If false, only packets from the first node to the second one are")
+	fmt.Println("        affected. Default: true")
+	fmt.Println("    /squirrel/master/link_overrides/<name>/delay  [Optional]")
+	fmt.Println("        Latency added to delivered packets, e.g. 20ms. Default: 0s")
+	fmt.Println("    /squirrel/master/link_overrides/<name>/jitter [Optional]")
+	fmt.Println("        Packets are delayed by up to this much more, uniformly. They are")
+	fmt.Println("        never reordered, except as reorder says. Default: 0s")
+	fmt.Println("    /squirrel/master/link_overrides/<name>/rate   [Optional]")
+	fmt.Println("        Capacity of the link, as in link_rate. Default: link_rate")
+	fmt.Println("    /squirrel/master/link_overrides/<name>/queue  [Optional]")
+	fmt.Println("        Queue size of the link, as in link_queue. Default: link_queue")
+	fmt.Println("    /squirrel/master/link_overrides/<name>/duplicate [Optional]")
+	fmt.Println("    /squirrel/master/link_overrides/<name>/reorder [Optional]")
+	fmt.Println("    /squirrel/master/link_overrides/<name>/reorder_delay [Optional]")
+	fmt.Println("        Impairments of the link, as in link_duplicate, link_reorder and")
+	fmt.Println("        link_reorder_delay. Default: those")
+	fmt.Println("    /squirrel/master/link_rate                    [Optional]")
+	fmt.Println("        Capacity of each link in bits per second, e.g. 6M or 6Mbps. Frames")
+	fmt.Println("        take size/rate to transmit, and wait behind earlier ones on the same")
+	fmt.Println("        link. Each direction between two nodes is a link of its own. Applied")
+	fmt.Println("        on reload. Default: 0 (unlimited)")
+	fmt.Println("    /squirrel/master/link_queue                   [Optional]")
+	fmt.Println("        Bytes that can wait on a link; frames that don't fit are dropped.")
+	fmt.Println("        Applied on reload. Default: 65536")
+	fmt.Println("    /squirrel/master/link_duplicate               [Optional]")
+	fmt.Println("        Probability (0 to 1) that a delivered frame is delivered twice; the")
+	fmt.Println("        copy goes through the link right after it. Applied on reload.")
+	fmt.Println("        Default: 0")
+	fmt.Println("    /squirrel/master/link_reorder                 [Optional]")
+	fmt.Println("        Probability (0 to 1) that a delivered frame is held back for")
+	fmt.Println("        link_reorder_delay, while frames after it on the link are not, so")
+	fmt.Println("        they can overtake it. Applied on reload. Default: 0")
+	fmt.Println("    /squirrel/master/link_reorder_delay           [Optional]")
+	fmt.Println("        How long reordered frames are held back beyond their delay. Applied")
+	fmt.Println("        on reload. Default: 10ms")
+	fmt.Println("        Link overrides are applied on reload, and can be changed at runtime")
+	fmt.Println("        through the control API.")
+	fmt.Println("    /squirrel/master/energy_tx_frame              [Optional]")
+	fmt.Println("    /squirrel/master/energy_tx_byte               [Optional]")
+	fmt.Println("    /squirrel/master/energy_rx_frame              [Optional]")
+	fmt.Println("    /squirrel/master/energy_rx_byte               [Optional]")
+	fmt.Println("        Joules that a node spends on each frame it sends or receives, and on")
+	fmt.Println("        each byte of it, e.g. 1e-7 per byte. Applied on reload. Default: 0")
+	fmt.Println("    /squirrel/master/battery                      [Optional]")
+	fmt.Println("        Energy in joules that each node has, which frames are counted")
+	fmt.Println("        against. GET /nodes/<mac>/energy of the control API tells how much")
+	fmt.Println("        is spent and remains. Applied on reload. Default: 0 (unlimited)")
+	fmt.Println("    /squirrel/master/battery_disable              [Optional]")
+	fmt.Println("        If true, a node whose battery is exhausted is disabled, as if it had")
+	fmt.Println("        left, until it joins under another identity or master restarts.")
+	fmt.Println("        Applied on reload. Default: false")
+	fmt.Println("    /squirrel/master/log/level                    [Optional]")
+	fmt.Println("        debug, info, warn or error. Default: info")
+	fmt.Println("    /squirrel/master/log/format                   [Optional]")
+	fmt.Println("        text or json (one object per line). Default: text")
+	fmt.Println("    /squirrel/master/log/output                   [Optional]")
+	fmt.Println("        File to log into. Default: stdout")
+	fmt.Println("    /squirrel/master/log/max_size                 [Optional]")
+	fmt.Println("        Size in bytes beyond which the log file is rotated. Default: 0 (never)")
+	fmt.Println("    /squirrel/master/log/max_backups              [Optional]")
+	fmt.Println("        Number of rotated log files (<output>.1 being the newest) to keep.")
+	fmt.Println("        Default: 0")
+	fmt.Println("    /squirrel/master/vars/<NAME>                  [Optional]")
+	fmt.Println("        Value of variable NAME. ${NAME} in any other value (including")
+	fmt.Println("        configuration of models) is substituted with environment variable")
+	fmt.Println("        NAME if it's set, or this value otherwise. $$ is a literal $.")
+	fmt.Println("    /squirrel/master/node_metadata/<mac>/<key>    [Optional]")
+	fmt.Println("        Metadata value of node with hardware address <mac>, e.g.")
+	fmt.Println("        /squirrel/master/node_metadata/02:00:00:00:00:01/role -> UAV")
+	fmt.Println("    /squirrel/master/control_listen               [Optional]")
+	fmt.Println("        host:port that the control API listens on, e.g. 127.0.0.1:9000.")
+	fmt.Println("        It's served over TLS, with tls/{cert,key} and client_ca, if they are")
+	fmt.Println("        set. Otherwise it's unauthenticated, so keep it on a loopback address.")
+	fmt.Println("        POST /mobility/pause and /mobility/resume freeze and unfreeze Mobility")
+	fmt.Println("        Managers that run on master's clock (all built-in ones); while paused,")
+	fmt.Println("        POST /mobility/step with {\"duration\": \"1s\"} advances them by exactly")
+	fmt.Println("        that much. PUT /mobility/time_scale with {\"time_scale\": ...} changes")
+	fmt.Println("        mobility_time_scale. GET /mobility reports the state, including random")
+	fmt.Println("        seeds of built-in Mobility Managers. GET /mobility/events streams")
+	fmt.Println("        mobility events as lines of JSON. PUT /mobility/manager with")
+	fmt.Println("        {\"mobility_manager\": ..., \"config_path\": ...} switches to another")
+	fmt.Println("        Mobility Manager, configured with parameters at config_path, without")
+	fmt.Println("        disturbing connections. GET /links lists link overrides, PUT")
+	fmt.Println("        /links/<name> with {\"nodes\": \"<mac>,<mac>\", \"loss\": 0.2} adds or")
+	fmt.Println("        replaces one (other entries of link_overrides are optional), and")
+	fmt.Println("        DELETE /links/<name> removes it. GET and PUT /nodes/<mac>/radio with")
+	fmt.Println("        {\"tx_power\": 30, \"sensitivity\": -95, \"channel\": \"36\"} read and set")
+	fmt.Println("        radio settings of a node (null for the September's own, or the")
+	fmt.Println("        default channel). GET /nodes/<mac>/links/<mac> tells what September")
+	fmt.Println("        believes about a link: loss of 1500 byte packets, and RSSI, SNR and")
+	fmt.Println("        rate if it models them; GET /nodes/<mac>/links lists links to all")
+	fmt.Println("        nodes that packets may reach. GET /nodes/<mac>/energy tells how much")
+	fmt.Println("        of its battery a node has spent. GET and PUT /september/parameters")
+	fmt.Println("        with {\"exponent\": 3.2, \"fading/model\": null} read and change")
+	fmt.Println("        parameters of the September at runtime, by keys under")
+	fmt.Println("        september_config_path (null for the default). GET /nodes lists nodes")
+	fmt.Println("        with their positions and metadata, and GET /nodes/<mac> tells of one;")
+	fmt.Println("        PUT /nodes/<mac>/position with {\"x\": 10, \"y\": 20, \"height\": 0}")
+	fmt.Println("        moves it, and POST /nodes/<mac>/enable and /nodes/<mac>/disable")
+	fmt.Println("        enable and disable it as scenario events do. GET /config tells which")
+	fmt.Println("        Mobility Manager and September run, with their parameters.")
+	fmt.Println("        Default: disabled")
+	fmt.Println("    /squirrel/master/grpc_listen                  [Optional]")
+	fmt.Println("        host:port that the gRPC control service, squirrel.Control, listens")
+	fmt.Println("        on, e.g. 127.0.0.1:9001, alongside or instead of control_listen.")
+	fmt.Println("        Nodes, SetPosition, SetEnabled and LinkQuality do what their")
+	fmt.Println("        counterparts in the control API do; WatchPositions streams moves of")
+	fmt.Println("        nodes, and WatchLinks streams links coming up and going down,")
+	fmt.Println("        checked every interval_ms. control/control.proto defines it, for")
+	fmt.Println("        clients in other languages to generate stubs from; Go clients can")
+	fmt.Println("        use control.ControlClient. Messages are protobuf encoded, or JSON")
+	fmt.Println("        encoded with the \"json\" content-subtype. Like control_listen, it's")
+	fmt.Println("        served over TLS if tls/{cert,key} are set, and should be kept on a")
+	fmt.Println("        loopback address otherwise. Default: disabled")
+	fmt.Println("    /squirrel/master/mobility_time_scale          [Optional]")
+	fmt.Println("        How fast master's clock runs compared to wall time, e.g. 2 to replay a")
+	fmt.Println("        trace at double speed or 0.5 at half. Update intervals of Mobility")
+	fmt.Println("        Managers are in clock time, so they are scaled too. Applied on reload.")
+	fmt.Println("        Default: 1")
+	fmt.Println("    /squirrel/master/mobility_paused              [Optional]")
+	fmt.Println("        If true, master's clock starts paused, e.g. to step it from the very")
+	fmt.Println("        beginning. Default: false")
+	fmt.Println("Signals:")
+	fmt.Println("    SIGHUP  : Reload configuration from etcd. Parameters of Mobility Manager")
+	fmt.Println("              and September are applied if they support it, a changed")
+	fmt.Println("              mobility_manager is switched to, and link_overrides replace")
+	fmt.Println("              current ones; other changes need a restart.")
+	fmt.Println("    SIGUSR2 : Pause built-in Mobility Managers, or resume them if they are")
+	fmt.Println("              paused. Nodes stay where they are while paused.")
 }
 
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file; if specified, squirrel-master runs for 60 seconds and exits.")
-var debug = flag.Bool("debug", false, "verbose logging for debug purposes")
+var debug = flag.Bool("debug", false, "verbose logging for debug purposes; same as /squirrel/master/log/level set to debug.")
+var listenOverride = flag.String("listen", "", "host:port to listen on; overrides SQUIRREL_MASTER_LISTEN_ADDRESS and /squirrel/master_ifce.")
+var networkOverride = flag.String("network", "", "emulated network in CIDR notation; overrides /squirrel/master/emulated_subnet.")
+var mobilityOverride = flag.String("mobility", "", "name of the Mobility Manager; overrides /squirrel/master/mobility_manager.")
+var septemberOverride = flag.String("september", "", "name of the September; overrides /squirrel/master/september.")
+var check = flag.Bool("check", false, "check configuration, including parameters of Mobility Manager and September, and exit with status 0 if it's OK or 1 otherwise.")
+var printConfigOnly = flag.Bool("print-config", false, "print resolved configuration (with defaults and overrides applied) and exit.")
+var snapshot = flag.String("snapshot", "", "load node positions from a snapshot file at startup; nodes are placed at snapshotted positions when they join.")
 
 func main() {
-	log.SetOutput(os.Stdout)
-
 	flag.Parse()
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
-			log.Fatal(err)
+			logger.errorf("%v", err)
+			os.Exit(1)
 		}
 		pprof.StartCPUProfile(f)
 		go func() {
@@ -220,16 +1617,34 @@ func main() {
 		}()
 	}
 
-	conf, err := getConfig()
-	if err != nil {
-		log.Println(err)
+	conf, errs := getConfig()
+	if errs = append(errs, validateConfig(conf)...); len(errs) > 0 {
+		logger.errorf("%d problem(s) found in configuration:", len(errs))
+		for _, e := range errs {
+			logger.errorf("    %v", e)
+		}
 		printHelp()
 		os.Exit(1)
 	}
+	// -check logs to stdout, rather than opening log outputs of master
+	if !*printConfigOnly && !*check {
+		if err := setupLogger(conf.log); err != nil {
+			logger.errorf("%v", err)
+			os.Exit(1)
+		}
+	}
+	if *printConfigOnly {
+		printConfig(os.Stdout, conf)
+		return
+	}
+	if *check {
+		// models have been configured by validateConfig
+		logger.infof("configuration is OK")
+		return
+	}
 
-	err = runMaster(conf)
-	if err != nil {
-		log.Println(err)
+	if err := runMaster(conf); err != nil {
+		logger.errorf("%v", err)
 		os.Exit(1)
 	}
 
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"crypto/tls"
 	"errors"
-	"log"
+	"fmt"
 	"net"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/coreos/go-etcd/etcd"
 	"github.com/songgao/packets/ethernet"
 	"github.com/squirrel-land/squirrel"
 	"github.com/squirrel-land/squirrel/common"
@@ -19,40 +24,167 @@ type Master struct {
 	addressPool     *addressPool
 	clients         []*client
 	addrReverse     *addressReverse
-	positionManager squirrel.PositionManager
+	positionManager *PositionManager
 
-	mobilityManager squirrel.MobilityManager
-	september       squirrel.September
+	mobilityGeneration uint32 // bumped each time mobilityManager is switched; accessed atomically
+
+	mobilityManager     squirrel.MobilityManager
+	mobilityManagerName string
+	mobilityManagerConf *etcd.Node           // parameters mobilityManager runs with
+	mobilityView        *positionManagerView // that mobilityManager is initialized with
+	muMobility          sync.Mutex           // mobilityManager, mobilityManagerName, mobilityManagerConf and mobilityView
+
+	assigned  []assignedMobilityManager // control assigned nodes instead of mobilityManager
+	september squirrel.September
+	delayer   squirrel.Delayer          // september, if it delays packets
+	batch     squirrel.BroadcastDelayer // september, if it delays broadcast packets at once
+	delivery  *deliveryScheduler
+	throttle  *linkThrottle
+	impair    *linkImpairment
+	random    *linkRandom // handed to september, and for impairments
+	energy    *energyMeter
+
+	septemberName   string
+	septemberConfig *etcd.Node // parameters september runs with, for changing some of them
+	muSeptember     sync.Mutex // septemberConfig, and reconfiguring september
+
+	tlsConfig *tls.Config // nil if not using TLS
+
+	// identities reserved for static nodes. They are only modified before Run.
+	reserved           map[string]int // lower-cased hardware address -> identity
+	reservedIdentities map[int]bool
 }
 
-func NewMaster(network *net.IPNet, mobilityManager squirrel.MobilityManager, september squirrel.September) (master *Master) {
-	master = &Master{addressPool: newAddressPool(network), addrReverse: newAddressReverse(), mobilityManager: mobilityManager, september: september}
+func NewMaster(network *net.IPNet, mobilityManagerName string, mobilityManager squirrel.MobilityManager, assigned []assignedMobilityManager, september squirrel.September, positionManagerConf positionManagerConfig, septemberSeed int64) (master *Master) {
+	master = &Master{addressPool: newAddressPool(network), addrReverse: newAddressReverse(), mobilityManager: mobilityManager, mobilityManagerName: mobilityManagerName, assigned: assigned, september: september}
+	master.reserved = make(map[string]int)
+	master.reservedIdentities = make(map[int]bool)
 	master.clients = make([]*client, master.addressPool.Capacity()+1, master.addressPool.Capacity()+1)
-	master.positionManager = NewPositionManager(master.addressPool.Capacity()+1, master.addrReverse)
-	master.mobilityManager.Initialize(master.positionManager)
+	master.positionManager = NewPositionManager(master.addressPool.Capacity()+1, master.addrReverse, positionManagerConf)
+	master.initializePrimary()
+	for i := range master.assigned {
+		i := i
+		initializeMobilityManager(master.assigned[i].model, newPositionManagerView(master.positionManager, func(index int) bool {
+			return master.owner(index) == i
+		}))
+	}
+	master.random = newLinkRandom(septemberSeed)
+	if r, ok := master.september.(squirrel.Randomized); ok {
+		r.SetRandomSource(master.random)
+	}
 	master.september.Initialize(master.positionManager)
+	master.delayer, _ = master.september.(squirrel.Delayer)
+	master.batch, _ = master.september.(squirrel.BroadcastDelayer)
+	master.delivery = newDeliveryScheduler(master)
+	master.throttle = newLinkThrottle()
+	master.impair = newLinkImpairment()
+	master.energy = newEnergyMeter(master.positionManager)
 	return
 }
 
+// send writes buf to client dst, after it has gone through the link from src
+// and delay, which September decides on for the packet. It's dropped if the
+// link's queue is full. It may be duplicated or reordered, as impairments of
+// the link say.
+func (master *Master) send(src, dst int, c *client, buf *common.ReusableSlice, size int, delay time.Duration) {
+	var o *linkOverride
+	if s, ok := master.september.(*linkOverrideSeptember); ok {
+		o = s.find(src, dst)
+	}
+	if master.impair.duplicated(o, master.random.Link(src, dst)) {
+		buf.AddOwner()
+		master.transmit(src, dst, c, buf, size, delay, o)
+		if logger.enabled(logDebug) {
+			logger.debugf("frame of length %d from client %d to client %d is duplicated", size, src, dst)
+		}
+	}
+	master.transmit(src, dst, c, buf, size, delay, o)
+}
+
+// transmit is send of a single copy of buf, over a link whose override is o,
+// if any.
+func (master *Master) transmit(src, dst int, c *client, buf *common.ReusableSlice, size int, delay time.Duration, o *linkOverride) {
+	queued, ok := master.throttle.admit(src, dst, size, o)
+	if !ok {
+		buf.Done()
+		if logger.enabled(logDebug) {
+			logger.debugf("frame of length %d from client %d to client %d is dropped since the link's queue is full", size, src, dst)
+		}
+		return
+	}
+	var confirm func() bool
+	if cf, ok := master.september.(confirmer); ok {
+		confirm = cf.confirmation(src, dst)
+	}
+	master.delivery.send(src, dst, c.Link, buf, queued+delay, master.impair.holdBack(o, master.random.Link(src, dst)), confirm)
+}
+
+// delay returns how long September delays a packet of size bytes from src to
+// dst, if it does.
+func (master *Master) delay(src, dst, size int) time.Duration {
+	if master.delayer == nil {
+		return 0
+	}
+	return master.delayer.Delay(src, dst, size)
+}
+
+// delayBroadcast sets delays[i] to how long September delays a broadcast
+// packet of size bytes from src to recipients[i], at once if it can.
+func (master *Master) delayBroadcast(src, size int, recipients []int, delays []time.Duration) {
+	switch {
+	case master.batch != nil:
+		master.batch.DelayBroadcast(src, size, recipients, delays)
+	case master.delayer != nil:
+		for i, id := range recipients {
+			delays[i] = master.delayer.Delay(src, id, size)
+		}
+	default:
+		for i := range recipients {
+			delays[i] = 0
+		}
+	}
+}
+
+// initializeMobilityManager initializes m, first handing it master's clock and
+// event bus if it uses them.
+func initializeMobilityManager(m squirrel.MobilityManager, positionManager squirrel.PositionManager) {
+	if c, ok := m.(squirrel.Clocked); ok {
+		c.SetClock(clock)
+	}
+	if s, ok := m.(squirrel.EventSource); ok {
+		s.SetEventBus(mobilityEvents)
+	}
+	m.Initialize(positionManager)
+}
+
 func (master *Master) clientJoin(identity int, addr net.HardwareAddr, link *common.Link) {
 	master.clients[identity] = &client{Link: link, Addr: addr}
-	master.positionManager.Enable(identity)
-	master.addrReverse.Add(addr, identity)
+	master.positionManager.place(identity, addr.String())
 	ipAddr, _ := master.addressPool.GetAddress(identity)
-	log.Printf("%v joined\n", ipAddr)
+	if master.energy.isDisabled(identity) {
+		logger.infof("%v stays disabled since its battery is exhausted", ipAddr)
+	} else {
+		master.positionManager.Enable(identity)
+	}
+	master.addrReverse.Add(addr, identity)
+	logger.infof("%v joined", ipAddr)
 }
 
 func (master *Master) clientLeave(identity int, err error) {
-	master.addrReverse.Remove(master.clients[identity].Addr)
+	if _, ok := master.reserved[strings.ToLower(master.clients[identity].Addr.String())]; !ok {
+		master.addrReverse.Remove(master.clients[identity].Addr)
+		// the identity may be given to another node
+		master.energy.forget(identity)
+	}
 	master.clients[identity] = nil
 	master.positionManager.Disable(identity)
 	addr, _ := master.addressPool.GetAddress(identity)
 	if err == nil {
-		log.Printf("link to %v is terminated with no error\n", addr)
+		logger.infof("link to %v is terminated with no error", addr)
 	} else {
-		log.Printf("link to %v is terminated with error: %v\n", addr, err)
+		logger.warnf("link to %v is terminated with error: %v", addr, err)
 	}
-	log.Printf("%v left\n", addr)
+	logger.infof("%v left", addr)
 }
 
 func (master *Master) accept(listener net.Listener) (identity int, err error) {
@@ -69,15 +201,32 @@ func (master *Master) accept(listener net.Listener) (identity int, err error) {
 		return
 	}
 
-	for identity = 1; identity < len(master.clients); identity++ {
-		if master.clients[identity] == nil {
-			break
+	var reserved bool
+	if identity, reserved = master.reserved[strings.ToLower(req.MACAddr.String())]; reserved {
+		if master.clients[identity] != nil {
+			err = fmt.Errorf("node with hardware address %s is already connected", req.MACAddr)
+			link.SendJoinRsp(&common.JoinRsp{Error: err})
+			return
+		}
+	} else {
+		for identity = 1; identity < len(master.clients); identity++ {
+			if master.clients[identity] == nil && !master.reservedIdentities[identity] {
+				break
+			}
+		}
+		if identity == len(master.clients) {
+			err = errors.New("Adress poll is full")
+			link.SendJoinRsp(&common.JoinRsp{Error: err})
+			return
 		}
 	}
-	if identity == len(master.clients) {
-		err = errors.New("Adress poll is full")
-		link.SendJoinRsp(&common.JoinRsp{Error: err})
-		return
+
+	if identity >= master.positionManager.Capacity() {
+		size := 2 * master.positionManager.Capacity()
+		if size <= identity {
+			size = identity + 1
+		}
+		master.positionManager.grow(size)
 	}
 
 	var addr net.IP
@@ -107,6 +256,7 @@ func (master *Master) frameHandler(myIdentity int) {
 		buf        *common.ReusableSlice
 		ok         bool
 		underlying = make([]int, master.addressPool.Capacity()+1)
+		delays     = make([]time.Duration, len(underlying))
 	)
 
 	for {
@@ -116,35 +266,57 @@ func (master *Master) frameHandler(myIdentity int) {
 		}
 		frame := ethernet.Frame(buf.Slice())
 		dst := frame.Destination()
+		et := frame.Ethertype()
+		packet := &squirrel.Packet{
+			Source:          myIdentity,
+			Size:            len(frame.Payload()),
+			Length:          len(frame),
+			EtherType:       uint16(et[0])<<8 | uint16(et[1]),
+			SourceAddr:      frame.Source(),
+			DestinationAddr: dst,
+		}
+		if !master.energy.transmit(myIdentity, len(frame)) {
+			buf.Done()
+			if logger.enabled(logDebug) {
+				logger.debugf("frame of length %d from client %d is dropped since its battery is exhausted", len(frame.Payload()), myIdentity)
+			}
+			continue
+		}
 		if isBroadcast(dst) || isIPv4Multicast(dst) {
-			recipients := master.september.SendBroadcast(myIdentity, len(frame.Payload()), underlying)
-			for _, id := range recipients {
-				if master.clients[id] != nil {
+			recipients := sendBroadcastPacket(master.september, packet, underlying)
+			// each recipient gets a delay of its own, as it gets a draw of
+			// whether it receives the frame
+			master.delayBroadcast(myIdentity, len(frame.Payload()), recipients, delays[:len(recipients)])
+			for i, id := range recipients {
+				if c := master.clients[id]; c != nil {
+					master.energy.receive(id, len(frame))
 					buf.AddOwner()
-					master.clients[id].Link.WriteFrame(buf)
-					if *debug {
-						log.Printf("broadcast frame of length %d from client %d to be delivered to client %d\n", len(frame.Payload()), myIdentity, id)
+					master.send(myIdentity, id, c, buf, len(frame.Payload()), delays[i])
+					if logger.enabled(logDebug) {
+						logger.debugf("broadcast frame of length %d from client %d to be delivered to client %d", len(frame.Payload()), myIdentity, id)
 					}
 				}
 			}
 			buf.Done()
 		} else { // unicast
 			dstID, ok := master.addrReverse.Get(dst)
-			if ok {
-				if master.september.SendUnicast(myIdentity, dstID, len(frame.Payload())) {
-					master.clients[dstID].Link.WriteFrame(buf)
-					if *debug {
-						log.Printf("unicast frame of length %d from client %d to be delivered to client %d\n", len(frame.Payload()), myIdentity, dstID)
+			// static nodes are known to addrReverse before they connect
+			if ok && master.clients[dstID] != nil {
+				if sendUnicastPacket(master.september, packet, dstID) {
+					master.energy.receive(dstID, len(frame))
+					master.send(myIdentity, dstID, master.clients[dstID], buf, len(frame.Payload()), master.delay(myIdentity, dstID, len(frame.Payload())))
+					if logger.enabled(logDebug) {
+						logger.debugf("unicast frame of length %d from client %d to be delivered to client %d", len(frame.Payload()), myIdentity, dstID)
 					}
 				} else {
 					buf.Done()
-					if *debug {
-						log.Printf("unicast frame of length %d from client %d NOT to be delivered to client %d\n", len(frame.Payload()), myIdentity, dstID)
+					if logger.enabled(logDebug) {
+						logger.debugf("unicast frame of length %d from client %d NOT to be delivered to client %d", len(frame.Payload()), myIdentity, dstID)
 					}
 				}
 			} else {
-				if *debug {
-					log.Printf("unicast frame of length %d from client %d has unknown dst address: %v\n", len(frame.Payload()), myIdentity, dst)
+				if logger.enabled(logDebug) {
+					logger.debugf("unicast frame of length %d from client %d has unknown dst address: %v", len(frame.Payload()), myIdentity, dst)
 				}
 			}
 		}
@@ -162,6 +334,9 @@ func (master *Master) Run(laddr string) (err error) {
 	if err != nil {
 		return
 	}
+	if master.tlsConfig != nil {
+		listener = tls.NewListener(listener, master.tlsConfig)
+	}
 	for {
 		identity, err = master.accept(listener)
 		if err != nil {
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// mobilityAssignment configures an additional MobilityManager that only
+// controls nodes assigned to it, by hardware address or tag.
+type mobilityAssignment struct {
+	name            string // name of the entry in configuration
+	mobilityManager string
+	parameters      *etcd.Node
+	nodes           []string // hardware addresses
+	tags            []string // matched against "tags" metadata of nodes
+}
+
+// assignedMobilityManager is a MobilityManager created for a
+// mobilityAssignment.
+type assignedMobilityManager struct {
+	mobilityAssignment
+	model squirrel.MobilityManager
+}
+
+func (a *mobilityAssignment) equal(b *mobilityAssignment) bool {
+	return a.name == b.name && a.mobilityManager == b.mobilityManager && sameEtcdNode(a.parameters, b.parameters) &&
+		strings.Join(a.nodes, ",") == strings.Join(b.nodes, ",") && strings.Join(a.tags, ",") == strings.Join(b.tags, ",")
+}
+
+// selects returns whether node at index is assigned.
+func (a *mobilityAssignment) selects(p *PositionManager, index int) bool {
+	for _, addr := range a.nodes {
+		if id, ok := p.addrReverse.GetS(addr); ok && id == index {
+			return true
+		}
+	}
+	if len(a.tags) == 0 {
+		return false
+	}
+	tags, _ := p.GetMetadata(index, "tags")
+	for _, tag := range strings.Split(tags, ",") {
+		for _, t := range a.tags {
+			if strings.TrimSpace(tag) == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// owner returns index into master.assigned of the MobilityManager that
+// controls node at index, or -1 if it's left to master.mobilityManager. A node
+// selected by more than one assignment belongs to the first one (by name), so
+// that only one MobilityManager ever moves it.
+func (master *Master) owner(index int) int {
+	for i := range master.assigned {
+		if master.assigned[i].selects(master.positionManager, index) {
+			return i
+		}
+	}
+	return -1
+}
+
+// positionManagerView is a squirrel.PositionManager that only reports nodes
+// selected by selects as enabled, and refuses to move other nodes, so that a
+// MobilityManager initialized with it only controls those nodes. Positions
+// and velocities read through it are in configured units and relative to the
+// configured origin, like those that are set through it, so that a
+// MobilityManager can move a node from where it is without converting twice.
+type positionManagerView struct {
+	*PositionManager
+	selects func(index int) bool
+
+	// subscriber's channel -> channel registered with PositionManager, which
+	// for positions is the same one if positions are in meters
+	changed   map[chan<- []int]chan []int
+	diffs     map[chan<- squirrel.EnabledDiff]chan squirrel.EnabledDiff
+	positions map[chan<- squirrel.PositionUpdate]chan<- squirrel.PositionUpdate
+	// subscriber's channel -> closed when it's unregistered, so that the
+	// goroutine forwarding into it isn't left blocked on a send
+	unregistered map[interface{}]chan struct{}
+	mu           sync.Mutex // mutex for changed, diffs, positions and unregistered
+}
+
+func newPositionManagerView(p *PositionManager, selects func(index int) bool) *positionManagerView {
+	return &positionManagerView{
+		PositionManager: p,
+		selects:         selects,
+		changed:         make(map[chan<- []int]chan []int),
+		diffs:           make(map[chan<- squirrel.EnabledDiff]chan squirrel.EnabledDiff),
+		positions:       make(map[chan<- squirrel.PositionUpdate]chan<- squirrel.PositionUpdate),
+		unregistered:    make(map[interface{}]chan struct{}),
+	}
+}
+
+func (v *positionManagerView) filter(indices []int) []int {
+	ret := make([]int, 0, len(indices))
+	for _, index := range indices {
+		if v.selects(index) {
+			ret = append(ret, index)
+		}
+	}
+	return ret
+}
+
+func (v *positionManagerView) IsEnabled(index int) bool {
+	return v.PositionManager.IsEnabled(index) && v.selects(index)
+}
+
+func (v *positionManagerView) Enabled() []int {
+	return v.filter(v.PositionManager.Enabled())
+}
+
+func (v *positionManagerView) Get(index int) (pos squirrel.Position, err error) {
+	if pos, err = v.PositionManager.Get(index); err == nil {
+		pos = v.toSupplied(pos)
+	}
+	return
+}
+
+func (v *positionManagerView) GetAddr(hardAddr string) (pos squirrel.Position, err error) {
+	if pos, err = v.PositionManager.GetAddr(hardAddr); err == nil {
+		pos = v.toSupplied(pos)
+	}
+	return
+}
+
+func (v *positionManagerView) GetWithVersion(index int) (pos squirrel.Position, version uint64, err error) {
+	if pos, version, err = v.PositionManager.GetWithVersion(index); err == nil {
+		pos = v.toSupplied(pos)
+	}
+	return
+}
+
+func (v *positionManagerView) GetVelocity(index int) (vel squirrel.Velocity, err error) {
+	if vel, err = v.PositionManager.GetVelocity(index); err == nil {
+		vel = v.toSuppliedVelocity(vel)
+	}
+	return
+}
+
+func (v *positionManagerView) History(index int, since time.Time) (records []squirrel.PositionRecord, err error) {
+	if records, err = v.PositionManager.History(index, since); err == nil {
+		for i := range records {
+			records[i].Position = v.toSupplied(records[i].Position)
+		}
+	}
+	return
+}
+
+func (v *positionManagerView) GetAll() []squirrel.PositionUpdate {
+	all := v.PositionManager.GetAll()
+	ret := all[:0]
+	for _, u := range all {
+		if v.selects(u.Index) {
+			u.Position = v.toSupplied(u.Position)
+			ret = append(ret, u)
+		}
+	}
+	return ret
+}
+
+func (v *positionManagerView) RegisterPositionChanged(channel chan<- squirrel.PositionUpdate) {
+	if v.inMeters() {
+		v.mu.Lock()
+		v.positions[channel] = channel
+		v.mu.Unlock()
+		v.PositionManager.RegisterPositionChanged(channel)
+		return
+	}
+	in := make(chan squirrel.PositionUpdate, cap(channel))
+	v.mu.Lock()
+	v.positions[channel] = in
+	unregistered := v.forward(channel)
+	v.mu.Unlock()
+	go func() {
+		for u := range in {
+			u.Position = v.toSupplied(u.Position)
+			select {
+			case channel <- u:
+			case <-unregistered:
+				return
+			}
+		}
+	}()
+	v.PositionManager.RegisterPositionChanged(in)
+}
+
+func (v *positionManagerView) UnregisterPositionChanged(channel chan<- squirrel.PositionUpdate) {
+	v.mu.Lock()
+	in, ok := v.positions[channel]
+	delete(v.positions, channel)
+	v.unforward(channel)
+	v.mu.Unlock()
+	if ok {
+		v.unregisterPositionChanged(channel, in)
+	}
+}
+
+// unregisterPositionChanged unregisters in, and closes it if it's forwarded
+// into channel rather than being channel itself.
+func (v *positionManagerView) unregisterPositionChanged(channel, in chan<- squirrel.PositionUpdate) {
+	v.PositionManager.UnregisterPositionChanged(in)
+	if in != channel {
+		close(in)
+	}
+}
+
+func (v *positionManagerView) RegisterEnabledChanged(channel chan<- []int) {
+	in := make(chan []int, cap(channel))
+	v.mu.Lock()
+	v.changed[channel] = in
+	unregistered := v.forward(channel)
+	v.mu.Unlock()
+	go func() {
+		for enabled := range in {
+			select {
+			case channel <- v.filter(enabled):
+			case <-unregistered:
+				return
+			}
+		}
+	}()
+	v.PositionManager.RegisterEnabledChanged(in)
+}
+
+func (v *positionManagerView) UnregisterEnabledChanged(channel chan<- []int) {
+	v.mu.Lock()
+	in, ok := v.changed[channel]
+	delete(v.changed, channel)
+	v.unforward(channel)
+	v.mu.Unlock()
+	if ok {
+		v.PositionManager.UnregisterEnabledChanged(in)
+		close(in)
+	}
+}
+
+func (v *positionManagerView) RegisterEnabledDiff(channel chan<- squirrel.EnabledDiff) {
+	in := make(chan squirrel.EnabledDiff, cap(channel))
+	v.mu.Lock()
+	v.diffs[channel] = in
+	unregistered := v.forward(channel)
+	v.mu.Unlock()
+	go func() {
+		added := make(map[int]bool)
+		for diff := range in {
+			diff = v.filterDiff(diff, added)
+			if len(diff.Added) == 0 && len(diff.Removed) == 0 {
+				continue
+			}
+			select {
+			case channel <- diff:
+			case <-unregistered:
+				return
+			}
+		}
+	}()
+	v.PositionManager.RegisterEnabledDiff(in)
+}
+
+// filterDiff filters diff for a subscriber that has been notified of added
+// nodes. Removals are reported for nodes in added rather than selected ones,
+// since a node may not be selected anymore (e.g. its hardware address is
+// forgotten) by the time it's disabled.
+func (v *positionManagerView) filterDiff(diff squirrel.EnabledDiff, added map[int]bool) (ret squirrel.EnabledDiff) {
+	ret.Added = v.filter(diff.Added)
+	for _, index := range ret.Added {
+		added[index] = true
+	}
+	for _, index := range diff.Removed {
+		if added[index] {
+			delete(added, index)
+			ret.Removed = append(ret.Removed, index)
+		}
+	}
+	return
+}
+
+func (v *positionManagerView) UnregisterEnabledDiff(channel chan<- squirrel.EnabledDiff) {
+	v.mu.Lock()
+	in, ok := v.diffs[channel]
+	delete(v.diffs, channel)
+	v.unforward(channel)
+	v.mu.Unlock()
+	if ok {
+		v.PositionManager.UnregisterEnabledDiff(in)
+		close(in)
+	}
+}
+
+// forward returns a channel that's closed when channel is unregistered. It's
+// called with v.mu held.
+func (v *positionManagerView) forward(channel interface{}) <-chan struct{} {
+	unregistered := make(chan struct{})
+	v.unregistered[channel] = unregistered
+	return unregistered
+}
+
+// unforward closes the channel returned by forward for channel, if there's
+// one. It's called with v.mu held.
+func (v *positionManagerView) unforward(channel interface{}) {
+	if unregistered, ok := v.unregistered[channel]; ok {
+		close(unregistered)
+		delete(v.unregistered, channel)
+	}
+}
+
+// retire unregisters channels that are registered through v, so that a
+// MobilityManager that's switched away from stops getting notified.
+func (v *positionManagerView) retire() {
+	v.mu.Lock()
+	changed, diffs, positions := v.changed, v.diffs, v.positions
+	v.changed = make(map[chan<- []int]chan []int)
+	v.diffs = make(map[chan<- squirrel.EnabledDiff]chan squirrel.EnabledDiff)
+	v.positions = make(map[chan<- squirrel.PositionUpdate]chan<- squirrel.PositionUpdate)
+	for _, unregistered := range v.unregistered {
+		close(unregistered)
+	}
+	v.unregistered = make(map[interface{}]chan struct{})
+	v.mu.Unlock()
+	for _, in := range changed {
+		v.PositionManager.UnregisterEnabledChanged(in)
+		close(in)
+	}
+	for _, in := range diffs {
+		v.PositionManager.UnregisterEnabledDiff(in)
+		close(in)
+	}
+	for channel, in := range positions {
+		v.unregisterPositionChanged(channel, in)
+	}
+}
+
+func (v *positionManagerView) check(index int) error {
+	if !v.selects(index) {
+		return fmt.Errorf("node with index %d is not controlled by this MobilityManager", index)
+	}
+	return nil
+}
+
+func (v *positionManagerView) checkAddr(hardAddr string) error {
+	if index, ok := v.addrReverse.GetS(hardAddr); ok {
+		return v.check(index)
+	}
+	// left to PositionManager to report
+	return nil
+}
+
+func (v *positionManagerView) SetPosition(index int, pos *squirrel.Position) error {
+	if err := v.check(index); err != nil {
+		return err
+	}
+	return v.PositionManager.SetPosition(index, pos)
+}
+
+func (v *positionManagerView) Set(index int, x, y, height float64) error {
+	if err := v.check(index); err != nil {
+		return err
+	}
+	return v.PositionManager.Set(index, x, y, height)
+}
+
+func (v *positionManagerView) SetPositionAddr(hardAddr string, pos *squirrel.Position) error {
+	if err := v.checkAddr(hardAddr); err != nil {
+		return err
+	}
+	return v.PositionManager.SetPositionAddr(hardAddr, pos)
+}
+
+func (v *positionManagerView) SetAddr(hardAddr string, x, y, height float64) error {
+	if err := v.checkAddr(hardAddr); err != nil {
+		return err
+	}
+	return v.PositionManager.SetAddr(hardAddr, x, y, height)
+}
+
+func (v *positionManagerView) SetIfVersion(index int, version uint64, pos *squirrel.Position) error {
+	if err := v.check(index); err != nil {
+		return err
+	}
+	return v.PositionManager.SetIfVersion(index, version, pos)
+}
+
+func (v *positionManagerView) SetWithVelocity(index int, pos *squirrel.Position, vel *squirrel.Velocity) error {
+	if err := v.check(index); err != nil {
+		return err
+	}
+	return v.PositionManager.SetWithVelocity(index, pos, vel)
+}
+
+func (v *positionManagerView) SetOrientation(index int, o *squirrel.Orientation) error {
+	if err := v.check(index); err != nil {
+		return err
+	}
+	return v.PositionManager.SetOrientation(index, o)
+}
+
+func (v *positionManagerView) SetOrientationAddr(hardAddr string, o *squirrel.Orientation) error {
+	if err := v.checkAddr(hardAddr); err != nil {
+		return err
+	}
+	return v.PositionManager.SetOrientationAddr(hardAddr, o)
+}
+
+func (v *positionManagerView) Attach(index, parent int, offset *squirrel.Position) error {
+	if err := v.check(index); err != nil {
+		return err
+	}
+	return v.PositionManager.Attach(index, parent, offset)
+}
+
+func (v *positionManagerView) AttachAddr(hardAddr, parentAddr string, offset *squirrel.Position) error {
+	if err := v.checkAddr(hardAddr); err != nil {
+		return err
+	}
+	return v.PositionManager.AttachAddr(hardAddr, parentAddr, offset)
+}
+
+func (v *positionManagerView) Detach(index int) error {
+	if err := v.check(index); err != nil {
+		return err
+	}
+	return v.PositionManager.Detach(index)
+}
+
+// SetBatch applies updates for controlled nodes. Like for disabled nodes,
+// other updates are skipped and the first such error is returned.
+func (v *positionManagerView) SetBatch(updates []squirrel.PositionUpdate) (err error) {
+	allowed := make([]squirrel.PositionUpdate, 0, len(updates))
+	for _, u := range updates {
+		if e := v.check(u.Index); e != nil {
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		allowed = append(allowed, u)
+	}
+	if e := v.PositionManager.SetBatch(allowed); e != nil && err == nil {
+		err = e
+	}
+	return
+}
+
+// viewTx refuses moves of nodes that are not controlled by the view.
+type viewTx struct {
+	squirrel.PositionTx
+	v *positionManagerView
+}
+
+func (tx viewTx) Get(index int) (pos squirrel.Position, err error) {
+	if pos, err = tx.PositionTx.Get(index); err == nil {
+		pos = tx.v.toSupplied(pos)
+	}
+	return
+}
+
+func (tx viewTx) Set(index int, pos squirrel.Position) error {
+	if err := tx.v.check(index); err != nil {
+		return err
+	}
+	return tx.PositionTx.Set(index, pos)
+}
+
+func (v *positionManagerView) Transaction(fn func(tx squirrel.PositionTx) error) error {
+	return v.PositionManager.Transaction(func(tx squirrel.PositionTx) error {
+		return fn(viewTx{PositionTx: tx, v: v})
+	})
+}
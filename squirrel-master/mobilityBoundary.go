@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// boundaryPolicy defines what built-in Mobility Managers do with a node that
+// moves out of their mobilityArena. Unlike arenaPolicy of master, it shapes
+// the model itself, e.g. a node that bounces off a wall turns around.
+type boundaryPolicy int
+
+const (
+	boundaryDefault boundaryPolicy = iota // whatever the model does by default
+	boundaryBounce                        // the node is reflected back in and turns around
+	boundaryWrap                          // the node re-enters from the opposite side, as on a torus
+	boundaryClamp                         // the node is kept at the boundary
+	boundaryRespawn                       // the node is placed at a random point in the arena
+)
+
+func parseBoundaryPolicy(s string) (policy boundaryPolicy, err error) {
+	switch s {
+	case "":
+		policy = boundaryDefault
+	case "bounce":
+		policy = boundaryBounce
+	case "wrap":
+		policy = boundaryWrap
+	case "clamp":
+		policy = boundaryClamp
+	case "respawn":
+		policy = boundaryRespawn
+	default:
+		err = fmt.Errorf("unknown arena boundary %s (expected bounce, wrap, clamp or respawn)", s)
+	}
+	return
+}
+
+// mobilityArena is the space built-in Mobility Managers move nodes in, from
+// (0, 0) to (Width, Height) in X-Y plane, and between MinAltitude and
+// MaxAltitude in Height. It's planar if both altitudes are 0.
+type mobilityArena struct {
+	Width       float64 `etcd:"width,required"`
+	Height      float64 `etcd:"height,required"`
+	MinAltitude float64 `etcd:"min_altitude" default:"0"`
+	MaxAltitude float64 `etcd:"max_altitude" default:"0"`
+	Boundary    string  `etcd:"boundary"`
+
+	boundary boundaryPolicy // parsed Boundary; set by check
+}
+
+// arenaAltitudeHelp documents altitudes of mobilityArena, for
+// ParametersHelp.
+const arenaAltitudeHelp = `
+  arena/min_altitude, arena/max_altitude [Optional]:
+    Range of Height that nodes move in, e.g. 20 and 120 for drones.
+    Default: 0 and 0 (nodes stay on the ground)
+`
+
+// arenaBoundaryHelp documents boundary of mobilityArena, for ParametersHelp
+// of models that move nodes out of the arena. It's followed by the model's
+// default.
+const arenaBoundaryHelp = `
+  arena/boundary [Optional]:
+    What happens to a node that moves out of the arena: bounce (reflected
+    back in, turning around), wrap (re-enters from the opposite side, as on
+    a torus), clamp (stays at the boundary) or respawn (placed at a random
+    point in the arena).`
+
+func (a *mobilityArena) check() error {
+	if a.Width <= 0 || a.Height <= 0 {
+		return fmt.Errorf("arena needs positive width and height (got %v and %v)", a.Width, a.Height)
+	}
+	if a.MaxAltitude < a.MinAltitude {
+		return fmt.Errorf("arena needs min_altitude <= max_altitude (got %v and %v)", a.MinAltitude, a.MaxAltitude)
+	}
+	var err error
+	a.boundary, err = parseBoundaryPolicy(a.Boundary)
+	return err
+}
+
+func (a *mobilityArena) random(r *rand.Rand) squirrel.Position {
+	return squirrel.Position{
+		X:      r.Float64() * a.Width,
+		Y:      r.Float64() * a.Height,
+		Height: a.MinAltitude + r.Float64()*(a.MaxAltitude-a.MinAltitude),
+	}
+}
+
+// is3D returns whether nodes move in Height as well.
+func (a *mobilityArena) is3D() bool {
+	return a.MaxAltitude > a.MinAltitude
+}
+
+// bounceAltitude is bounce for Height, between MinAltitude and MaxAltitude.
+func (a *mobilityArena) bounceAltitude(h *float64) bool {
+	*h -= a.MinAltitude
+	bounced := bounce(h, a.MaxAltitude-a.MinAltitude)
+	*h += a.MinAltitude
+	return bounced
+}
+
+func (a *mobilityArena) clampAltitude(h float64) float64 {
+	return clamp(h, a.MinAltitude, a.MaxAltitude)
+}
+
+func (a *mobilityArena) contains(pos squirrel.Position) bool {
+	return pos.X >= 0 && pos.X <= a.Width && pos.Y >= 0 && pos.Y <= a.Height &&
+		pos.Height >= a.MinAltitude && pos.Height <= a.MaxAltitude
+}
+
+// keepIn brings pos, which may have moved out of the arena, back in by the
+// configured boundary, or by def if none is. It returns along which axes pos
+// is bounced, so that the caller can turn the node around, and whether it's
+// respawned.
+func (a *mobilityArena) keepIn(pos *squirrel.Position, def boundaryPolicy, r *rand.Rand) (bouncedX, bouncedY, bouncedHeight, respawned bool) {
+	if !a.is3D() {
+		pos.Height = a.MinAltitude
+	}
+	if a.contains(*pos) {
+		return
+	}
+	policy := a.boundary
+	if policy == boundaryDefault {
+		policy = def
+	}
+	switch policy {
+	case boundaryBounce:
+		bouncedX = bounce(&pos.X, a.Width)
+		bouncedY = bounce(&pos.Y, a.Height)
+		bouncedHeight = a.is3D() && a.bounceAltitude(&pos.Height)
+	case boundaryWrap:
+		pos.X = wrap(pos.X, 0, a.Width)
+		pos.Y = wrap(pos.Y, 0, a.Height)
+		pos.Height = wrap(pos.Height, a.MinAltitude, a.MaxAltitude)
+	case boundaryClamp:
+		pos.X = clamp(pos.X, 0, a.Width)
+		pos.Y = clamp(pos.Y, 0, a.Height)
+		pos.Height = a.clampAltitude(pos.Height)
+	case boundaryRespawn:
+		*pos = a.random(r)
+		respawned = true
+	}
+	return
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// clockTicker is a subscription to mobilityClock.
+type clockTicker struct {
+	clock    *mobilityClock
+	fn       func(now time.Time)
+	interval time.Duration
+	next     time.Time // mobility time of next call
+	stopped  bool
+}
+
+func (t *clockTicker) Reset(interval time.Duration) {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.next = t.next.Add(interval - t.interval)
+	t.interval = interval
+	t.clock.wakeLocked()
+}
+
+func (t *clockTicker) Stop() {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	t.stopped = true
+}
+
+// mobilityClock is master's squirrel.Clock, i.e. time as seen by Mobility
+// Managers. It stands still while mobility is paused, so that nodes neither
+// move during a pause nor jump ahead when it's resumed, and runs scale times
+// as fast as wall time otherwise, so that traces can be replayed faster or
+// slower. While paused, it can be stepped forward, calling tickers exactly as
+// if the time had passed.
+type mobilityClock struct {
+	mu     sync.Mutex
+	paused bool
+	scale  float64
+
+	// mobility time is anchor at wall time anchoredAt, and advances scale
+	// times as fast since then unless paused
+	anchor     time.Time
+	anchoredAt time.Time
+
+	tickers []*clockTicker
+	wake    chan struct{} // nudges run to recompute when next call is due
+
+	firing sync.Mutex // held while calling tickers, so that run and step don't interleave
+}
+
+var clock = newMobilityClock()
+
+func newMobilityClock() *mobilityClock {
+	now := time.Now()
+	c := &mobilityClock{scale: 1, anchor: now, anchoredAt: now, wake: make(chan struct{}, 1)}
+	go c.run()
+	return c
+}
+
+func (c *mobilityClock) nowLocked() time.Time {
+	if c.paused {
+		return c.anchor
+	}
+	return c.anchor.Add(time.Duration(float64(time.Since(c.anchoredAt)) * c.scale))
+}
+
+// reanchorLocked makes mobility time from now on count from where it is.
+func (c *mobilityClock) reanchorLocked() {
+	c.anchor = c.nowLocked()
+	c.anchoredAt = time.Now()
+}
+
+func (c *mobilityClock) wakeLocked() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Now returns current mobility time.
+func (c *mobilityClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nowLocked()
+}
+
+func (c *mobilityClock) Every(interval time.Duration, fn func(now time.Time)) squirrel.Ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &clockTicker{clock: c, fn: fn, interval: interval, next: c.nowLocked().Add(interval)}
+	c.tickers = append(c.tickers, t)
+	c.wakeLocked()
+	return t
+}
+
+// dueLocked returns the ticker that is due first, dropping stopped ones, or
+// nil if there's none.
+func (c *mobilityClock) dueLocked() (due *clockTicker) {
+	alive := c.tickers[:0]
+	for _, t := range c.tickers {
+		if t.stopped {
+			continue
+		}
+		alive = append(alive, t)
+		if due == nil || t.next.Before(due.next) {
+			due = t
+		}
+	}
+	for i := len(alive); i < len(c.tickers); i++ {
+		c.tickers[i] = nil
+	}
+	c.tickers = alive
+	return
+}
+
+// fire calls tickers that are due by until, in order of time. If stepping,
+// mobility time is moved to each call as it's made. Otherwise, tickers that
+// fall behind skip missed calls rather than catching up.
+func (c *mobilityClock) fire(until time.Time, stepping bool) {
+	for {
+		c.mu.Lock()
+		t := c.dueLocked()
+		if t == nil || t.next.After(until) {
+			c.mu.Unlock()
+			return
+		}
+		now := t.next
+		if stepping {
+			// a ticker may have been due right when mobility is paused
+			if now.After(c.anchor) {
+				c.anchor = now
+			} else {
+				now = c.anchor
+			}
+		} else {
+			now = c.nowLocked()
+		}
+		t.next = t.next.Add(t.interval)
+		if !stepping && !t.next.After(now) {
+			t.next = now.Add(t.interval)
+		}
+		fn := t.fn
+		c.mu.Unlock()
+		fn(now)
+	}
+}
+
+// run calls tickers as mobility time passes.
+func (c *mobilityClock) run() {
+	timer := time.NewTimer(time.Hour)
+	for {
+		c.firing.Lock()
+		c.mu.Lock()
+		paused := c.paused
+		now := c.nowLocked()
+		c.mu.Unlock()
+		if !paused {
+			c.fire(now, false)
+		}
+		c.firing.Unlock()
+
+		c.mu.Lock()
+		wait := time.Duration(-1)
+		if t := c.dueLocked(); t != nil && !c.paused {
+			if wait = time.Duration(float64(t.next.Sub(c.nowLocked())) / c.scale); wait < 0 {
+				wait = 0
+			}
+		}
+		c.mu.Unlock()
+
+		if wait >= 0 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(wait)
+			select {
+			case <-timer.C:
+			case <-c.wake:
+			}
+		} else {
+			<-c.wake
+		}
+	}
+}
+
+// step moves paused mobility time forward by d, calling tickers that are due
+// on the way. It returns after all of them return.
+func (c *mobilityClock) step(d time.Duration) error {
+	if d <= 0 {
+		return errors.New("step needs to be positive")
+	}
+	c.firing.Lock()
+	defer c.firing.Unlock()
+	c.mu.Lock()
+	if !c.paused {
+		c.mu.Unlock()
+		return errors.New("mobility needs to be paused to be stepped")
+	}
+	until := c.anchor.Add(d)
+	c.mu.Unlock()
+	c.fire(until, true)
+	c.mu.Lock()
+	c.anchor = until
+	c.mu.Unlock()
+	return nil
+}
+
+// pause freezes mobility time. It returns false if it's already paused.
+func (c *mobilityClock) pause() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		return false
+	}
+	c.reanchorLocked()
+	c.paused = true
+	c.wakeLocked()
+	return true
+}
+
+// resume lets mobility time go on from where it's paused. It returns false if
+// it's not paused.
+func (c *mobilityClock) resume() bool {
+	// not in the middle of a step
+	c.firing.Lock()
+	defer c.firing.Unlock()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.paused {
+		return false
+	}
+	c.paused = false
+	c.anchoredAt = time.Now()
+	c.wakeLocked()
+	return true
+}
+
+func (c *mobilityClock) isPaused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// setScale changes how fast mobility time runs from now on. scale needs to be
+// positive.
+func (c *mobilityClock) setScale(scale float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reanchorLocked()
+	c.scale = scale
+	c.wakeLocked()
+}
+
+func (c *mobilityClock) getScale() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.scale
+}
+
+func pauseMobility() {
+	if clock.pause() {
+		logger.infof("mobility is paused")
+	}
+}
+
+func resumeMobility() {
+	if clock.resume() {
+		logger.infof("mobility is resumed")
+	}
+}
+
+func stepMobility(d time.Duration) error {
+	if err := clock.step(d); err != nil {
+		return err
+	}
+	logger.infof("mobility is stepped by %v", d)
+	return nil
+}
+
+func scaleMobility(scale float64) {
+	if clock.getScale() != scale {
+		clock.setScale(scale)
+		logger.infof("mobility time scale is changed to %v", scale)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// eventBus is master's squirrel.EventBus. Subscribers that don't keep up miss
+// events rather than holding up Mobility Managers.
+type eventBus struct {
+	subscribers map[chan<- squirrel.MobilityEvent]bool
+	mu          sync.RWMutex // subscribers
+	dropped     uint64       // accessed atomically
+}
+
+var mobilityEvents = &eventBus{subscribers: make(map[chan<- squirrel.MobilityEvent]bool)}
+
+// eventNode describes the node that e is about, for logs.
+func eventNode(e *squirrel.MobilityEvent) string {
+	if e.Addr != "" {
+		return e.Addr
+	}
+	return "index " + strconv.Itoa(e.Index)
+}
+
+func (b *eventBus) Publish(e squirrel.MobilityEvent) {
+	if e.Time.IsZero() {
+		e.Time = clock.Now()
+	}
+	if logger.enabled(logDebug) {
+		logger.debugf("mobility event from %s: %s of node %s", e.Source, e.Kind, eventNode(&e))
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for c := range b.subscribers {
+		select {
+		case c <- e:
+		default:
+			// not each one, as a subscriber that falls behind tends to stay behind
+			if d := atomic.AddUint64(&b.dropped, 1); d == 1 || d%1000 == 0 {
+				logger.warnf("mobility events are dropped for slow subscribers (%d so far)", d)
+			}
+		}
+	}
+}
+
+// subscribe registers c to receive events published from now on, until it's
+// unsubscribed.
+func (b *eventBus) subscribe(c chan<- squirrel.MobilityEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[c] = true
+}
+
+func (b *eventBus) unsubscribe(c chan<- squirrel.MobilityEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, c)
+}
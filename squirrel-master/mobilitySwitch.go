@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// initializePrimary initializes master.mobilityManager with a view of nodes
+// that are not assigned to any other MobilityManager, for as long as it's not
+// switched away from. Callers other than NewMaster need to hold muMobility.
+func (master *Master) initializePrimary() {
+	generation := atomic.LoadUint32(&master.mobilityGeneration)
+	master.mobilityView = newPositionManagerView(master.positionManager, func(index int) bool {
+		return atomic.LoadUint32(&master.mobilityGeneration) == generation && master.owner(index) < 0
+	})
+	initializeMobilityManager(master.mobilityManager, master.mobilityView)
+}
+
+// primary returns the running MobilityManager that isn't assigned specific
+// nodes, along with its name.
+func (master *Master) primary() (squirrel.MobilityManager, string) {
+	master.muMobility.Lock()
+	defer master.muMobility.Unlock()
+	return master.mobilityManager, master.mobilityManagerName
+}
+
+// reconfigurePrimary reconfigures the MobilityManager that primary returns
+// with parameters, which are kept for GET /config if it succeeds.
+func (master *Master) reconfigurePrimary(parameters *etcd.Node) error {
+	master.muMobility.Lock()
+	defer master.muMobility.Unlock()
+	if err := reconfigure(master.mobilityManager, "MobilityManager "+master.mobilityManagerName, parameters); err != nil {
+		return err
+	}
+	master.mobilityManagerConf = parameters
+	return nil
+}
+
+// mobilityManagerParameters returns the name of the MobilityManager that
+// primary returns, and values of parameters it runs with, by their keys
+// relative to its configuration node.
+func (master *Master) mobilityManagerParameters() (string, map[string]string) {
+	master.muMobility.Lock()
+	defer master.muMobility.Unlock()
+	return master.mobilityManagerName, etcdValues(master.mobilityManagerConf)
+}
+
+// switchMobilityManager replaces the running MobilityManager with a new one
+// named name, configured with parameters. Nodes stay where they are until the
+// new one moves them; connections of clients are not affected. If the new
+// one can't be created, the running one is kept.
+func (master *Master) switchMobilityManager(name string, parameters *etcd.Node) error {
+	m, err := newMobilityManager(name)
+	if err != nil {
+		return fmt.Errorf("MobilityManager %s: %v", name, err)
+	}
+	if err = m.Configure(parameters); err != nil {
+		return fmt.Errorf("configuring MobilityManager %s failed: %v", name, err)
+	}
+
+	master.muMobility.Lock()
+	defer master.muMobility.Unlock()
+	// the old one can't move nodes from here on, even if it keeps running
+	atomic.AddUint32(&master.mobilityGeneration, 1)
+	master.mobilityView.retire()
+	if s, ok := master.mobilityManager.(squirrel.Stopper); ok {
+		s.Stop()
+	}
+	old := master.mobilityManagerName
+	master.mobilityManager, master.mobilityManagerName, master.mobilityManagerConf = m, name, parameters
+	master.initializePrimary()
+	logger.infof("MobilityManager is switched from %s to %s", old, name)
+	return nil
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// notifyPolicy defines what happens to a notification when a subscriber
+// hasn't received previous ones yet.
+type notifyPolicy int
+
+const (
+	// a pending notification is replaced by (merged with) the new one
+	notifyCoalesce notifyPolicy = iota
+	// the new notification is dropped if subscriber's channel is full
+	notifyDrop
+)
+
+func parseNotifyPolicy(s string) (policy notifyPolicy, err error) {
+	switch s {
+	case "coalesce":
+		policy = notifyCoalesce
+	case "drop":
+		policy = notifyDrop
+	default:
+		err = fmt.Errorf("unknown notification policy %s (expected coalesce or drop)", s)
+	}
+	return
+}
+
+// notifyStats counts notifications that are not delivered as is.
+type notifyStats struct {
+	coalesced uint64 // accessed atomically
+	dropped   uint64 // accessed atomically
+}
+
+func (s *notifyStats) coalesce() {
+	c := atomic.AddUint64(&s.coalesced, 1)
+	logger.debugf("notification coalesced (%d in total)", c)
+}
+
+func (s *notifyStats) drop() {
+	c := atomic.AddUint64(&s.dropped, 1)
+	logger.debugf("notification dropped (%d in total)", c)
+}
+
+// enabledNotifier delivers slices of enabled nodes to a subscriber without
+// blocking the notifying goroutine. With notifyCoalesce, a dedicated goroutine
+// does the (possibly blocking) sends, and only the latest undelivered slice is
+// kept.
+type enabledNotifier struct {
+	channel chan<- []int
+	policy  notifyPolicy
+	stats   *notifyStats
+
+	pending    []int
+	hasPending bool
+	mu         sync.Mutex
+	wake       chan struct{}
+	done       chan struct{} // closed by stop
+	closed     int32         // set atomically when stopped or subscriber's channel is found closed
+}
+
+func newEnabledNotifier(channel chan<- []int, policy notifyPolicy, stats *notifyStats) *enabledNotifier {
+	n := &enabledNotifier{channel: channel, policy: policy, stats: stats, wake: make(chan struct{}, 1), done: make(chan struct{})}
+	if policy == notifyCoalesce {
+		go n.run()
+	}
+	return n
+}
+
+// send sends enabled into subscriber's channel. It returns false if the
+// channel is closed.
+func (n *enabledNotifier) send(enabled []int, block bool) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			atomic.StoreInt32(&n.closed, 1)
+			ok = false
+		}
+	}()
+	if block {
+		select {
+		case n.channel <- enabled:
+			return true
+		case <-n.done:
+			return false
+		}
+	}
+	select {
+	case n.channel <- enabled:
+	default:
+		n.stats.drop()
+	}
+	return true
+}
+
+func (n *enabledNotifier) notify(enabled []int) {
+	if atomic.LoadInt32(&n.closed) != 0 {
+		return
+	}
+	if n.policy == notifyDrop {
+		n.send(enabled, false)
+		return
+	}
+	n.mu.Lock()
+	if n.hasPending {
+		n.stats.coalesce()
+	}
+	n.pending, n.hasPending = enabled, true
+	n.mu.Unlock()
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (n *enabledNotifier) run() {
+	for range n.wake {
+		n.mu.Lock()
+		enabled, ok := n.pending, n.hasPending
+		n.pending, n.hasPending = nil, false
+		n.mu.Unlock()
+		if ok && !n.send(enabled, true) {
+			return
+		}
+	}
+}
+
+// stop stops delivering notifications. It should not be called concurrently
+// with notify.
+func (n *enabledNotifier) stop() {
+	atomic.StoreInt32(&n.closed, 1)
+	close(n.done)
+	if n.policy == notifyCoalesce {
+		close(n.wake)
+	}
+}
+
+// enabledDiffNotifier is like enabledNotifier, but undelivered diffs are
+// merged rather than replaced.
+type enabledDiffNotifier struct {
+	channel chan<- squirrel.EnabledDiff
+	policy  notifyPolicy
+	stats   *notifyStats
+
+	added      map[int]struct{}
+	removed    map[int]struct{}
+	hasPending bool
+	mu         sync.Mutex
+	wake       chan struct{}
+	done       chan struct{} // closed by stop
+	closed     int32         // set atomically when stopped or subscriber's channel is found closed
+}
+
+func newEnabledDiffNotifier(channel chan<- squirrel.EnabledDiff, policy notifyPolicy, stats *notifyStats) *enabledDiffNotifier {
+	n := &enabledDiffNotifier{
+		channel: channel,
+		policy:  policy,
+		stats:   stats,
+		added:   make(map[int]struct{}),
+		removed: make(map[int]struct{}),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	if policy == notifyCoalesce {
+		go n.run()
+	}
+	return n
+}
+
+// send sends diff into subscriber's channel. It returns false if the channel
+// is closed.
+func (n *enabledDiffNotifier) send(diff squirrel.EnabledDiff, block bool) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			atomic.StoreInt32(&n.closed, 1)
+			ok = false
+		}
+	}()
+	if block {
+		select {
+		case n.channel <- diff:
+			return true
+		case <-n.done:
+			return false
+		}
+	}
+	select {
+	case n.channel <- diff:
+	default:
+		n.stats.drop()
+	}
+	return true
+}
+
+func (n *enabledDiffNotifier) notify(diff squirrel.EnabledDiff) {
+	if atomic.LoadInt32(&n.closed) != 0 {
+		return
+	}
+	if n.policy == notifyDrop {
+		n.send(diff, false)
+		return
+	}
+	n.mu.Lock()
+	if n.hasPending {
+		n.stats.coalesce()
+	}
+	for _, i := range diff.Added {
+		if _, ok := n.removed[i]; ok {
+			delete(n.removed, i)
+		} else {
+			n.added[i] = struct{}{}
+		}
+	}
+	for _, i := range diff.Removed {
+		if _, ok := n.added[i]; ok {
+			delete(n.added, i)
+		} else {
+			n.removed[i] = struct{}{}
+		}
+	}
+	n.hasPending = true
+	n.mu.Unlock()
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+func sortedKeys(m map[int]struct{}) []int {
+	ret := make([]int, 0, len(m))
+	for i := range m {
+		ret = append(ret, i)
+	}
+	sort.Ints(ret)
+	return ret
+}
+
+func (n *enabledDiffNotifier) run() {
+	for range n.wake {
+		n.mu.Lock()
+		ok := n.hasPending
+		diff := squirrel.EnabledDiff{Added: sortedKeys(n.added), Removed: sortedKeys(n.removed)}
+		n.added, n.removed, n.hasPending = make(map[int]struct{}), make(map[int]struct{}), false
+		n.mu.Unlock()
+		// a node enabled then disabled before delivery results in an empty diff
+		if ok && (len(diff.Added) > 0 || len(diff.Removed) > 0) && !n.send(diff, true) {
+			return
+		}
+	}
+}
+
+// stop stops delivering notifications. It should not be called concurrently
+// with notify.
+func (n *enabledDiffNotifier) stop() {
+	atomic.StoreInt32(&n.closed, 1)
+	close(n.done)
+	if n.policy == notifyCoalesce {
+		close(n.wake)
+	}
+}
+
+// positionNotifier is like enabledNotifier, but for moves of nodes; with
+// notifyCoalesce, only the latest undelivered position of each node is kept.
+type positionNotifier struct {
+	channel chan<- squirrel.PositionUpdate
+	policy  notifyPolicy
+	stats   *notifyStats
+
+	pending map[int]squirrel.Position
+	order   []int // indices in pending, in the order they were first notified
+	mu      sync.Mutex
+	wake    chan struct{}
+	done    chan struct{} // closed by stop
+	closed  int32         // set atomically when stopped or subscriber's channel is found closed
+}
+
+func newPositionNotifier(channel chan<- squirrel.PositionUpdate, policy notifyPolicy, stats *notifyStats) *positionNotifier {
+	n := &positionNotifier{channel: channel, policy: policy, stats: stats, pending: make(map[int]squirrel.Position), wake: make(chan struct{}, 1), done: make(chan struct{})}
+	if policy == notifyCoalesce {
+		go n.run()
+	}
+	return n
+}
+
+// send sends u into subscriber's channel. It returns false if the channel is
+// closed.
+func (n *positionNotifier) send(u squirrel.PositionUpdate, block bool) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			atomic.StoreInt32(&n.closed, 1)
+			ok = false
+		}
+	}()
+	if block {
+		select {
+		case n.channel <- u:
+			return true
+		case <-n.done:
+			return false
+		}
+	}
+	select {
+	case n.channel <- u:
+	default:
+		n.stats.drop()
+	}
+	return true
+}
+
+func (n *positionNotifier) notify(updates []squirrel.PositionUpdate) {
+	if atomic.LoadInt32(&n.closed) != 0 {
+		return
+	}
+	if n.policy == notifyDrop {
+		for _, u := range updates {
+			n.send(u, false)
+		}
+		return
+	}
+	n.mu.Lock()
+	for _, u := range updates {
+		if _, ok := n.pending[u.Index]; ok {
+			n.stats.coalesce()
+		} else {
+			n.order = append(n.order, u.Index)
+		}
+		n.pending[u.Index] = u.Position
+	}
+	n.mu.Unlock()
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (n *positionNotifier) run() {
+	for range n.wake {
+		n.mu.Lock()
+		pending, order := n.pending, n.order
+		n.pending, n.order = make(map[int]squirrel.Position), nil
+		n.mu.Unlock()
+		for _, index := range order {
+			if !n.send(squirrel.PositionUpdate{Index: index, Position: pending[index]}, true) {
+				return
+			}
+		}
+	}
+}
+
+// stop stops delivering notifications. It should not be called concurrently
+// with notify.
+func (n *positionNotifier) stop() {
+	atomic.StoreInt32(&n.closed, 1)
+	close(n.done)
+	if n.policy == notifyCoalesce {
+		close(n.wake)
+	}
+}
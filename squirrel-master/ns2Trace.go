@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/common"
+)
+
+var (
+	ns2SetPattern     = regexp.MustCompile(`^\$node_\((\d+)\)\s+set\s+([XYZ])_\s+(\S+)`)
+	ns2SetdestPattern = regexp.MustCompile(`^\$ns_\s+at\s+(\S+)\s+"\$node_\((\d+)\)\s+setdest\s+(\S+)\s+(\S+)\s+(\S+)\s*"`)
+)
+
+// ns2Setdest is a "$ns_ at <time> "$node_(<id>) setdest <x> <y> <speed>""
+// line of an ns-2 movement file.
+type ns2Setdest struct {
+	at    time.Duration
+	node  int
+	dest  squirrel.Position
+	speed float64
+}
+
+// ns2Trace is a parsed ns-2 movement file.
+type ns2Trace struct {
+	initial map[int]squirrel.Position // by ns-2 node id
+	events  []ns2Setdest              // ordered by at
+}
+
+// parseNs2Trace parses movement files as generated by ns-2 setdest. Lines
+// other than initial positions and setdest commands, e.g. "$god_ set-dist",
+// are ignored.
+func parseNs2Trace(filename string) (trace *ns2Trace, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	trace = &ns2Trace{initial: make(map[int]squirrel.Position)}
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if m := ns2SetPattern.FindStringSubmatch(line); m != nil {
+			var id int
+			var v float64
+			if id, err = strconv.Atoi(m[1]); err == nil {
+				v, err = strconv.ParseFloat(m[3], 64)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", filename, lineNo, err)
+			}
+			pos := trace.initial[id]
+			switch m[2] {
+			case "X":
+				pos.X = v
+			case "Y":
+				pos.Y = v
+			case "Z":
+				pos.Height = v
+			}
+			trace.initial[id] = pos
+		} else if m := ns2SetdestPattern.FindStringSubmatch(line); m != nil {
+			var e ns2Setdest
+			var v [4]float64 // at, x, y, speed
+			for i, s := range []string{m[1], m[3], m[4], m[5]} {
+				if v[i], err = strconv.ParseFloat(s, 64); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				e.node, err = strconv.Atoi(m[2])
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", filename, lineNo, err)
+			}
+			e.at = time.Duration(v[0] * float64(time.Second))
+			e.dest = squirrel.Position{X: v[1], Y: v[2]}
+			e.speed = v[3]
+			trace.events = append(trace.events, e)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(trace.events, func(i, j int) bool { return trace.events[i].at < trace.events[j].at })
+	return
+}
+
+type ns2TraceParameters struct {
+	Path     string        `etcd:"path,required"`
+	Nodes    string        `etcd:"nodes"`
+	Interval time.Duration `etcd:"interval" default:"100ms"`
+}
+
+// ns2TraceReplay moves nodes as described in an ns-2 movement file, with time
+// 0 of the file being when the Mobility Manager is initialized.
+type ns2TraceReplay struct {
+	nodes    traceNodes
+	trace    *ns2Trace
+	interval time.Duration
+}
+
+func newNs2TraceReplay() squirrel.MobilityManager {
+	return &ns2TraceReplay{}
+}
+
+func (m *ns2TraceReplay) ParametersHelp() string {
+	return `
+  path [Required]:
+    Path to an ns-2 movement file, e.g. as generated by setdest.
+` + traceNodesHelp + `
+  interval [Optional]:
+    How often positions are updated. Default: 100ms
+    `
+}
+
+func (m *ns2TraceReplay) Configure(conf *etcd.Node) (err error) {
+	var params ns2TraceParameters
+	if err = common.DecodeParameters(conf, &params); err != nil {
+		return
+	}
+	if params.Interval <= 0 {
+		return fmt.Errorf("interval needs to be positive (got %v)", params.Interval)
+	}
+	m.interval = params.Interval
+	if m.nodes.addrs, err = parseTraceNodes(params.Nodes); err != nil {
+		return
+	}
+	m.trace, err = parseNs2Trace(params.Path)
+	return
+}
+
+func (m *ns2TraceReplay) Initialize(positionManager squirrel.PositionManager) {
+	m.nodes.positionManager = positionManager
+	m.run(clock.Now())
+}
+
+// Stop stops replaying.
+func (m *ns2TraceReplay) Stop() {
+	m.nodes.stop()
+}
+
+func (m *ns2TraceReplay) run(start time.Time) {
+	legs := make(map[int]*linearLeg)
+	for id, pos := range m.trace.initial {
+		legs[id] = &linearLeg{from: pos, dest: pos}
+	}
+	var end time.Duration
+	if n := len(m.trace.events); n > 0 {
+		end = m.trace.events[n-1].at
+	}
+	next := 0
+	m.nodes.replay("ns2-trace", start, m.interval, end, func(t time.Duration) map[int]squirrel.Position {
+		for ; next < len(m.trace.events) && m.trace.events[next].at <= t; next++ {
+			e := m.trace.events[next]
+			leg, ok := legs[e.node]
+			if !ok {
+				leg = &linearLeg{}
+			}
+			from := leg.at(e.at)
+			// setdest is in X-Y plane
+			dest := squirrel.Position{X: e.dest.X, Y: e.dest.Y, Height: from.Height}
+			legs[e.node] = &linearLeg{from: from, start: e.at, dest: dest, speed: e.speed}
+		}
+		positions := make(map[int]squirrel.Position, len(legs))
+		for id, leg := range legs {
+			positions[id] = leg.at(t)
+		}
+		return positions
+	})
+}
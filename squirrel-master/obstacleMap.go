@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+type point2 struct {
+	x, y float64
+}
+
+// obstacle is a polygon in X-Y plane, e.g. footprint of a building. If height
+// is positive, the obstacle only blocks paths that go below height; otherwise
+// it's infinitely high.
+type obstacle struct {
+	name    string
+	polygon []point2
+	height  float64
+	loss    float64 // dB that radio propagation Septembers add to paths it blocks; 0 for theirs
+	file    string  // that it's loaded from; empty if it's configured in etcd
+}
+
+// parsePolygon parses a polygon in the form of "x1,y1 x2,y2 x3,y3 ...".
+func parsePolygon(s string) (polygon []point2, err error) {
+	for _, field := range strings.Fields(s) {
+		xy := strings.Split(field, ",")
+		if len(xy) != 2 {
+			err = fmt.Errorf("invalid point %q in polygon (expected x,y)", field)
+			return
+		}
+		var pt point2
+		if pt.x, err = strconv.ParseFloat(xy[0], 64); err != nil {
+			return
+		}
+		if pt.y, err = strconv.ParseFloat(xy[1], 64); err != nil {
+			return
+		}
+		polygon = append(polygon, pt)
+	}
+	if len(polygon) < 2 {
+		err = fmt.Errorf("polygon needs at least 2 points (got %d)", len(polygon))
+	}
+	return
+}
+
+// segmentIntersection returns whether segment p1-p2 intersects segment q1-q2,
+// and if so, the fraction along p1-p2 where they intersect.
+func segmentIntersection(p1, p2, q1, q2 point2) (t float64, ok bool) {
+	rx, ry := p2.x-p1.x, p2.y-p1.y
+	sx, sy := q2.x-q1.x, q2.y-q1.y
+	denom := rx*sy - ry*sx
+	if denom == 0 { // parallel or collinear; grazing a wall doesn't block
+		return
+	}
+	qpx, qpy := q1.x-p1.x, q1.y-p1.y
+	t = (qpx*sy - qpy*sx) / denom
+	u := (qpx*ry - qpy*rx) / denom
+	ok = t >= 0 && t <= 1 && u >= 0 && u <= 1
+	return
+}
+
+// blocks returns whether the obstacle blocks the straight path between a and
+// b.
+func (o *obstacle) blocks(a, b squirrel.Position) bool {
+	p1, p2 := point2{a.X, a.Y}, point2{b.X, b.Y}
+	for i := range o.polygon {
+		j := (i + 1) % len(o.polygon)
+		if len(o.polygon) == 2 && j == 0 { // a single wall, not a closed polygon
+			break
+		}
+		t, ok := segmentIntersection(p1, p2, o.polygon[i], o.polygon[j])
+		if !ok {
+			continue
+		}
+		if o.height <= 0 || a.Height+t*(b.Height-a.Height) < o.height {
+			return true
+		}
+	}
+	return false
+}
+
+// obstacleMap is a set of obstacles, and terrain, used for line-of-sight
+// checks and attenuation of radio propagation.
+type obstacleMap struct {
+	obstacles []obstacle
+	terrain   *terrain // nil if there's none
+}
+
+// lineOfSight returns whether neither an obstacle nor terrain blocks the path
+// between a and b.
+func (m *obstacleMap) lineOfSight(a, b squirrel.Position) bool {
+	a, b = m.terrain.absolute(a), m.terrain.absolute(b)
+	for i := range m.obstacles {
+		if m.obstacles[i].blocks(a, b) {
+			return false
+		}
+	}
+	if m.terrain != nil {
+		if h, _ := m.terrain.obstruction(a, b); h > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// attenuation returns loss in dB of obstacles that block the path between a
+// and b, each adding its loss, or fallback if it has none.
+func (m *obstacleMap) attenuation(a, b squirrel.Position, fallback float64) (loss float64) {
+	a, b = m.terrain.absolute(a), m.terrain.absolute(b)
+	for i := range m.obstacles {
+		if o := &m.obstacles[i]; o.blocks(a, b) {
+			if o.loss > 0 {
+				loss += o.loss
+			} else {
+				loss += fallback
+			}
+		}
+	}
+	return
+}
+
+// geoJSON is the part of a GeoJSON FeatureCollection that obstacles are read
+// from.
+type geoJSON struct {
+	Features []struct {
+		Geometry struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+		Properties struct {
+			Name   string  `json:"name"`
+			Height float64 `json:"height"`
+			Loss   float64 `json:"loss"`
+		} `json:"properties"`
+	} `json:"features"`
+}
+
+// loadObstacles reads obstacles from a GeoJSON file: outer rings of Polygon
+// and MultiPolygon features become polygons, and each segment of LineString
+// and MultiLineString ones a wall. Properties "name", "height" and "loss"
+// are those of obstacles.
+func loadObstacles(file string) (obstacles []obstacle, err error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return
+	}
+	var g geoJSON
+	if err = json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	for i, f := range g.Features {
+		var lines [][][2]float64
+		switch f.Geometry.Type {
+		case "Polygon", "LineString":
+			var polygon [][][2]float64
+			if f.Geometry.Type == "LineString" {
+				var line [][2]float64
+				err = json.Unmarshal(f.Geometry.Coordinates, &line)
+				polygon = [][][2]float64{line}
+			} else {
+				err = json.Unmarshal(f.Geometry.Coordinates, &polygon)
+			}
+			if len(polygon) > 0 {
+				lines = polygon[:1]
+			}
+		case "MultiPolygon":
+			var polygons [][][][2]float64
+			err = json.Unmarshal(f.Geometry.Coordinates, &polygons)
+			for _, polygon := range polygons {
+				if len(polygon) > 0 {
+					lines = append(lines, polygon[0])
+				}
+			}
+		case "MultiLineString":
+			err = json.Unmarshal(f.Geometry.Coordinates, &lines)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: feature %d: %v", file, i, err)
+		}
+		name := f.Properties.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		walls := f.Geometry.Type == "LineString" || f.Geometry.Type == "MultiLineString"
+		for _, line := range lines {
+			o := obstacle{name: name, height: f.Properties.Height, loss: f.Properties.Loss, file: file}
+			for _, pt := range line {
+				o.polygon = append(o.polygon, point2{pt[0], pt[1]})
+			}
+			if n := len(o.polygon); !walls && n > 1 && o.polygon[0] == o.polygon[n-1] {
+				// rings repeat the first point at the end
+				o.polygon = o.polygon[:n-1]
+			}
+			if walls {
+				for j := 0; j+1 < len(o.polygon); j++ {
+					wall := o
+					wall.polygon = []point2{o.polygon[j], o.polygon[j+1]}
+					obstacles = append(obstacles, wall)
+				}
+			} else if len(o.polygon) >= 2 {
+				obstacles = append(obstacles, o)
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// orbitPath is an ellipse that nodes fly along, e.g. relay drones circling
+// over a site.
+type orbitPath struct {
+	name         string
+	center       squirrel.Position // Height is altitude of the orbit
+	radiusX      float64
+	radiusY      float64
+	angularSpeed float64  // degrees per second, counterclockwise; negative for clockwise
+	phase        float64  // degrees, of the first node
+	nodes        []string // hardware addresses, spread evenly along the orbit in this order
+}
+
+// at returns position of k-th node after t.
+func (o *orbitPath) at(k int, t time.Duration) squirrel.Position {
+	deg := o.phase + o.angularSpeed*t.Seconds() + 360*float64(k)/float64(len(o.nodes))
+	rad := deg * math.Pi / 180
+	return squirrel.Position{
+		X:      o.center.X + o.radiusX*math.Cos(rad),
+		Y:      o.center.Y + o.radiusY*math.Sin(rad),
+		Height: o.center.Height,
+	}
+}
+
+// parseOrbits parses parameters of orbit, with orbits ordered by name.
+func parseOrbits(conf *etcd.Node) (orbits []orbitPath, interval time.Duration, err error) {
+	if conf == nil || !conf.Dir {
+		err = fmt.Errorf("orbit needs a Dir of parameters")
+		return
+	}
+	interval = 100 * time.Millisecond
+	seen := make(map[string]string)
+	for _, node := range conf.Nodes {
+		switch path.Base(node.Key) {
+		case "interval":
+			if interval, err = time.ParseDuration(node.Value); err != nil {
+				return
+			}
+			if interval <= 0 {
+				err = fmt.Errorf("interval needs to be positive (got %v)", interval)
+				return
+			}
+			continue
+		case "orbits":
+		default:
+			err = fmt.Errorf("unknown orbit parameter %s", node.Key)
+			return
+		}
+		if !node.Dir {
+			return nil, 0, fmt.Errorf("%s is not a Dir node", node.Key)
+		}
+		for _, dir := range node.Nodes {
+			if !dir.Dir {
+				return nil, 0, fmt.Errorf("%s is not a Dir node", dir.Key)
+			}
+			o := orbitPath{name: path.Base(dir.Key)}
+			hasCenter := false
+			for _, entry := range dir.Nodes {
+				switch path.Base(entry.Key) {
+				case "center":
+					if o.center, err = parsePosition(entry.Value); err != nil {
+						return
+					}
+					hasCenter = true
+				case "radius":
+					o.radiusX, err = strconv.ParseFloat(entry.Value, 64)
+				case "radius_y":
+					o.radiusY, err = strconv.ParseFloat(entry.Value, 64)
+				case "angular_speed":
+					o.angularSpeed, err = strconv.ParseFloat(entry.Value, 64)
+				case "phase":
+					o.phase, err = strconv.ParseFloat(entry.Value, 64)
+				case "nodes":
+					for _, s := range strings.Split(entry.Value, ",") {
+						var addr net.HardwareAddr
+						if addr, err = net.ParseMAC(strings.TrimSpace(s)); err != nil {
+							return
+						}
+						if other, ok := seen[addr.String()]; ok {
+							return nil, 0, fmt.Errorf("node %s is in both orbits %s and %s", addr, other, o.name)
+						}
+						seen[addr.String()] = o.name
+						o.nodes = append(o.nodes, addr.String())
+					}
+				default:
+					return nil, 0, fmt.Errorf("unknown orbit entry %s", entry.Key)
+				}
+				if err != nil {
+					return nil, 0, fmt.Errorf("%s: %v", entry.Key, err)
+				}
+			}
+			if o.radiusY == 0 {
+				o.radiusY = o.radiusX
+			}
+			if !hasCenter || o.radiusX <= 0 || o.radiusY <= 0 || len(o.nodes) == 0 {
+				return nil, 0, fmt.Errorf("orbit %s needs center, a positive radius and nodes", o.name)
+			}
+			orbits = append(orbits, o)
+		}
+	}
+	if len(orbits) == 0 {
+		return nil, 0, fmt.Errorf("orbit needs at least one orbit in orbits")
+	}
+	sort.Slice(orbits, func(i, j int) bool { return orbits[i].name < orbits[j].name })
+	return
+}
+
+// orbitMobility flies nodes along circles or ellipses around configured
+// centers at fixed altitudes and angular speeds.
+type orbitMobility struct {
+	positionManager squirrel.PositionManager
+
+	orbits []orbitPath
+	ticker squirrel.Ticker
+	mu     sync.Mutex // orbits, ticker
+
+	interval time.Duration // initial one; later ones are applied to ticker
+}
+
+func newOrbitMobility() squirrel.MobilityManager {
+	return &orbitMobility{}
+}
+
+func (m *orbitMobility) ParametersHelp() string {
+	return `
+  orbits/<name>/center [Required]:
+    Center of orbit <name>, as "x,y,height"; nodes fly at this height.
+
+  orbits/<name>/radius [Required]:
+    Radius of the orbit, or its semi-axis along X for an ellipse.
+
+  orbits/<name>/radius_y [Optional]:
+    Semi-axis along Y, for an ellipse. Default: radius
+
+  orbits/<name>/angular_speed [Optional]:
+    Degrees per second, counterclockwise; negative for clockwise. Default: 0
+
+  orbits/<name>/phase [Optional]:
+    Angle in degrees, from X axis, of the first node at start. Default: 0
+
+  orbits/<name>/nodes [Required]:
+    Comma separated hardware addresses of nodes on the orbit. They are
+    spread evenly along it, in this order.
+
+  interval [Optional]:
+    How often positions are updated. Default: 100ms
+    `
+}
+
+func (m *orbitMobility) Configure(conf *etcd.Node) (err error) {
+	m.orbits, m.interval, err = parseOrbits(conf)
+	return
+}
+
+// Reconfigure replaces orbits. Nodes move to where they'd be on new orbits
+// had they been flying them from the start.
+func (m *orbitMobility) Reconfigure(conf *etcd.Node) error {
+	orbits, interval, err := parseOrbits(conf)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.orbits = orbits
+	if m.ticker != nil {
+		m.ticker.Reset(interval)
+	}
+	return nil
+}
+
+func (m *orbitMobility) Initialize(positionManager squirrel.PositionManager) {
+	m.positionManager = positionManager
+	start := clock.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ticker = clock.Every(m.interval, func(now time.Time) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for i := range m.orbits {
+			o := &m.orbits[i]
+			for k, addr := range o.nodes {
+				pos := o.at(k, now.Sub(start))
+				// nodes that are not connected are skipped
+				if err := m.positionManager.SetPositionAddr(addr, &pos); err != nil {
+					logger.debugf("orbit: %v", err)
+				}
+			}
+		}
+	})
+}
+
+// Stop stops updating positions.
+func (m *orbitMobility) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ticker != nil {
+		m.ticker.Stop()
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// positionExporterConfig configures periodic export of node positions.
+type positionExporterConfig struct {
+	path     string
+	format   string // "csv" or "jsonl"
+	interval time.Duration
+}
+
+type exportedPosition struct {
+	Time         time.Time
+	Index        int
+	HardwareAddr string
+	X            float64
+	Y            float64
+	Height       float64
+}
+
+// positionExporter periodically appends positions of all enabled nodes to a
+// file.
+type positionExporter struct {
+	conf            positionExporterConfig
+	positionManager *PositionManager
+	addrReverse     *addressReverse
+}
+
+func newPositionExporter(conf positionExporterConfig, positionManager *PositionManager, addrReverse *addressReverse) (*positionExporter, error) {
+	if conf.format != "csv" && conf.format != "jsonl" {
+		return nil, fmt.Errorf("unknown position export format %s (expected csv or jsonl)", conf.format)
+	}
+	if conf.interval <= 0 {
+		return nil, fmt.Errorf("position export interval needs to be positive (got %v)", conf.interval)
+	}
+	return &positionExporter{conf: conf, positionManager: positionManager, addrReverse: addrReverse}, nil
+}
+
+// Run exports positions every interval until an error happens. It blocks.
+func (e *positionExporter) Run() (err error) {
+	var f *os.File
+	if f, err = os.OpenFile(e.conf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+		return
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	if e.conf.format == "csv" {
+		if info, _ := f.Stat(); info != nil && info.Size() == 0 {
+			w.WriteString("time,index,mac,x,y,height\n")
+		}
+	}
+	encoder := json.NewEncoder(w)
+
+	ticker := time.NewTicker(e.conf.interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		addrs := make(map[int]string)
+		for addr, id := range e.addrReverse.All() {
+			addrs[id] = addr
+		}
+		for _, u := range e.positionManager.GetAll() {
+			if e.conf.format == "csv" {
+				_, err = fmt.Fprintf(w, "%s,%d,%s,%s,%s,%s\n", now.Format(time.RFC3339Nano), u.Index, addrs[u.Index],
+					strconv.FormatFloat(u.Position.X, 'g', -1, 64),
+					strconv.FormatFloat(u.Position.Y, 'g', -1, 64),
+					strconv.FormatFloat(u.Position.Height, 'g', -1, 64))
+			} else {
+				err = encoder.Encode(exportedPosition{Time: now, Index: u.Index, HardwareAddr: addrs[u.Index], X: u.Position.X, Y: u.Position.Y, Height: u.Position.Height})
+			}
+			if err != nil {
+				return
+			}
+		}
+		if err = w.Flush(); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (e *positionExporter) start() {
+	go func() {
+		if err := e.Run(); err != nil {
+			logger.errorf("exporting positions to %s failed: %v", e.conf.path, err)
+		}
+	}()
+}
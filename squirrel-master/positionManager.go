@@ -2,57 +2,290 @@ package main
 
 import (
 	"fmt"
-	"log"
 	"math"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/squirrel-land/squirrel"
 )
 
+type positionManagerConfig struct {
+	// number of recent positions kept for each node. 0 disables history.
+	historySize int
+
+	// size of grid cells in the spatial index used by Nearest and Within.
+	spatialIndexCellSize float64
+
+	// if true, positions are WGS84 longitude/latitude/altitude.
+	geographic bool
+
+	// if true, distances between nodes are cached until either one moves.
+	distanceCache bool
+
+	// bounding box that nodes are kept in. nil means unbounded.
+	arena *arena
+
+	// if true, Height is ignored (always 0).
+	planar bool
+
+	// obstacles for line-of-sight checks
+	obstacles *obstacleMap
+
+	// how enabled changed notifications are delivered to slow subscribers
+	notifyPolicy notifyPolicy
+
+	// number of nodes whose state is allocated upfront. 0 means all. State of
+	// others is allocated as they join; slots of all of them are reserved
+	// upfront anyway.
+	preallocatedNodes int
+
+	// if not nil, distances are served from a matrix recomputed in background
+	distanceMatrix *distanceMatrixConfig
+
+	// meters per unit of positions supplied to PositionManager. 0 means 1.
+	scale float64
+
+	// subtracted from supplied positions (before scaling), so that large raw
+	// coordinates such as UTM don't lose precision
+	origin squirrel.Position
+}
+
+// positionHistory is a ring buffer of recent positions of a node.
+type positionHistory struct {
+	records []squirrel.PositionRecord
+	next    int
+	count   int
+}
+
+func (h *positionHistory) add(pos *squirrel.Position) {
+	if len(h.records) == 0 {
+		return
+	}
+	h.records[h.next] = squirrel.PositionRecord{Time: time.Now(), Position: *pos}
+	h.next = (h.next + 1) % len(h.records)
+	if h.count < len(h.records) {
+		h.count++
+	}
+}
+
+func (h *positionHistory) since(t time.Time) (ret []squirrel.PositionRecord) {
+	start := h.next - h.count
+	if start < 0 {
+		start += len(h.records)
+	}
+	for i := 0; i < h.count; i++ {
+		r := h.records[(start+i)%len(h.records)]
+		if !r.Time.Before(t) {
+			ret = append(ret, r)
+		}
+	}
+	return
+}
+
+func (h *positionHistory) reset() {
+	h.next = 0
+	h.count = 0
+}
+
+// node holds state of a single node. Readers load state without any lock.
+// Writers hold mu, modify a copy of current state and store the copy, so a
+// stored nodeState is never modified.
+type node struct {
+	state atomic.Value // *nodeState
+	mu    sync.Mutex   // serializes writers of state; also mutex for hist and meta
+	hist  positionHistory
+	meta  map[string]string
+}
+
+type nodeState struct {
+	enabled bool
+	fixed   bool // position can't be changed
+	pos     squirrel.Position
+	vel     squirrel.Velocity
+	orient  squirrel.Orientation
+	ver     uint64 // version of pos; increases each time pos changes
+}
+
+func (n *node) load() *nodeState {
+	return n.state.Load().(*nodeState)
+}
+
 type PositionManager struct {
-	pos []*squirrel.Position
-	mu  []*sync.RWMutex
+	// nodes is allocated for the maximum capacity, but only elements below
+	// capacity are populated. Elements are never moved, so accessing them
+	// doesn't need any lock.
+	capacity    int64 // accessed atomically
+	muCapacity  *sync.Mutex
+	historySize int
+	nodes       []*node
+
+	index      *spatialIndex
+	geographic bool
+	cache      *distanceCache  // nil if distance cache is disabled
+	matrix     *distanceMatrix // nil if distance matrix is disabled
+	moves      uint64          // accessed atomically; bumped each time any node moves or is enabled/disabled
+	arena      *arena          // nil if unbounded
+	planar     bool
+	obstacles  *obstacleMap
+	scale      float64 // meters per unit of supplied positions
+	origin     squirrel.Position
+
+	isEnabled      []bool // same as enabled in nodeState, for listing enabled nodes
+	enabledChanged []*enabledNotifier
+	enabledDiff    []*enabledDiffNotifier
+	muEnabled      *sync.RWMutex // mutex for isEnabled, enabled, enabledChanged and enabledDiff
+	notifyPolicy   notifyPolicy
+	notifyStats    notifyStats
+
+	positionChanged   []*positionNotifier
+	muPositionChanged *sync.RWMutex // mutex for positionChanged
 
-	isEnabled      []bool
-	enabledChanged []chan<- []int
-	muEnabled      *sync.RWMutex // mutex for isEnabled, enabled and enabledChanged
+	attachments map[int]attachment // attached node -> attachment
+	muAttach    *sync.RWMutex      // mutex for attachments
+
+	// initial positions and metadata for nodes that haven't joined yet, keyed
+	// by lower-cased hardware address
+	initial      map[string]squirrel.Position
+	initialMeta  map[string]map[string]string
+	initialFixed map[string]bool
+	muInitial    *sync.Mutex // mutex for initial, initialMeta and initialFixed
 
 	addrReverse *addressReverse
 }
 
-func NewPositionManager(size int, addrReverse *addressReverse) squirrel.PositionManager {
+// NewPositionManager creates a PositionManager that can grow to hold up to
+// size nodes.
+func NewPositionManager(size int, addrReverse *addressReverse, conf positionManagerConfig) *PositionManager {
 	ret := new(PositionManager)
-	ret.pos = make([]*squirrel.Position, size)
-	ret.mu = make([]*sync.RWMutex, size)
+	ret.nodes = make([]*node, size)
 	ret.isEnabled = make([]bool, size)
-	ret.enabledChanged = make([]chan<- []int, 0)
+	ret.enabledChanged = make([]*enabledNotifier, 0)
+	ret.enabledDiff = make([]*enabledDiffNotifier, 0)
+	ret.notifyPolicy = conf.notifyPolicy
 	ret.muEnabled = new(sync.RWMutex)
+	ret.positionChanged = make([]*positionNotifier, 0)
+	ret.muPositionChanged = new(sync.RWMutex)
+	ret.attachments = make(map[int]attachment)
+	ret.muAttach = new(sync.RWMutex)
+	ret.initial = make(map[string]squirrel.Position)
+	ret.initialMeta = make(map[string]map[string]string)
+	ret.initialFixed = make(map[string]bool)
+	ret.muInitial = new(sync.Mutex)
 	ret.addrReverse = addrReverse
-	for i := 0; i < size; i++ {
-		ret.pos[i] = &squirrel.Position{0, 0, 0}
-		ret.mu[i] = new(sync.RWMutex)
+	ret.index = newSpatialIndex(conf.spatialIndexCellSize)
+	ret.geographic = conf.geographic
+	ret.arena = conf.arena
+	ret.planar = conf.planar
+	ret.scale = conf.scale
+	ret.origin = conf.origin
+	if ret.scale == 0 {
+		ret.scale = 1
+	}
+	ret.obstacles = conf.obstacles
+	if ret.obstacles == nil {
+		ret.obstacles = &obstacleMap{}
+	}
+	if conf.distanceCache {
+		ret.cache = newDistanceCache(size)
+	}
+	ret.muCapacity = new(sync.Mutex)
+	ret.historySize = conf.historySize
+	if conf.preallocatedNodes <= 0 || conf.preallocatedNodes > size {
+		ret.grow(size)
+	} else {
+		ret.grow(conf.preallocatedNodes)
+	}
+	if conf.distanceMatrix != nil {
+		ret.matrix = newDistanceMatrix(*conf.distanceMatrix)
+		go ret.matrix.run(ret)
 	}
 	return ret
 }
 
+// Capacity returns number of nodes currently allocated. It can grow (but never
+// shrinks) as nodes join.
 func (p *PositionManager) Capacity() int {
-	return len(p.pos)
+	return int(atomic.LoadInt64(&p.capacity))
+}
+
+// MaxCapacity returns the number of nodes that capacity can grow up to.
+func (p *PositionManager) MaxCapacity() int {
+	return len(p.nodes)
+}
+
+// grow allocates nodes up to size (capped by MaxCapacity()).
+func (p *PositionManager) grow(size int) {
+	p.muCapacity.Lock()
+	defer p.muCapacity.Unlock()
+	if size > len(p.nodes) {
+		size = len(p.nodes)
+	}
+	current := p.Capacity()
+	if size <= current {
+		return
+	}
+	for i := current; i < size; i++ {
+		n := &node{
+			hist: positionHistory{records: make([]squirrel.PositionRecord, p.historySize)},
+			meta: make(map[string]string),
+		}
+		n.state.Store(&nodeState{})
+		p.nodes[i] = n
+	}
+	// publish new capacity only after nodes are allocated
+	atomic.StoreInt64(&p.capacity, int64(size))
+	if current > 0 {
+		logger.infof("PositionManager capacity grows from %d to %d", current, size)
+	}
+}
+
+// lookup returns node at index.
+func (p *PositionManager) lookup(index int) (n *node, err error) {
+	if index < 0 || index >= p.Capacity() {
+		err = fmt.Errorf("invalid index %d. capacity is %d", index, p.Capacity())
+		return
+	}
+	n = p.nodes[index]
+	return
+}
+
+// loadEnabled returns current state of node at index, which has to be enabled.
+// It doesn't lock.
+func (p *PositionManager) loadEnabled(index int) (s *nodeState, err error) {
+	var n *node
+	if n, err = p.lookup(index); err != nil {
+		return
+	}
+	if s = n.load(); !s.enabled {
+		s, err = nil, fmt.Errorf("node with index %d is disabled", index)
+	}
+	return
 }
 
 // Get returns a copy of Position at given index. Avoid this if possible. It
 // causes copying Position struct.
 func (p *PositionManager) Get(index int) (pos squirrel.Position, err error) {
-	if index >= len(p.pos) {
-		err = fmt.Errorf("invalid index %d. capacity is %d", index, len(p.pos))
+	if pos, err = p.getStored(index); err != nil {
 		return
 	}
-	p.mu[index].RLock()
-	defer p.mu[index].RUnlock()
-	if !p.isEnabled[index] {
-		err = fmt.Errorf("node with index %d is disabled", index)
+	if a, ok := p.attachedTo(index); ok {
+		pos, err = p.Get(a.parent)
+		pos = addOffset(pos, a.offset)
+	}
+	return
+}
+
+// getStored returns position stored for node at index, without resolving
+// attachments.
+func (p *PositionManager) getStored(index int) (pos squirrel.Position, err error) {
+	var s *nodeState
+	if s, err = p.loadEnabled(index); err != nil {
 		return
 	}
-	pos = *(p.pos[index])
+	pos = s.pos
 	return
 }
 
@@ -68,15 +301,150 @@ func (p *PositionManager) GetAddr(hardAddr string) (pos squirrel.Position, err e
 	return
 }
 
+// GetWithVersion returns a copy of Position at given index along with its
+// version.
+func (p *PositionManager) GetWithVersion(index int) (pos squirrel.Position, version uint64, err error) {
+	var s *nodeState
+	if s, err = p.loadEnabled(index); err != nil {
+		return
+	}
+	pos, version = s.pos, s.ver
+	if a, ok := p.attachedTo(index); ok {
+		pos, err = p.Get(a.parent)
+		pos = addOffset(pos, a.offset)
+	}
+	return
+}
+
+// GetAll returns indices and positions of all enabled nodes. Writers of all
+// enabled nodes are held off together while copying, so that a transaction is
+// either fully seen or not seen at all.
+func (p *PositionManager) GetAll() []squirrel.PositionUpdate {
+	p.muEnabled.RLock()
+	defer p.muEnabled.RUnlock()
+	enabled := p.calculateEnabled()
+	for _, index := range enabled {
+		p.nodes[index].mu.Lock()
+	}
+	ret := make([]squirrel.PositionUpdate, len(enabled))
+	for i, index := range enabled {
+		ret[i] = squirrel.PositionUpdate{Index: index, Position: p.nodes[index].load().pos}
+	}
+	for _, index := range enabled {
+		p.nodes[index].mu.Unlock()
+	}
+	p.resolveAll(ret)
+	return ret
+}
+
+// resolveAll resolves positions of attached nodes in positions, which is
+// sorted by index, using positions of their parents in it.
+func (p *PositionManager) resolveAll(positions []squirrel.PositionUpdate) {
+	p.muAttach.RLock()
+	defer p.muAttach.RUnlock()
+	if len(p.attachments) == 0 {
+		return
+	}
+	find := func(index int) *squirrel.PositionUpdate {
+		i := sort.Search(len(positions), func(i int) bool { return positions[i].Index >= index })
+		if i < len(positions) && positions[i].Index == index {
+			return &positions[i]
+		}
+		return nil
+	}
+	resolved := make(map[int]bool)
+	var resolve func(u *squirrel.PositionUpdate)
+	resolve = func(u *squirrel.PositionUpdate) {
+		a, ok := p.attachments[u.Index]
+		if !ok || resolved[u.Index] {
+			return
+		}
+		resolved[u.Index] = true
+		if parent := find(a.parent); parent != nil {
+			resolve(parent)
+			u.Position = addOffset(parent.Position, a.offset)
+		}
+	}
+	for i := range positions {
+		resolve(&positions[i])
+	}
+}
+
 // Distance calculates Euclidean distance between positions at index1 and
 // index2.
 func (p *PositionManager) Distance(index1, index2 int) float64 {
+	if p.matrix != nil {
+		if d, ok := p.matrix.distance(index1, index2); ok {
+			return d
+		}
+	}
+	if p.cache != nil {
+		return p.cache.distance(index1, index2, p.distance)
+	}
+	return p.distance(index1, index2)
+}
+
+func (p *PositionManager) distance(index1, index2 int) float64 {
 	pos1, err1 := p.Get(index1)
 	pos2, err2 := p.Get(index2)
 	if err1 != nil || err2 != nil {
 		return math.MaxFloat64
 	}
-	return math.Sqrt(math.Pow(pos1.X-pos2.X, 2) + math.Pow(pos1.Y-pos2.Y, 2) + math.Pow(pos1.Height-pos2.Height, 2))
+	return euclidean(p.cartesian(pos1), p.cartesian(pos2))
+}
+
+// DistanceSq calculates squared Euclidean distance between positions at index1
+// and index2.
+func (p *PositionManager) DistanceSq(index1, index2 int) float64 {
+	if p.matrix != nil {
+		if d, ok := p.matrix.distance(index1, index2); ok {
+			return d * d
+		}
+	}
+	pos1, err1 := p.Get(index1)
+	pos2, err2 := p.Get(index2)
+	if err1 != nil || err2 != nil {
+		return math.MaxFloat64
+	}
+	return euclideanSq(p.cartesian(pos1), p.cartesian(pos2))
+}
+
+// LineOfSight returns whether the straight path between nodes at index1 and
+// index2 is not blocked by any obstacle.
+func (p *PositionManager) LineOfSight(index1, index2 int) bool {
+	pos1, err1 := p.Get(index1)
+	pos2, err2 := p.Get(index2)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return p.obstacles.lineOfSight(pos1, pos2)
+}
+
+// cartesian converts pos into the Cartesian coordinate system in which
+// distances are calculated.
+func (p *PositionManager) cartesian(pos squirrel.Position) squirrel.Position {
+	if p.geographic {
+		return wgs84ToECEF(pos)
+	}
+	return pos
+}
+
+// offset returns where to is relative to from, in meters: X east, Y north
+// and Height up from from in geographic mode.
+func (p *PositionManager) offset(from, to squirrel.Position) squirrel.Position {
+	if p.geographic {
+		return ecefToENU(wgs84ToECEF(from), wgs84ToECEF(to), from)
+	}
+	return squirrel.Position{X: to.X - from.X, Y: to.Y - from.Y, Height: to.Height - from.Height}
+}
+
+func euclidean(pos1, pos2 squirrel.Position) float64 {
+	return math.Sqrt(euclideanSq(pos1, pos2))
+}
+
+func euclideanSq(pos1, pos2 squirrel.Position) float64 {
+	dx, dy, dh := pos1.X-pos2.X, pos1.Y-pos2.Y, pos1.Height-pos2.Height
+	return dx*dx + dy*dy + dh*dh
 }
 
 func (p *PositionManager) SetAddr(hardAddr string, x, y, height float64) (err error) {
@@ -87,7 +455,7 @@ func (p *PositionManager) SetAddr(hardAddr string, x, y, height float64) (err er
 		err = fmt.Errorf("node with hardware address %s is not found", hardAddr)
 		return
 	}
-	p.Set(id, x, y, height)
+	err = p.Set(id, x, y, height)
 	return
 }
 
@@ -99,32 +467,431 @@ func (p *PositionManager) SetPositionAddr(hardAddr string, pos *squirrel.Positio
 		err = fmt.Errorf("node with hardware address %s is not found", hardAddr)
 		return
 	}
-	p.SetPosition(id, pos)
+	err = p.SetPosition(id, pos)
 	return
 }
 
 func (p *PositionManager) Set(index int, x, y, height float64) (err error) {
-	if index >= len(p.pos) {
-		err = fmt.Errorf("invalid index %d. capacity is %d", index, len(p.pos))
+	err = p.move(index, p.fromSupplied(squirrel.Position{X: x, Y: y, Height: height}))
+	return
+}
+
+// move sets position of node at index to be pos, which is already converted
+// into meters.
+func (p *PositionManager) move(index int, pos squirrel.Position) (err error) {
+	var n *node
+	if n, err = p.lookup(index); err != nil {
+		return
+	}
+	if pos, err = p.normalize(pos); err != nil {
 		return
 	}
-	p.mu[index].Lock()
-	defer p.mu[index].Unlock()
-	if !p.isEnabled[index] {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	s := *n.load()
+	if !s.enabled {
 		err = fmt.Errorf("node with index %d is disabled", index)
 		return
 	}
-	p.pos[index].X = x
-	p.pos[index].Y = y
-	p.pos[index].Height = height
-	if *debug {
-		log.Printf("position for %d is updated to: %v\n", index, p.pos[index])
+	if s.fixed {
+		err = fmt.Errorf("node with index %d is fixed", index)
+		return
+	}
+	s.pos = pos
+	p.positionUpdated(index, n, &s)
+	if logger.enabled(logDebug) {
+		logger.debugf("position for %d is updated to: %v", index, s.pos)
 	}
 	return
 }
 
+// SetIfVersion sets position at index to be pos if its version is still
+// version; otherwise squirrel.VersionMismatch is returned.
+func (p *PositionManager) SetIfVersion(index int, version uint64, pos *squirrel.Position) (err error) {
+	var n *node
+	if n, err = p.lookup(index); err != nil {
+		return
+	}
+	var adjusted squirrel.Position
+	if adjusted, err = p.normalize(p.fromSupplied(*pos)); err != nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	s := *n.load()
+	if !s.enabled {
+		err = fmt.Errorf("node with index %d is disabled", index)
+		return
+	}
+	if s.fixed {
+		err = fmt.Errorf("node with index %d is fixed", index)
+		return
+	}
+	if s.ver != version {
+		err = squirrel.VersionMismatch
+		return
+	}
+	s.pos = adjusted
+	p.positionUpdated(index, n, &s)
+	if logger.enabled(logDebug) {
+		logger.debugf("position for %d is updated to: %v (version %d)", index, s.pos, s.ver)
+	}
+	return
+}
+
+// positionUpdated stores s, in which position is changed, as the new state of
+// node n at index, with n.mu locked.
+func (p *PositionManager) positionUpdated(index int, n *node, s *nodeState) {
+	p.positionApplied(index, n, s)
+	if _, ok := p.attachedTo(index); !ok {
+		p.notifyPositionChanged(index, s.pos)
+	}
+}
+
+// positionApplied stores s like positionUpdated, and updates derived state
+// (distance cache, history, spatial index), but doesn't notify.
+func (p *PositionManager) positionApplied(index int, n *node, s *nodeState) {
+	s.ver++
+	n.state.Store(s)
+	if _, ok := p.attachedTo(index); ok {
+		// position is derived from parent; stored one is ignored
+		return
+	}
+	p.invalidateDistances(index)
+	n.hist.add(&s.pos)
+	p.index.update(index, p.cartesian(s.pos))
+	for _, u := range p.descendants(index, s.pos) {
+		p.invalidateDistances(u.Index)
+		p.index.update(u.Index, p.cartesian(u.Position))
+	}
+}
+
+// storePosition sets stored position of node at index without updating
+// derived state or version, e.g. in preparation of it being enabled or
+// detached.
+func (p *PositionManager) storePosition(index int, pos squirrel.Position) {
+	n := p.nodes[index]
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	s := *n.load()
+	s.pos = pos
+	n.state.Store(&s)
+}
+
 func (p *PositionManager) SetPosition(index int, pos *squirrel.Position) (err error) {
-	p.Set(index, pos.X, pos.Y, pos.Height)
+	err = p.Set(index, pos.X, pos.Y, pos.Height)
+	return
+}
+
+// normalize returns pos as it should be stored: Height is dropped in planar
+// mode, and pos is adjusted by arena policy. err is set if pos is rejected.
+func (p *PositionManager) normalize(pos squirrel.Position) (squirrel.Position, error) {
+	if p.planar {
+		pos.Height = 0
+	}
+	if p.arena == nil {
+		return pos, nil
+	}
+	return p.arena.apply(pos)
+}
+
+func (p *PositionManager) SetWithVelocity(index int, pos *squirrel.Position, vel *squirrel.Velocity) (err error) {
+	var n *node
+	if n, err = p.lookup(index); err != nil {
+		return
+	}
+	var adjusted squirrel.Position
+	if adjusted, err = p.normalize(p.fromSupplied(*pos)); err != nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	s := *n.load()
+	if !s.enabled {
+		err = fmt.Errorf("node with index %d is disabled", index)
+		return
+	}
+	if s.fixed {
+		err = fmt.Errorf("node with index %d is fixed", index)
+		return
+	}
+	s.pos = adjusted
+	s.vel = p.scaledVelocity(*vel)
+	p.positionUpdated(index, n, &s)
+	if logger.enabled(logDebug) {
+		logger.debugf("position for %d is updated to: %v, velocity: %v", index, s.pos, s.vel)
+	}
+	return
+}
+
+// GetVelocity returns a copy of Velocity at given index.
+func (p *PositionManager) GetVelocity(index int) (vel squirrel.Velocity, err error) {
+	var s *nodeState
+	if s, err = p.loadEnabled(index); err != nil {
+		return
+	}
+	vel = s.vel
+	return
+}
+
+// GetOrientation returns a copy of Orientation at given index.
+func (p *PositionManager) GetOrientation(index int) (o squirrel.Orientation, err error) {
+	var s *nodeState
+	if s, err = p.loadEnabled(index); err != nil {
+		return
+	}
+	o = s.orient
+	return
+}
+
+func (p *PositionManager) GetOrientationAddr(hardAddr string) (o squirrel.Orientation, err error) {
+	var id int
+	var ok bool
+	id, ok = p.addrReverse.GetS(hardAddr)
+	if !ok {
+		err = fmt.Errorf("node with hardware address %s is not found", hardAddr)
+		return
+	}
+	o, err = p.GetOrientation(id)
+	return
+}
+
+func (p *PositionManager) SetOrientation(index int, o *squirrel.Orientation) (err error) {
+	var n *node
+	if n, err = p.lookup(index); err != nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	s := *n.load()
+	if !s.enabled {
+		err = fmt.Errorf("node with index %d is disabled", index)
+		return
+	}
+	s.orient = *o
+	n.state.Store(&s)
+	if logger.enabled(logDebug) {
+		logger.debugf("orientation for %d is updated to: %v", index, s.orient)
+	}
+	return
+}
+
+func (p *PositionManager) SetOrientationAddr(hardAddr string, o *squirrel.Orientation) (err error) {
+	var id int
+	var ok bool
+	id, ok = p.addrReverse.GetS(hardAddr)
+	if !ok {
+		err = fmt.Errorf("node with hardware address %s is not found", hardAddr)
+		return
+	}
+	err = p.SetOrientation(id, o)
+	return
+}
+
+// History returns recorded positions of node at index since given time,
+// oldest first.
+func (p *PositionManager) History(index int, since time.Time) (records []squirrel.PositionRecord, err error) {
+	var n *node
+	if n, err = p.lookup(index); err != nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.load().enabled {
+		err = fmt.Errorf("node with index %d is disabled", index)
+		return
+	}
+	records = n.hist.since(since)
+	return
+}
+
+// Nearest returns indices of up to k enabled nodes that are nearest to node at
+// index, nearest first.
+func (p *PositionManager) Nearest(index int, k int) (ret []int, err error) {
+	ret, ok := p.index.nearest(index, k)
+	if !ok {
+		err = fmt.Errorf("node with index %d is disabled or invalid", index)
+	}
+	return
+}
+
+// Within returns indices of enabled nodes whose distance to node at index is
+// no more than radius.
+func (p *PositionManager) Within(index int, radius float64) (ret []int, err error) {
+	ret, ok := p.index.within(index, radius)
+	if !ok {
+		err = fmt.Errorf("node with index %d is disabled or invalid", index)
+	}
+	return
+}
+
+// EnabledWithin returns indices of enabled nodes whose distance to node at
+// index is no more than radius, or nil if node at index is not enabled.
+func (p *PositionManager) EnabledWithin(index int, radius float64) []int {
+	ret, _ := p.index.within(index, radius)
+	return ret
+}
+
+// SetBatch sets positions of multiple nodes in one call. muEnabled is only
+// acquired once for the whole batch, and moves are notified in one pass after
+// all of them are applied.
+func (p *PositionManager) SetBatch(updates []squirrel.PositionUpdate) (err error) {
+	moved := make([]squirrel.PositionUpdate, 0, len(updates))
+	p.muEnabled.RLock()
+	for i := range updates {
+		index := updates[i].Index
+		n, e := p.lookup(index)
+		if e == nil && !p.isEnabled[index] {
+			e = fmt.Errorf("node with index %d is disabled", index)
+		} else if e == nil && n.load().fixed {
+			e = fmt.Errorf("node with index %d is fixed", index)
+		}
+		var pos squirrel.Position
+		if e == nil {
+			pos, e = p.normalize(p.fromSupplied(updates[i].Position))
+		}
+		if e != nil {
+			if err == nil {
+				err = e
+			}
+			continue
+		}
+		n.mu.Lock()
+		s := *n.load()
+		s.pos = pos
+		p.positionApplied(index, n, &s)
+		n.mu.Unlock()
+		if _, ok := p.attachedTo(index); !ok {
+			moved = append(moved, squirrel.PositionUpdate{Index: index, Position: pos})
+		}
+	}
+	p.muEnabled.RUnlock()
+	p.notifyPositionsChanged(moved)
+	logger.debugf("positions for %d nodes are updated in batch", len(updates))
+	return
+}
+
+func (p *PositionManager) invalidateDistances(index int) {
+	atomic.AddUint64(&p.moves, 1)
+	if p.cache != nil {
+		p.cache.invalidate(index)
+	}
+}
+
+// changes returns a counter that increases each time any node moves or is
+// enabled/disabled.
+func (p *PositionManager) changes() uint64 {
+	return atomic.LoadUint64(&p.moves)
+}
+
+// setInitialAddr sets the position where node with hardAddr is placed when it
+// joins.
+func (p *PositionManager) setInitialAddr(hardAddr string, pos squirrel.Position) {
+	p.muInitial.Lock()
+	defer p.muInitial.Unlock()
+	p.initial[strings.ToLower(hardAddr)] = pos
+}
+
+// setInitialMetadataAddr sets metadata that node with hardAddr has when it
+// joins.
+func (p *PositionManager) setInitialMetadataAddr(hardAddr string, key, value string) {
+	p.muInitial.Lock()
+	defer p.muInitial.Unlock()
+	hardAddr = strings.ToLower(hardAddr)
+	if p.initialMeta[hardAddr] == nil {
+		p.initialMeta[hardAddr] = make(map[string]string)
+	}
+	p.initialMeta[hardAddr][key] = value
+}
+
+// setInitialFixedAddr sets whether node with hardAddr stays at its initial
+// position when it joins.
+func (p *PositionManager) setInitialFixedAddr(hardAddr string, fixed bool) {
+	p.muInitial.Lock()
+	defer p.muInitial.Unlock()
+	p.initialFixed[strings.ToLower(hardAddr)] = fixed
+}
+
+// place puts a joining node at index to its initial position, if there's one
+// for hardAddr, and resets its metadata and whether it's fixed to initial ones.
+// It should be called before the node is enabled.
+func (p *PositionManager) place(index int, hardAddr string) {
+	hardAddr = strings.ToLower(hardAddr)
+	p.muInitial.Lock()
+	pos, ok := p.initial[hardAddr]
+	fixed := p.initialFixed[hardAddr]
+	meta := make(map[string]string, len(p.initialMeta[hardAddr]))
+	for k, v := range p.initialMeta[hardAddr] {
+		meta[k] = v
+	}
+	p.muInitial.Unlock()
+	n := p.nodes[index]
+	n.mu.Lock()
+	n.meta = meta
+	s := *n.load()
+	s.fixed = fixed
+	n.state.Store(&s)
+	n.mu.Unlock()
+	if ok {
+		if pos, err := p.normalize(pos); err == nil {
+			p.storePosition(index, pos)
+		} else {
+			logger.warnf("initial position of %s is ignored: %v", hardAddr, err)
+		}
+	}
+}
+
+// GetMetadata returns value of metadata key of node at index.
+func (p *PositionManager) GetMetadata(index int, key string) (value string, ok bool) {
+	n, err := p.lookup(index)
+	if err != nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	value, ok = n.meta[key]
+	return
+}
+
+// Metadata returns a copy of all metadata of node at index.
+func (p *PositionManager) Metadata(index int) (meta map[string]string, err error) {
+	var n *node
+	if n, err = p.lookup(index); err != nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	meta = make(map[string]string, len(n.meta))
+	for k, v := range n.meta {
+		meta[k] = v
+	}
+	return
+}
+
+func (p *PositionManager) SetMetadata(index int, key, value string) (err error) {
+	var n *node
+	if n, err = p.lookup(index); err != nil {
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.load().enabled {
+		err = fmt.Errorf("node with index %d is disabled", index)
+		return
+	}
+	n.meta[key] = value
+	return
+}
+
+func (p *PositionManager) SetMetadataAddr(hardAddr string, key, value string) (err error) {
+	var id int
+	var ok bool
+	id, ok = p.addrReverse.GetS(hardAddr)
+	if !ok {
+		err = fmt.Errorf("node with hardware address %s is not found", hardAddr)
+		return
+	}
+	err = p.SetMetadata(id, key, value)
 	return
 }
 
@@ -132,22 +899,58 @@ func (p *PositionManager) SetPosition(index int, pos *squirrel.Position) (err er
 func (p *PositionManager) Enable(index int) {
 	p.muEnabled.Lock()
 	defer p.muEnabled.Unlock()
+	n, err := p.lookup(index)
+	if err != nil {
+		logger.errorf("PositionManager: cannot enable node: %v", err)
+		return
+	}
+	n.mu.Lock()
+	s := *n.load()
+	changed := !s.enabled
+	s.enabled = true
+	n.state.Store(&s)
 	p.isEnabled[index] = true
+	n.hist.reset()
+	p.invalidateDistances(index)
+	p.index.update(index, p.cartesian(s.pos))
+	n.mu.Unlock()
 	p.notifyEnabledChanged()
+	if changed {
+		p.notifyEnabledDiff(squirrel.EnabledDiff{Added: []int{index}})
+	}
 }
 
 // Disable marks a node disabled.
 func (p *PositionManager) Disable(index int) {
 	p.muEnabled.Lock()
 	defer p.muEnabled.Unlock()
+	if pos, err := p.Get(index); err == nil {
+		p.detachAll(index, pos)
+	}
+	n, err := p.lookup(index)
+	if err != nil {
+		logger.errorf("PositionManager: cannot disable node: %v", err)
+		return
+	}
+	n.mu.Lock()
+	s := *n.load()
+	changed := s.enabled
+	s.enabled = false
+	n.state.Store(&s)
 	p.isEnabled[index] = false
+	p.index.remove(index)
+	p.invalidateDistances(index)
+	n.mu.Unlock()
 	p.notifyEnabledChanged()
+	if changed {
+		p.notifyEnabledDiff(squirrel.EnabledDiff{Removed: []int{index}})
+	}
 }
 
+// IsEnabled returns whether node at index is enabled. It doesn't lock.
 func (p *PositionManager) IsEnabled(index int) bool {
-	p.muEnabled.RLock()
-	defer p.muEnabled.RUnlock()
-	return p.isEnabled[index]
+	n, err := p.lookup(index)
+	return err == nil && n.load().enabled
 }
 
 func (p *PositionManager) calculateEnabled() []int {
@@ -172,11 +975,110 @@ func (p *PositionManager) Enabled() []int {
 func (p *PositionManager) RegisterEnabledChanged(channel chan<- []int) {
 	p.muEnabled.Lock()
 	defer p.muEnabled.Unlock()
-	p.enabledChanged = append(p.enabledChanged, channel)
+	p.enabledChanged = append(p.enabledChanged, newEnabledNotifier(channel, p.notifyPolicy, &p.notifyStats))
 }
 
+// UnregisterEnabledChanged stops sending notifications into a channel
+// registered by RegisterEnabledChanged.
+func (p *PositionManager) UnregisterEnabledChanged(channel chan<- []int) {
+	p.muEnabled.Lock()
+	defer p.muEnabled.Unlock()
+	for i, n := range p.enabledChanged {
+		if n.channel == channel {
+			n.stop()
+			p.enabledChanged = append(p.enabledChanged[:i], p.enabledChanged[i+1:]...)
+			return
+		}
+	}
+}
+
+// notifyEnabledChanged never blocks; slow subscribers get coalesced or dropped
+// notifications depending on notifyPolicy.
 func (p *PositionManager) notifyEnabledChanged() {
-	for _, c := range p.enabledChanged {
-		c <- p.calculateEnabled()
+	for _, n := range p.enabledChanged {
+		n.notify(p.calculateEnabled())
+	}
+}
+
+// NotificationStats returns numbers of enabled and position changed
+// notifications that have been coalesced or dropped because subscribers were
+// not keeping up.
+func (p *PositionManager) NotificationStats() (coalesced, dropped uint64) {
+	return atomic.LoadUint64(&p.notifyStats.coalesced), atomic.LoadUint64(&p.notifyStats.dropped)
+}
+
+// RegisterEnabledDiff registers a channel used to receive indices of nodes that
+// are enabled or disabled. A diff is sent into channel each time any node is
+// enabled/disabled.
+func (p *PositionManager) RegisterEnabledDiff(channel chan<- squirrel.EnabledDiff) {
+	p.muEnabled.Lock()
+	defer p.muEnabled.Unlock()
+	p.enabledDiff = append(p.enabledDiff, newEnabledDiffNotifier(channel, p.notifyPolicy, &p.notifyStats))
+}
+
+// UnregisterEnabledDiff stops sending notifications into a channel registered
+// by RegisterEnabledDiff.
+func (p *PositionManager) UnregisterEnabledDiff(channel chan<- squirrel.EnabledDiff) {
+	p.muEnabled.Lock()
+	defer p.muEnabled.Unlock()
+	for i, n := range p.enabledDiff {
+		if n.channel == channel {
+			n.stop()
+			p.enabledDiff = append(p.enabledDiff[:i], p.enabledDiff[i+1:]...)
+			return
+		}
+	}
+}
+
+func (p *PositionManager) notifyEnabledDiff(diff squirrel.EnabledDiff) {
+	for _, n := range p.enabledDiff {
+		n.notify(diff)
+	}
+}
+
+// RegisterPositionChanged registers a channel used to receive index and new
+// position of a node each time its position is changed.
+func (p *PositionManager) RegisterPositionChanged(channel chan<- squirrel.PositionUpdate) {
+	p.muPositionChanged.Lock()
+	defer p.muPositionChanged.Unlock()
+	p.positionChanged = append(p.positionChanged, newPositionNotifier(channel, p.notifyPolicy, &p.notifyStats))
+}
+
+// UnregisterPositionChanged stops sending notifications into a channel
+// registered by RegisterPositionChanged.
+func (p *PositionManager) UnregisterPositionChanged(channel chan<- squirrel.PositionUpdate) {
+	p.muPositionChanged.Lock()
+	defer p.muPositionChanged.Unlock()
+	for i, n := range p.positionChanged {
+		if n.channel == channel {
+			n.stop()
+			p.positionChanged = append(p.positionChanged[:i], p.positionChanged[i+1:]...)
+			return
+		}
+	}
+}
+
+// notifyPositionChanged notifies that node at index is moved to pos, along
+// with nodes attached to it. Like notifyEnabledChanged, it never blocks, so
+// it's safe to call with locks held.
+func (p *PositionManager) notifyPositionChanged(index int, pos squirrel.Position) {
+	p.notifyPositionsChanged([]squirrel.PositionUpdate{{Index: index, Position: pos}})
+}
+
+// notifyPositionsChanged is like notifyPositionChanged, but for multiple nodes
+// moved at once, which subscribers are handed in one pass.
+func (p *PositionManager) notifyPositionsChanged(moved []squirrel.PositionUpdate) {
+	p.muPositionChanged.RLock()
+	defer p.muPositionChanged.RUnlock()
+	if len(p.positionChanged) == 0 || len(moved) == 0 {
+		return
+	}
+	updates := make([]squirrel.PositionUpdate, 0, len(moved))
+	for _, u := range moved {
+		updates = append(updates, u)
+		updates = append(updates, p.descendants(u.Index, u.Position)...)
+	}
+	for _, n := range p.positionChanged {
+		n.notify(updates)
 	}
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+type positionSnapshotEntry struct {
+	HardwareAddr string
+	Position     squirrel.Position
+}
+
+// Snapshot returns positions of all enabled nodes, keyed by their hardware
+// addresses, encoded in JSON.
+func (p *PositionManager) Snapshot() ([]byte, error) {
+	entries := make([]positionSnapshotEntry, 0)
+	for addr, id := range p.addrReverse.All() {
+		pos, err := p.Get(id)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, positionSnapshotEntry{HardwareAddr: addr, Position: pos})
+	}
+	return json.Marshal(entries)
+}
+
+// Restore loads positions from data produced by Snapshot. Nodes that are
+// currently enabled are moved immediately; other nodes are placed at restored
+// positions when they join.
+func (p *PositionManager) Restore(data []byte) (err error) {
+	var entries []positionSnapshotEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return
+	}
+	for i := range entries {
+		p.setInitialAddr(entries[i].HardwareAddr, entries[i].Position)
+		if id, ok := p.addrReverse.GetS(entries[i].HardwareAddr); ok && p.IsEnabled(id) {
+			p.move(id, entries[i].Position)
+		}
+	}
+	return
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// readPositionsFile reads initial positions of nodes from a CSV file with
+// records in the form of "mac,x,y,height". A header line is allowed. Lines
+// starting with '#' are ignored.
+func readPositionsFile(name string) (positions map[string]squirrel.Position, err error) {
+	var f *os.File
+	if f, err = os.Open(name); err != nil {
+		return
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.Comment = '#'
+	r.FieldsPerRecord = 4
+	r.TrimLeadingSpace = true
+	positions = make(map[string]squirrel.Position)
+	for line := 1; ; line++ {
+		var record []string
+		record, err = r.Read()
+		if err == io.EOF {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		if _, e := net.ParseMAC(record[0]); e != nil {
+			if line == 1 { // header
+				continue
+			}
+			err = fmt.Errorf("%s: invalid hardware address %q", name, record[0])
+			return
+		}
+		var values [3]float64
+		for i := range values {
+			if values[i], err = strconv.ParseFloat(record[i+1], 64); err != nil {
+				err = fmt.Errorf("%s: %v", name, err)
+				return
+			}
+		}
+		positions[strings.ToLower(record[0])] = squirrel.Position{X: values[0], Y: values[1], Height: values[2]}
+	}
+}
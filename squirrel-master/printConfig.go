@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+var arenaPolicyNames = map[arenaPolicy]string{arenaReject: "reject", arenaClamp: "clamp", arenaWrap: "wrap"}
+var notifyPolicyNames = map[notifyPolicy]string{notifyCoalesce: "coalesce", notifyDrop: "drop"}
+
+// printConfig writes the resolved configuration, with defaults filled in and
+// overrides applied, to w.
+func printConfig(w io.Writer, conf config) {
+	p := func(key string, value interface{}) {
+		fmt.Fprintf(w, "%-46s %v\n", key, value)
+	}
+	pm := conf.positionManager
+
+	var names []string
+	for name := range conf.vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p("/squirrel/master/vars/"+name, conf.vars[name])
+	}
+	p("listen address", conf.uri)
+	p("/squirrel/master/emulated_subnet", conf.emulatedSubnet)
+	p("/squirrel/master/mobility_manager", conf.mobilityManager)
+	printEtcdNode(w, "Mobility Manager parameters", conf.mobilityManagerConfig)
+	for _, a := range conf.mobilityAssignments {
+		dir := "/squirrel/master/mobility_managers/" + a.name
+		p(dir+"/mobility_manager", a.mobilityManager)
+		printEtcdNode(w, dir+" parameters", a.parameters)
+		p(dir+"/nodes", strings.Join(a.nodes, ","))
+		p(dir+"/tags", strings.Join(a.tags, ","))
+	}
+	p("/squirrel/master/september", conf.september)
+	printEtcdNode(w, "September parameters", conf.septemberConfig)
+	p("/squirrel/master/september_seed", conf.septemberSeed)
+
+	p("/squirrel/master/position_history_size", pm.historySize)
+	p("/squirrel/master/spatial_index_cell_size", pm.spatialIndexCellSize)
+	if pm.geographic {
+		p("/squirrel/master/coordinate_system", "wgs84")
+	} else {
+		p("/squirrel/master/coordinate_system", "cartesian")
+	}
+	if pm.preallocatedNodes > 0 {
+		p("/squirrel/master/preallocated_nodes", pm.preallocatedNodes)
+	} else {
+		p("/squirrel/master/preallocated_nodes", "size of emulated_subnet")
+	}
+	p("/squirrel/master/distance_cache", pm.distanceCache)
+	p("/squirrel/master/planar", pm.planar)
+	p("/squirrel/master/enabled_changed_policy", notifyPolicyNames[pm.notifyPolicy])
+	p("meters per supplied unit", pm.scale)
+	p("/squirrel/master/origin", fmt.Sprintf("%v,%v,%v", pm.origin.X, pm.origin.Y, pm.origin.Height))
+	if a := pm.arena; a != nil {
+		p("/squirrel/master/arena/min", fmt.Sprintf("%v,%v,%v", a.min.X, a.min.Y, a.min.Height))
+		p("/squirrel/master/arena/max", fmt.Sprintf("%v,%v,%v", a.max.X, a.max.Y, a.max.Height))
+		p("/squirrel/master/arena/policy", arenaPolicyNames[a.policy])
+	} else {
+		p("/squirrel/master/arena", "unbounded")
+	}
+	if pm.obstacles != nil {
+		files := make(map[string]int)
+		for _, o := range pm.obstacles.obstacles {
+			if o.file != "" {
+				files[o.file]++
+				continue
+			}
+			var points []string
+			for _, pt := range o.polygon {
+				points = append(points, fmt.Sprintf("%v,%v", pt.x, pt.y))
+			}
+			p("/squirrel/master/obstacles/"+o.name+"/polygon", strings.Join(points, " "))
+			p("/squirrel/master/obstacles/"+o.name+"/height", o.height)
+			p("/squirrel/master/obstacles/"+o.name+"/loss", o.loss)
+		}
+		for file, n := range files {
+			p("/squirrel/master/obstacles_file", fmt.Sprintf("%s (%d obstacles)", file, n))
+		}
+		if t := pm.obstacles.terrain; t != nil {
+			p("/squirrel/master/terrain/file", fmt.Sprintf("%s (%d by %d cells of %v)", t.file, t.cols, t.rows, t.cell))
+			p("/squirrel/master/terrain/relative", t.relative)
+		}
+	}
+	if m := pm.distanceMatrix; m != nil {
+		p("/squirrel/master/distance_matrix/min_interval", m.minInterval)
+		p("/squirrel/master/distance_matrix/max_interval", m.maxInterval)
+	} else {
+		p("/squirrel/master/distance_matrix", "disabled")
+	}
+
+	for _, n := range conf.staticNodes {
+		dir := "/squirrel/master/nodes/" + n.addr.String()
+		p(dir+"/name", n.name)
+		if n.position != nil {
+			p(dir+"/position", fmt.Sprintf("%v,%v,%v", n.position.X, n.position.Y, n.position.Height))
+		}
+		p(dir+"/tags", strings.Join(n.tags, ","))
+		p(dir+"/fixed", n.fixed)
+		p(dir+"/group", n.group)
+		p(dir+"/gpx", n.gpx)
+		if n.txPower != nil {
+			p(dir+"/tx_power", *n.txPower)
+		}
+		if n.sensitivity != nil {
+			p(dir+"/sensitivity", *n.sensitivity)
+		}
+		p(dir+"/channel", n.channel)
+		if n.battery != nil {
+			p(dir+"/battery", *n.battery)
+		}
+	}
+	var addrs []string
+	for addr := range conf.nodeMetadata {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		var keys []string
+		for k := range conf.nodeMetadata[addr] {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p("/squirrel/master/node_metadata/"+addr+"/"+k, conf.nodeMetadata[addr][k])
+		}
+	}
+	if conf.positionsFile != "" {
+		p("/squirrel/master/positions_file", conf.positionsFile)
+	}
+	if conf.controlListen != "" {
+		p("/squirrel/master/control_listen", conf.controlListen)
+	}
+	if conf.grpcListen != "" {
+		p("/squirrel/master/grpc_listen", conf.grpcListen)
+	}
+	p("/squirrel/master/mobility_time_scale", conf.mobilityTimeScale)
+	p("/squirrel/master/mobility_paused", conf.mobilityPaused)
+	if t := conf.tls; t != nil {
+		p("/squirrel/master/tls/cert", t.cert)
+		p("/squirrel/master/tls/key", t.key)
+		p("/squirrel/master/tls/client_ca", t.clientCA)
+	}
+	for _, o := range conf.linkOverrides {
+		dir := "/squirrel/master/link_overrides/" + o.name
+		p(dir+"/nodes", o.a+","+o.b)
+		p(dir+"/loss", o.loss)
+		if o.connected != nil {
+			p(dir+"/connected", *o.connected)
+		}
+		p(dir+"/symmetric", o.symmetric)
+		p(dir+"/delay", o.delay)
+		p(dir+"/jitter", o.jitter)
+		p(dir+"/rate", o.rate)
+		p(dir+"/queue", o.queue)
+		if o.duplicate != nil {
+			p(dir+"/duplicate", *o.duplicate)
+		}
+		if o.reorder != nil {
+			p(dir+"/reorder", *o.reorder)
+		}
+		p(dir+"/reorder_delay", o.reorderDelay)
+	}
+	p("/squirrel/master/link_rate", conf.linkRate)
+	p("/squirrel/master/link_queue", conf.linkQueue)
+	p("/squirrel/master/link_duplicate", conf.linkDuplicate)
+	p("/squirrel/master/link_reorder", conf.linkReorder)
+	p("/squirrel/master/link_reorder_delay", conf.linkReorderDelay)
+	p("/squirrel/master/energy_tx_frame", conf.energy.txFrame)
+	p("/squirrel/master/energy_tx_byte", conf.energy.txByte)
+	p("/squirrel/master/energy_rx_frame", conf.energy.rxFrame)
+	p("/squirrel/master/energy_rx_byte", conf.energy.rxByte)
+	p("/squirrel/master/battery", conf.energy.battery)
+	p("/squirrel/master/battery_disable", conf.energy.disable)
+	p("/squirrel/master/log/level", logLevelNames[conf.log.level])
+	p("/squirrel/master/log/format", conf.log.format)
+	if conf.log.output != "" {
+		p("/squirrel/master/log/output", conf.log.output)
+	} else {
+		p("/squirrel/master/log/output", "stdout")
+	}
+	p("/squirrel/master/log/max_size", conf.log.maxSize)
+	p("/squirrel/master/log/max_backups", conf.log.maxBackups)
+	if e := conf.positionExport; e != nil {
+		p("/squirrel/master/position_export/path", e.path)
+		p("/squirrel/master/position_export/format", e.format)
+		p("/squirrel/master/position_export/interval", e.interval)
+	}
+}
+
+// printEtcdNode writes entries under node, with keys relative to it, to w.
+func printEtcdNode(w io.Writer, title string, node *etcd.Node) {
+	if node == nil {
+		fmt.Fprintf(w, "%-46s %v\n", title, "(none)")
+		return
+	}
+	fmt.Fprintf(w, "%-46s %v\n", title, node.Key)
+	var walk func(n *etcd.Node, prefix string)
+	walk = func(n *etcd.Node, prefix string) {
+		children := append(etcd.Nodes(nil), n.Nodes...)
+		sort.Slice(children, func(i, j int) bool { return children[i].Key < children[j].Key })
+		for _, child := range children {
+			name := prefix + path.Base(child.Key)
+			if child.Dir {
+				walk(child, name+"/")
+			} else {
+				fmt.Fprintf(w, "    %-42s %v\n", name, child.Value)
+			}
+		}
+	}
+	walk(node, "")
+}
@@ -0,0 +1,614 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+const speedOfLight = 299792458 // m/s
+
+// pathLossModel is how much a signal at frequency in Hz is attenuated on its
+// way from tx to rx, d meters apart.
+type pathLossModel interface {
+	// loss returns path loss in dB.
+	loss(tx, rx squirrel.Position, d, frequency float64) float64
+
+	// maxRange returns a distance beyond which path loss is more than loss
+	// wherever nodes are, or +Inf if there's none.
+	maxRange(loss, frequency float64) float64
+}
+
+// freeSpaceLoss returns free-space path loss in dB over d meters at
+// frequency in Hz.
+func freeSpaceLoss(d, frequency float64) float64 {
+	return 20 * math.Log10(4*math.Pi*d*frequency/speedOfLight)
+}
+
+// freeSpaceRange is the inverse of freeSpaceLoss.
+func freeSpaceRange(loss, frequency float64) float64 {
+	return math.Pow(10, loss/20) * speedOfLight / (4 * math.Pi * frequency)
+}
+
+// deliveryProbability returns probability that a packet received at rxPower
+// (dBm) is delivered. It's 1/2 at sensitivity, and goes from 0 to 1 over a few
+// times transition (dB) around it; a transition of 0 makes it a hard
+// threshold.
+func deliveryProbability(rxPower, sensitivity, transition float64) float64 {
+	if transition <= 0 {
+		if rxPower >= sensitivity {
+			return 1
+		}
+		return 0
+	}
+	return 1 / (1 + math.Exp(-(rxPower-sensitivity)/transition))
+}
+
+// radioHelp documents parameters that all radio propagation Septembers have,
+// for ParametersHelp.
+const radioHelp = `
+  tx_power [Optional]:
+    Transmit power in dBm, including antenna gains other than those of
+    antennas below. Nodes with metadata "tx_power" use that instead, e.g.
+    set by /squirrel/master/nodes/<mac>/tx_power. Default: 20
+
+  sensitivity [Optional]:
+    Received power in dBm at which half of packets are delivered. Nodes with
+    metadata "sensitivity" use that instead. Default: -90
+
+  min_sensitivity [Optional]:
+    Lowest sensitivity of any node, for finding nodes that broadcasts can
+    reach; nodes more sensitive than this miss distant broadcasts. Default:
+    sensitivity
+
+  transition [Optional]:
+    Width in dB of the transition from no packets to all of them being
+    delivered around sensitivity; 0 makes sensitivity a hard threshold.
+    Default: 2
+
+  frequency [Optional]:
+    Carrier frequency in Hz. Default: 2.4e9
+
+  channels/<name>/frequency [Required]:
+    Carrier frequency in Hz of channel <name>, which nodes are on when their
+    metadata "channel" is <name>, e.g. set by
+    /squirrel/master/nodes/<mac>/channel. Nodes only receive and interfere
+    with nodes on the same channel; those without one, or with one not in
+    channels, are on the default channel at frequency.
+
+  obstruction/loss [Optional]:
+    dB added to path loss for each obstacle of master that blocks the path
+    between nodes, unless the obstacle has a loss of its own. Default: 0
+
+  obstruction/terrain [Optional]:
+    If true, knife-edge diffraction loss over the highest point of terrain
+    of master along the path between nodes is added to path loss, when
+    there's terrain. Default: true
+` + errorModelHelp + rateAdaptationHelp + antennaHelp + fadingHelp + shadowingHelp + interferenceHelp + macHelp + delayHelp + dopplerHelp
+
+type channelParameters struct {
+	Frequency float64 `etcd:"frequency,required"`
+}
+
+type obstructionParameters struct {
+	Loss    float64 `etcd:"loss" default:"0"`
+	Terrain bool    `etcd:"terrain" default:"true"`
+}
+
+// radioParameters are parameters that all radio propagation Septembers have,
+// besides those of their pathLossModel.
+type radioParameters struct {
+	txPower      float64
+	sensitivity  float64
+	lowest       string // min_sensitivity; sensitivity if empty
+	transition   float64
+	frequency    float64
+	channels     map[string]channelParameters
+	noiseFloor   float64
+	errors       errorModelParameters
+	rates        rateAdaptationParameters
+	obstruction  obstructionParameters
+	antennas     map[string]antennaParameters
+	antenna      string // default_antenna
+	fading       fadingParameters
+	shadowing    shadowingParameters
+	interference interferenceParameters
+	mac          macParameters
+	delay        delayParameters
+	doppler      dopplerParameters
+}
+
+// radioConfig is the parsed configuration of a radioSeptember. It's never
+// modified after being published, except for shadowing values and
+// transmissions in flight, which have their own locks.
+type radioConfig struct {
+	txPower     float64 // of nodes without metadata "tx_power"
+	sensitivity float64 // of nodes without metadata "sensitivity"
+	transition  float64
+	frequency   float64            // Hz, of the default channel
+	channels    map[string]float64 // frequencies by name
+	obstruction obstructionParameters
+	noiseFloor  float64     // dBm
+	errors      *errorModel // nil for sensitivity and transition
+	rates       *rateAdaptation
+	model       pathLossModel
+	antennas    *antennas
+	fading      *fading
+	shadowing   *shadowing
+	air         *airspace // nil if neither interference nor CSMA is enabled
+	delay       *packetDelay
+	doppler     *doppler
+	floor       float64 // dBm below which packets are never delivered to any node
+	margin      float64 // dB a packet can be received stronger than by path loss
+}
+
+func newRadioConfig(params radioParameters, model pathLossModel) (*radioConfig, error) {
+	if params.transition < 0 {
+		return nil, fmt.Errorf("transition cannot be negative (got %v)", params.transition)
+	}
+	a, err := newAntennas(params.antennas, params.antenna)
+	if err != nil {
+		return nil, err
+	}
+	e, err := newErrorModel(params.errors)
+	if err != nil {
+		return nil, err
+	}
+	rates, err := newRateAdaptation(params.rates, e)
+	if err != nil {
+		return nil, err
+	}
+	f, err := newFading(params.fading)
+	if err != nil {
+		return nil, err
+	}
+	sh, err := newShadowing(params.shadowing)
+	if err != nil {
+		return nil, err
+	}
+	air, err := newAirspace(params.interference, params.mac, params.transition, params.noiseFloor, e)
+	if err != nil {
+		return nil, err
+	}
+	delay, err := newPacketDelay(params.delay)
+	if err != nil {
+		return nil, err
+	}
+	dop, err := newDoppler(params.doppler, e)
+	if err != nil {
+		return nil, err
+	}
+	channels := make(map[string]float64, len(params.channels))
+	for name, ch := range params.channels {
+		if ch.Frequency <= 0 {
+			return nil, fmt.Errorf("channels/%s/frequency needs to be positive (got %v)", name, ch.Frequency)
+		}
+		channels[name] = ch.Frequency
+	}
+	lowest := params.sensitivity
+	if params.lowest != "" {
+		if lowest, err = strconv.ParseFloat(params.lowest, 64); err != nil {
+			return nil, fmt.Errorf("invalid min_sensitivity %q: %v", params.lowest, err)
+		}
+	}
+	c := &radioConfig{
+		txPower:     params.txPower,
+		sensitivity: params.sensitivity,
+		transition:  params.transition,
+		frequency:   params.frequency,
+		channels:    channels,
+		obstruction: params.obstruction,
+		noiseFloor:  params.noiseFloor,
+		errors:      e,
+		rates:       rates,
+		model:       model,
+		antennas:    a,
+		fading:      f,
+		shadowing:   sh,
+		air:         air,
+		delay:       delay,
+		doppler:     dop,
+	}
+	if e != nil {
+		c.floor = c.noiseFloor + e.threshold(rates != nil)
+	} else {
+		// delivery probability is below 1/1000 beyond 7 times transition
+		c.floor = lowest - 7*c.transition
+	}
+	c.margin = a.margin() + f.margin() + sh.margin()
+	return c, nil
+}
+
+// cutoff returns distance beyond which packets sent at txPower on frequency
+// are never delivered.
+func (c *radioConfig) cutoff(txPower, frequency float64) float64 {
+	return c.model.maxRange(txPower-c.floor+c.margin, frequency)
+}
+
+// probability returns probability that a packet of size bytes at rate (nil
+// for the configured one) received at rxPower in dBm is delivered to
+// destination.
+func (s *radioSeptember) probability(c *radioConfig, rxPower float64, destination, size int, rate *ofdmRate) float64 {
+	if c.errors != nil {
+		return c.errors.success(rate, rxPower-c.noiseFloor, size)
+	}
+	return deliveryProbability(rxPower, s.nodeValue(destination, "sensitivity", c.sensitivity), c.transition)
+}
+
+// radioSeptember delivers packets with a probability given by received power,
+// under a pathLossModel. Radio propagation Septembers only differ in how they
+// parse their pathLossModel.
+type radioSeptember struct {
+	parse           func(conf *etcd.Node) (*radioConfig, error)
+	help            string
+	positionManager squirrel.PositionManager
+	cartesian       func(squirrel.Position) squirrel.Position          // into meters, for how far nodes move
+	offset          func(from, to squirrel.Position) squirrel.Position // in meters, for where antennas point
+	obstacles       *obstacleMap                                       // of master; nil with other PositionManagers
+	random          squirrel.RandomSource                              // of master; nil for math/rand
+
+	config atomic.Value // *radioConfig
+}
+
+func (s *radioSeptember) ParametersHelp() string {
+	return s.help
+}
+
+func (s *radioSeptember) Configure(conf *etcd.Node) error {
+	c, err := s.parse(conf)
+	if err != nil {
+		return err
+	}
+	s.config.Store(c)
+	return nil
+}
+
+// Reconfigure applies new parameters from the next packet on.
+func (s *radioSeptember) Reconfigure(conf *etcd.Node) error {
+	return s.Configure(conf)
+}
+
+func (s *radioSeptember) SetRandomSource(random squirrel.RandomSource) {
+	s.random = random
+}
+
+// rand returns the source of random numbers of the link from source to
+// destination.
+func (s *radioSeptember) rand(source, destination int) squirrel.Rand {
+	return randOf(s.random, source, destination)
+}
+
+func (s *radioSeptember) Initialize(positionManager squirrel.PositionManager) {
+	s.positionManager = positionManager
+	s.cartesian = func(pos squirrel.Position) squirrel.Position { return pos }
+	s.offset = func(from, to squirrel.Position) squirrel.Position {
+		return squirrel.Position{X: to.X - from.X, Y: to.Y - from.Y, Height: to.Height - from.Height}
+	}
+	if p, ok := positionManager.(*PositionManager); ok {
+		s.cartesian = p.cartesian
+		s.offset = p.offset
+		s.obstacles = p.obstacles
+	}
+}
+
+// nodeValue returns metadata key of node at index as a number, or fallback if
+// it's not set or not a number.
+func (s *radioSeptember) nodeValue(index int, key string, fallback float64) float64 {
+	value, ok := s.positionManager.GetMetadata(index, key)
+	if !ok || value == "" {
+		return fallback
+	}
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fallback
+	}
+	return v
+}
+
+// channelOf returns name of the channel that node at index is on, and its
+// frequency in Hz. Nodes without metadata "channel" are on the default
+// channel, named "".
+func (s *radioSeptember) channelOf(c *radioConfig, index int) (name string, frequency float64) {
+	name, _ = s.positionManager.GetMetadata(index, "channel")
+	if f, ok := c.channels[name]; ok {
+		return name, f
+	}
+	return name, c.frequency
+}
+
+// received returns power in dBm that destination receives from source at.
+// With fading, it's drawn for each call.
+func (s *radioSeptember) received(c *radioConfig, source, destination int) (rxPower float64, ok bool) {
+	if rxPower, ok = s.meanReceived(c, source, destination); ok {
+		rxPower += c.fading.draw(s.rand(source, destination))
+	}
+	return
+}
+
+// meanReceived returns power in dBm that destination receives from source at
+// before fading, including gains of antennas of both towards each other, on
+// the channel of source.
+func (s *radioSeptember) meanReceived(c *radioConfig, source, destination int) (rxPower float64, ok bool) {
+	tx, err1 := s.positionManager.Get(source)
+	rx, err2 := s.positionManager.Get(destination)
+	if err1 != nil || err2 != nil {
+		return 0, false
+	}
+	d := s.positionManager.Distance(source, destination)
+	_, frequency := s.channelOf(c, source)
+	rxPower = s.nodeValue(source, "tx_power", c.txPower) - c.model.loss(tx, rx, d, frequency)
+	if c.shadowing != nil {
+		// drawn from the same source either way, as it's the same either way
+		a, b := source, destination
+		if a > b {
+			a, b = b, a
+		}
+		rxPower += c.shadowing.get(source, destination, s.cartesian(tx), s.cartesian(rx), s.rand(a, b))
+	}
+	if c.antennas != nil {
+		rxPower += s.antennaGain(c, source, tx, rx) + s.antennaGain(c, destination, rx, tx)
+	}
+	if s.obstacles != nil {
+		rxPower -= s.obstacles.attenuation(tx, rx, c.obstruction.Loss)
+		if t := s.obstacles.terrain; t != nil && c.obstruction.Terrain {
+			h, at := t.obstruction(t.absolute(tx), t.absolute(rx))
+			rxPower -= diffractionLoss(h, at, d, speedOfLight/frequency)
+		}
+	}
+	return rxPower, true
+}
+
+// antennaGain returns gain of antenna of node at index, at from, towards to.
+func (s *radioSeptember) antennaGain(c *radioConfig, index int, from, to squirrel.Position) float64 {
+	a := c.antennas.of(s.positionManager, index)
+	if a == nil {
+		return 0
+	}
+	o, err := s.positionManager.GetOrientation(index)
+	if err != nil {
+		return 0
+	}
+	return a.gainTowards(o, s.offset(from, to))
+}
+
+// delivers decides whether a frame of size bytes at rate from source, on the
+// air as t while others overlap with it, is delivered to destination. t is
+// nil without interference or CSMA. Only nodes on the channel of source can
+// receive it, and interfere with it.
+func (s *radioSeptember) delivers(c *radioConfig, t *transmission, others []*transmission, source, destination, size int, rate *ofdmRate) bool {
+	channel, _ := s.channelOf(c, source)
+	if other, _ := s.channelOf(c, destination); other != channel {
+		return false
+	}
+	rxPower, ok := s.received(c, source, destination)
+	if ok && c.doppler != nil {
+		_, frequency := s.channelOf(c, source)
+		rxPower -= c.doppler.loss(s.radial(source, destination), frequency, size, rate)
+	}
+	if !ok || s.rand(source, destination).Float64() >= s.probability(c, rxPower, destination, size, rate) {
+		return false
+	}
+	if t == nil || t.receptions == nil {
+		return true
+	}
+	var interference float64
+	for _, o := range others {
+		if o.source == destination {
+			// it's transmitting, and can't receive meanwhile
+			return false
+		}
+		if other, _ := s.channelOf(c, o.source); other != channel {
+			continue
+		}
+		if i, ok := s.received(c, o.source, destination); ok {
+			interference += dBmToMilliwatts(i)
+		}
+	}
+	return c.air.receive(t, destination, dBmToMilliwatts(rxPower), interference, streamOf(s.random, streamInterference, source, destination))
+}
+
+// transmit puts a frame of size bytes at rate from source on the air, if
+// there's interference or CSMA, and returns other transmissions overlapping
+// with it, whose receptions on the same channel it interferes with. It
+// returns false if CSMA drops the frame, which only senses the same channel.
+func (s *radioSeptember) transmit(c *radioConfig, source, size int, rate *ofdmRate) (t *transmission, others []*transmission, ok bool) {
+	if c.air == nil {
+		return nil, nil, true
+	}
+	channel, _ := s.channelOf(c, source)
+	t, others, ok = c.air.transmit(source, size, rate, func(other int) bool {
+		if name, _ := s.channelOf(c, other); name != channel {
+			return false
+		}
+		p, ok := s.received(c, other, source)
+		return ok && p >= c.air.csma.carrierSense
+	}, streamOf(s.random, streamCSMA, source, source))
+	if !ok || !c.air.interference || len(others) == 0 {
+		return
+	}
+	receivers := c.air.receivers(others)
+	power := make(map[int]float64, len(receivers))
+	for _, r := range receivers {
+		if r == source {
+			power[r] = math.Inf(1)
+		} else if name, _ := s.channelOf(c, r); name != channel {
+			continue
+		} else if p, ok := s.received(c, source, r); ok {
+			power[r] = dBmToMilliwatts(p)
+		}
+	}
+	c.air.interfere(others, power)
+	return
+}
+
+func (s *radioSeptember) SendUnicast(source int, destination int, size int) bool {
+	return s.Unicast(&squirrel.Packet{Source: source, Size: size}, destination)
+}
+
+// Unicast decides on packet as SendUnicast does, as a stage of a chain of
+// Septembers. It notes "rate", in Mb/s, if the packet is sent at one of its
+// own.
+func (s *radioSeptember) Unicast(packet *squirrel.Packet, destination int) bool {
+	source, size := packet.Source, packet.Size
+	if !s.positionManager.IsEnabled(source) || !s.positionManager.IsEnabled(destination) {
+		return false
+	}
+	c := s.config.Load().(*radioConfig)
+	var rate *ofdmRate
+	if c.rates != nil {
+		rate = c.rates.choose(source, destination, s.rand(source, destination))
+	} else if c.errors != nil && c.errors.target > 0 {
+		if p, ok := s.meanReceived(c, source, destination); ok {
+			rate = c.errors.pick(p-c.noiseFloor, size)
+		}
+	}
+	if rate != nil {
+		packet.Note("rate", c.errors.bitrate(rate)/1e6)
+	}
+	t, others, ok := s.transmit(c, source, size, rate)
+	delivered := ok && s.delivers(c, t, others, source, destination, size, rate)
+	if c.rates != nil {
+		c.rates.report(source, destination, rate, delivered)
+	}
+	return delivered
+}
+
+func (s *radioSeptember) SendBroadcast(source int, size int, underlying []int) []int {
+	return s.SendBroadcastPacket(&squirrel.Packet{Source: source, Size: size}, underlying)
+}
+
+// SendUnicastPacket is Unicast, so that notes are kept in packet.
+func (s *radioSeptember) SendUnicastPacket(packet *squirrel.Packet, destination int) bool {
+	return s.Unicast(packet, destination)
+}
+
+// SendBroadcastPacket is SendBroadcast, noting "rate" in packet as Unicast
+// does.
+func (s *radioSeptember) SendBroadcastPacket(packet *squirrel.Packet, underlying []int) []int {
+	c := s.config.Load().(*radioConfig)
+	_, frequency := s.channelOf(c, packet.Source)
+	cutoff := c.cutoff(s.nodeValue(packet.Source, "tx_power", c.txPower), frequency)
+	return s.broadcast(c, packet, s.positionManager.EnabledWithin(packet.Source, cutoff), underlying)
+}
+
+// Broadcast decides on packet as SendBroadcast does, among recipients, as a
+// stage of a chain of Septembers. It notes "rate" as Unicast does.
+func (s *radioSeptember) Broadcast(packet *squirrel.Packet, recipients []int) []int {
+	return s.broadcast(s.config.Load().(*radioConfig), packet, recipients, recipients)
+}
+
+// broadcast puts packet on the air once, and returns those of candidates
+// that it's delivered to, in a sub-slice of underlying. underlying can be
+// candidates.
+func (s *radioSeptember) broadcast(c *radioConfig, packet *squirrel.Packet, candidates, underlying []int) []int {
+	source, size := packet.Source, packet.Size
+	var rate *ofdmRate
+	if c.rates != nil || c.errors != nil && c.errors.target > 0 {
+		// at the configured rate, and on the air for as long as it takes at it
+		rate = c.errors.rate
+		packet.Note("rate", c.errors.bitrate(rate)/1e6)
+	}
+	t, others, ok := s.transmit(c, source, size, rate)
+	if !ok {
+		return underlying[:0]
+	}
+	count := 0
+	for _, id := range candidates {
+		if s.delivers(c, t, others, source, id, size, rate) {
+			underlying[count] = id
+			count++
+		}
+	}
+	if c.rates != nil {
+		c.rates.broadcast(source, underlying[:count])
+	}
+	return underlying[:count]
+}
+
+// confirmation returns a function that tells whether the latest frame from
+// source is still decoded by destination, since a frame that starts later
+// can corrupt it while it's on the air.
+func (s *radioSeptember) confirmation(source, destination int) func() bool {
+	c := s.config.Load().(*radioConfig)
+	if c.air == nil {
+		return nil
+	}
+	return c.air.confirm(c.air.latestOf(source), destination)
+}
+
+// Delay returns delay of a delivered packet, as configured in delay, plus
+// how long until it's fully on the air with interference or CSMA, or how
+// long it takes at its rate with only rate adaptation.
+func (s *radioSeptember) Delay(source int, destination int, size int) (delay time.Duration) {
+	c := s.config.Load().(*radioConfig)
+	if c.air != nil {
+		delay = c.air.delay(source)
+	} else if c.rates != nil {
+		delay = c.rates.airtime(source, destination, size)
+	}
+	if c.delay != nil {
+		delay += c.delay.get(s.positionManager.Distance(source, destination), s.rand(source, destination))
+	}
+	return
+}
+
+// LinkQuality tells received power and SNR on the link before fading,
+// shadowing included, the rate that unicast packets would be sent at now,
+// and the probability that they are lost at it, without interference.
+func (s *radioSeptember) LinkQuality(source int, destination int) (q squirrel.LinkQuality, ok bool) {
+	if !s.positionManager.IsEnabled(source) || !s.positionManager.IsEnabled(destination) {
+		return q, false
+	}
+	c := s.config.Load().(*radioConfig)
+	if q.RSSI, ok = s.meanReceived(c, source, destination); !ok {
+		return q, false
+	}
+	q.SNR = q.RSSI - c.noiseFloor
+	var rate *ofdmRate
+	if c.rates != nil {
+		rate = c.rates.best(source, destination)
+	} else if c.errors != nil && c.errors.target > 0 {
+		rate = c.errors.pick(q.SNR, squirrel.LinkQualitySize)
+	} else if c.errors != nil {
+		rate = c.errors.rate
+	}
+	if rate != nil {
+		q.Rate = c.errors.bitrate(rate) / 1e6
+	}
+	rxPower := q.RSSI
+	if c.doppler != nil {
+		_, frequency := s.channelOf(c, source)
+		penalty := c.doppler.loss(s.radial(source, destination), frequency, squirrel.LinkQualitySize, rate)
+		rxPower, q.SNR = rxPower-penalty, q.SNR-penalty
+	}
+	q.Loss = 1 - s.probability(c, rxPower, destination, squirrel.LinkQualitySize, rate)
+	channel, _ := s.channelOf(c, source)
+	if other, _ := s.channelOf(c, destination); other != channel {
+		q.Loss = 1
+	}
+	return q, true
+}
+
+// DelayBroadcast is Delay for each of recipients, with the part for getting
+// the frame on the air worked out once, since all of them hear the same
+// transmission at the broadcast rate.
+func (s *radioSeptember) DelayBroadcast(source int, size int, recipients []int, delays []time.Duration) {
+	c := s.config.Load().(*radioConfig)
+	var air time.Duration
+	if c.air != nil {
+		air = c.air.delay(source)
+	} else if c.rates != nil {
+		air = time.Duration(float64(size) * 8 / c.errors.bitrate(c.errors.rate) * float64(time.Second))
+	}
+	for i, id := range recipients {
+		delays[i] = air
+		if c.delay != nil {
+			delays[i] += c.delay.get(s.positionManager.Distance(source, id), s.rand(source, id))
+		}
+	}
+}
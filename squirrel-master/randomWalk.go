@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/common"
+)
+
+type randomWalkParameters struct {
+	Step              float64       `etcd:"step" default:"1"`
+	VerticalStep      float64       `etcd:"vertical_step" default:"0"`
+	DirectionInterval time.Duration `etcd:"direction_interval" default:"1s"`
+	Interval          time.Duration `etcd:"interval" default:"100ms"`
+	Seed              int64         `etcd:"seed" default:"0"`
+	Arena             mobilityArena `etcd:"arena"`
+}
+
+func (p *randomWalkParameters) check() error {
+	if p.Step <= 0 {
+		return fmt.Errorf("random-walk needs a positive step (got %v)", p.Step)
+	}
+	if p.VerticalStep < 0 {
+		return fmt.Errorf("vertical_step cannot be negative (got %v)", p.VerticalStep)
+	}
+	if p.Interval <= 0 || p.DirectionInterval <= 0 {
+		return fmt.Errorf("interval and direction_interval need to be positive (got %v and %v)", p.Interval, p.DirectionInterval)
+	}
+	return p.Arena.check()
+}
+
+type walkNode struct {
+	pos         squirrel.Position
+	heading     float64 // radians, counterclockwise from X axis
+	climb       float64 // -1 to 1, fraction of vertical_step
+	changeAfter time.Time
+}
+
+// bounce reflects v back into [0, max] like a wall would, and returns whether
+// it did.
+func bounce(v *float64, max float64) bool {
+	switch {
+	case *v < 0:
+		*v = -*v
+	case *v > max:
+		*v = 2*max - *v
+	default:
+		return false
+	}
+	// a step longer than the arena could cross the other wall as well
+	*v = math.Max(0, math.Min(max, *v))
+	return true
+}
+
+// randomWalk moves each node by step in a random direction on each update,
+// picking a new direction every direction_interval. By default, nodes bounce
+// off boundaries of the arena.
+type randomWalk struct {
+	positionManager squirrel.PositionManager
+
+	params randomWalkParameters
+	rand   *rand.Rand
+	ticker squirrel.Ticker
+	mu     sync.Mutex // params, rand, ticker
+}
+
+func newRandomWalk() squirrel.MobilityManager {
+	return &randomWalk{}
+}
+
+func (m *randomWalk) ParametersHelp() string {
+	return `
+  arena/width, arena/height [Required]:
+    Size of the arena, from (0, 0), that nodes move in. Nodes start at random
+    points in the arena.
+
+  step [Optional]:
+    Distance a node moves on each update. Default: 1
+` + arenaAltitudeHelp + `
+  vertical_step [Optional]:
+    Largest change of Height on each update; each node climbs or descends
+    by a random fraction of it, picked with direction. Default: 0
+` + arenaBoundaryHelp + ` Default: bounce
+
+  direction_interval [Optional]:
+    How often a node picks a new random direction, e.g. 5s. Default: 1s
+
+  interval [Optional]:
+    How often positions are updated. Default: 100ms
+
+  seed [Optional]:
+    Seed of random numbers, for reproducible runs. Default: 0 (current time;
+    the seed is logged, and reported by GET /mobility of the control API)
+    `
+}
+
+func (m *randomWalk) Configure(conf *etcd.Node) error {
+	if err := common.DecodeParameters(conf, &m.params); err != nil {
+		return err
+	}
+	if err := m.params.check(); err != nil {
+		return err
+	}
+	m.rand = newRand("random-walk", m.params.Seed)
+	return nil
+}
+
+// Reconfigure applies new parameters from the next update.
+func (m *randomWalk) Reconfigure(conf *etcd.Node) error {
+	var params randomWalkParameters
+	if err := common.DecodeParameters(conf, &params); err != nil {
+		return err
+	}
+	if err := params.check(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if params.Seed != m.params.Seed {
+		m.rand = newRand("random-walk", params.Seed)
+	}
+	if m.ticker != nil && params.Interval != m.params.Interval {
+		m.ticker.Reset(params.Interval)
+	}
+	m.params = params
+	return nil
+}
+
+func (m *randomWalk) Initialize(positionManager squirrel.PositionManager) {
+	m.positionManager = positionManager
+	m.run()
+}
+
+// Stop stops updating positions.
+func (m *randomWalk) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ticker != nil {
+		m.ticker.Stop()
+	}
+}
+
+func (m *randomWalk) step(nodes map[int]*walkNode, now time.Time) (updates []squirrel.PositionUpdate, events []squirrel.MobilityEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	enabled := make(map[int]bool)
+	for _, index := range m.positionManager.Enabled() {
+		enabled[index] = true
+		n, ok := nodes[index]
+		if !ok {
+			n = &walkNode{pos: m.params.Arena.random(m.rand)}
+			nodes[index] = n
+		}
+		if !now.Before(n.changeAfter) {
+			n.heading = m.rand.Float64() * 2 * math.Pi
+			n.climb = 2*m.rand.Float64() - 1
+			// the first direction of a node is not a change
+			if !n.changeAfter.IsZero() {
+				events = append(events, squirrel.MobilityEvent{Kind: squirrel.DirectionChanged, Source: "random-walk", Index: index, Time: now})
+			}
+			n.changeAfter = now.Add(m.params.DirectionInterval)
+		}
+		n.pos.X += m.params.Step * math.Cos(n.heading)
+		n.pos.Y += m.params.Step * math.Sin(n.heading)
+		if m.params.Arena.is3D() {
+			n.pos.Height += m.params.VerticalStep * n.climb
+		}
+		x, y, height, _ := m.params.Arena.keepIn(&n.pos, boundaryBounce, m.rand)
+		if x {
+			n.heading = math.Pi - n.heading
+		}
+		if y {
+			n.heading = -n.heading
+		}
+		if height {
+			n.climb = -n.climb
+		}
+		updates = append(updates, squirrel.PositionUpdate{Index: index, Position: n.pos})
+	}
+	for index := range nodes {
+		if !enabled[index] {
+			delete(nodes, index)
+		}
+	}
+	return
+}
+
+func (m *randomWalk) run() {
+	nodes := make(map[int]*walkNode)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ticker = clock.Every(m.params.Interval, func(now time.Time) {
+		updates, events := m.step(nodes, now)
+		if len(updates) > 0 {
+			// fixed nodes are refused; they just stay where they are
+			if err := m.positionManager.SetBatch(updates); err != nil {
+				logger.debugf("random-walk: %v", err)
+			}
+		}
+		for _, e := range events {
+			mobilityEvents.Publish(e)
+		}
+	})
+}
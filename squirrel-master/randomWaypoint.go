@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/common"
+)
+
+type randomWaypointParameters struct {
+	MinSpeed  float64       `etcd:"min_speed" default:"1"`
+	MaxSpeed  float64       `etcd:"max_speed" default:"5"`
+	PauseTime time.Duration `etcd:"pause_time" default:"0s"`
+	ClimbRate float64       `etcd:"climb_rate" default:"0"`
+	Interval  time.Duration `etcd:"interval" default:"100ms"`
+	Seed      int64         `etcd:"seed" default:"0"`
+	Arena     mobilityArena `etcd:"arena"`
+}
+
+func (p *randomWaypointParameters) check() error {
+	if p.MinSpeed <= 0 || p.MaxSpeed < p.MinSpeed {
+		return fmt.Errorf("random-waypoint needs 0 < min_speed <= max_speed (got %v and %v)", p.MinSpeed, p.MaxSpeed)
+	}
+	if p.PauseTime < 0 || p.ClimbRate < 0 {
+		return fmt.Errorf("pause_time and climb_rate cannot be negative (got %v and %v)", p.PauseTime, p.ClimbRate)
+	}
+	if p.Interval <= 0 {
+		return fmt.Errorf("interval needs to be positive (got %v)", p.Interval)
+	}
+	return p.Arena.check()
+}
+
+// waypointNode is where a node is heading under random-waypoint.
+type waypointNode struct {
+	pos        squirrel.Position
+	waypoint   squirrel.Position
+	speed      float64
+	pauseUntil time.Time
+}
+
+// advance moves n toward its waypoint for elapsed, unless it's pausing. It
+// returns whether n has arrived at the waypoint, in which case the caller
+// picks the next leg.
+func (n *waypointNode) advance(now time.Time, elapsed time.Duration) (arrived bool) {
+	if now.Before(n.pauseUntil) {
+		return false
+	}
+	dx, dy, dh := n.waypoint.X-n.pos.X, n.waypoint.Y-n.pos.Y, n.waypoint.Height-n.pos.Height
+	d := math.Sqrt(dx*dx + dy*dy + dh*dh)
+	if travel := n.speed * elapsed.Seconds(); travel < d {
+		n.pos.X += dx * travel / d
+		n.pos.Y += dy * travel / d
+		n.pos.Height += dh * travel / d
+		return false
+	}
+	n.pos = n.waypoint
+	return true
+}
+
+// nextLeg picks the next waypoint and speed of n, after pausing until
+// pauseUntil. If climbRate is positive, the leg is slowed down so that Height
+// doesn't change faster than it.
+func (n *waypointNode) nextLeg(arena *mobilityArena, minSpeed, maxSpeed, climbRate float64, pauseUntil time.Time, r *rand.Rand) {
+	n.waypoint = arena.random(r)
+	n.speed = minSpeed + r.Float64()*(maxSpeed-minSpeed)
+	n.pauseUntil = pauseUntil
+	if dh := math.Abs(n.waypoint.Height - n.pos.Height); climbRate > 0 && dh > 0 {
+		dx, dy := n.waypoint.X-n.pos.X, n.waypoint.Y-n.pos.Y
+		d := math.Sqrt(dx*dx + dy*dy + dh*dh)
+		n.speed = math.Min(n.speed, climbRate*d/dh)
+	}
+}
+
+// randomWaypoint is the Random Waypoint model: each node moves in a straight
+// line to a random point in the arena at a random speed, pauses there, and
+// then picks the next point.
+type randomWaypoint struct {
+	positionManager squirrel.PositionManager
+
+	params randomWaypointParameters
+	rand   *rand.Rand
+	ticker squirrel.Ticker
+	mu     sync.Mutex // params, rand, ticker
+}
+
+func newRandomWaypoint() squirrel.MobilityManager {
+	return &randomWaypoint{}
+}
+
+func (m *randomWaypoint) ParametersHelp() string {
+	return `
+  arena/width, arena/height [Required]:
+    Size of the arena, from (0, 0), that nodes move in. Nodes start at random
+    points in the arena.
+
+  min_speed, max_speed [Optional]:
+    Range of speed, in units per second, that is picked for each leg.
+    Default: 1 and 5
+
+  pause_time [Optional]:
+    How long a node stays at a waypoint before moving on, e.g. 2s. Default: 0s
+` + arenaAltitudeHelp + `
+  climb_rate [Optional]:
+    Fastest change of Height, in units per second; legs that climb or
+    descend steeper are flown slower. Default: 0 (no limit)
+
+  interval [Optional]:
+    How often positions are updated. Default: 100ms
+
+  seed [Optional]:
+    Seed of random numbers, for reproducible runs. Default: 0 (current time;
+    the seed is logged, and reported by GET /mobility of the control API)
+    `
+}
+
+func (m *randomWaypoint) Configure(conf *etcd.Node) error {
+	if err := common.DecodeParameters(conf, &m.params); err != nil {
+		return err
+	}
+	if err := m.params.check(); err != nil {
+		return err
+	}
+	m.rand = newRand("random-waypoint", m.params.Seed)
+	return nil
+}
+
+// Reconfigure applies new parameters. Nodes keep moving to their current
+// waypoints; new speeds and pause time apply from the next leg.
+func (m *randomWaypoint) Reconfigure(conf *etcd.Node) error {
+	var params randomWaypointParameters
+	if err := common.DecodeParameters(conf, &params); err != nil {
+		return err
+	}
+	if err := params.check(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if params.Seed != m.params.Seed {
+		m.rand = newRand("random-waypoint", params.Seed)
+	}
+	if m.ticker != nil && params.Interval != m.params.Interval {
+		m.ticker.Reset(params.Interval)
+	}
+	m.params = params
+	return nil
+}
+
+func (m *randomWaypoint) Initialize(positionManager squirrel.PositionManager) {
+	m.positionManager = positionManager
+	m.run()
+}
+
+// Stop stops updating positions.
+func (m *randomWaypoint) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ticker != nil {
+		m.ticker.Stop()
+	}
+}
+
+func (m *randomWaypoint) step(nodes map[int]*waypointNode, now time.Time, elapsed time.Duration) (updates []squirrel.PositionUpdate, events []squirrel.MobilityEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	enabled := make(map[int]bool)
+	for _, index := range m.positionManager.Enabled() {
+		enabled[index] = true
+		n, ok := nodes[index]
+		if !ok {
+			n = &waypointNode{pos: m.params.Arena.random(m.rand)}
+			n.nextLeg(&m.params.Arena, m.params.MinSpeed, m.params.MaxSpeed, m.params.ClimbRate, now, m.rand)
+			nodes[index] = n
+		} else if now.Before(n.pauseUntil) {
+			continue
+		} else if n.advance(now, elapsed) {
+			n.nextLeg(&m.params.Arena, m.params.MinSpeed, m.params.MaxSpeed, m.params.ClimbRate, now.Add(m.params.PauseTime), m.rand)
+			events = append(events, squirrel.MobilityEvent{Kind: squirrel.WaypointReached, Source: "random-waypoint", Index: index, Time: now})
+		}
+		updates = append(updates, squirrel.PositionUpdate{Index: index, Position: n.pos})
+	}
+	for index := range nodes {
+		if !enabled[index] {
+			delete(nodes, index)
+		}
+	}
+	return
+}
+
+func (m *randomWaypoint) run() {
+	nodes := make(map[int]*waypointNode)
+	last := clock.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ticker = clock.Every(m.params.Interval, func(now time.Time) {
+		updates, events := m.step(nodes, now, now.Sub(last))
+		if len(updates) > 0 {
+			// fixed nodes are refused; they just stay where they are
+			if err := m.positionManager.SetBatch(updates); err != nil {
+				logger.debugf("random-waypoint: %v", err)
+			}
+		}
+		// published after nodes are moved, so that subscribers see them there
+		for _, e := range events {
+			mobilityEvents.Publish(e)
+		}
+		last = now
+	})
+}
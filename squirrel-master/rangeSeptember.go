@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync/atomic"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/common"
+)
+
+type rangeParameters struct {
+	MaxRange float64 `etcd:"max_range,required"`
+}
+
+// rangeSeptember delivers packets between nodes no farther apart than a
+// maximum range, and drops all others. On its own it's a unit disk model; as
+// a stage of a chain of Septembers, it bounds whatever later stages model,
+// and as the first stage, it picks recipients of broadcast packets from the
+// spatial index, so that later stages only consider nodes within range, e.g.
+// in scenarios with many nodes.
+type rangeSeptember struct {
+	positionManager squirrel.PositionManager
+
+	maxRange atomic.Value // float64, in meters
+}
+
+func newRangeSeptember() squirrel.September {
+	return &rangeSeptember{}
+}
+
+func (s *rangeSeptember) ParametersHelp() string {
+	return `
+  max_range [Required]:
+    Distance in meters (also with wgs84 coordinates) beyond which packets are
+    dropped, regardless of other stages of a chain. Packets within it are
+    delivered, unless other stages drop them.
+    `
+}
+
+func parseRange(conf *etcd.Node) (float64, error) {
+	var params rangeParameters
+	if err := common.DecodeParameters(conf, &params); err != nil {
+		return 0, err
+	}
+	if params.MaxRange <= 0 || math.IsInf(params.MaxRange, 0) {
+		return 0, fmt.Errorf("max_range needs to be positive and finite (got %v)", params.MaxRange)
+	}
+	return params.MaxRange, nil
+}
+
+func (s *rangeSeptember) Configure(conf *etcd.Node) error {
+	maxRange, err := parseRange(conf)
+	if err != nil {
+		return err
+	}
+	s.maxRange.Store(maxRange)
+	return nil
+}
+
+// Reconfigure changes the maximum range from the next packet on.
+func (s *rangeSeptember) Reconfigure(conf *etcd.Node) error {
+	return s.Configure(conf)
+}
+
+func (s *rangeSeptember) Initialize(positionManager squirrel.PositionManager) {
+	s.positionManager = positionManager
+}
+
+// inRange returns whether source and destination are enabled and no farther
+// apart than the maximum range.
+func (s *rangeSeptember) inRange(source, destination int) bool {
+	if !s.positionManager.IsEnabled(source) || !s.positionManager.IsEnabled(destination) {
+		return false
+	}
+	maxRange := s.maxRange.Load().(float64)
+	return s.positionManager.DistanceSq(source, destination) <= maxRange*maxRange
+}
+
+func (s *rangeSeptember) SendUnicast(source int, destination int, size int) bool {
+	return s.inRange(source, destination)
+}
+
+func (s *rangeSeptember) SendBroadcast(source int, size int, underlying []int) []int {
+	count := 0
+	for _, id := range s.positionManager.EnabledWithin(source, s.maxRange.Load().(float64)) {
+		underlying[count] = id
+		count++
+	}
+	return underlying[:count]
+}
+
+// LinkQuality tells whether nodes are within range, which never loses
+// packets.
+func (s *rangeSeptember) LinkQuality(source int, destination int) (squirrel.LinkQuality, bool) {
+	if s.positionManager == nil || !s.positionManager.IsEnabled(source) || !s.positionManager.IsEnabled(destination) {
+		return squirrel.LinkQuality{}, false
+	}
+	q := squirrel.LinkQuality{RSSI: math.NaN(), SNR: math.NaN()}
+	if !s.inRange(source, destination) {
+		q.Loss = 1
+	}
+	return q, true
+}
+
+// Unicast delivers packet only within range, as a stage of a chain of
+// Septembers.
+func (s *rangeSeptember) Unicast(packet *squirrel.Packet, destination int) bool {
+	return s.inRange(packet.Source, destination)
+}
+
+// Broadcast keeps those of recipients that are within range of source of
+// packet, as a stage of a chain of Septembers.
+func (s *rangeSeptember) Broadcast(packet *squirrel.Packet, recipients []int) []int {
+	count := 0
+	for _, id := range recipients {
+		if s.inRange(packet.Source, id) {
+			recipients[count] = id
+			count++
+		}
+	}
+	return recipients[:count]
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+type rateAdaptationParameters struct {
+	Enabled  bool          `etcd:"enabled" default:"false"`
+	Interval time.Duration `etcd:"interval" default:"100ms"`
+	EWMA     float64       `etcd:"ewma" default:"0.75"`
+	Sampling float64       `etcd:"sampling" default:"0.1"`
+}
+
+// rateAdaptationHelp documents rate adaptation parameters of radio
+// propagation Septembers, for ParametersHelp.
+const rateAdaptationHelp = `
+  rate_adaptation/enabled [Optional]:
+    If true, each link picks the rate of its unicast frames from those of
+    the kind of error_model/rate, with error_model being ber and its
+    selection fixed, the way Minstrel does: the one with
+    the highest throughput by recent delivery ratios, except for a share of
+    frames sent at other rates to find out how they do. Frames are on the air
+    (with interference or mac/csma) and delayed for as long as they take at
+    the rate picked. Broadcasts are sent at error_model/rate. Default: false
+
+  rate_adaptation/interval [Optional]:
+    How often delivery ratios are updated. Default: 100ms
+
+  rate_adaptation/ewma [Optional]:
+    Weight of previous delivery ratios when updating them, from 0 to 1.
+    Default: 0.75
+
+  rate_adaptation/sampling [Optional]:
+    Share of frames sent at other rates than the best one. Default: 0.1
+`
+
+// rateStats are statistics of frames sent at a rate over a link.
+type rateStats struct {
+	attempts  int
+	successes int
+	prob      float64 // EWMA of delivery ratio
+	known     bool    // whether prob has ever been updated
+}
+
+// linkRates is the state of rate adaptation of a link.
+type linkRates struct {
+	stats   []rateStats // by index in rates of errorModel
+	best    int
+	last    *ofdmRate // of the latest frame, unicast or broadcast
+	updated time.Time
+}
+
+// rateAdaptation picks rates of unicast frames on each link by recent
+// delivery ratios, like Minstrel, but without retries.
+type rateAdaptation struct {
+	errors   *errorModel
+	interval time.Duration
+	ewma     float64
+	sampling float64
+
+	links map[linkPair]*linkRates
+	mu    sync.Mutex // links
+}
+
+// newRateAdaptation returns nil if rates are not adapted.
+func newRateAdaptation(params rateAdaptationParameters, errors *errorModel) (*rateAdaptation, error) {
+	if !params.Enabled {
+		return nil, nil
+	}
+	if errors == nil || errors.target > 0 {
+		return nil, fmt.Errorf("rate_adaptation needs error_model/model to be ber, and error_model/selection fixed")
+	}
+	if params.Interval <= 0 {
+		return nil, fmt.Errorf("rate_adaptation/interval needs to be positive (got %v)", params.Interval)
+	}
+	if params.EWMA < 0 || params.EWMA > 1 || params.Sampling < 0 || params.Sampling > 1 {
+		return nil, fmt.Errorf("rate_adaptation/ewma and rate_adaptation/sampling need to be within 0 and 1 (got %v and %v)", params.EWMA, params.Sampling)
+	}
+	return &rateAdaptation{
+		errors:   errors,
+		interval: params.Interval,
+		ewma:     params.EWMA,
+		sampling: params.Sampling,
+		links:    make(map[linkPair]*linkRates),
+	}, nil
+}
+
+// link returns the state of link from source to destination. r.mu needs to
+// be held.
+func (r *rateAdaptation) link(source, destination int) *linkRates {
+	pair := linkPair{src: source, dst: destination}
+	l, ok := r.links[pair]
+	if !ok {
+		l = &linkRates{stats: make([]rateStats, len(r.errors.rates)), updated: time.Now()}
+		r.links[pair] = l
+	}
+	return l
+}
+
+// update folds delivery ratios since the last update into l, and picks its
+// best rate anew, if interval has passed.
+func (r *rateAdaptation) update(l *linkRates, now time.Time) {
+	if now.Sub(l.updated) < r.interval {
+		return
+	}
+	l.updated = now
+	best := -1.0
+	for i := range l.stats {
+		s := &l.stats[i]
+		if s.attempts > 0 {
+			ratio := float64(s.successes) / float64(s.attempts)
+			if s.known {
+				s.prob = r.ewma*s.prob + (1-r.ewma)*ratio
+			} else {
+				s.prob, s.known = ratio, true
+			}
+			s.attempts, s.successes = 0, 0
+		}
+		if throughput := r.errors.rates[i].mbps * s.prob; s.known && throughput > best {
+			best = throughput
+			l.best = i
+		}
+	}
+}
+
+// choose returns the rate of the next frame from source to destination,
+// sampling other rates by random.
+func (r *rateAdaptation) choose(source, destination int, random squirrel.Rand) *ofdmRate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l := r.link(source, destination)
+	r.update(l, time.Now())
+	last := l.best
+	if random.Float64() < r.sampling {
+		// only rates that could do better than the best one are worth trying
+		throughput := r.errors.rates[l.best].mbps * l.stats[l.best].prob
+		var candidates []int
+		for i := range r.errors.rates {
+			if i != l.best && r.errors.rates[i].mbps > throughput {
+				candidates = append(candidates, i)
+			}
+		}
+		if len(candidates) > 0 {
+			last = candidates[random.Intn(len(candidates))]
+		}
+	}
+	l.last = &r.errors.rates[last]
+	return l.last
+}
+
+// best returns the rate with the highest throughput on the link from source
+// to destination so far, without picking one for a frame.
+func (r *rateAdaptation) best(source, destination int) *ofdmRate {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return &r.errors.rates[r.link(source, destination).best]
+}
+
+// broadcast records that the latest frame from source to each of
+// destinations is a broadcast one, at the configured rate.
+func (r *rateAdaptation) broadcast(source int, destinations []int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, destination := range destinations {
+		r.link(source, destination).last = r.errors.rate
+	}
+}
+
+// report records whether a frame from source to destination at rate is
+// delivered.
+func (r *rateAdaptation) report(source, destination int, rate *ofdmRate, delivered bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l := r.link(source, destination)
+	for i := range l.stats {
+		if &r.errors.rates[i] == rate {
+			l.stats[i].attempts++
+			if delivered {
+				l.stats[i].successes++
+			}
+		}
+	}
+}
+
+// airtime returns how long the latest frame of size bytes from source to
+// destination takes to transmit at its rate.
+func (r *rateAdaptation) airtime(source, destination, size int) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l := r.link(source, destination)
+	if l.last == nil {
+		return 0
+	}
+	bitrate := r.errors.bitrate(l.last)
+	return time.Duration(float64(size) * 8 / bitrate * float64(time.Second))
+}
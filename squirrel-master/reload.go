@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// sameEtcdNode returns whether a and b have the same keys and values,
+// regardless of order of children and etcd indices.
+func sameEtcdNode(a, b *etcd.Node) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Key != b.Key || a.Value != b.Value || a.Dir != b.Dir || len(a.Nodes) != len(b.Nodes) {
+		return false
+	}
+	children := make(map[string]*etcd.Node, len(b.Nodes))
+	for _, node := range b.Nodes {
+		children[node.Key] = node
+	}
+	for _, node := range a.Nodes {
+		if !sameEtcdNode(node, children[node.Key]) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameMobilityAssignments(a, b []mobilityAssignment) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].equal(&b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameScenarioEvents is like sameMobilityAssignments, for events.
+func sameScenarioEvents(a, b []scenarioEvent) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		if !sameEtcdNode(x.parameters, y.parameters) {
+			return false
+		}
+		x.parameters, y.parameters = nil, nil
+		if !reflect.DeepEqual(x, y) {
+			return false
+		}
+	}
+	return true
+}
+
+// reconfigure applies parameters to a running MobilityManager or September
+// named name.
+func reconfigure(model interface{}, name string, parameters *etcd.Node) error {
+	r, ok := model.(squirrel.Reconfigurable)
+	if !ok {
+		return fmt.Errorf("%s does not support changing parameters at runtime; restart required", name)
+	}
+	return r.Reconfigure(parameters)
+}
+
+// reload applies changes from running to reloaded that can be applied at
+// runtime, and returns the configuration in effect along with errors for
+// changes that are not applied.
+func (master *Master) reload(running, reloaded config) (effective config, errs []error) {
+	effective = running
+	restart := func(name string, changed bool) {
+		if changed {
+			errs = append(errs, fmt.Errorf("%s is changed; restart required", name))
+		}
+	}
+	restart("master_ifce", running.uri != reloaded.uri)
+	restart("emulated_subnet", running.emulatedSubnet != reloaded.emulatedSubnet)
+	restart("september", running.september != reloaded.september)
+	restart("september_seed", running.septemberSeed != reloaded.septemberSeed)
+	restart("mobility_managers", !sameMobilityAssignments(running.mobilityAssignments, reloaded.mobilityAssignments))
+	restart("PositionManager configuration", !reflect.DeepEqual(running.positionManager, reloaded.positionManager))
+	restart("node_metadata", !reflect.DeepEqual(running.nodeMetadata, reloaded.nodeMetadata))
+	restart("nodes", !reflect.DeepEqual(running.staticNodes, reloaded.staticNodes))
+	restart("positions_file", running.positionsFile != reloaded.positionsFile)
+	restart("position_export", !reflect.DeepEqual(running.positionExport, reloaded.positionExport))
+	restart("events", !sameScenarioEvents(running.events, reloaded.events))
+	// pausing at runtime is done through the control API or SIGUSR2
+	restart("mobility_paused", running.mobilityPaused != reloaded.mobilityPaused)
+	restart("control_listen", running.controlListen != reloaded.controlListen)
+	restart("grpc_listen", running.grpcListen != reloaded.grpcListen)
+	restart("tls", !reflect.DeepEqual(running.tls, reloaded.tls))
+	// only level can be changed at runtime
+	runningLog, reloadedLog := running.log, reloaded.log
+	runningLog.level, reloadedLog.level = 0, 0
+	restart("log", runningLog != reloadedLog)
+
+	if running.mobilityTimeScale != reloaded.mobilityTimeScale {
+		scaleMobility(reloaded.mobilityTimeScale)
+		effective.mobilityTimeScale = reloaded.mobilityTimeScale
+	}
+	if running.log.level != reloaded.log.level {
+		logger.setLevel(reloaded.log.level)
+		effective.log.level = reloaded.log.level
+		logger.infof("log level is changed to %s", logLevelNames[reloaded.log.level])
+	}
+
+	// only changes in etcd are applied, so that a MobilityManager switched to
+	// through the control API or a scenario stays otherwise
+	if running.mobilityManager != reloaded.mobilityManager || !sameEtcdNode(running.mobilityManagerConfig, reloaded.mobilityManagerConfig) {
+		var err error
+		if _, name := master.primary(); name == reloaded.mobilityManager {
+			if err = master.reconfigurePrimary(reloaded.mobilityManagerConfig); err == nil {
+				logger.infof("MobilityManager %s is reconfigured", name)
+			}
+		} else {
+			err = master.switchMobilityManager(reloaded.mobilityManager, reloaded.mobilityManagerConfig)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			effective.mobilityManager = reloaded.mobilityManager
+			effective.mobilityManagerConfig = reloaded.mobilityManagerConfig
+		}
+	}
+	// as above, overrides changed through the control API stay unless they
+	// are changed in etcd too
+	if !reflect.DeepEqual(running.linkOverrides, reloaded.linkOverrides) {
+		if s, ok := master.september.(*linkOverrideSeptember); ok {
+			s.setOverrides(reloaded.linkOverrides)
+			effective.linkOverrides = reloaded.linkOverrides
+			logger.infof("link overrides are replaced (%d of them)", len(reloaded.linkOverrides))
+		}
+	}
+	if running.linkRate != reloaded.linkRate || running.linkQueue != reloaded.linkQueue {
+		master.throttle.set(reloaded.linkRate, reloaded.linkQueue)
+		effective.linkRate, effective.linkQueue = reloaded.linkRate, reloaded.linkQueue
+		logger.infof("link rate and queue are changed to %v bps and %d bytes", reloaded.linkRate, reloaded.linkQueue)
+	}
+	if running.linkDuplicate != reloaded.linkDuplicate || running.linkReorder != reloaded.linkReorder || running.linkReorderDelay != reloaded.linkReorderDelay {
+		master.impair.set(reloaded.linkDuplicate, reloaded.linkReorder, reloaded.linkReorderDelay)
+		effective.linkDuplicate, effective.linkReorder, effective.linkReorderDelay = reloaded.linkDuplicate, reloaded.linkReorder, reloaded.linkReorderDelay
+		logger.infof("link duplicate and reorder are changed to %v and %v, held back for %v", reloaded.linkDuplicate, reloaded.linkReorder, reloaded.linkReorderDelay)
+	}
+	if running.energy != reloaded.energy {
+		master.energy.set(reloaded.energy)
+		effective.energy = reloaded.energy
+		logger.infof("energy costs and battery are changed")
+	}
+	// as above, parameters changed through the control API stay unless they
+	// are changed in etcd too
+	if running.september == reloaded.september && !sameEtcdNode(running.septemberConfig, reloaded.septemberConfig) {
+		if err := master.reconfigureSeptember(reloaded.septemberConfig); err != nil {
+			errs = append(errs, err)
+		} else {
+			effective.septemberConfig = reloaded.septemberConfig
+			logger.infof("September %s is reconfigured", running.september)
+		}
+	}
+	return
+}
+
+// watchReload reloads configuration from etcd each time SIGHUP is received.
+// Client connections are kept.
+func (master *Master) watchReload(conf config) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for range c {
+		logger.infof("SIGHUP received; reloading configuration")
+		reloaded, errs := getConfig()
+		if errs = append(errs, validateConfig(reloaded)...); len(errs) > 0 {
+			for _, err := range errs {
+				logger.errorf("reloading configuration failed: %v", err)
+			}
+			continue
+		}
+		conf, errs = master.reload(conf, reloaded)
+		for _, err := range errs {
+			logger.warnf("reloading configuration: %v", err)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/common"
+)
+
+type rpgmParameters struct {
+	MinSpeed    float64       `etcd:"min_speed" default:"1"`
+	MaxSpeed    float64       `etcd:"max_speed" default:"5"`
+	PauseTime   time.Duration `etcd:"pause_time" default:"0s"`
+	ClimbRate   float64       `etcd:"climb_rate" default:"0"`
+	MaxOffset   float64       `etcd:"max_offset" default:"10"`
+	OffsetSpeed float64       `etcd:"offset_speed" default:"1"`
+	Interval    time.Duration `etcd:"interval" default:"100ms"`
+	Seed        int64         `etcd:"seed" default:"0"`
+	Arena       mobilityArena `etcd:"arena"`
+}
+
+func (p *rpgmParameters) check() error {
+	if p.MinSpeed <= 0 || p.MaxSpeed < p.MinSpeed {
+		return fmt.Errorf("rpgm needs 0 < min_speed <= max_speed (got %v and %v)", p.MinSpeed, p.MaxSpeed)
+	}
+	if p.PauseTime < 0 || p.MaxOffset < 0 || p.OffsetSpeed < 0 || p.ClimbRate < 0 {
+		return fmt.Errorf("pause_time, max_offset, offset_speed and climb_rate cannot be negative (got %v, %v, %v and %v)", p.PauseTime, p.MaxOffset, p.OffsetSpeed, p.ClimbRate)
+	}
+	if p.Interval <= 0 {
+		return fmt.Errorf("interval needs to be positive (got %v)", p.Interval)
+	}
+	return p.Arena.check()
+}
+
+// rpgmMember is a node's offset from reference point of its group.
+type rpgmMember struct {
+	group  string
+	offset squirrel.Position
+}
+
+// rpgm is the Reference Point Group Mobility model: reference point of each
+// group moves like a node under random-waypoint, and members of the group
+// follow it, each wandering randomly within max_offset from it. Groups are
+// taken from metadata "group" of nodes; a node without one is a group by
+// itself.
+type rpgm struct {
+	positionManager squirrel.PositionManager
+
+	params rpgmParameters
+	rand   *rand.Rand
+	ticker squirrel.Ticker
+	mu     sync.Mutex // params, rand, ticker
+}
+
+func newRPGM() squirrel.MobilityManager {
+	return &rpgm{}
+}
+
+func (m *rpgm) ParametersHelp() string {
+	return `
+  arena/width, arena/height [Required]:
+    Size of the arena, from (0, 0), that reference points of groups move in.
+    Members are kept in the arena as well.
+
+  min_speed, max_speed [Optional]:
+    Range of speed of reference points, in units per second, that is picked
+    for each leg. Default: 1 and 5
+
+  pause_time [Optional]:
+    How long a reference point stays at a waypoint, e.g. 2s. Default: 0s
+` + arenaAltitudeHelp + `
+  climb_rate [Optional]:
+    Fastest change of Height of reference points, in units per second.
+    Default: 0 (no limit)
+
+  max_offset [Optional]:
+    Longest distance of a member from reference point of its group.
+    Default: 10
+
+  offset_speed [Optional]:
+    Speed, in units per second, that members wander around reference points.
+    Default: 1
+` + arenaBoundaryHelp + ` Members that are respawned get a new random
+    offset from reference point of their group instead. Default: clamp
+
+  interval [Optional]:
+    How often positions are updated. Default: 100ms
+
+  seed [Optional]:
+    Seed of random numbers, for reproducible runs. Default: 0 (current time;
+    the seed is logged, and reported by GET /mobility of the control API)
+
+  Nodes are grouped by metadata "group", e.g. set by
+  /squirrel/master/nodes/<mac>/group.
+    `
+}
+
+func (m *rpgm) Configure(conf *etcd.Node) error {
+	if err := common.DecodeParameters(conf, &m.params); err != nil {
+		return err
+	}
+	if err := m.params.check(); err != nil {
+		return err
+	}
+	m.rand = newRand("rpgm", m.params.Seed)
+	return nil
+}
+
+// Reconfigure applies new parameters. Reference points keep moving to their
+// current waypoints; new speeds and pause time apply from the next leg.
+func (m *rpgm) Reconfigure(conf *etcd.Node) error {
+	var params rpgmParameters
+	if err := common.DecodeParameters(conf, &params); err != nil {
+		return err
+	}
+	if err := params.check(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if params.Seed != m.params.Seed {
+		m.rand = newRand("rpgm", params.Seed)
+	}
+	if m.ticker != nil && params.Interval != m.params.Interval {
+		m.ticker.Reset(params.Interval)
+	}
+	m.params = params
+	return nil
+}
+
+func (m *rpgm) Initialize(positionManager squirrel.PositionManager) {
+	m.positionManager = positionManager
+	m.run()
+}
+
+// wander moves offset randomly by up to distance, keeping it within radius.
+// If vertical, it moves in 3D.
+func wander(offset *squirrel.Position, distance, radius float64, vertical bool, r *rand.Rand) {
+	heading := r.Float64() * 2 * math.Pi
+	climb := 0.0 // sine of pitch
+	if vertical {
+		// uniform on the sphere
+		climb = 2*r.Float64() - 1
+	}
+	d := r.Float64() * distance
+	horizontal := d * math.Sqrt(1-climb*climb)
+	offset.X += horizontal * math.Cos(heading)
+	offset.Y += horizontal * math.Sin(heading)
+	offset.Height += d * climb
+	if l := math.Sqrt(offset.X*offset.X + offset.Y*offset.Y + offset.Height*offset.Height); l > radius {
+		offset.X *= radius / l
+		offset.Y *= radius / l
+		offset.Height *= radius / l
+	}
+}
+
+// Stop stops updating positions.
+func (m *rpgm) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ticker != nil {
+		m.ticker.Stop()
+	}
+}
+
+func (m *rpgm) step(groups map[string]*waypointNode, members map[int]*rpgmMember, now time.Time, elapsed time.Duration) (updates []squirrel.PositionUpdate, events []squirrel.MobilityEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := &m.params
+
+	enabled := m.positionManager.Enabled()
+	active := make(map[string]bool)
+	isEnabled := make(map[int]bool, len(enabled))
+	for _, index := range enabled {
+		isEnabled[index] = true
+		group, ok := m.positionManager.GetMetadata(index, "group")
+		if !ok || group == "" {
+			// not a valid group name in configuration, so won't collide
+			group = "#" + strconv.Itoa(index)
+		}
+		active[group] = true
+		mem, ok := members[index]
+		if !ok || mem.group != group {
+			mem = &rpgmMember{group: group}
+			wander(&mem.offset, p.MaxOffset, p.MaxOffset, p.Arena.is3D(), m.rand)
+			members[index] = mem
+		}
+	}
+	for index := range members {
+		if !isEnabled[index] {
+			delete(members, index)
+		}
+	}
+
+	// move reference points first so that all members of a group follow the
+	// same one
+	reached := make(map[string]bool)
+	for group := range active {
+		ref, ok := groups[group]
+		if !ok {
+			ref = &waypointNode{pos: p.Arena.random(m.rand)}
+			ref.nextLeg(&p.Arena, p.MinSpeed, p.MaxSpeed, p.ClimbRate, now, m.rand)
+			groups[group] = ref
+		} else if ref.advance(now, elapsed) {
+			ref.nextLeg(&p.Arena, p.MinSpeed, p.MaxSpeed, p.ClimbRate, now.Add(p.PauseTime), m.rand)
+			reached[group] = true
+		}
+	}
+	for group := range groups {
+		if !active[group] {
+			delete(groups, group)
+		}
+	}
+
+	for _, index := range enabled {
+		mem := members[index]
+		wander(&mem.offset, p.OffsetSpeed*elapsed.Seconds(), p.MaxOffset, p.Arena.is3D(), m.rand)
+		ref := groups[mem.group].pos
+		pos := addOffset(ref, mem.offset)
+		if _, _, _, respawned := p.Arena.keepIn(&pos, boundaryClamp, m.rand); respawned {
+			// near the group; anywhere in the arena would break it up
+			mem.offset = squirrel.Position{}
+			wander(&mem.offset, p.MaxOffset, p.MaxOffset, p.Arena.is3D(), m.rand)
+			pos = addOffset(ref, mem.offset)
+			pos.X = clamp(pos.X, 0, p.Arena.Width)
+			pos.Y = clamp(pos.Y, 0, p.Arena.Height)
+			pos.Height = p.Arena.clampAltitude(pos.Height)
+		}
+		updates = append(updates, squirrel.PositionUpdate{Index: index, Position: pos})
+		// members arrive along with their reference point
+		if reached[mem.group] {
+			events = append(events, squirrel.MobilityEvent{Kind: squirrel.WaypointReached, Source: "rpgm", Index: index, Time: now})
+		}
+	}
+	return
+}
+
+func (m *rpgm) run() {
+	groups := make(map[string]*waypointNode)
+	members := make(map[int]*rpgmMember)
+	last := clock.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ticker = clock.Every(m.params.Interval, func(now time.Time) {
+		// one batch for all groups, so that members of a group are moved
+		// together
+		updates, events := m.step(groups, members, now, now.Sub(last))
+		if len(updates) > 0 {
+			// fixed nodes are refused; they just stay where they are
+			if err := m.positionManager.SetBatch(updates); err != nil {
+				logger.debugf("rpgm: %v", err)
+			}
+		}
+		for _, e := range events {
+			mobilityEvents.Publish(e)
+		}
+		last = now
+	})
+}
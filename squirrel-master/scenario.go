@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// scenarioEvent is an action that master takes at a given time after it
+// starts, or after it's triggered by mobility events.
+type scenarioEvent struct {
+	name   string
+	at     time.Duration
+	action string // enable, disable, move, reconfigure_mobility_manager, reconfigure_september, switch_mobility_manager, pause_mobility or resume_mobility
+
+	on      string   // kind of mobility event that triggers it; empty if it's timed
+	onNodes []string // hardware addresses of nodes that all need to have had on; empty for any node
+
+	node            string            // hardware address of node for enable, disable and move
+	position        squirrel.Position // for move; in supplied units
+	parameters      *etcd.Node        // for reconfigure_* and switch_mobility_manager
+	mobilityManager string            // for switch_mobility_manager
+}
+
+func (e *scenarioEvent) check() error {
+	switch e.action {
+	case "enable", "disable", "move":
+		if e.node == "" {
+			return fmt.Errorf("event %s: node is required for %s", e.name, e.action)
+		}
+	case "reconfigure_mobility_manager", "reconfigure_september":
+		if e.parameters == nil {
+			return fmt.Errorf("event %s: parameters_path is required for %s", e.name, e.action)
+		}
+	case "switch_mobility_manager":
+		if e.mobilityManager == "" {
+			return fmt.Errorf("event %s: mobility_manager is required for %s", e.name, e.action)
+		}
+		if mobilityManagerConstructor(e.mobilityManager) == nil {
+			return fmt.Errorf("event %s: MobilityManager %s is not registered", e.name, e.mobilityManager)
+		}
+	case "pause_mobility", "resume_mobility":
+	default:
+		return fmt.Errorf("event %s: unknown action %s (expected enable, disable, move, reconfigure_mobility_manager, reconfigure_september, switch_mobility_manager, pause_mobility or resume_mobility)", e.name, e.action)
+	}
+	if e.on == "" && len(e.onNodes) > 0 {
+		return fmt.Errorf("event %s: on_nodes needs on", e.name)
+	}
+	return nil
+}
+
+// runScenario executes timed events at their times, counted from now, and
+// triggered ones when their mobility events are published.
+func (master *Master) runScenario(events []scenarioEvent) {
+	var timed, triggered []scenarioEvent
+	for _, e := range events {
+		if e.on != "" {
+			triggered = append(triggered, e)
+		} else {
+			timed = append(timed, e)
+		}
+	}
+	if len(triggered) > 0 {
+		go master.runTriggered(triggered)
+	}
+	sort.SliceStable(timed, func(i, j int) bool { return timed[i].at < timed[j].at })
+	start := time.Now()
+	for i := range timed {
+		time.Sleep(timed[i].at - time.Since(start))
+		master.executeLogged(&timed[i])
+	}
+}
+
+func (master *Master) executeLogged(e *scenarioEvent) {
+	if err := master.execute(e); err != nil {
+		logger.errorf("event %s failed: %v", e.name, err)
+	} else if e.on != "" {
+		logger.infof("event %s (%s) executed %v after it's triggered", e.name, e.action, e.at)
+	} else {
+		logger.infof("event %s (%s) executed at %v", e.name, e.action, e.at)
+	}
+}
+
+// pendingTrigger is a triggered scenarioEvent waiting for its mobility
+// events.
+type pendingTrigger struct {
+	event   *scenarioEvent
+	waiting map[string]bool // hardware addresses in onNodes that haven't had on yet
+}
+
+// matches returns whether mobility event e is about node with hardware
+// address addr.
+func (master *Master) matches(e *squirrel.MobilityEvent, addr string) bool {
+	if e.Addr != "" {
+		return e.Addr == addr
+	}
+	identity, ok := master.addrReverse.GetS(addr)
+	return ok && e.Index != 0 && identity == e.Index
+}
+
+// runTriggered executes each of events, once, at after its trigger.
+func (master *Master) runTriggered(events []scenarioEvent) {
+	c := make(chan squirrel.MobilityEvent, 256)
+	mobilityEvents.subscribe(c)
+	defer mobilityEvents.unsubscribe(c)
+	var pending []*pendingTrigger
+	for i := range events {
+		p := &pendingTrigger{event: &events[i], waiting: make(map[string]bool)}
+		for _, addr := range events[i].onNodes {
+			p.waiting[addr] = true
+		}
+		pending = append(pending, p)
+	}
+	for len(pending) > 0 {
+		e := <-c
+		left := pending[:0]
+		for _, p := range pending {
+			if p.event.on != e.Kind {
+				left = append(left, p)
+				continue
+			}
+			for addr := range p.waiting {
+				if master.matches(&e, addr) {
+					delete(p.waiting, addr)
+				}
+			}
+			if len(p.event.onNodes) > 0 && len(p.waiting) > 0 {
+				left = append(left, p)
+				continue
+			}
+			logger.infof("event %s is triggered by %s from %s", p.event.name, e.Kind, e.Source)
+			go func(event *scenarioEvent) {
+				time.Sleep(event.at)
+				master.executeLogged(event)
+			}(p.event)
+		}
+		pending = left
+	}
+}
+
+func (master *Master) execute(e *scenarioEvent) (err error) {
+	switch e.action {
+	case "enable", "disable":
+		identity, ok := master.addrReverse.GetS(e.node)
+		if !ok || master.clients[identity] == nil {
+			return fmt.Errorf("node with hardware address %s is not connected", e.node)
+		}
+		if e.action == "enable" {
+			master.positionManager.Enable(identity)
+		} else {
+			master.positionManager.Disable(identity)
+		}
+	case "move":
+		err = master.positionManager.SetPositionAddr(e.node, &e.position)
+	case "reconfigure_mobility_manager":
+		err = master.reconfigurePrimary(e.parameters)
+	case "reconfigure_september":
+		err = master.reconfigureSeptember(e.parameters)
+	case "switch_mobility_manager":
+		err = master.switchMobilityManager(e.mobilityManager, e.parameters)
+	case "pause_mobility":
+		pauseMobility()
+	case "resume_mobility":
+		resumeMobility()
+	}
+	return
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/common"
+)
+
+// scriptedWaypoint is an entry of a waypoints script, e.g.
+//
+//	{"node": "02:00:00:00:00:03", "at": "10s", "to": [50, 20], "speed": 2}
+//
+// moves the node from wherever it is at 10s toward (50, 20) at 2 units per
+// second. Without speed, the node is placed at to right away.
+type scriptedWaypoint struct {
+	Node  string    `json:"node"`
+	At    string    `json:"at"`
+	To    []float64 `json:"to"`
+	Speed float64   `json:"speed"`
+
+	at time.Duration
+	id int // trace node
+}
+
+// parseWaypointsScript reads a JSON array of scriptedWaypoints from filename.
+// It returns waypoints ordered by time, and hardware addresses of nodes
+// indexed by id of waypoints.
+func parseWaypointsScript(filename string) (waypoints []scriptedWaypoint, addrs []string, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if err = json.NewDecoder(f).Decode(&waypoints); err != nil {
+		err = fmt.Errorf("parsing %s failed: %v", filename, err)
+		return
+	}
+	ids := make(map[string]int)
+	for i := range waypoints {
+		w := &waypoints[i]
+		var addr net.HardwareAddr
+		if addr, err = net.ParseMAC(w.Node); err != nil {
+			err = fmt.Errorf("waypoint %d: %v", i, err)
+			return
+		}
+		if w.at, err = time.ParseDuration(w.At); err != nil {
+			err = fmt.Errorf("waypoint %d: %v", i, err)
+			return
+		}
+		if len(w.To) != 2 && len(w.To) != 3 {
+			err = fmt.Errorf("waypoint %d: to needs to be [x, y] or [x, y, height]", i)
+			return
+		}
+		if w.Speed < 0 {
+			err = fmt.Errorf("waypoint %d: speed cannot be negative", i)
+			return
+		}
+		id, ok := ids[addr.String()]
+		if !ok {
+			id = len(addrs)
+			ids[addr.String()] = id
+			addrs = append(addrs, addr.String())
+		}
+		w.id = id
+	}
+	sort.SliceStable(waypoints, func(i, j int) bool { return waypoints[i].at < waypoints[j].at })
+	return
+}
+
+func (w *scriptedWaypoint) position() (pos squirrel.Position) {
+	pos.X, pos.Y = w.To[0], w.To[1]
+	if len(w.To) == 3 {
+		pos.Height = w.To[2]
+	}
+	return
+}
+
+type scriptedWaypointsParameters struct {
+	Path     string        `etcd:"path,required"`
+	Interval time.Duration `etcd:"interval" default:"100ms"`
+}
+
+// scriptedWaypoints moves nodes through waypoints in a script, with time 0 of
+// the script being when the Mobility Manager is initialized. It's for
+// deterministic, choreographed tests.
+type scriptedWaypoints struct {
+	nodes     traceNodes
+	waypoints []scriptedWaypoint
+	interval  time.Duration
+}
+
+func newScriptedWaypoints() squirrel.MobilityManager {
+	return &scriptedWaypoints{}
+}
+
+func (m *scriptedWaypoints) ParametersHelp() string {
+	return `
+  path [Required]:
+    Path to a JSON file of waypoints, e.g.
+      [
+        {"node": "02:00:00:00:00:01", "at": "0s", "to": [0, 0]},
+        {"node": "02:00:00:00:00:01", "at": "10s", "to": [50, 20], "speed": 2}
+      ]
+    At time at, node starts moving from wherever it is toward to (as [x, y]
+    or [x, y, height]) at speed units per second, or is placed there right
+    away if speed is not specified.
+
+  interval [Optional]:
+    How often positions are updated. Default: 100ms
+    `
+}
+
+func (m *scriptedWaypoints) Configure(conf *etcd.Node) (err error) {
+	var params scriptedWaypointsParameters
+	if err = common.DecodeParameters(conf, &params); err != nil {
+		return
+	}
+	if params.Interval <= 0 {
+		return fmt.Errorf("interval needs to be positive (got %v)", params.Interval)
+	}
+	m.interval = params.Interval
+	m.waypoints, m.nodes.addrs, err = parseWaypointsScript(params.Path)
+	return
+}
+
+func (m *scriptedWaypoints) Initialize(positionManager squirrel.PositionManager) {
+	m.nodes.positionManager = positionManager
+	m.run(clock.Now())
+}
+
+// Stop stops replaying.
+func (m *scriptedWaypoints) Stop() {
+	m.nodes.stop()
+}
+
+func (m *scriptedWaypoints) run(start time.Time) {
+	legs := make(map[int]*linearLeg)
+	arrived := make(map[int]bool) // at dest of current leg
+	var end time.Duration
+	if n := len(m.waypoints); n > 0 {
+		end = m.waypoints[n-1].at
+	}
+	next := 0
+	m.nodes.replay("waypoints", start, m.interval, end, func(t time.Duration) map[int]squirrel.Position {
+		for ; next < len(m.waypoints) && m.waypoints[next].at <= t; next++ {
+			w := &m.waypoints[next]
+			to := w.position()
+			leg := &linearLeg{from: to, start: w.at, dest: to, speed: w.Speed}
+			if w.Speed > 0 {
+				if current, ok := legs[w.id]; ok {
+					leg.from = current.at(w.at)
+				} else if pos, err := m.nodes.positionManager.GetAddr(m.nodes.addrs[w.id]); err == nil {
+					// read in the same units as to, so the leg starts where
+					// the node is
+					leg.from = pos
+				}
+			}
+			legs[w.id] = leg
+			arrived[w.id] = false
+		}
+		positions := make(map[int]squirrel.Position, len(legs))
+		for id, leg := range legs {
+			positions[id] = leg.at(t)
+			if !arrived[id] && positions[id] == leg.dest {
+				arrived[id] = true
+				m.nodes.arrive(id)
+			}
+		}
+		return positions
+	})
+}
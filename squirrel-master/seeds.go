@@ -0,0 +1,186 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// usedSeed is a seed that a built-in model uses, as reported by the control
+// API.
+type usedSeed struct {
+	Model string `json:"model"`
+	Seed  int64  `json:"seed"`
+}
+
+var (
+	usedSeeds   []usedSeed
+	muUsedSeeds sync.Mutex
+)
+
+// newRand returns a source of randomness for model, seeded with seed, or with
+// current time if seed is 0. Each model has a source of its own, so that runs
+// with the same seeds are reproducible. The seed is logged and recorded, so
+// that a run with a random seed can be repeated.
+func newRand(model string, seed int64) *rand.Rand {
+	return rand.New(rand.NewSource(useSeed(model, seed)))
+}
+
+// useSeed returns seed, or current time if it's 0, after logging and
+// recording it as that of model.
+func useSeed(model string, seed int64) int64 {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	logger.infof("%s: random seed is %d", model, seed)
+	muUsedSeeds.Lock()
+	usedSeeds = append(usedSeeds, usedSeed{Model: model, Seed: seed})
+	muUsedSeeds.Unlock()
+	return seed
+}
+
+// seeds returns seeds used so far, in order of use.
+func seeds() []usedSeed {
+	muUsedSeeds.Lock()
+	defer muUsedSeeds.Unlock()
+	return append([]usedSeed(nil), usedSeeds...)
+}
+
+// Streams of a link, so that draws whose number depends on timing, e.g. of
+// overlapping transmissions, don't shift those of deliveries of the link.
+const (
+	streamLink         = iota // of Link
+	streamInterference        // of decoding frames under interference
+	streamCSMA                // of backoffs of CSMA
+)
+
+// linkStreamKey identifies a stream of the link from src to dst.
+type linkStreamKey struct {
+	stream, src, dst int
+}
+
+// linkRandom is master's squirrel.RandomSource. Each stream of a link is
+// counter based: its n-th number is splitmix64 of the seed of master, the
+// stream, identities at ends of the link and n, so that streams are neither
+// locked nor hold state of their own but a counter.
+type linkRandom struct {
+	seed    int64
+	streams sync.Map // linkStreamKey to *linkStream
+}
+
+// newLinkRandom seeds streams of links with seed, or with current time if
+// seed is 0.
+func newLinkRandom(seed int64) *linkRandom {
+	return &linkRandom{seed: useSeed("September", seed)}
+}
+
+func (l *linkRandom) Link(source, destination int) squirrel.Rand {
+	return l.stream(streamLink, source, destination)
+}
+
+// stream returns stream of the link from source to destination, the same one
+// each time.
+func (l *linkRandom) stream(stream, source, destination int) squirrel.Rand {
+	k := linkStreamKey{stream: stream, src: source, dst: destination}
+	if r, ok := l.streams.Load(k); ok {
+		return r.(*linkStream)
+	}
+	r, _ := l.streams.LoadOrStore(k, &linkStream{key: mixSeed(l.seed, stream, source, destination)})
+	return r.(*linkStream)
+}
+
+// mixSeed derives the key of stream of the link from source to destination
+// from seed.
+func mixSeed(seed int64, stream, source, destination int) uint64 {
+	z := splitmix64(uint64(seed) + uint64(stream)<<56)
+	return splitmix64(z + uint64(source)<<32 + uint64(destination))
+}
+
+// splitmix64 is the output function of splitmix64, which maps neighboring
+// inputs far apart.
+func splitmix64(z uint64) uint64 {
+	z += 0x9e3779b97f4a7c15
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
+
+// linkStream is a stream of a link. It's safe for concurrent use, but
+// numbers are only reproducible if draws of the stream are made in the same
+// order.
+type linkStream struct {
+	key uint64
+	n   uint64 // of numbers drawn so far; accessed atomically
+}
+
+func (s *linkStream) next() uint64 {
+	return splitmix64(s.key ^ splitmix64(atomic.AddUint64(&s.n, 1)))
+}
+
+// Float64 returns a number in [0, 1) from the top 53 bits of the next one.
+func (s *linkStream) Float64() float64 {
+	return float64(s.next()>>11) / (1 << 53)
+}
+
+// NormFloat64 draws by the Box-Muller transform.
+func (s *linkStream) NormFloat64() float64 {
+	u := 1 - s.Float64() // in (0, 1], for the log
+	return math.Sqrt(-2*math.Log(u)) * math.Cos(2*math.Pi*s.Float64())
+}
+
+func (s *linkStream) ExpFloat64() float64 {
+	return -math.Log(1 - s.Float64())
+}
+
+func (s *linkStream) Intn(n int) int {
+	if n <= 0 {
+		panic("invalid argument to Intn")
+	}
+	return int(s.Int63n(int64(n)))
+}
+
+// Int63n rejects draws from the top of the range that'd bias it towards
+// small numbers.
+func (s *linkStream) Int63n(n int64) int64 {
+	if n <= 0 {
+		panic("invalid argument to Int63n")
+	}
+	limit := uint64(1<<63 - 1 - (1<<63)%uint64(n))
+	v := s.next() >> 1
+	for v > limit {
+		v = s.next() >> 1
+	}
+	return int64(v % uint64(n))
+}
+
+// globalRand is the shared source of math/rand, for built-in Septembers that
+// aren't handed master's RandomSource.
+type globalRand struct{}
+
+func (globalRand) Float64() float64     { return rand.Float64() }
+func (globalRand) NormFloat64() float64 { return rand.NormFloat64() }
+func (globalRand) ExpFloat64() float64  { return rand.ExpFloat64() }
+func (globalRand) Intn(n int) int       { return rand.Intn(n) }
+func (globalRand) Int63n(n int64) int64 { return rand.Int63n(n) }
+
+// randOf returns the source of the link from source to destination in r, or
+// globalRand if r is nil.
+func randOf(r squirrel.RandomSource, source, destination int) squirrel.Rand {
+	if r == nil {
+		return globalRand{}
+	}
+	return r.Link(source, destination)
+}
+
+// streamOf returns stream of the link from source to destination in r, if
+// it's master's, or the source of the link as randOf does.
+func streamOf(r squirrel.RandomSource, stream, source, destination int) squirrel.Rand {
+	if l, ok := r.(*linkRandom); ok {
+		return l.stream(stream, source, destination)
+	}
+	return randOf(r, source, destination)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+type shadowingParameters struct {
+	Sigma                 float64 `etcd:"sigma" default:"0"`
+	DecorrelationDistance float64 `etcd:"decorrelation_distance" default:"20"`
+}
+
+// shadowingHelp documents shadowing parameters of radio propagation
+// Septembers, for ParametersHelp.
+const shadowingHelp = `
+  shadowing/sigma [Optional]:
+    Standard deviation in dB of log-normal shadowing, i.e. of obstructions
+    that make some links weaker or stronger than path loss says. It's drawn
+    for each pair of nodes, and stays as it is while they don't move.
+    Typically 4 to 12; 0 for none. Default: 0
+
+  shadowing/decorrelation_distance [Optional]:
+    Distance in meters either node of a pair moves before shadowing of the
+    pair is drawn again. New values are correlated with previous ones by
+    exp(-moved/decorrelation_distance). Default: 20
+`
+
+// shadowingMargin is how many standard deviations stronger than without
+// shadowing a link is allowed to be, for cutting off distant nodes.
+const shadowingMargin = 3
+
+// shadow is shadowing of a pair of nodes, and where they were when it was
+// drawn.
+type shadow struct {
+	value float64 // dB
+	at1   squirrel.Position
+	at2   squirrel.Position
+}
+
+// shadowing is log-normal shadowing, spatially correlated as in Gudmundson's
+// model: values of a pair of nodes change only when either of them moves,
+// and the more they move, the less new values are related to old ones.
+type shadowing struct {
+	sigma                 float64
+	decorrelationDistance float64
+
+	shadows map[linkPair]*shadow // by pair of identities, lower one first
+	mu      sync.Mutex           // shadows
+}
+
+// newShadowing returns nil if there's no shadowing.
+func newShadowing(params shadowingParameters) (*shadowing, error) {
+	if params.Sigma < 0 {
+		return nil, fmt.Errorf("shadowing/sigma cannot be negative (got %v)", params.Sigma)
+	}
+	if params.DecorrelationDistance <= 0 {
+		return nil, fmt.Errorf("shadowing/decorrelation_distance needs to be positive (got %v)", params.DecorrelationDistance)
+	}
+	if params.Sigma == 0 {
+		return nil, nil
+	}
+	return &shadowing{
+		sigma:                 params.Sigma,
+		decorrelationDistance: params.DecorrelationDistance,
+		shadows:               make(map[linkPair]*shadow),
+	}, nil
+}
+
+// get returns shadowing in dB between nodes with identities id1 and id2, at
+// pos1 and pos2 in meters, drawn from r when it changes. It's the same either
+// way. A nil shadowing has none.
+func (s *shadowing) get(id1, id2 int, pos1, pos2 squirrel.Position, r squirrel.Rand) float64 {
+	if s == nil {
+		return 0
+	}
+	if id1 > id2 {
+		id1, id2 = id2, id1
+		pos1, pos2 = pos2, pos1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := linkPair{src: id1, dst: id2}
+	sh, ok := s.shadows[key]
+	if !ok {
+		sh = &shadow{value: s.sigma * r.NormFloat64(), at1: pos1, at2: pos2}
+		s.shadows[key] = sh
+		return sh.value
+	}
+	moved := math.Max(euclidean(pos1, sh.at1), euclidean(pos2, sh.at2))
+	if moved >= s.decorrelationDistance {
+		rho := math.Exp(-moved / s.decorrelationDistance)
+		sh.value = rho*sh.value + math.Sqrt(1-rho*rho)*s.sigma*r.NormFloat64()
+		sh.at1, sh.at2 = pos1, pos2
+	}
+	return sh.value
+}
+
+// margin returns shadowingMargin standard deviations, or 0 if there's no
+// shadowing.
+func (s *shadowing) margin() float64 {
+	if s == nil {
+		return 0
+	}
+	return shadowingMargin * s.sigma
+}
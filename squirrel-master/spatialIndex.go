@@ -0,0 +1,173 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+type gridCell struct {
+	x, y int
+}
+
+// spatialIndex is a uniform grid over X-Y plane that indexes positions of
+// enabled nodes, so that neighbor queries don't need to go through every node.
+type spatialIndex struct {
+	cellSize float64
+
+	cells map[gridCell]map[int]struct{}
+	nodes map[int]squirrel.Position
+	mu    sync.RWMutex
+}
+
+func newSpatialIndex(cellSize float64) *spatialIndex {
+	return &spatialIndex{
+		cellSize: cellSize,
+		cells:    make(map[gridCell]map[int]struct{}),
+		nodes:    make(map[int]squirrel.Position),
+	}
+}
+
+func (s *spatialIndex) cellOf(pos squirrel.Position) gridCell {
+	return gridCell{x: int(math.Floor(pos.X / s.cellSize)), y: int(math.Floor(pos.Y / s.cellSize))}
+}
+
+func (s *spatialIndex) removeLocked(index int) {
+	old, ok := s.nodes[index]
+	if !ok {
+		return
+	}
+	c := s.cellOf(old)
+	delete(s.cells[c], index)
+	if len(s.cells[c]) == 0 {
+		delete(s.cells, c)
+	}
+	delete(s.nodes, index)
+}
+
+// update inserts node at index, or moves it if it's already indexed.
+func (s *spatialIndex) update(index int, pos squirrel.Position) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(index)
+	c := s.cellOf(pos)
+	if s.cells[c] == nil {
+		s.cells[c] = make(map[int]struct{})
+	}
+	s.cells[c][index] = struct{}{}
+	s.nodes[index] = pos
+}
+
+func (s *spatialIndex) remove(index int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(index)
+}
+
+type indexCandidate struct {
+	index    int
+	distance float64
+}
+
+// ring calls f for each indexed node in cells that are exactly r cells away
+// (in Chebyshev distance) from center.
+func (s *spatialIndex) ring(center gridCell, r int, f func(index int, pos squirrel.Position)) {
+	visit := func(c gridCell) {
+		for index := range s.cells[c] {
+			f(index, s.nodes[index])
+		}
+	}
+	if r == 0 {
+		visit(center)
+		return
+	}
+	for x := center.x - r; x <= center.x+r; x++ {
+		visit(gridCell{x: x, y: center.y - r})
+		visit(gridCell{x: x, y: center.y + r})
+	}
+	for y := center.y - r + 1; y <= center.y+r-1; y++ {
+		visit(gridCell{x: center.x - r, y: y})
+		visit(gridCell{x: center.x + r, y: y})
+	}
+}
+
+// within returns indices of indexed nodes, other than index, whose distance to
+// node at index is no more than radius. ok is false if index is not indexed.
+func (s *spatialIndex) within(index int, radius float64) (ret []int, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var center squirrel.Position
+	if center, ok = s.nodes[index]; !ok {
+		return
+	}
+	collect := func(i int, pos squirrel.Position) {
+		if i != index && euclidean(center, pos) <= radius {
+			ret = append(ret, i)
+		}
+	}
+	c := s.cellOf(center)
+	// counted in floats, so that an infinite or huge radius (e.g. maxRange of
+	// a model with a small exponent) doesn't overflow
+	rings := math.Ceil(radius / s.cellSize)
+	if side := 2*rings + 1; math.IsNaN(side) || side*side > float64(len(s.cells)) {
+		// cheaper to go through all nodes than all cells within radius
+		for i, pos := range s.nodes {
+			collect(i, pos)
+		}
+		return
+	}
+	for r := 0; r <= int(rings); r++ {
+		s.ring(c, r, collect)
+	}
+	return
+}
+
+// nearest returns indices of up to k indexed nodes other than index, nearest
+// first. ok is false if index is not indexed.
+func (s *spatialIndex) nearest(index int, k int) (ret []int, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var center squirrel.Position
+	if center, ok = s.nodes[index]; !ok {
+		return
+	}
+	if k <= 0 {
+		return
+	}
+	var candidates []indexCandidate
+	collect := func(i int, pos squirrel.Position) {
+		if i != index {
+			candidates = append(candidates, indexCandidate{index: i, distance: euclidean(center, pos)})
+		}
+	}
+	c := s.cellOf(center)
+	for r := 0; len(candidates) < len(s.nodes)-1; r++ {
+		if 8*r > len(s.cells) {
+			// sparse grid; cheaper to go through all nodes than empty cells
+			candidates = candidates[:0]
+			for i, pos := range s.nodes {
+				collect(i, pos)
+			}
+			break
+		}
+		s.ring(c, r, collect)
+		if len(candidates) >= k {
+			sort.Slice(candidates, func(a, b int) bool { return candidates[a].distance < candidates[b].distance })
+			// Any node in cells not visited so far is at least r cells away.
+			if candidates[k-1].distance <= float64(r)*s.cellSize {
+				break
+			}
+		}
+	}
+	sort.Slice(candidates, func(a, b int) bool { return candidates[a].distance < candidates[b].distance })
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	ret = make([]int, len(candidates))
+	for i := range candidates {
+		ret[i] = candidates[i].index
+	}
+	return
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"sync"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// staticMobility places nodes at positions in its configuration, each time
+// they are enabled, and otherwise never moves them.
+type staticMobility struct {
+	positionManager squirrel.PositionManager
+
+	positions map[string]squirrel.Position // by hardware address; in supplied units
+	mu        sync.Mutex                   // positions
+
+	diffs chan squirrel.EnabledDiff
+	done  chan struct{} // closed by Stop
+}
+
+func newStaticMobility() squirrel.MobilityManager {
+	return &staticMobility{}
+}
+
+func (m *staticMobility) ParametersHelp() string {
+	return `
+  positions [Optional]:
+    A Dir where each child is named by hardware address of a node and is its
+    position as "x,y" or "x,y,height", e.g.
+    positions/02:00:00:00:00:01 -> 10,20.
+
+  path [Optional]:
+    Path to a CSV file of positions (mac,x,y,height), like
+    /squirrel/master/positions_file. Entries in positions take precedence.
+
+  Nodes that are not listed stay wherever they are placed when they join.
+    `
+}
+
+func parseStaticPositions(conf *etcd.Node) (positions map[string]squirrel.Position, err error) {
+	positions = make(map[string]squirrel.Position)
+	if conf == nil {
+		return
+	}
+	if !conf.Dir {
+		err = fmt.Errorf("static needs a Dir of parameters")
+		return
+	}
+	var listed map[string]squirrel.Position
+	for _, node := range conf.Nodes {
+		switch path.Base(node.Key) {
+		case "positions":
+			if !node.Dir {
+				err = fmt.Errorf("%s is not a Dir node", node.Key)
+				return
+			}
+			listed = make(map[string]squirrel.Position)
+			for _, entry := range node.Nodes {
+				var addr net.HardwareAddr
+				if addr, err = net.ParseMAC(path.Base(entry.Key)); err != nil {
+					return
+				}
+				var pos squirrel.Position
+				if pos, err = parsePosition(entry.Value); err != nil {
+					return nil, fmt.Errorf("%s: %v", entry.Key, err)
+				}
+				listed[addr.String()] = pos
+			}
+		case "path":
+			var file map[string]squirrel.Position
+			if file, err = readPositionsFile(node.Value); err != nil {
+				return
+			}
+			for addr, pos := range file {
+				positions[addr] = pos
+			}
+		default:
+			err = fmt.Errorf("unknown static parameter %s", node.Key)
+			return
+		}
+	}
+	for addr, pos := range listed {
+		positions[addr] = pos
+	}
+	return
+}
+
+func (m *staticMobility) Configure(conf *etcd.Node) (err error) {
+	m.positions, err = parseStaticPositions(conf)
+	return
+}
+
+// Reconfigure replaces positions and moves connected nodes to new ones right
+// away.
+func (m *staticMobility) Reconfigure(conf *etcd.Node) error {
+	positions, err := parseStaticPositions(conf)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.positions = positions
+	m.mu.Unlock()
+	if m.positionManager != nil {
+		m.place()
+	}
+	return nil
+}
+
+func (m *staticMobility) Initialize(positionManager squirrel.PositionManager) {
+	m.positionManager = positionManager
+	m.diffs, m.done = make(chan squirrel.EnabledDiff, 1), make(chan struct{})
+	positionManager.RegisterEnabledDiff(m.diffs)
+	go func() {
+		for {
+			select {
+			case diff := <-m.diffs:
+				if len(diff.Added) > 0 {
+					m.place()
+				}
+			case <-m.done:
+				return
+			}
+		}
+	}()
+	m.place()
+}
+
+// Stop unregisters the channel of m, and ends the goroutine draining it.
+func (m *staticMobility) Stop() {
+	if m.diffs != nil {
+		m.positionManager.UnregisterEnabledDiff(m.diffs)
+		close(m.done)
+	}
+}
+
+// place moves listed nodes that are connected to their positions.
+func (m *staticMobility) place() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for addr, pos := range m.positions {
+		pos := pos
+		// nodes that are not connected yet are placed when they are enabled
+		if err := m.positionManager.SetPositionAddr(addr, &pos); err != nil {
+			logger.debugf("static: %v", err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// staticNode is a node declared in configuration. It has an identity reserved
+// and is known to addressReverse before it connects.
+type staticNode struct {
+	addr     net.HardwareAddr
+	name     string
+	position *squirrel.Position // in supplied units; nil if not specified
+	tags     []string
+	fixed    bool   // if true, position of the node can't be changed
+	group    string // mobility group, e.g. for rpgm
+	gpx      string // path of GPX track, for gpx
+
+	txPower     *float64 // dBm, for radio Septembers; nil if not specified
+	sensitivity *float64 // dBm, for radio Septembers; nil if not specified
+	channel     string   // for radio Septembers
+	battery     *float64 // joules; nil if not specified
+}
+
+// reserve reserves an identity for node with addr, so that it always gets the
+// same address when it joins.
+func (master *Master) reserve(addr net.HardwareAddr) (identity int, err error) {
+	key := strings.ToLower(addr.String())
+	if identity, ok := master.reserved[key]; ok {
+		return identity, nil
+	}
+	for identity = 1; identity < len(master.clients); identity++ {
+		if master.clients[identity] == nil && !master.reservedIdentities[identity] {
+			break
+		}
+	}
+	if identity == len(master.clients) {
+		err = errors.New("Adress poll is full")
+		return
+	}
+	master.reserved[key] = identity
+	master.reservedIdentities[identity] = true
+	master.addrReverse.Add(addr, identity)
+	return
+}
+
+// addStaticNodes reserves identities for nodes and sets their initial
+// positions and metadata. It needs to be called before Run.
+func (master *Master) addStaticNodes(nodes []staticNode) (err error) {
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].addr.String() < nodes[j].addr.String() })
+	for _, node := range nodes {
+		if _, err = master.reserve(node.addr); err != nil {
+			return fmt.Errorf("reserving identity for %s failed: %v", node.addr, err)
+		}
+		addr := node.addr.String()
+		if node.name != "" {
+			master.positionManager.setInitialMetadataAddr(addr, "name", node.name)
+		}
+		if len(node.tags) > 0 {
+			master.positionManager.setInitialMetadataAddr(addr, "tags", strings.Join(node.tags, ","))
+		}
+		if node.group != "" {
+			master.positionManager.setInitialMetadataAddr(addr, "group", node.group)
+		}
+		if node.gpx != "" {
+			master.positionManager.setInitialMetadataAddr(addr, "gpx", node.gpx)
+		}
+		if node.txPower != nil {
+			master.positionManager.setInitialMetadataAddr(addr, "tx_power", strconv.FormatFloat(*node.txPower, 'f', -1, 64))
+		}
+		if node.sensitivity != nil {
+			master.positionManager.setInitialMetadataAddr(addr, "sensitivity", strconv.FormatFloat(*node.sensitivity, 'f', -1, 64))
+		}
+		if node.channel != "" {
+			master.positionManager.setInitialMetadataAddr(addr, "channel", node.channel)
+		}
+		if node.battery != nil {
+			master.positionManager.setInitialMetadataAddr(addr, "battery", strconv.FormatFloat(*node.battery, 'f', -1, 64))
+		}
+		if node.position != nil {
+			master.positionManager.setInitialAddr(addr, master.positionManager.fromSupplied(*node.position))
+		}
+		master.positionManager.setInitialFixedAddr(addr, node.fixed)
+	}
+	return
+}
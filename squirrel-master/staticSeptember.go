@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+)
+
+// staticTopology is a set of links resolved into identities. It's never
+// modified after being published.
+type staticTopology struct {
+	connected map[linkPair]bool
+	neighbors map[int][]int
+}
+
+// staticSeptember connects nodes by a fixed adjacency list, regardless of
+// their positions, e.g. to emulate wired topologies. Packets on a link are
+// always delivered; nodes without a link in between never hear each other.
+type staticSeptember struct {
+	positionManager *PositionManager
+
+	links    [][2]string // hardware addresses
+	directed bool
+	mu       sync.Mutex // links, directed
+
+	topology atomic.Value // *staticTopology
+}
+
+func newStaticSeptember() squirrel.September {
+	s := &staticSeptember{}
+	s.topology.Store(&staticTopology{})
+	return s
+}
+
+func (s *staticSeptember) ParametersHelp() string {
+	return `
+  links [Required]:
+    A Dir where each child is a link, as two comma separated hardware
+    addresses of nodes, e.g. links/ab -> 02:00:00:00:00:01,02:00:00:00:00:02.
+
+  directed [Optional]:
+    If true, a link only carries packets from the first node to the second
+    one. Default: false
+    `
+}
+
+func parseStaticLinks(conf *etcd.Node) (links [][2]string, directed bool, err error) {
+	if conf == nil || !conf.Dir {
+		err = fmt.Errorf("StaticSeptember needs a Dir of parameters")
+		return
+	}
+	hasLinks := false
+	for _, node := range conf.Nodes {
+		switch path.Base(node.Key) {
+		case "links":
+			if !node.Dir {
+				err = fmt.Errorf("%s is not a Dir node", node.Key)
+				return
+			}
+			hasLinks = true
+			for _, link := range node.Nodes {
+				addrs := strings.Split(link.Value, ",")
+				if len(addrs) != 2 {
+					err = fmt.Errorf("%s needs exactly two hardware addresses (got %q)", link.Key, link.Value)
+					return
+				}
+				var a, b net.HardwareAddr
+				if a, err = net.ParseMAC(strings.TrimSpace(addrs[0])); err != nil {
+					return
+				}
+				if b, err = net.ParseMAC(strings.TrimSpace(addrs[1])); err != nil {
+					return
+				}
+				links = append(links, [2]string{a.String(), b.String()})
+			}
+		case "directed":
+			if directed, err = strconv.ParseBool(node.Value); err != nil {
+				return
+			}
+		default:
+			err = fmt.Errorf("unknown StaticSeptember parameter %s", node.Key)
+			return
+		}
+	}
+	if !hasLinks {
+		err = fmt.Errorf("links is required")
+	}
+	return
+}
+
+func (s *staticSeptember) Configure(conf *etcd.Node) (err error) {
+	s.links, s.directed, err = parseStaticLinks(conf)
+	return
+}
+
+// Reconfigure replaces the topology, e.g. for a scenario event that cuts
+// links.
+func (s *staticSeptember) Reconfigure(conf *etcd.Node) error {
+	links, directed, err := parseStaticLinks(conf)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.links, s.directed = links, directed
+	s.mu.Unlock()
+	if s.positionManager != nil {
+		s.resolve()
+	}
+	return nil
+}
+
+// Initialize starts resolving hardware addresses in links into identities
+// each time a node is enabled or disabled. As a built-in model, it relies on
+// positionManager being master's PositionManager.
+func (s *staticSeptember) Initialize(positionManager squirrel.PositionManager) {
+	p, ok := positionManager.(*PositionManager)
+	if !ok {
+		logger.errorf("StaticSeptember can't resolve hardware addresses; all nodes are disconnected")
+		return
+	}
+	s.positionManager = p
+	diffs := make(chan squirrel.EnabledDiff, 1)
+	p.RegisterEnabledDiff(diffs)
+	go func() {
+		for range diffs {
+			s.resolve()
+		}
+	}()
+	s.resolve()
+}
+
+func (s *staticSeptember) resolve() {
+	t := &staticTopology{connected: make(map[linkPair]bool), neighbors: make(map[int][]int)}
+	connect := func(src, dst int) {
+		if !t.connected[linkPair{src: src, dst: dst}] {
+			t.connected[linkPair{src: src, dst: dst}] = true
+			t.neighbors[src] = append(t.neighbors[src], dst)
+		}
+	}
+	s.mu.Lock()
+	for _, link := range s.links {
+		a, okA := s.positionManager.addrReverse.GetS(link[0])
+		b, okB := s.positionManager.addrReverse.GetS(link[1])
+		if !okA || !okB || a == b {
+			continue
+		}
+		connect(a, b)
+		if !s.directed {
+			connect(b, a)
+		}
+	}
+	s.mu.Unlock()
+	s.topology.Store(t)
+}
+
+func (s *staticSeptember) SendUnicast(source int, destination int, size int) bool {
+	return s.topology.Load().(*staticTopology).connected[linkPair{src: source, dst: destination}]
+}
+
+func (s *staticSeptember) SendBroadcast(source int, size int, underlying []int) []int {
+	count := 0
+	for _, id := range s.topology.Load().(*staticTopology).neighbors[source] {
+		if s.positionManager.IsEnabled(id) {
+			underlying[count] = id
+			count++
+		}
+	}
+	return underlying[:count]
+}
+
+// LinkQuality tells whether there's a link, which never loses packets.
+func (s *staticSeptember) LinkQuality(source int, destination int) (squirrel.LinkQuality, bool) {
+	if s.positionManager == nil || !s.positionManager.IsEnabled(source) || !s.positionManager.IsEnabled(destination) {
+		return squirrel.LinkQuality{}, false
+	}
+	q := squirrel.LinkQuality{RSSI: math.NaN(), SNR: math.NaN()}
+	if !s.SendUnicast(source, destination, squirrel.LinkQualitySize) {
+		q.Loss = 1
+	}
+	return q, true
+}
+
+// Unicast delivers packet only over a link, as a stage of a chain of
+// Septembers.
+func (s *staticSeptember) Unicast(packet *squirrel.Packet, destination int) bool {
+	return s.SendUnicast(packet.Source, destination, packet.Size)
+}
+
+// Broadcast keeps those of recipients that have a link from source of
+// packet, as a stage of a chain of Septembers.
+func (s *staticSeptember) Broadcast(packet *squirrel.Packet, recipients []int) []int {
+	connected := s.topology.Load().(*staticTopology).connected
+	count := 0
+	for _, id := range recipients {
+		if connected[linkPair{src: packet.Source, dst: id}] {
+			recipients[count] = id
+			count++
+		}
+	}
+	return recipients[:count]
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/common"
+)
+
+type fcdVehicle struct {
+	ID string  `xml:"id,attr"`
+	X  float64 `xml:"x,attr"`
+	Y  float64 `xml:"y,attr"`
+	Z  float64 `xml:"z,attr"`
+}
+
+type fcdTimestep struct {
+	Time     float64      `xml:"time,attr"`
+	Vehicles []fcdVehicle `xml:"vehicle"`
+}
+
+// parseSumoFCD parses a SUMO floating car data file (as written with
+// --fcd-output) into waypoints of vehicles. Vehicles are numbered by ids, or
+// in order of appearance if ids is nil; vehicles not in ids are skipped.
+func parseSumoFCD(filename string, ids map[string]int) (waypoints [][]timedPosition, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	appearing := ids == nil
+	if appearing {
+		ids = make(map[string]int)
+	} else {
+		waypoints = make([][]timedPosition, len(ids))
+	}
+
+	d := xml.NewDecoder(f)
+	for {
+		var token xml.Token
+		if token, err = d.Token(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "timestep" {
+			continue
+		}
+		// timesteps are decoded one at a time, as files can be large
+		var step fcdTimestep
+		if err = d.DecodeElement(&step, &start); err != nil {
+			return
+		}
+		at := time.Duration(step.Time * float64(time.Second))
+		for _, v := range step.Vehicles {
+			id, ok := ids[v.ID]
+			if !ok {
+				if !appearing {
+					continue
+				}
+				id = len(waypoints)
+				ids[v.ID] = id
+				waypoints = append(waypoints, nil)
+			}
+			waypoints[id] = append(waypoints[id], timedPosition{at: at, pos: squirrel.Position{X: v.X, Y: v.Y, Height: v.Z}})
+		}
+	}
+}
+
+type sumoFCDParameters struct {
+	Path     string        `etcd:"path,required"`
+	Vehicles string        `etcd:"vehicles"`
+	Interval time.Duration `etcd:"interval" default:"100ms"`
+}
+
+// sumoFCDReplay moves nodes along vehicle traces in a SUMO floating car data
+// file, with time 0 of the file being when the Mobility Manager is
+// initialized. Nodes stay where vehicles are last seen after they leave the
+// simulation.
+type sumoFCDReplay struct {
+	nodes     traceNodes
+	waypoints [][]timedPosition // by trace node
+	interval  time.Duration
+}
+
+func newSumoFCDReplay() squirrel.MobilityManager {
+	return &sumoFCDReplay{}
+}
+
+func (m *sumoFCDReplay) ParametersHelp() string {
+	return `
+  path [Required]:
+    Path to a SUMO floating car data file, as written by sumo --fcd-output.
+    With --fcd-output.geo, use geographic coordinates in master.
+
+  vehicles [Optional]:
+    Comma separated mapping from vehicle IDs to hardware addresses of nodes,
+    e.g. veh0=02:00:00:00:00:01,veh1=02:00:00:00:00:02. Vehicles not in the
+    mapping are ignored. Default: vehicles in order of appearance are mapped
+    to nodes with identities 1, 2, 3, ..., i.e. addresses of emulated subnet.
+
+  interval [Optional]:
+    How often positions are updated. Default: 100ms
+
+  Only replaying files is supported; connecting to a running SUMO over TraCI
+  is not.
+    `
+}
+
+func (m *sumoFCDReplay) Configure(conf *etcd.Node) (err error) {
+	var params sumoFCDParameters
+	if err = common.DecodeParameters(conf, &params); err != nil {
+		return
+	}
+	if params.Interval <= 0 {
+		return fmt.Errorf("interval needs to be positive (got %v)", params.Interval)
+	}
+	m.interval = params.Interval
+
+	var ids map[string]int
+	if params.Vehicles != "" {
+		ids = make(map[string]int)
+		var addrs []string
+		for _, pair := range strings.Split(params.Vehicles, ",") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("invalid vehicle mapping %q (expected <vehicle id>=<hardware address>)", pair)
+			}
+			if _, ok := ids[kv[0]]; ok {
+				return fmt.Errorf("vehicle %s is mapped more than once", kv[0])
+			}
+			ids[kv[0]] = len(addrs)
+			addrs = append(addrs, kv[1])
+		}
+		if m.nodes.addrs, err = parseTraceNodes(strings.Join(addrs, ",")); err != nil {
+			return
+		}
+	}
+	if m.waypoints, err = parseSumoFCD(params.Path, ids); err != nil {
+		return fmt.Errorf("parsing %s failed: %v", params.Path, err)
+	}
+	logger.infof("sumo-fcd: %s has traces of %d vehicles", params.Path, len(m.waypoints))
+	return
+}
+
+func (m *sumoFCDReplay) Initialize(positionManager squirrel.PositionManager) {
+	m.nodes.positionManager = positionManager
+	m.nodes.replay("sumo-fcd", clock.Now(), m.interval, lastWaypoint(m.waypoints), func(t time.Duration) map[int]squirrel.Position {
+		positions := make(map[int]squirrel.Position, len(m.waypoints))
+		for id, waypoints := range m.waypoints {
+			// a mapped vehicle may not be in the file
+			if len(waypoints) > 0 {
+				positions[id] = interpolate(waypoints, t)
+			}
+		}
+		return positions
+	})
+}
+
+// Stop stops replaying.
+func (m *sumoFCDReplay) Stop() {
+	m.nodes.stop()
+}
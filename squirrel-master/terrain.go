@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// maxTerrainSamples bounds how many points along a path are checked against
+// terrain, however far apart nodes are compared to cells.
+const maxTerrainSamples = 1000
+
+// terrain is a heightmap of ground in X-Y plane, on a regular grid of cells.
+// It's loaded from an ESRI ASCII grid, in the coordinates that positions are
+// in (longitude and latitude in geographic mode).
+type terrain struct {
+	file     string
+	relative bool // if true, Height of nodes is above ground
+
+	cols, rows int
+	x0, y0     float64   // lower left corner
+	cell       float64   // size of cells
+	heights    []float64 // by row, northernmost first; 0 where there's no data
+}
+
+// loadTerrain reads an ESRI ASCII grid from file.
+func loadTerrain(file string, relative bool) (*terrain, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t := &terrain{file: file, relative: relative}
+	header := map[string]float64{"nodata_value": math.NaN()}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1<<20), 1<<26)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		key := strings.ToLower(fields[0])
+		if key == "" || (key[0] >= '0' && key[0] <= '9') || key[0] == '-' || key[0] == '.' {
+			// start of data
+			if err = t.parseRow(fields, header); err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			break
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s: invalid header line %q", file, scanner.Text())
+		}
+		if header[key], err = strconv.ParseFloat(fields[1], 64); err != nil {
+			return nil, fmt.Errorf("%s: invalid header line %q", file, scanner.Text())
+		}
+	}
+	for scanner.Scan() {
+		if err = t.parseRow(strings.Fields(scanner.Text()), header); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(t.heights) != t.cols*t.rows || t.cols == 0 {
+		return nil, fmt.Errorf("%s: %d heights for a grid of %d by %d", file, len(t.heights), t.cols, t.rows)
+	}
+	return t, nil
+}
+
+// parseRow appends heights in fields, after checking header the first time.
+func (t *terrain) parseRow(fields []string, header map[string]float64) error {
+	if t.cols == 0 {
+		for _, key := range []string{"ncols", "nrows", "cellsize"} {
+			if _, ok := header[key]; !ok {
+				return fmt.Errorf("%s is missing from header", key)
+			}
+		}
+		t.cols, t.rows, t.cell = int(header["ncols"]), int(header["nrows"]), header["cellsize"]
+		if t.cols <= 0 || t.rows <= 0 || t.cell <= 0 {
+			return fmt.Errorf("ncols, nrows and cellsize need to be positive")
+		}
+		var ok1, ok2 bool
+		if t.x0, ok1 = header["xllcorner"]; !ok1 {
+			t.x0, ok1 = header["xllcenter"]
+			t.x0 -= t.cell / 2
+		}
+		if t.y0, ok2 = header["yllcorner"]; !ok2 {
+			t.y0, ok2 = header["yllcenter"]
+			t.y0 -= t.cell / 2
+		}
+		if !ok1 || !ok2 {
+			return fmt.Errorf("xllcorner and yllcorner (or xllcenter and yllcenter) are missing from header")
+		}
+		t.heights = make([]float64, 0, t.cols*t.rows)
+	}
+	for _, field := range fields {
+		h, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return fmt.Errorf("invalid height %q", field)
+		}
+		if h == header["nodata_value"] {
+			h = 0
+		}
+		t.heights = append(t.heights, h)
+	}
+	return nil
+}
+
+// ground returns height of ground at x, y, or 0 outside the grid and where
+// there's no data.
+func (t *terrain) ground(x, y float64) float64 {
+	col := int(math.Floor((x - t.x0) / t.cell))
+	row := t.rows - 1 - int(math.Floor((y-t.y0)/t.cell))
+	if col < 0 || col >= t.cols || row < 0 || row >= t.rows {
+		return 0
+	}
+	return t.heights[row*t.cols+col]
+}
+
+// absolute returns pos with Height over the datum of terrain. A nil terrain
+// leaves it as is.
+func (t *terrain) absolute(pos squirrel.Position) squirrel.Position {
+	if t != nil && t.relative {
+		pos.Height += t.ground(pos.X, pos.Y)
+	}
+	return pos
+}
+
+// obstruction returns how high above the straight path between a and b
+// (absolute positions) ground rises at most, which is negative if it's
+// clear, and the fraction along the path where it does.
+func (t *terrain) obstruction(a, b squirrel.Position) (h, at float64) {
+	h = math.Inf(-1)
+	steps := int(math.Min(math.Ceil(math.Hypot(b.X-a.X, b.Y-a.Y)/(t.cell/2)), maxTerrainSamples))
+	for i := 1; i < steps; i++ {
+		f := float64(i) / float64(steps)
+		above := t.ground(a.X+f*(b.X-a.X), a.Y+f*(b.Y-a.Y)) - (a.Height + f*(b.Height-a.Height))
+		if above > h {
+			h, at = above, f
+		}
+	}
+	return
+}
+
+// diffractionLoss returns loss in dB of single knife-edge diffraction (ITU-R
+// P.526) over an edge h meters above the straight path between nodes d
+// meters apart, at fraction at along it, for wavelength in meters.
+func diffractionLoss(h, at, d, wavelength float64) float64 {
+	d1, d2 := at*d, (1-at)*d
+	if d1 <= 0 || d2 <= 0 {
+		return 0
+	}
+	v := h * math.Sqrt(2*d/(wavelength*d1*d2))
+	if v <= -0.78 {
+		return 0
+	}
+	return 6.9 + 20*math.Log10(math.Sqrt((v-0.1)*(v-0.1)+1)+v-0.1)
+}
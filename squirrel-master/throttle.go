@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// linkThrottle emulates links of finite capacity. A frame occupies its link
+// for as long as it takes to transmit at the link's rate, frames sent
+// meanwhile wait in a queue behind it, and those that don't fit into the
+// queue are dropped (tail drop). Each direction between two nodes is a link
+// of its own, and so is each recipient of a broadcast frame.
+type linkThrottle struct {
+	rate  float64 // bits per second of links without an override; 0 for unlimited
+	queue int     // bytes that can be queued on a link without an override
+
+	busy map[linkPair]time.Time // until when each link is transmitting frames queued on it
+	mu   sync.Mutex             // rate, queue, busy
+}
+
+func newLinkThrottle() *linkThrottle {
+	t := &linkThrottle{busy: make(map[linkPair]time.Time)}
+	go func() {
+		for range time.Tick(10 * time.Second) {
+			t.forget()
+		}
+	}()
+	return t
+}
+
+// set changes rate and queue size of links without an override, from the
+// next frame on.
+func (t *linkThrottle) set(rate float64, queue int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rate, t.queue = rate, queue
+}
+
+// admit returns how long a frame of size bytes from src to dst waits in the
+// queue and takes to be transmitted, or false if it is dropped. o is the
+// override of the link, if any; its rate and queue take precedence.
+func (t *linkThrottle) admit(src, dst, size int, o *linkOverride) (delay time.Duration, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rate, queue := t.rate, t.queue
+	if o != nil && o.rate > 0 {
+		rate = o.rate
+		if o.queue > 0 {
+			queue = o.queue
+		}
+	}
+	if rate <= 0 {
+		return 0, true
+	}
+	pair := linkPair{src: src, dst: dst}
+	now := time.Now()
+	start := now
+	if busy, ok := t.busy[pair]; ok && busy.After(now) {
+		// bytes still to be transmitted before this frame
+		if backlog := busy.Sub(now).Seconds() * rate / 8; backlog+float64(size) > float64(queue) {
+			return 0, false
+		}
+		start = busy
+	}
+	end := start.Add(time.Duration(float64(size) * 8 / rate * float64(time.Second)))
+	t.busy[pair] = end
+	return end.Sub(now), true
+}
+
+// forget removes links that are idle, so that busy doesn't grow with every
+// pair of nodes that ever talked.
+func (t *linkThrottle) forget() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for pair, busy := range t.busy {
+		if !busy.After(now) {
+			delete(t.busy, pair)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// traceNodesHelp documents the nodes parameter of trace replaying Mobility
+// Managers.
+const traceNodesHelp = `
+  nodes [Optional]:
+    Comma separated hardware addresses of nodes that nodes 0, 1, 2, ... in the
+    trace are mapped to. Default: node N in the trace is mapped to node with
+    identity N+1, i.e. (N+1)th address of emulated subnet.
+`
+
+// traceNodes maps nodes in a trace, numbered from 0, to nodes in emulation.
+type traceNodes struct {
+	positionManager squirrel.PositionManager
+	addrs           []string        // hardware address by trace node; nil to map by identity
+	reached         []int           // trace nodes that arrived at waypoints, to be published by replay
+	ticker          squirrel.Ticker // of replay
+}
+
+// parseTraceNodes parses the nodes parameter (see traceNodesHelp).
+func parseTraceNodes(s string) (addrs []string, err error) {
+	if s == "" {
+		return
+	}
+	for _, v := range strings.Split(s, ",") {
+		var addr net.HardwareAddr
+		if addr, err = net.ParseMAC(strings.TrimSpace(v)); err != nil {
+			return
+		}
+		addrs = append(addrs, addr.String())
+	}
+	return
+}
+
+// set moves node that trace node id is mapped to to pos. An error is returned
+// if the node hasn't joined yet.
+func (t *traceNodes) set(id int, pos squirrel.Position) error {
+	if t.addrs != nil {
+		if id >= len(t.addrs) {
+			return fmt.Errorf("trace node %d is not mapped to any node", id)
+		}
+		return t.positionManager.SetPositionAddr(t.addrs[id], &pos)
+	}
+	index := id + 1
+	if !t.positionManager.IsEnabled(index) {
+		return fmt.Errorf("node with index %d is disabled", index)
+	}
+	return t.positionManager.SetPosition(index, &pos)
+}
+
+// event returns a MobilityEvent about node that trace node id is mapped to.
+func (t *traceNodes) event(kind, source string, id int, now time.Time) squirrel.MobilityEvent {
+	e := squirrel.MobilityEvent{Kind: kind, Source: source, Time: now}
+	if t.addrs != nil {
+		if id < len(t.addrs) {
+			e.Addr = t.addrs[id]
+		}
+	} else {
+		e.Index = id + 1
+	}
+	return e
+}
+
+// arrive queues a WaypointReached event of trace node id from the at function
+// of replay. It's published after the node is moved there.
+func (t *traceNodes) arrive(id int) {
+	t.reached = append(t.reached, id)
+}
+
+// replay moves nodes every interval to positions that at returns for time
+// since start, by trace node. Positions that are already applied are skipped.
+// Once it's past end, the time of the last entry in the trace, and at no
+// longer moves any node, a TraceFinished event is published. Nodes that join
+// later are still placed.
+func (t *traceNodes) replay(name string, start time.Time, interval, end time.Duration, at func(t time.Duration) map[int]squirrel.Position) {
+	applied := make(map[int]squirrel.Position)
+	returned := make(map[int]squirrel.Position) // by last call of at, whether applied or not
+	finished := false
+	t.ticker = clock.Every(interval, func(now time.Time) {
+		elapsed := now.Sub(start)
+		moving := false
+		for id, pos := range at(elapsed) {
+			if last, ok := returned[id]; !ok || last != pos {
+				returned[id] = pos
+				moving = true
+			}
+			if last, ok := applied[id]; ok && last == pos {
+				continue
+			}
+			if err := t.set(id, pos); err != nil {
+				logger.debugf("%s: %v", name, err)
+				continue
+			}
+			applied[id] = pos
+		}
+		for _, id := range t.reached {
+			mobilityEvents.Publish(t.event(squirrel.WaypointReached, name, id, now))
+		}
+		t.reached = t.reached[:0]
+		if !finished && !moving && elapsed >= end {
+			finished = true
+			logger.infof("%s: trace is finished", name)
+			mobilityEvents.Publish(squirrel.MobilityEvent{Kind: squirrel.TraceFinished, Source: name, Time: now})
+		}
+	})
+}
+
+// stop stops replay.
+func (t *traceNodes) stop() {
+	if t.ticker != nil {
+		t.ticker.Stop()
+	}
+}
+
+// linearLeg is a straight move of a node, from from at start toward dest at
+// speed.
+type linearLeg struct {
+	from  squirrel.Position
+	start time.Duration
+	dest  squirrel.Position
+	speed float64
+}
+
+// at returns position on leg at t (since replay started). Node stays at dest
+// after arriving there.
+func (l *linearLeg) at(t time.Duration) squirrel.Position {
+	dx, dy, dh := l.dest.X-l.from.X, l.dest.Y-l.from.Y, l.dest.Height-l.from.Height
+	d := math.Sqrt(dx*dx + dy*dy + dh*dh)
+	travel := l.speed * (t - l.start).Seconds()
+	if d == 0 || travel >= d {
+		return l.dest
+	}
+	f := travel / d
+	return squirrel.Position{X: l.from.X + dx*f, Y: l.from.Y + dy*f, Height: l.from.Height + dh*f}
+}
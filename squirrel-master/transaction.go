@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// positionTx buffers moves made in a transaction until it commits.
+type positionTx struct {
+	p     *PositionManager
+	moves map[int]squirrel.Position
+}
+
+func (tx *positionTx) Get(index int) (squirrel.Position, error) {
+	if pos, ok := tx.moves[index]; ok {
+		return pos, nil
+	}
+	return tx.p.Get(index)
+}
+
+func (tx *positionTx) Set(index int, pos squirrel.Position) (err error) {
+	if index < 0 || index >= tx.p.Capacity() {
+		err = fmt.Errorf("invalid index %d. capacity is %d", index, tx.p.Capacity())
+		return
+	}
+	if pos, err = tx.p.normalize(tx.p.fromSupplied(pos)); err != nil {
+		return
+	}
+	tx.moves[index] = pos
+	return
+}
+
+// Transaction applies moves made by fn atomically.
+func (p *PositionManager) Transaction(fn func(tx squirrel.PositionTx) error) (err error) {
+	tx := &positionTx{p: p, moves: make(map[int]squirrel.Position)}
+	if err = fn(tx); err != nil {
+		return
+	}
+
+	// lock in ascending order to avoid deadlocking with other transactions
+	indices := make([]int, 0, len(tx.moves))
+	for index := range tx.moves {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+
+	p.muEnabled.RLock()
+	defer p.muEnabled.RUnlock()
+	for _, index := range indices {
+		if !p.isEnabled[index] {
+			return fmt.Errorf("node with index %d is disabled", index)
+		}
+		if p.nodes[index].load().fixed {
+			return fmt.Errorf("node with index %d is fixed", index)
+		}
+	}
+	for _, index := range indices {
+		p.nodes[index].mu.Lock()
+	}
+	for _, index := range indices {
+		n := p.nodes[index]
+		s := *n.load()
+		s.pos = tx.moves[index]
+		p.positionApplied(index, n, &s)
+	}
+	for _, index := range indices {
+		p.nodes[index].mu.Unlock()
+	}
+
+	moved := make([]squirrel.PositionUpdate, 0, len(indices))
+	for _, index := range indices {
+		if _, ok := p.attachedTo(index); !ok {
+			moved = append(moved, squirrel.PositionUpdate{Index: index, Position: tx.moves[index]})
+		}
+	}
+	p.notifyPositionsChanged(moved)
+	logger.debugf("positions for %d nodes are updated in transaction", len(indices))
+	return
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// etcdValues returns values of all entries under node, by their keys relative
+// to it, e.g. "fading/model".
+func etcdValues(node *etcd.Node) map[string]string {
+	values := make(map[string]string)
+	var walk func(n *etcd.Node, prefix string)
+	walk = func(n *etcd.Node, prefix string) {
+		for _, child := range n.Nodes {
+			name := prefix + path.Base(child.Key)
+			if child.Dir {
+				walk(child, name+"/")
+			} else {
+				values[name] = child.Value
+			}
+		}
+	}
+	if node != nil {
+		walk(node, "")
+	}
+	return values
+}
+
+// copyEtcdNode returns a deep copy of node.
+func copyEtcdNode(node *etcd.Node) *etcd.Node {
+	c := &etcd.Node{Key: node.Key, Value: node.Value, Dir: node.Dir}
+	for _, child := range node.Nodes {
+		c.Nodes = append(c.Nodes, copyEtcdNode(child))
+	}
+	return c
+}
+
+// withEtcdValues returns a copy of node with entries at keys relative to it
+// set to values, creating Dirs on the way, or removed where values are nil.
+// node is left intact; a nil one is an empty Dir.
+func withEtcdValues(node *etcd.Node, values map[string]*string) (*etcd.Node, error) {
+	if node == nil {
+		node = &etcd.Node{Dir: true}
+	}
+	node = copyEtcdNode(node)
+	for key, value := range values {
+		var names []string
+		for _, name := range strings.Split(key, "/") {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("invalid key %q", key)
+		}
+		dir := node
+		for i, name := range names {
+			var child *etcd.Node
+			index := -1
+			for j, n := range dir.Nodes {
+				if path.Base(n.Key) == name {
+					child, index = n, j
+					break
+				}
+			}
+			last := i == len(names)-1
+			switch {
+			case last && value == nil:
+				if child != nil {
+					dir.Nodes = append(dir.Nodes[:index], dir.Nodes[index+1:]...)
+				}
+			case last:
+				if child != nil && child.Dir {
+					return nil, fmt.Errorf("%s is a Dir node", key)
+				}
+				if child == nil {
+					child = &etcd.Node{Key: dir.Key + "/" + name}
+					dir.Nodes = append(dir.Nodes, child)
+				}
+				child.Value = *value
+			case child == nil && value == nil:
+				// nothing to remove
+			case child == nil:
+				child = &etcd.Node{Key: dir.Key + "/" + name, Dir: true}
+				dir.Nodes = append(dir.Nodes, child)
+			case !child.Dir:
+				return nil, fmt.Errorf("%s is not a Dir node", dir.Key+"/"+name)
+			}
+			if child == nil || last {
+				break
+			}
+			dir = child
+		}
+	}
+	return node, nil
+}
+
+// reconfigureSeptember reconfigures september with parameters, which are kept
+// for later changes if it succeeds.
+func (master *Master) reconfigureSeptember(parameters *etcd.Node) error {
+	master.muSeptember.Lock()
+	defer master.muSeptember.Unlock()
+	if err := reconfigure(master.september, "September", parameters); err != nil {
+		return err
+	}
+	master.septemberConfig = parameters
+	return nil
+}
+
+// septemberParameters returns values of parameters september runs with, by
+// their keys relative to its configuration node.
+func (master *Master) septemberParameters() map[string]string {
+	master.muSeptember.Lock()
+	defer master.muSeptember.Unlock()
+	return etcdValues(master.septemberConfig)
+}
+
+// tuneSeptember changes parameters of september at keys relative to its
+// configuration node to values, or removes those that are nil so that they
+// are back to their defaults, leaving others as they are.
+func (master *Master) tuneSeptember(values map[string]*string) error {
+	master.muSeptember.Lock()
+	defer master.muSeptember.Unlock()
+	parameters, err := withEtcdValues(master.septemberConfig, values)
+	if err != nil {
+		return err
+	}
+	if err = reconfigure(master.september, "September", parameters); err != nil {
+		return err
+	}
+	master.septemberConfig = parameters
+	return nil
+}
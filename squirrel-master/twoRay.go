@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/coreos/go-etcd/etcd"
+	"github.com/squirrel-land/squirrel"
+	"github.com/squirrel-land/squirrel/common"
+)
+
+type twoRayParameters struct {
+	TxPower        float64                      `etcd:"tx_power" default:"20"`
+	Sensitivity    float64                      `etcd:"sensitivity" default:"-90"`
+	MinSensitivity string                       `etcd:"min_sensitivity"`
+	Transition     float64                      `etcd:"transition" default:"2"`
+	Frequency      float64                      `etcd:"frequency" default:"2.4e9"`
+	Channels       map[string]channelParameters `etcd:"channels"`
+	AntennaHeight  float64                      `etcd:"antenna_height" default:"1.5"`
+	NoiseFloor     float64                      `etcd:"noise_floor" default:"-95"`
+	ErrorModel     errorModelParameters         `etcd:"error_model"`
+	RateAdaptation rateAdaptationParameters     `etcd:"rate_adaptation"`
+	Obstruction    obstructionParameters        `etcd:"obstruction"`
+	Antennas       map[string]antennaParameters `etcd:"antennas"`
+	DefaultAntenna string                       `etcd:"default_antenna"`
+	Fading         fadingParameters             `etcd:"fading"`
+	Shadowing      shadowingParameters          `etcd:"shadowing"`
+	Interference   interferenceParameters       `etcd:"interference"`
+	MAC            macParameters                `etcd:"mac"`
+	Delay          delayParameters              `etcd:"delay"`
+	Doppler        dopplerParameters            `etcd:"doppler"`
+}
+
+func (p *twoRayParameters) radio() radioParameters {
+	return radioParameters{
+		txPower:      p.TxPower,
+		sensitivity:  p.Sensitivity,
+		lowest:       p.MinSensitivity,
+		transition:   p.Transition,
+		frequency:    p.Frequency,
+		channels:     p.Channels,
+		noiseFloor:   p.NoiseFloor,
+		errors:       p.ErrorModel,
+		rates:        p.RateAdaptation,
+		obstruction:  p.Obstruction,
+		antennas:     p.Antennas,
+		antenna:      p.DefaultAntenna,
+		fading:       p.Fading,
+		shadowing:    p.Shadowing,
+		interference: p.Interference,
+		mac:          p.MAC,
+		delay:        p.Delay,
+		doppler:      p.Doppler,
+	}
+}
+
+// minAntennaHeight keeps antennas at or below ground, e.g. of nodes that have
+// no Height, from making path loss infinite.
+const minAntennaHeight = 0.1 // m
+
+// twoRayLoss is two-ray ground reflection path loss: free-space loss up to
+// the crossover distance 4*pi*ht*hr/lambda, where the ray reflected off the
+// ground starts cancelling out the direct one, and 40 dB per tenfold distance
+// beyond it. Antenna heights ht and hr are Height of nodes plus
+// antennaHeight, so higher nodes reach further.
+type twoRayLoss struct {
+	antennaHeight float64
+}
+
+func (l *twoRayLoss) loss(tx, rx squirrel.Position, d, frequency float64) float64 {
+	// free-space loss is meaningless in the near field
+	d = math.Max(d, 1)
+	ht := math.Max(tx.Height+l.antennaHeight, minAntennaHeight)
+	hr := math.Max(rx.Height+l.antennaHeight, minAntennaHeight)
+	crossover := 4 * math.Pi * ht * hr * frequency / speedOfLight
+	if d <= crossover {
+		return freeSpaceLoss(d, frequency)
+	}
+	return 40*math.Log10(d) - 20*math.Log10(ht) - 20*math.Log10(hr)
+}
+
+// maxRange is range under free-space loss, which is never more than two-ray
+// loss, however high antennas are.
+func (l *twoRayLoss) maxRange(loss, frequency float64) float64 {
+	return math.Max(freeSpaceRange(loss, frequency), 1)
+}
+
+func parseTwoRay(conf *etcd.Node) (*radioConfig, error) {
+	var params twoRayParameters
+	if err := common.DecodeParameters(conf, &params); err != nil {
+		return nil, err
+	}
+	if params.Frequency <= 0 {
+		return nil, fmt.Errorf("frequency needs to be positive (got %v)", params.Frequency)
+	}
+	model := &twoRayLoss{antennaHeight: params.AntennaHeight}
+	return newRadioConfig(params.radio(), model)
+}
+
+// newTwoRaySeptember creates a September that delivers packets by received
+// power under two-ray ground reflection path loss, for long-range outdoor
+// scenarios over flat ground, where free-space loss is far too optimistic.
+func newTwoRaySeptember() squirrel.September {
+	return &radioSeptember{
+		parse: parseTwoRay,
+		help: radioHelp + `
+  antenna_height [Optional]:
+    Height in meters of antennas above nodes; antenna heights are Height of
+    nodes plus this, and at least 0.1. Default: 1.5
+
+  Up to the crossover distance 4*pi*ht*hr/wavelength, where ht and hr are
+  antenna heights, path loss is free-space loss. Beyond it, loss grows by
+  40 dB each tenfold distance, less for higher antennas. Height is taken as
+  height above ground, so with wgs84 heights are best relative to terrain.
+  Each packet is delivered with a probability that goes from 0 to 1 as
+  received power, tx_power minus path loss, rises through sensitivity.
+    `,
+	}
+}
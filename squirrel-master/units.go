@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/squirrel-land/squirrel"
+)
+
+// lengthUnits maps names of supported length units to meters per unit.
+var lengthUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"ft": 0.3048,
+}
+
+func parseLengthUnit(s string) (meters float64, err error) {
+	var ok bool
+	if meters, ok = lengthUnits[s]; !ok {
+		err = fmt.Errorf("unknown units %s (expected m, km or ft)", s)
+	}
+	return
+}
+
+// fromSupplied converts a position supplied to PositionManager into meters
+// relative to the configured origin.
+func (p *PositionManager) fromSupplied(pos squirrel.Position) squirrel.Position {
+	pos.X -= p.origin.X
+	pos.Y -= p.origin.Y
+	pos.Height -= p.origin.Height
+	return p.scaled(pos)
+}
+
+// scaled converts pos supplied in configured units into meters. X and Y are
+// left as is in geographic mode, being longitude and latitude.
+func (p *PositionManager) scaled(pos squirrel.Position) squirrel.Position {
+	if p.scale == 1 {
+		return pos
+	}
+	if !p.geographic {
+		pos.X *= p.scale
+		pos.Y *= p.scale
+	}
+	pos.Height *= p.scale
+	return pos
+}
+
+// scaledVelocity is like scaled, but for velocities.
+func (p *PositionManager) scaledVelocity(vel squirrel.Velocity) squirrel.Velocity {
+	if p.scale == 1 {
+		return vel
+	}
+	if !p.geographic {
+		vel.X *= p.scale
+		vel.Y *= p.scale
+	}
+	vel.Height *= p.scale
+	return vel
+}
+
+// inMeters returns whether supplied positions are stored as is, i.e. without
+// any scale or origin.
+func (p *PositionManager) inMeters() bool {
+	return p.scale == 1 && p.origin == squirrel.Position{}
+}
+
+// toSupplied converts a position in meters relative to the configured origin
+// back into configured units, undoing fromSupplied.
+func (p *PositionManager) toSupplied(pos squirrel.Position) squirrel.Position {
+	if p.scale != 1 {
+		if !p.geographic {
+			pos.X /= p.scale
+			pos.Y /= p.scale
+		}
+		pos.Height /= p.scale
+	}
+	pos.X += p.origin.X
+	pos.Y += p.origin.Y
+	pos.Height += p.origin.Height
+	return pos
+}
+
+// toSuppliedVelocity undoes scaledVelocity.
+func (p *PositionManager) toSuppliedVelocity(vel squirrel.Velocity) squirrel.Velocity {
+	if p.scale == 1 {
+		return vel
+	}
+	if !p.geographic {
+		vel.X /= p.scale
+		vel.Y /= p.scale
+	}
+	vel.Height /= p.scale
+	return vel
+}
+
+// parsePosition parses a position in the form of "x,y" or "x,y,height".
+func parsePosition(s string) (pos squirrel.Position, err error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 2 && len(fields) != 3 {
+		err = fmt.Errorf("invalid position %q (expected x,y or x,y,height)", s)
+		return
+	}
+	values := make([]float64, 3)
+	for i, f := range fields {
+		if values[i], err = strconv.ParseFloat(strings.TrimSpace(f), 64); err != nil {
+			return
+		}
+	}
+	pos = squirrel.Position{X: values[0], Y: values[1], Height: values[2]}
+	return
+}
+
+// parseBitRate parses a rate in bits per second, with an optional k, M or G
+// prefix and bps suffix, e.g. 6M, 6Mbps or 500kbps.
+func parseBitRate(s string) (bps float64, err error) {
+	v := strings.TrimSuffix(strings.TrimSpace(s), "bps")
+	multiplier := 1.0
+	if n := len(v); n > 0 {
+		switch v[n-1] {
+		case 'k':
+			multiplier = 1e3
+		case 'M':
+			multiplier = 1e6
+		case 'G':
+			multiplier = 1e9
+		}
+		if multiplier != 1 {
+			v = v[:n-1]
+		}
+	}
+	if bps, err = strconv.ParseFloat(v, 64); err != nil {
+		return 0, fmt.Errorf("invalid bit rate %q", s)
+	}
+	if bps < 0 {
+		return 0, fmt.Errorf("bit rate cannot be negative (got %q)", s)
+	}
+	return bps * multiplier, nil
+}
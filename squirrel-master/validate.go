@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+// validateConfig checks conf for problems that would otherwise only show up
+// one at a time later in startup, and returns all of them.
+func validateConfig(conf config) (errs []error) {
+	// required entries that are left empty are already reported by getConfig
+	if _, _, err := net.SplitHostPort(conf.uri); err != nil && conf.uri != "" {
+		errs = append(errs, fmt.Errorf("invalid listen address %s: %v", conf.uri, err))
+	}
+
+	if conf.emulatedSubnet != "" {
+		if _, network, err := net.ParseCIDR(conf.emulatedSubnet); err != nil {
+			errs = append(errs, fmt.Errorf("emulated_subnet %s is not in CIDR notation", conf.emulatedSubnet))
+		} else if network.IP.To4() == nil {
+			errs = append(errs, fmt.Errorf("emulated_subnet %s is not an IPv4 network", conf.emulatedSubnet))
+		} else if ones, bits := network.Mask.Size(); bits-ones < 2 {
+			errs = append(errs, fmt.Errorf("emulated_subnet %s has no room for any node", conf.emulatedSubnet))
+		}
+	}
+
+	if constructor := mobilityManagerConstructor(conf.mobilityManager); constructor != nil {
+		errs = appendConfigureError(errs, "mobility_manager "+conf.mobilityManager, constructor().Configure(conf.mobilityManagerConfig))
+	} else if conf.mobilityManager != "" {
+		errs = append(errs, fmt.Errorf("unknown mobility_manager %s (registered: %s)", conf.mobilityManager, joinSorted(mobilityManagerNames())))
+	}
+	for _, a := range conf.mobilityAssignments {
+		if constructor := mobilityManagerConstructor(a.mobilityManager); constructor != nil {
+			errs = appendConfigureError(errs, "mobility manager "+a.name, constructor().Configure(a.parameters))
+		} else {
+			errs = append(errs, fmt.Errorf("unknown mobility manager %s for %s", a.mobilityManager, a.name))
+		}
+		if len(a.nodes) == 0 && len(a.tags) == 0 {
+			errs = append(errs, fmt.Errorf("mobility manager %s has no nodes or tags assigned", a.name))
+		}
+	}
+	names := parseSeptemberNames(conf.september)
+	if len(names) == 0 {
+		errs = append(errs, fmt.Errorf("september is empty"))
+	}
+	known := len(names) > 0
+	for _, name := range names {
+		if septemberConstructor(name) == nil {
+			errs = append(errs, fmt.Errorf("unknown september %s (registered: %s)", name, joinSorted(septemberNames())))
+			known = false
+		}
+	}
+	if known {
+		if september, err := newSeptember(conf.september); err != nil {
+			errs = append(errs, err)
+		} else {
+			errs = appendConfigureError(errs, "september "+conf.september, september.Configure(conf.septemberConfig))
+		}
+	}
+
+	if conf.positionManager.preallocatedNodes < 0 {
+		errs = append(errs, fmt.Errorf("preallocated_nodes cannot be negative (got %d)", conf.positionManager.preallocatedNodes))
+	}
+
+	if conf.positionsFile != "" {
+		if _, err := os.Stat(conf.positionsFile); err != nil {
+			errs = append(errs, fmt.Errorf("positions_file: %v", err))
+		}
+	}
+
+	if conf.controlListen != "" {
+		if _, _, err := net.SplitHostPort(conf.controlListen); err != nil {
+			errs = append(errs, fmt.Errorf("control_listen: %v", err))
+		}
+	}
+
+	if conf.grpcListen != "" {
+		if _, _, err := net.SplitHostPort(conf.grpcListen); err != nil {
+			errs = append(errs, fmt.Errorf("grpc_listen: %v", err))
+		}
+	}
+
+	for _, o := range conf.linkOverrides {
+		if err := o.check(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if conf.linkQueue < 0 {
+		errs = append(errs, fmt.Errorf("link_queue cannot be negative (got %d)", conf.linkQueue))
+	}
+	if err := checkImpairment(conf.linkDuplicate, conf.linkReorder, conf.linkReorderDelay); err != nil {
+		errs = append(errs, fmt.Errorf("link impairments: %v", err))
+	}
+	if err := conf.energy.check(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if conf.log.format != "text" && conf.log.format != "json" {
+		errs = append(errs, fmt.Errorf("unknown log format %s (expected text or json)", conf.log.format))
+	}
+	if conf.log.maxSize < 0 || conf.log.maxBackups < 0 {
+		errs = append(errs, fmt.Errorf("log max_size and max_backups cannot be negative (got %d and %d)", conf.log.maxSize, conf.log.maxBackups))
+	}
+
+	if e := conf.positionExport; e != nil {
+		if e.format != "csv" && e.format != "jsonl" {
+			errs = append(errs, fmt.Errorf("unknown position export format %s (expected csv or jsonl)", e.format))
+		}
+		if e.interval <= 0 {
+			errs = append(errs, fmt.Errorf("position export interval needs to be positive (got %v)", e.interval))
+		}
+	}
+	return
+}
+
+// appendConfigureError appends err of configuring model to errs, if there's
+// one. Models are configured as they are at startup, but not initialized, so
+// that problems with their parameters are found along with others.
+func appendConfigureError(errs []error, model string, err error) []error {
+	if err != nil {
+		errs = append(errs, fmt.Errorf("%s: %v", model, err))
+	}
+	return errs
+}
+
+func joinSorted(names []string) string {
+	if len(names) == 0 {
+		return "none"
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
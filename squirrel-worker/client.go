@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
@@ -11,20 +12,23 @@ import (
 )
 
 type Client struct {
-	link *common.Link
-	tap  *water.Interface
+	link      *common.Link
+	tap       *water.Interface
+	tlsConfig *tls.Config // nil if not using TLS
 }
 
-// Create a new client along with a TAP network interface whose name is tapName
-func NewClient(tapName string) (client *Client, err error) {
+// Create a new client along with a TAP network interface whose name is
+// tapName. If tlsConfig is not nil, master is connected over TLS.
+func NewClient(tapName string, tlsConfig *tls.Config) (client *Client, err error) {
 	var tap *water.Interface
 	tap, err = water.NewTAP(tapName)
 	if err != nil {
 		return nil, err
 	}
 	client = &Client{
-		link: nil,
-		tap:  tap,
+		link:      nil,
+		tap:       tap,
+		tlsConfig: tlsConfig,
 	}
 	return
 }
@@ -43,7 +47,11 @@ func (client *Client) configureTap(joinRsp *common.JoinRsp) (err error) {
 
 func (client *Client) connect(masterAddr string) (err error) {
 	var connection net.Conn
-	connection, err = net.Dial("tcp", masterAddr)
+	if client.tlsConfig != nil {
+		connection, err = tls.Dial("tcp", masterAddr, client.tlsConfig)
+	} else {
+		connection, err = net.Dial("tcp", masterAddr)
+	}
 	if err != nil {
 		return
 	}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log"
 	"os"
@@ -13,14 +14,21 @@ import (
 type config struct {
 	masterURI string
 	tapName   string
+	tls       *tls.Config // nil if not using TLS
 }
 
-func getConfig() (conf config, err error) {
-	endpoint := os.Getenv("SQUIRREL_ENDPOINT")
-	if endpoint == "" {
-		endpoint = "http://127.0.0.1:4001"
+// getOptionalEtcdValue is like common.GetEtcdValue, but returns an empty
+// string rather than an error if key does not exist.
+func getOptionalEtcdValue(client *etcd.Client, key string) (value string, err error) {
+	value, err = common.GetEtcdValue(client, key)
+	if err != nil && common.IsEtcdNotFoundError(err) {
+		err = nil
 	}
-	client := etcd.NewClient([]string{endpoint})
+	return
+}
+
+func getConfig() (conf config, err error) {
+	client := etcd.NewClient(common.EtcdEndpoints(os.Getenv("SQUIRREL_ENDPOINT")))
 
 	conf.masterURI, err = common.GetEtcdValue(client, "/squirrel/master_uri")
 	if err != nil {
@@ -37,6 +45,23 @@ func getConfig() (conf config, err error) {
 		}
 	}
 
+	var ca, cert, key string
+	if ca, err = getOptionalEtcdValue(client, "/squirrel/worker_tls_ca"); err != nil {
+		return
+	}
+	if cert, err = getOptionalEtcdValue(client, "/squirrel/worker_tls_cert"); err != nil {
+		return
+	}
+	if key, err = getOptionalEtcdValue(client, "/squirrel/worker_tls_key"); err != nil {
+		return
+	}
+	if ca != "" || cert != "" || key != "" {
+		conf.tls, err = common.ClientTLSConfig(ca, cert, key)
+		if err != nil {
+			return
+		}
+	}
+
 	return
 }
 
@@ -45,12 +70,19 @@ func printHelp() {
 	fmt.Printf("Usage: %s\n", os.Args[0])
 	fmt.Println()
 	fmt.Println("Environment Variables:")
-	fmt.Println("    SQUIRREL_ENDPOINT  : etcd endpoint UIR. [Optional]")
+	fmt.Println("    SQUIRREL_ENDPOINT  : etcd endpoint UIR, or comma separated URIs of an etcd")
+	fmt.Println("                             cluster. etcd:// and etcds:// are accepted as")
+	fmt.Println("                             http:// and https://. [Optional]")
 	fmt.Println("                             Default: http://127.0.0.1:4001")
 	fmt.Println()
 	fmt.Println("Etcd Configuration Entries:")
 	fmt.Println("    /squirrel/master_uri      : URI of the squirrel-master. [Required]")
 	fmt.Println("    /squirrel/worker_tap_name : Name of the TAP interface.  [Optional]")
+	fmt.Println("    /squirrel/worker_tls_ca   : PEM file of CAs to verify master with. If")
+	fmt.Println("                                any worker_tls_* is set, master is connected")
+	fmt.Println("                                over TLS. [Optional]")
+	fmt.Println("    /squirrel/worker_tls_cert : PEM client certificate file. [Optional]")
+	fmt.Println("    /squirrel/worker_tls_key  : PEM client key file. [Optional]")
 }
 
 func main() {
@@ -66,7 +98,7 @@ func main() {
 		printHelp()
 		log.Fatalf("reading config error: %v\n", err)
 	}
-	if client, err = NewClient(conf.tapName); err != nil {
+	if client, err = NewClient(conf.tapName, conf.tls); err != nil {
 		log.Fatalf("creating client error: %v\n", err)
 	}
 	if err = client.Start(conf.masterURI); err != nil {
@@ -1,6 +1,16 @@
 package squirrel
 
-import "github.com/coreos/go-etcd/etcd"
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/coreos/go-etcd/etcd"
+)
+
+// VersionMismatch is returned by PositionManager.SetIfVersion when the
+// position has been changed since the given version.
+var VersionMismatch = errors.New("position version mismatch")
 
 // MobilityManager controls locations and defines model of mobility of each
 // nodes. Master uses an implementation of MobilityManager interface to
@@ -61,13 +71,296 @@ type September interface {
 	SendBroadcast(source int, size int, underlying []int) []int
 }
 
+// Delayer is optionally implemented by a September that delays packets it
+// decides to deliver, e.g. by distance or a configured distribution. Master
+// delivers each packet after its delay rather than right away; packets
+// between two nodes are still delivered in the order they are sent.
+type Delayer interface {
+	// Delay returns how long a packet as large as size(in bytes) from
+	// source(identity) takes to reach destination(identity). It's only called
+	// for packets that are delivered, once for each recipient of a broadcast
+	// packet unless the September is a BroadcastDelayer too.
+	Delay(source int, destination int, size int) time.Duration
+}
+
+// BroadcastDelayer is optionally implemented by a Delayer that can work out
+// delays of all recipients of a broadcast packet at once, cheaper than by
+// calling Delay for each of them. Master uses it for broadcast packets
+// instead of Delay.
+type BroadcastDelayer interface {
+	// DelayBroadcast sets delays[i] to how long a packet as large as
+	// size(in bytes) from source(identity) takes to reach recipients[i], as
+	// Delay would return for it. Random parts of delays are drawn for each
+	// recipient independently. delays is as long as recipients.
+	DelayBroadcast(source int, size int, recipients []int, delays []time.Duration)
+}
+
+// LinkQuality is what a September believes about a link, at the moment.
+type LinkQuality struct {
+	// Loss is probability that a unicast packet of LinkQualitySize bytes is
+	// not delivered, from 0 to 1.
+	Loss float64
+
+	// RSSI is mean received power in dBm and SNR is that over noise in dB,
+	// before fading and interference. They are NaN if the September doesn't
+	// model them.
+	RSSI float64
+	SNR  float64
+
+	// Rate is the data rate in Mb/s that unicast packets are sent at, or 0 if
+	// the September doesn't model it.
+	Rate float64
+}
+
+// LinkQualitySize is the size in bytes of packets that LinkQuality.Loss is
+// of.
+const LinkQualitySize = 1500
+
+// LinkQualifier is optionally implemented by a September that can tell what it
+// believes about each link, e.g. for routing daemons and dashboards through
+// the control API of master.
+type LinkQualifier interface {
+	// LinkQuality returns quality of the link from source(identity) to
+	// destination(identity), or false if there's none, e.g. since either node
+	// is disabled.
+	LinkQuality(source int, destination int) (LinkQuality, bool)
+}
+
+// Packet is what a September knows about a packet, besides its size, e.g.
+// to treat control traffic differently from data by EtherType. With a chain
+// of Septembers, it's passed from one stage to the next, so that a stage can
+// note what later ones might need, e.g. the rate a radio propagation
+// September sends it at.
+type Packet struct {
+	Source int // identity
+	Size   int // bytes of payload, as in SendUnicast and SendBroadcast
+
+	// Length is bytes of the whole Ethernet frame, including its header.
+	Length int
+
+	// EtherType is of the payload. Frames with an 802.1Q tag have the
+	// EtherType after it.
+	EtherType uint16
+
+	// SourceAddr and DestinationAddr are hardware addresses in the frame
+	// header. They are only valid during the call they are passed to.
+	SourceAddr      net.HardwareAddr
+	DestinationAddr net.HardwareAddr
+
+	// Notes are values noted by stages the packet has gone through, by key.
+	Notes map[string]float64
+}
+
+// Note sets value of key in Notes.
+func (p *Packet) Note(key string, value float64) {
+	if p.Notes == nil {
+		p.Notes = make(map[string]float64)
+	}
+	p.Notes[key] = value
+}
+
+// PacketSeptember is optionally implemented by a September that decides on
+// packets by more than their sizes. Master calls its methods rather than
+// SendUnicast and SendBroadcast, which are still used where there's no frame
+// to tell more, with Packets only having Source and Size.
+type PacketSeptember interface {
+	// SendUnicastPacket is SendUnicast of packet to destination(identity).
+	SendUnicastPacket(packet *Packet, destination int) bool
+
+	// SendBroadcastPacket is SendBroadcast of packet.
+	SendBroadcastPacket(packet *Packet, underlying []int) []int
+}
+
+// Stage is optionally implemented by a September that can be a stage of a
+// chain of Septembers, i.e. decide on packets that earlier stages deliver,
+// knowing what they have noted, rather than on all of them. Septembers that
+// don't implement it can still be stages; they decide on packets on their own
+// and a packet is delivered only where all stages deliver it.
+type Stage interface {
+	// Unicast returns whether packet, which earlier stages deliver to
+	// destination(identity), is delivered.
+	Unicast(packet *Packet, destination int) bool
+
+	// Broadcast returns those of recipients that packet, which earlier stages
+	// deliver to recipients, is delivered to. The returned slice is a
+	// sub-slice of recipients.
+	Broadcast(packet *Packet, recipients []int) []int
+}
+
+// Reconfigurable is optionally implemented by a MobilityManager or September
+// that can apply new parameters while the master is running, e.g. when the
+// master reloads its configuration on SIGHUP. Reconfigure may be called
+// concurrently with other methods.
+type Reconfigurable interface {
+	Reconfigure(*etcd.Node) error
+}
+
+// Stopper is optionally implemented by a MobilityManager that can release its
+// timers, goroutines and listeners when master switches to another one at
+// runtime. Once it's switched away from, a MobilityManager can no longer move
+// nodes whether it implements Stopper or not, and channels it has registered
+// are unregistered.
+type Stopper interface {
+	Stop()
+}
+
+// Clock is simulation time of master. Mobility Managers that run on it
+// rather than on their own timers are paused, sped up or slowed down, and
+// stepped along with all others.
+type Clock interface {
+
+	// Now returns current simulation time.
+	Now() time.Time
+
+	// Every calls fn with current simulation time each time interval of
+	// simulation time passes, until the returned Ticker is stopped. Calls for
+	// all Tickers of the Clock are made one at a time, in order of time, so fn
+	// should return quickly.
+	Every(interval time.Duration, fn func(now time.Time)) Ticker
+}
+
+// Ticker is a subscription to a Clock, returned by Clock.Every.
+type Ticker interface {
+
+	// Reset changes interval of the Ticker, from the current tick.
+	Reset(interval time.Duration)
+
+	// Stop stops calls of the Ticker. It does not wait for a call in progress.
+	Stop()
+}
+
+// Clocked is optionally implemented by a MobilityManager that runs on the
+// master's Clock. SetClock is called before Initialize.
+type Clocked interface {
+	SetClock(clock Clock)
+}
+
+// Rand is a source of random numbers, like a math/rand.Rand but safe for
+// concurrent use.
+type Rand interface {
+	Float64() float64
+	NormFloat64() float64
+	ExpFloat64() float64
+	Intn(n int) int
+	Int63n(n int64) int64
+}
+
+// RandomSource hands out sources of random numbers of master, derived from
+// its seed: one for each link, so that runs with the same seed and the same
+// traces draw the same numbers for each link, whatever order packets of
+// different links are handled in.
+type RandomSource interface {
+	// Link returns the source of random numbers of packets from
+	// source(identity) to destination(identity), the same one each time.
+	// Link(index, index) is that of the node itself, e.g. for its backoffs.
+	Link(source, destination int) Rand
+}
+
+// Randomized is optionally implemented by a September that draws random
+// numbers from master's RandomSource rather than sources of its own, so that
+// delivery decisions can be reproduced. SetRandomSource is called before
+// Initialize.
+type Randomized interface {
+	SetRandomSource(source RandomSource)
+}
+
+// Kinds of MobilityEvent emitted by built-in Mobility Managers.
+const (
+	WaypointReached  = "waypoint_reached"  // a node arrives at a waypoint
+	DirectionChanged = "direction_changed" // a node turns to a new random direction
+	TraceFinished    = "trace_finished"    // all entries of a trace are replayed
+)
+
+// MobilityEvent is something of note that happens in a Mobility Manager,
+// e.g. a node reaching a waypoint.
+type MobilityEvent struct {
+	Kind   string    // e.g. WaypointReached
+	Source string    // name of the Mobility Manager
+	Index  int       // index of the node; 0 if it's not about a node or it's identified by Addr
+	Addr   string    // hardware address of the node, if it's identified by it
+	Time   time.Time // simulation time of master's Clock; set on publishing if zero
+}
+
+// EventBus carries MobilityEvents to whoever is interested in them, e.g.
+// scenario events of master. Publish never blocks.
+type EventBus interface {
+	Publish(event MobilityEvent)
+}
+
+// EventSource is optionally implemented by a MobilityManager that emits
+// MobilityEvents. SetEventBus is called before Initialize.
+type EventSource interface {
+	SetEventBus(bus EventBus)
+}
+
+// Position is the position of a node. By default it's in a Cartesian
+// coordinate system. If master is configured to use geographic coordinates, X
+// is longitude and Y is latitude (both in degrees, WGS84), and Height is
+// altitude in meters. In planar mode, Height is always 0.
 type Position struct {
 	X      float64
 	Y      float64
 	Height float64
 }
 
+// Velocity is a velocity vector, in units of Position per second.
+type Velocity struct {
+	X      float64
+	Y      float64
+	Height float64
+}
+
+// Orientation describes which way a node is facing. Heading is the angle in
+// radians in X-Y plane, counterclockwise from X axis. Pitch is the angle in
+// radians above X-Y plane.
+type Orientation struct {
+	Heading float64
+	Pitch   float64
+}
+
+// EnabledDiff describes nodes that are enabled (Added) and disabled (Removed)
+// by a change.
+type EnabledDiff struct {
+	Added   []int
+	Removed []int
+}
+
+// PositionRecord is a position of a node at a point of time.
+type PositionRecord struct {
+	Time     time.Time
+	Position Position
+}
+
+// PositionUpdate carries a new position for the node at Index. It is used for
+// updating positions of multiple nodes at once.
+type PositionUpdate struct {
+	Index    int
+	Position Position
+}
+
+// PositionTx is used within PositionManager.Transaction to read and move
+// nodes. Moves are not visible to others until the transaction commits.
+type PositionTx interface {
+	// Get returns position of node at index, including moves made earlier in
+	// the transaction.
+	Get(index int) (Position, error)
+
+	// Set moves node at index to pos.
+	Set(index int, pos Position) error
+}
+
+// PositionManager keeps positions of nodes. With units, scale or origin
+// configured on master, positions that are set are converted into meters
+// relative to the origin. Septembers read positions, velocities and distances
+// in meters; Mobility Managers are given a PositionManager that converts
+// positions and velocities they read back into configured units, so that they
+// read what they have set. Offsets of Attach are in configured units (without
+// the origin), and Distance, Within etc. are in meters in both cases.
 type PositionManager interface {
+	// Capacity returns number of nodes that can be managed currently. Valid
+	// indices are from 0 to Capacity()-1. Capacity may grow (but never shrinks)
+	// as more nodes join, which is always followed by an enabled changed
+	// notification.
 	Capacity() int
 
 	// Get returns a copy of Position at given index. Avoid this if possible. It
@@ -75,10 +368,29 @@ type PositionManager interface {
 	Get(index int) (Position, error)
 	GetAddr(hardAddr string) (Position, error)
 
+	// GetWithVersion is like Get, but also returns version of the position.
+	// Version of a node's position increases each time the node is moved.
+	GetWithVersion(index int) (Position, uint64, error)
+
+	// GetAll returns indices and positions of all enabled nodes, in ascending
+	// order of index. It's a consistent view: no node moves while positions are
+	// being copied.
+	GetAll() []PositionUpdate
+
 	// Distance calculates Euclidean distance between positions at index1 and
-	// index2.
+	// index2. With geographic coordinates, it's the straight-line distance in
+	// meters.
 	Distance(index1, index2 int) float64
 
+	// DistanceSq calculates squared distance between positions at index1 and
+	// index2. It's cheaper than Distance for comparing against a radius.
+	DistanceSq(index1, index2 int) float64
+
+	// LineOfSight returns whether the straight path between nodes at index1 and
+	// index2 is not blocked by any configured obstacle. It returns false if
+	// either node is disabled.
+	LineOfSight(index1, index2 int) bool
+
 	// SetPosition sets position at index to be pos. It copies X, Y, and Height
 	// values from inside pos into internal slice. pos is left intact and safe to
 	// be changed afterwards.
@@ -87,6 +399,72 @@ type PositionManager interface {
 	SetPositionAddr(hardAddr string, pos *Position) (err error)
 	SetAddr(hardAddr string, x, y, height float64) (err error)
 
+	// SetIfVersion sets position at index to be pos only if version of the
+	// position is still version (as returned by GetWithVersion). Otherwise
+	// VersionMismatch is returned. It allows multiple writers to update a
+	// node's position without overwriting each other's changes unknowingly.
+	SetIfVersion(index int, version uint64, pos *Position) error
+
+	// SetWithVelocity sets position and velocity at index. Velocity is only
+	// stored for models that need it (e.g. Doppler-aware ones); it's not used to
+	// extrapolate positions.
+	SetWithVelocity(index int, pos *Position, vel *Velocity) error
+
+	// GetVelocity returns a copy of Velocity at given index. It's zero unless
+	// set by SetWithVelocity.
+	GetVelocity(index int) (Velocity, error)
+
+	// GetOrientation returns a copy of Orientation at given index.
+	GetOrientation(index int) (Orientation, error)
+	GetOrientationAddr(hardAddr string) (Orientation, error)
+
+	// SetOrientation sets orientation at index to be o.
+	SetOrientation(index int, o *Orientation) error
+	SetOrientationAddr(hardAddr string, o *Orientation) error
+
+	// History returns recorded positions of node at index since given time,
+	// oldest first. Only a limited number of recent positions are kept for each
+	// node; it returns nil if position history is not enabled.
+	History(index int, since time.Time) ([]PositionRecord, error)
+
+	// Attach makes node at index positioned at offset relative to node at
+	// parent, e.g. for devices mounted on the same vehicle. Get (and everything
+	// based on it) resolves chains of attachments. Positions set on an attached
+	// node are ignored until it's detached. Attachments are removed when either
+	// node is disabled.
+	Attach(index, parent int, offset *Position) error
+	AttachAddr(hardAddr, parentAddr string, offset *Position) error
+
+	// Detach makes an attached node positioned independently again, at where it
+	// currently is.
+	Detach(index int) error
+
+	// Transaction calls fn with a PositionTx, and if fn returns nil, applies
+	// all moves made through the PositionTx atomically: all involved nodes are
+	// locked while moves are applied, and position changed notifications are
+	// sent only after all moves are applied. If fn returns an error, no move is
+	// applied and the error is returned.
+	Transaction(fn func(tx PositionTx) error) error
+
+	// Nearest returns indices of up to k enabled nodes that are nearest to node
+	// at index, nearest first. The node itself is not included.
+	Nearest(index int, k int) ([]int, error)
+
+	// Within returns indices of enabled nodes whose distance to node at index is
+	// no more than radius. The node itself is not included.
+	Within(index int, radius float64) ([]int, error)
+
+	// EnabledWithin is like Within, but returns nil rather than an error if node
+	// at index is disabled or invalid. It's intended for link models in hot
+	// paths that only care about enabled nodes in communication radius.
+	EnabledWithin(index int, radius float64) []int
+
+	// SetBatch sets positions of multiple nodes in one call. It's equivalent to
+	// calling Set for each element in updates, but with less locking overhead.
+	// Updates for invalid or disabled nodes are skipped, and the first such
+	// error is returned after all other updates are applied.
+	SetBatch(updates []PositionUpdate) error
+
 	// Enable marks a node as enabled.
 	Enable(index int)
 
@@ -100,5 +478,54 @@ type PositionManager interface {
 
 	// RegisterEnabledChanged registers a channel, which when a node is enabled
 	// or disabled, is used to send a slice of indices of all enabled nodes.
+	// Delivery doesn't block the PositionManager; if the channel is not drained
+	// fast enough, notifications are coalesced (only the latest is delivered)
+	// or dropped, depending on master configuration.
 	RegisterEnabledChanged(channel chan<- []int)
+
+	// UnregisterEnabledChanged unregisters a channel registered by
+	// RegisterEnabledChanged. Closed channels are skipped (and never sent to
+	// again) even if not unregistered.
+	UnregisterEnabledChanged(channel chan<- []int)
+
+	// RegisterEnabledDiff registers a channel, which when a node is enabled or
+	// disabled, is used to send indices of nodes that are added to or removed
+	// from enabled nodes, rather than all enabled nodes.
+	RegisterEnabledDiff(channel chan<- EnabledDiff)
+
+	// UnregisterEnabledDiff unregisters a channel registered by
+	// RegisterEnabledDiff.
+	UnregisterEnabledDiff(channel chan<- EnabledDiff)
+
+	// GetMetadata returns value of metadata key of node at index. Metadata are
+	// arbitrary strings attached to nodes, e.g. "role" being "UAV", configured
+	// at startup or set at runtime.
+	GetMetadata(index int, key string) (value string, ok bool)
+
+	// Metadata returns a copy of all metadata of node at index.
+	Metadata(index int) (map[string]string, error)
+
+	// SetMetadata sets metadata key of node at index to be value.
+	SetMetadata(index int, key, value string) error
+	SetMetadataAddr(hardAddr string, key, value string) error
+
+	// Snapshot returns positions of all enabled nodes as an opaque blob that
+	// can be loaded by Restore.
+	Snapshot() ([]byte, error)
+
+	// Restore loads positions from a blob returned by Snapshot. Nodes are
+	// matched by hardware address; those that haven't joined yet are placed at
+	// restored positions when they join.
+	Restore(data []byte) error
+
+	// RegisterPositionChanged registers a channel, which when position of a node
+	// is changed, is used to send the index and new position of the node.
+	// Delivery doesn't block the PositionManager; if the channel is not drained
+	// fast enough, only the latest position of each node is delivered, or
+	// notifications are dropped, like for RegisterEnabledChanged.
+	RegisterPositionChanged(channel chan<- PositionUpdate)
+
+	// UnregisterPositionChanged unregisters a channel registered by
+	// RegisterPositionChanged.
+	UnregisterPositionChanged(channel chan<- PositionUpdate)
 }